@@ -0,0 +1,127 @@
+// Copyright 2024 UMH Systems GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package report_by_exception implements the report-by-exception mode of the polling inputs, which only
+// emit values that changed since they were last reported.
+package report_by_exception
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// ReportByException configures an input to only emit the values that changed since they were last reported.
+type ReportByException struct {
+	Enabled            bool
+	Deadband           float64       // Minimum absolute change of numeric values to be reported. 0 reports every change.
+	MaxSilenceInterval time.Duration // Time after which a value is reported even if it did not change. 0 disables it.
+
+	// reported are the last reported values by key. The inputs reset them on every connect, so that the
+	// first read after a reconnect reports all values.
+	reported map[string]reportedValue
+}
+
+type reportedValue struct {
+	payload    []byte
+	reportedAt time.Time
+}
+
+// ConfigField is the configuration of the report-by-exception mode. The description tells how the input
+// keeps the last emitted values.
+func ConfigField(description string) *service.ConfigField {
+	return service.NewObjectField("reportByException",
+		service.NewBoolField("enabled").Description("Only emit a value if it changed since it was last emitted").Default(false),
+		service.NewFloatField("deadband").Description("Minimum absolute change of a numeric value to be emitted. 0 emits every change.").Default(0.0),
+		service.NewDurationField("maxSilenceInterval").Description("Time after which an unchanged value is emitted anyway. 0s never emits unchanged values.").Default("0s")).
+		Description(description)
+}
+
+// Parse parses the reportByException object.
+func Parse(conf *service.ParsedConfig) (ReportByException, error) {
+	var rbe ReportByException
+	var err error
+
+	rbeConf := conf.Namespace("reportByException")
+	if rbe.Enabled, err = rbeConf.FieldBool("enabled"); err != nil {
+		return rbe, err
+	}
+	if rbe.Deadband, err = rbeConf.FieldFloat("deadband"); err != nil {
+		return rbe, err
+	}
+	if rbe.MaxSilenceInterval, err = rbeConf.FieldDuration("maxSilenceInterval"); err != nil {
+		return rbe, err
+	}
+
+	if rbe.Deadband < 0 {
+		return rbe, fmt.Errorf("deadband of reportByException must not be negative")
+	}
+	if rbe.MaxSilenceInterval < 0 {
+		return rbe, fmt.Errorf("maxSilenceInterval of reportByException must not be negative")
+	}
+
+	return rbe, nil
+}
+
+// Reset forgets all reported values.
+func (r *ReportByException) Reset() {
+	r.reported = make(map[string]reportedValue)
+}
+
+// Report checks whether the payload of a value has to be reported and remembers it if so. Numeric
+// payloads are compared with the deadband.
+func (r *ReportByException) Report(key string, payload []byte, numeric bool, now time.Time) bool {
+	if !r.Enabled {
+		return true
+	}
+	if r.reported == nil {
+		r.Reset()
+	}
+
+	last, ok := r.reported[key]
+	if ok && !r.changed(last.payload, payload, numeric) &&
+		(r.MaxSilenceInterval == 0 || now.Sub(last.reportedAt) < r.MaxSilenceInterval) {
+		return false
+	}
+
+	r.reported[key] = reportedValue{payload: bytes.Clone(payload), reportedAt: now}
+	return true
+}
+
+// changed checks whether a value differs from the last reported value. Numeric values only change if the
+// difference exceeds the deadband.
+func (r *ReportByException) changed(last, current []byte, numeric bool) bool {
+	if bytes.Equal(last, current) {
+		return false
+	}
+	if !numeric || r.Deadband == 0 {
+		return true
+	}
+
+	lastValue, err := strconv.ParseFloat(string(last), 64)
+	if err != nil {
+		return true
+	}
+	currentValue, err := strconv.ParseFloat(string(current), 64)
+	if err != nil {
+		return true
+	}
+
+	// NaN never lies within the deadband
+	return !(math.Abs(currentValue-lastValue) <= r.Deadband)
+}
@@ -0,0 +1,13 @@
+package report_by_exception_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestReportByException(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "ReportByException Suite")
+}
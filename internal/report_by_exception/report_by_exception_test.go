@@ -0,0 +1,64 @@
+package report_by_exception_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/united-manufacturing-hub/benthos-umh/internal/report_by_exception"
+)
+
+var _ = Describe("Report by exception", func() {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	It("should report every value if it is disabled", func() {
+		rbe := report_by_exception.ReportByException{}
+		Expect(rbe.Report("a", []byte("1"), true, start)).To(BeTrue())
+		Expect(rbe.Report("a", []byte("1"), true, start)).To(BeTrue())
+	})
+
+	It("should only report values that changed by more than the deadband", func() {
+		rbe := report_by_exception.ReportByException{Enabled: true, Deadband: 5}
+		Expect(rbe.Report("a", []byte("100"), true, start)).To(BeTrue())
+		Expect(rbe.Report("a", []byte("100"), true, start)).To(BeFalse())
+		Expect(rbe.Report("a", []byte("104"), true, start)).To(BeFalse())
+		Expect(rbe.Report("a", []byte("105"), true, start)).To(BeFalse())
+
+		// The deadband is relative to the last reported value, so slow drifts are reported as well
+		Expect(rbe.Report("a", []byte("106"), true, start)).To(BeTrue())
+		Expect(rbe.Report("a", []byte("101"), true, start)).To(BeFalse())
+		Expect(rbe.Report("a", []byte("100.5"), true, start)).To(BeTrue())
+
+		// Every key has its own last value
+		Expect(rbe.Report("b", []byte("104"), true, start)).To(BeTrue())
+	})
+
+	It("should report every change of non-numeric values", func() {
+		rbe := report_by_exception.ReportByException{Enabled: true, Deadband: 5}
+		Expect(rbe.Report("s", []byte("1"), false, start)).To(BeTrue())
+		Expect(rbe.Report("s", []byte("2"), false, start)).To(BeTrue())
+		Expect(rbe.Report("s", []byte("2"), false, start)).To(BeFalse())
+		Expect(rbe.Report("x", []byte("true"), false, start)).To(BeTrue())
+		Expect(rbe.Report("x", []byte("false"), false, start)).To(BeTrue())
+	})
+
+	It("should report changes from and to NaN", func() {
+		rbe := report_by_exception.ReportByException{Enabled: true, Deadband: 5}
+		Expect(rbe.Report("r", []byte("1.5"), true, start)).To(BeTrue())
+		Expect(rbe.Report("r", []byte("NaN"), true, start)).To(BeTrue())
+		Expect(rbe.Report("r", []byte("NaN"), true, start)).To(BeFalse())
+		Expect(rbe.Report("r", []byte("1.5"), true, start)).To(BeTrue())
+	})
+
+	It("should report unchanged values after the maximum silence interval", func() {
+		rbe := report_by_exception.ReportByException{Enabled: true, MaxSilenceInterval: time.Minute}
+		Expect(rbe.Report("a", []byte("1"), true, start)).To(BeTrue())
+		Expect(rbe.Report("a", []byte("1"), true, start.Add(59*time.Second))).To(BeFalse())
+		Expect(rbe.Report("a", []byte("1"), true, start.Add(time.Minute))).To(BeTrue())
+
+		// The interval starts again with the last report
+		Expect(rbe.Report("a", []byte("1"), true, start.Add(90*time.Second))).To(BeFalse())
+		Expect(rbe.Report("a", []byte("1"), true, start.Add(2*time.Minute))).To(BeTrue())
+	})
+})
@@ -11,6 +11,10 @@ const (
 	maxQuantityCoils            = uint16(2000)
 	maxQuantityInputRegisters   = uint16(125)
 	maxQuantityHoldingRegisters = uint16(125)
+
+	maxQuantityWriteCoils         = uint16(1968)
+	maxQuantityWriteRegisters     = uint16(123)
+	maxQuantityReadWriteRegisters = uint16(121)
 )
 
 func removeDuplicates(elements []uint16) []uint16 {
@@ -31,7 +35,8 @@ func normalizeInputDatatype(dataType string) (string, error) {
 	switch dataType {
 	case "BIT", "INT8L", "INT8H", "UINT8L", "UINT8H",
 		"INT16", "UINT16", "INT32", "UINT32", "INT64", "UINT64",
-		"FLOAT16", "FLOAT32", "FLOAT64", "STRING":
+		"FLOAT16", "FLOAT32", "FLOAT64", "STRING",
+		"BCD16", "BCD32", "UNIXTIME32", "UNIXTIME64", "BITFIELD":
 		return dataType, nil
 	}
 	return "unknown", fmt.Errorf("unknown input type %q", dataType)
@@ -58,15 +63,81 @@ func normalizeByteOrder(byteOrder string) (string, error) {
 	case "DCBA", "LSW-LE", "LSW": // Little endian (Intel)
 		return "DCBA", nil
 	}
+	if isByteOrder64(byteOrder) {
+		return byteOrder, nil
+	}
 	return "unknown", fmt.Errorf("unknown byte-order %q", byteOrder)
 }
 
+// isByteOrder64 checks whether the byte order lists the eight bytes of a 64-bit value, from A (most significant)
+// to H (least significant), in the order in which they are stored in the registers. Such byte orders cover any
+// word order of 64-bit values, e.g. 'CDABGHEF' for two 32-bit values with swapped words.
+func isByteOrder64(byteOrder string) bool {
+	if len(byteOrder) != 8 {
+		return false
+	}
+	var seen [8]bool
+	for _, c := range byteOrder {
+		if c < 'A' || c > 'H' || seen[c-'A'] {
+			return false
+		}
+		seen[c-'A'] = true
+	}
+	return true
+}
+
+// fieldByteOrder returns the byte order of a field of the given input type. The byte order of the field
+// overrides the inherited one. Byte orders of eight letters are kept as they are for 64-bit types. Other types
+// reject them if they are set on the field, while an inherited one is replaced by its equivalent of four letters.
+func fieldByteOrder(fieldOrder, inheritedOrder, inType string) (string, error) {
+	if fieldOrder != "" {
+		order, err := normalizeByteOrder(fieldOrder)
+		if err != nil {
+			return "unknown", err
+		}
+		if isByteOrder64(order) && !is64BitType(inType) {
+			return "unknown", fmt.Errorf("byte-order %q is only valid for 64-bit types", fieldOrder)
+		}
+		return order, nil
+	}
+
+	order, err := normalizeByteOrder(inheritedOrder)
+	if err != nil {
+		return "unknown", err
+	}
+	if !isByteOrder64(order) || is64BitType(inType) {
+		return order, nil
+	}
+	switch order {
+	case "ABCDEFGH":
+		return "ABCD", nil
+	case "BADCFEHG":
+		return "BADC", nil
+	case "GHEFCDAB":
+		return "CDAB", nil
+	case "HGFEDCBA":
+		return "DCBA", nil
+	}
+	return "unknown", fmt.Errorf("byte-order %q has no equivalent for types other than 64-bit types", inheritedOrder)
+}
+
+// is64BitType checks whether the input type occupies four registers.
+func is64BitType(inType string) bool {
+	switch inType {
+	case "INT64", "UINT64", "FLOAT64", "UNIXTIME64":
+		return true
+	}
+	return false
+}
+
 func tagID(seed maphash.Seed, item ModbusDataItemWithAddress) uint64 {
 	var mh maphash.Hash
 	mh.SetSeed(seed)
 
 	mh.WriteString(item.Register)
 	mh.WriteByte(0)
+	mh.WriteString(strconv.Itoa(int(item.File)))
+	mh.WriteByte(0)
 	mh.WriteString(strconv.Itoa(int(item.Address)))
 	mh.WriteByte(0)
 
@@ -78,11 +149,11 @@ func determineTagLength(input string, length uint16) (uint16, error) {
 	switch input {
 	case "BIT", "INT8L", "INT8H", "UINT8L", "UINT8H":
 		return 1, nil
-	case "INT16", "UINT16", "FLOAT16":
+	case "INT16", "UINT16", "FLOAT16", "BCD16", "BITFIELD":
 		return 1, nil
-	case "INT32", "UINT32", "FLOAT32":
+	case "INT32", "UINT32", "FLOAT32", "BCD32", "UNIXTIME32":
 		return 2, nil
-	case "INT64", "UINT64", "FLOAT64":
+	case "INT64", "UINT64", "FLOAT64", "UNIXTIME64":
 		return 4, nil
 	case "STRING":
 		return length, nil
@@ -95,12 +166,14 @@ func determineOutputDatatype(input string) (string, error) {
 	switch input {
 	case "INT8L", "INT8H", "INT16", "INT32", "INT64":
 		return "INT64", nil
-	case "BIT", "UINT8L", "UINT8H", "UINT16", "UINT32", "UINT64":
+	case "BIT", "UINT8L", "UINT8H", "UINT16", "UINT32", "UINT64", "BCD16", "BCD32":
 		return "UINT64", nil
 	case "FLOAT16", "FLOAT32", "FLOAT64":
 		return "FLOAT64", nil
-	case "STRING":
+	case "STRING", "UNIXTIME32", "UNIXTIME64":
 		return "STRING", nil
+	case "BITFIELD":
+		return "native", nil
 	}
 	return "unknown", fmt.Errorf("invalid input datatype %q for determining output", input)
 }
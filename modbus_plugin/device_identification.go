@@ -0,0 +1,256 @@
+// Copyright 2024 UMH Systems GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus_plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/grid-x/modbus"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+const (
+	funcCodeEncapsulatedInterface = 0x2B
+	meiTypeReadDeviceID           = 0x0E
+
+	readDeviceIDBasic   = 0x01 // VendorName, ProductCode and MajorMinorRevision
+	readDeviceIDRegular = 0x02 // The basic objects, VendorUrl, ProductName, ModelName and UserApplicationName
+
+	// maxDeviceIDResponses limits the number of responses of a device that splits its objects with "more follows"
+	maxDeviceIDResponses = 16
+)
+
+// deviceIDObjects are the objects of the basic and regular categories of Read Device Identification with the
+// metadata keys they are attached with.
+var deviceIDObjects = []struct {
+	id       byte
+	name     string
+	metadata string
+}{
+	{0x00, "VendorName", "modbus_device_vendor_name"},
+	{0x01, "ProductCode", "modbus_device_product_code"},
+	{0x02, "MajorMinorRevision", "modbus_device_major_minor_revision"},
+	{0x03, "VendorUrl", "modbus_device_vendor_url"},
+	{0x04, "ProductName", "modbus_device_product_name"},
+	{0x05, "ModelName", "modbus_device_model_name"},
+	{0x06, "UserApplicationName", "modbus_device_user_application_name"},
+}
+
+// DeviceIdentification configures reading the identification of the slaves with Read Device Identification
+// (FC 43 / MEI type 14).
+type DeviceIdentification struct {
+	Enabled  bool
+	Interval time.Duration // Interval in which the identification is read again. 0 only reads it after connecting.
+	Output   string        // "metadata" attaches the identification to every message, "message" emits it separately
+
+	// identities are the identifications by slave ID. They are reset on every connect.
+	identities map[byte]*deviceIdentity
+}
+
+type deviceIdentity struct {
+	objects     map[string]string // values by object name, e.g. "VendorName"
+	readAt      time.Time
+	unsupported bool
+}
+
+// deviceIdentificationField is the configuration of the device identification.
+func deviceIdentificationField() *service.ConfigField {
+	return service.NewObjectField("deviceIdentification",
+		service.NewBoolField("enabled").Description("Read the identification of each slave with Read Device Identification (FC 43/14) after connecting").Default(false),
+		service.NewDurationField("interval").Description("Interval in which the identification is read again. 0s only reads it after a (re)connect.").Default("1h"),
+		service.NewStringField("output").Description("'metadata' attaches the identification to every message of the slave, 'message' emits it as a separate message with the tag name 'deviceIdentification'").Default("metadata")).
+		Description("Device identification of the slaves. Slaves that do not support FC 43 are read without it.")
+}
+
+// parseDeviceIdentification parses the deviceIdentification object.
+func parseDeviceIdentification(conf *service.ParsedConfig) (DeviceIdentification, error) {
+	var identification DeviceIdentification
+	var err error
+
+	identificationConf := conf.Namespace("deviceIdentification")
+	if identification.Enabled, err = identificationConf.FieldBool("enabled"); err != nil {
+		return identification, err
+	}
+	if identification.Interval, err = identificationConf.FieldDuration("interval"); err != nil {
+		return identification, err
+	}
+	if identification.Output, err = identificationConf.FieldString("output"); err != nil {
+		return identification, err
+	}
+
+	if identification.Interval < 0 {
+		return identification, fmt.Errorf("interval of deviceIdentification must not be negative")
+	}
+	switch identification.Output {
+	case "metadata", "message":
+	default:
+		return identification, fmt.Errorf("invalid output %q of deviceIdentification, must be 'metadata' or 'message'", identification.Output)
+	}
+
+	return identification, nil
+}
+
+// reset forgets the identifications of all slaves, so that they are read again.
+func (d *DeviceIdentification) reset() {
+	d.identities = make(map[byte]*deviceIdentity)
+}
+
+// identifySlave reads the identification of the slave if it is due. In the message output, it returns the
+// message with the identification, otherwise nil.
+func (m *ModbusInput) identifySlave(slaveID byte) *service.Message {
+	d := &m.DeviceIdentification
+	if !d.Enabled {
+		return nil
+	}
+	if d.identities == nil {
+		d.reset()
+	}
+
+	identity, ok := d.identities[slaveID]
+	if ok && (identity.unsupported || d.Interval == 0 || time.Since(identity.readAt) < d.Interval) {
+		return nil
+	}
+	if !ok {
+		identity = &deviceIdentity{}
+		d.identities[slaveID] = identity
+	}
+
+	objects, err := m.readDeviceIdentification(slaveID)
+	identity.readAt = time.Now()
+	if err != nil {
+		var mbErr *modbus.Error
+		if errors.As(err, &mbErr) && (mbErr.ExceptionCode == modbus.ExceptionCodeIllegalFunction ||
+			mbErr.ExceptionCode == modbus.ExceptionCodeIllegalDataAddress ||
+			mbErr.ExceptionCode == modbus.ExceptionCodeIllegalDataValue) {
+			m.Log.Warnf("Slave %d does not support Read Device Identification (FC 43/14), reading it without identification: %v", slaveID, err)
+			identity.unsupported = true
+			return nil
+		}
+		// Keep the last identification and try again in the next interval
+		m.Log.Warnf("Failed to read the device identification of slave %d: %v", slaveID, err)
+		return nil
+	}
+
+	identity.objects = objects
+	m.Log.Infof("Device identification of slave %d: %v", slaveID, objects)
+
+	if d.Output != "message" {
+		return nil
+	}
+
+	payload, err := json.Marshal(objects)
+	if err != nil {
+		m.Log.Errorf("Could not marshal the device identification of slave %d: %v", slaveID, err)
+		return nil
+	}
+	message := service.NewMessage(payload)
+	message.MetaSet("modbus_tag_name", "deviceIdentification")
+	message.MetaSet("modbus_tag_name_original", "deviceIdentification")
+	message.MetaSet("modbus_tag_datatype", "string")
+	message.MetaSet("modbus_tag_datatype_json", "object")
+	message.MetaSet("modbus_tag_address", "auto-generated-device-identification")
+	message.MetaSet("modbus_tag_length", strconv.Itoa(len(payload)))
+	message.MetaSet("modbus_tag_register", "auto-generated")
+	message.MetaSet("modbus_tag_slaveid", strconv.Itoa(int(slaveID)))
+	return message
+}
+
+// attachDeviceIdentification adds the identification of the slave as metadata to its messages.
+func (m *ModbusInput) attachDeviceIdentification(slaveID byte, msgs service.MessageBatch) {
+	d := &m.DeviceIdentification
+	if !d.Enabled || d.Output != "metadata" {
+		return
+	}
+	identity, ok := d.identities[slaveID]
+	if !ok || len(identity.objects) == 0 {
+		return
+	}
+
+	for _, msg := range msgs {
+		for _, object := range deviceIDObjects {
+			if value, ok := identity.objects[object.name]; ok {
+				msg.MetaSet(object.metadata, value)
+			}
+		}
+	}
+}
+
+// readDeviceIdentification reads the objects of the regular category of a slave. Devices that only implement
+// the basic category are read with it instead.
+func (m *ModbusInput) readDeviceIdentification(slaveID byte) (map[string]string, error) {
+	m.SlaveMutex.Lock()
+	defer m.SlaveMutex.Unlock()
+
+	m.Handler.SetSlave(slaveID)
+	m.CurrentSlaveID = slaveID
+
+	objects, err := m.readDeviceIDObjects(readDeviceIDRegular)
+	var mbErr *modbus.Error
+	if errors.As(err, &mbErr) && mbErr.ExceptionCode == modbus.ExceptionCodeIllegalDataValue {
+		return m.readDeviceIDObjects(readDeviceIDBasic)
+	}
+	return objects, err
+}
+
+// readDeviceIDObjects reads all objects of a category. Devices whose objects do not fit into one response
+// set "more follows" and continue with the next object ID in the following request.
+func (m *ModbusInput) readDeviceIDObjects(category byte) (map[string]string, error) {
+	objects := make(map[string]string)
+	objectID := byte(0x00)
+
+	for i := 0; i < maxDeviceIDResponses; i++ {
+		response, err := sendRequest(m.Handler, &modbus.ProtocolDataUnit{
+			FunctionCode: funcCodeEncapsulatedInterface,
+			Data:         []byte{meiTypeReadDeviceID, category, objectID},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// MEI type, category, conformity level, more follows, next object ID and number of objects
+		data := response.Data
+		if len(data) < 6 || data[0] != meiTypeReadDeviceID {
+			return nil, fmt.Errorf("invalid device identification response % x", data)
+		}
+		moreFollows, nextObjectID, count := data[3], data[4], int(data[5])
+
+		// Each object consists of its ID, its length and its value
+		data = data[6:]
+		for j := 0; j < count; j++ {
+			if len(data) < 2 || len(data) < 2+int(data[1]) {
+				return nil, fmt.Errorf("truncated device identification response")
+			}
+			id, value := data[0], string(data[2:2+int(data[1])])
+			data = data[2+int(data[1]):]
+
+			for _, object := range deviceIDObjects {
+				if object.id == id {
+					objects[object.name] = value
+				}
+			}
+		}
+
+		if moreFollows != 0xFF {
+			return objects, nil
+		}
+		objectID = nextObjectID
+	}
+
+	return nil, fmt.Errorf("device identification did not end after %d responses", maxDeviceIDResponses)
+}
@@ -0,0 +1,150 @@
+// Copyright 2024 UMH Systems GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus_plugin
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/grid-x/modbus"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+const (
+	funcCodeReadFileRecord = 0x14
+	funcCodeReadFIFOQueue  = 0x18
+
+	fileRecordReferenceType = 0x06
+
+	// maxQuantityFIFO is the maximum number of registers in a FIFO queue
+	maxQuantityFIFO = uint16(31)
+	// maxQuantityFileRecord is the maximum number of registers of a record that fit into one response
+	maxQuantityFileRecord = uint16(124)
+	// maxFileRecordNumber is the highest record number of a file
+	maxFileRecordNumber = uint16(9999)
+)
+
+// isRawRegister checks whether the register is read with a function code that the client does not implement.
+func isRawRegister(register string) bool {
+	return register == "fifo" || register == "file"
+}
+
+// gatherRequestsFIFO reads the FIFO queues (FC 24). The queue of each field is split into records of the
+// length of the field's type, and every record is emitted as a separate message in the order of the queue.
+// If a request fails, the records of the queues read before are returned with the error.
+func (m *ModbusInput) gatherRequestsFIFO(fields []modbusTag, timeBetweenRequests time.Duration) (service.MessageBatch, error) {
+	msgs := service.MessageBatch{}
+
+	for _, field := range fields {
+		m.Log.Debugf("trying to read fifo@%v...", field.address)
+		response, err := sendRequest(m.Handler, &modbus.ProtocolDataUnit{
+			FunctionCode: funcCodeReadFIFOQueue,
+			Data:         binary.BigEndian.AppendUint16(nil, field.address),
+		})
+		if err != nil {
+			return msgs, err
+		}
+
+		// Byte count, FIFO count and the queued registers
+		data := response.Data
+		if len(data) < 4 {
+			return msgs, fmt.Errorf("fifo@%v: response too short: % x", field.address, data)
+		}
+		count := binary.BigEndian.Uint16(data[2:])
+		if count > maxQuantityFIFO || len(data) < 4+2*int(count) {
+			return msgs, fmt.Errorf("fifo@%v: invalid FIFO count %d for %d bytes", field.address, count, len(data)-4)
+		}
+		m.Log.Debugf("got fifo@%v[%v]: %v", field.address, count, data[4:4+2*count])
+
+		if count%field.length != 0 {
+			m.Log.Warnf("FIFO queue of field %s contains %d registers, which is not a multiple of the record length %d. Ignoring the incomplete record.", field.name, count, field.length)
+		}
+		for i := uint16(0); i+field.length <= count; i += field.length {
+			message := m.createMessageFromValue(field, data[4+2*i:4+2*(i+field.length)], "fifo")
+			if message != nil {
+				message.MetaSet("modbus_tag_fifo_index", strconv.Itoa(int(i/field.length)))
+				msgs = append(msgs, message)
+			}
+		}
+
+		// Sleep between requests to avoid flooding the device
+		if timeBetweenRequests > 0 {
+			time.Sleep(timeBetweenRequests)
+		}
+	}
+	return msgs, nil
+}
+
+// gatherRequestsFile reads the file records (FC 20). Every field is read with its own request, the record
+// number is the address of the field.
+func (m *ModbusInput) gatherRequestsFile(fields []modbusTag, timeBetweenRequests time.Duration) (service.MessageBatch, error) {
+	msgs := service.MessageBatch{}
+
+	for _, field := range fields {
+		m.Log.Debugf("trying to read file@%v/%v[%v]...", field.file, field.address, field.length)
+
+		// Byte count and one sub-request with reference type, file number, record number and record length
+		request := []byte{7, fileRecordReferenceType}
+		request = binary.BigEndian.AppendUint16(request, field.file)
+		request = binary.BigEndian.AppendUint16(request, field.address)
+		request = binary.BigEndian.AppendUint16(request, field.length)
+		response, err := sendRequest(m.Handler, &modbus.ProtocolDataUnit{
+			FunctionCode: funcCodeReadFileRecord,
+			Data:         request,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// Response data length and one sub-response with its length, reference type and the record data
+		data := response.Data
+		length := 2 * int(field.length)
+		if len(data) < 3+length || int(data[1]) != 1+length || data[2] != fileRecordReferenceType {
+			return nil, fmt.Errorf("file@%v/%v: invalid response % x", field.file, field.address, data)
+		}
+		m.Log.Debugf("got file@%v/%v[%v]: %v", field.file, field.address, field.length, data[3:3+length])
+
+		message := m.createMessageFromValue(field, data[3:3+length], "file")
+		if message != nil {
+			message.MetaSet("modbus_tag_file", strconv.Itoa(int(field.file)))
+			msgs = append(msgs, message)
+		}
+
+		// Sleep between requests to avoid flooding the device
+		if timeBetweenRequests > 0 {
+			time.Sleep(timeBetweenRequests)
+		}
+	}
+	return msgs, nil
+}
+
+// readsRawRegisters checks whether any address of the input or its slaves reads a FIFO queue or a file.
+func (m *ModbusInput) readsRawRegisters() bool {
+	for _, item := range m.Addresses {
+		if isRawRegister(item.Register) {
+			return true
+		}
+	}
+	for _, slave := range m.Slaves {
+		for _, item := range slave.Addresses {
+			if isRawRegister(item.Register) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,299 @@
+// Copyright 2024 UMH Systems GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus_plugin
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grid-x/modbus"
+	"github.com/grid-x/serial"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// SerialConfig holds the line settings of a serial controller (file:///dev/ttyUSB0).
+type SerialConfig struct {
+	BaudRate int    // Defaults to 19200
+	DataBits int    // 5, 6, 7 or 8. Defaults to 8.
+	Parity   string // "N" (none), "E" (even) or "O" (odd). Defaults to "E".
+	StopBits int    // 1 or 2. Defaults to 1.
+	RS485    RS485Config
+}
+
+// RS485Config holds the RTS settings for RS-485 transceivers that are controlled by the serial driver.
+type RS485Config struct {
+	Enabled            bool
+	DelayRtsBeforeSend time.Duration
+	DelayRtsAfterSend  time.Duration
+	RtsHighDuringSend  bool
+	RtsHighAfterSend   bool
+	RxDuringTx         bool
+}
+
+// serialConfigField is the configuration of the serial line, which is shared by all Modbus plugins
+// that can use a serial controller.
+func serialConfigField() *service.ConfigField {
+	return service.NewObjectField("serial",
+		service.NewIntField("baudRate").Description("Baud rate of the serial line").Default(19200),
+		service.NewIntField("dataBits").Description("Data bits: 5, 6, 7 or 8").Default(8),
+		service.NewStringField("parity").Description("Parity: 'N' (none), 'E' (even) or 'O' (odd)").Default("E"),
+		service.NewIntField("stopBits").Description("Stop bits: 1 or 2. The Modbus specification requires 2 stop bits if no parity is used.").Default(1),
+		service.NewObjectField("rs485",
+			service.NewBoolField("enabled").Description("Let the serial driver switch the RS-485 transceiver with the RTS line").Default(false),
+			service.NewDurationField("delayRtsBeforeSend").Description("Delay between setting RTS and sending").Default("0s"),
+			service.NewDurationField("delayRtsAfterSend").Description("Delay between sending and resetting RTS").Default("0s"),
+			service.NewBoolField("rtsHighDuringSend").Description("Set RTS high while sending").Default(true),
+			service.NewBoolField("rtsHighAfterSend").Description("Set RTS high after sending").Default(false),
+			service.NewBoolField("rxDuringTx").Description("Receive while sending").Default(false)).
+			Description("RS-485 settings of the serial driver. Only required for transceivers that are switched by RTS."),
+	).Description("Settings of the serial line. Only used if the controller is a serial device, e.g., 'file:///dev/ttyUSB0'.")
+}
+
+// parseSerialConfig parses the serial configuration, which is defined by serialConfigField.
+func parseSerialConfig(conf *service.ParsedConfig) (SerialConfig, error) {
+	var c SerialConfig
+	var err error
+
+	serialConf := conf.Namespace("serial")
+	if c.BaudRate, err = serialConf.FieldInt("baudRate"); err != nil {
+		return c, err
+	}
+	if c.DataBits, err = serialConf.FieldInt("dataBits"); err != nil {
+		return c, err
+	}
+	if c.Parity, err = serialConf.FieldString("parity"); err != nil {
+		return c, err
+	}
+	if c.StopBits, err = serialConf.FieldInt("stopBits"); err != nil {
+		return c, err
+	}
+
+	rs485Conf := serialConf.Namespace("rs485")
+	if c.RS485.Enabled, err = rs485Conf.FieldBool("enabled"); err != nil {
+		return c, err
+	}
+	if c.RS485.DelayRtsBeforeSend, err = rs485Conf.FieldDuration("delayRtsBeforeSend"); err != nil {
+		return c, err
+	}
+	if c.RS485.DelayRtsAfterSend, err = rs485Conf.FieldDuration("delayRtsAfterSend"); err != nil {
+		return c, err
+	}
+	if c.RS485.RtsHighDuringSend, err = rs485Conf.FieldBool("rtsHighDuringSend"); err != nil {
+		return c, err
+	}
+	if c.RS485.RtsHighAfterSend, err = rs485Conf.FieldBool("rtsHighAfterSend"); err != nil {
+		return c, err
+	}
+	if c.RS485.RxDuringTx, err = rs485Conf.FieldBool("rxDuringTx"); err != nil {
+		return c, err
+	}
+
+	return c, c.normalize()
+}
+
+// normalize checks the serial settings and fills in the defaults.
+func (c *SerialConfig) normalize() error {
+	if c.BaudRate == 0 {
+		c.BaudRate = 19200
+	}
+	if c.BaudRate < 0 {
+		return fmt.Errorf("invalid baud rate %d", c.BaudRate)
+	}
+
+	switch c.DataBits {
+	case 0:
+		c.DataBits = 8
+	case 5, 6, 7, 8:
+	default:
+		return fmt.Errorf("invalid data bits %d", c.DataBits)
+	}
+
+	switch strings.ToUpper(c.Parity) {
+	case "", "E", "EVEN":
+		c.Parity = "E"
+	case "N", "NONE":
+		c.Parity = "N"
+	case "O", "ODD":
+		c.Parity = "O"
+	default:
+		return fmt.Errorf("invalid parity %q", c.Parity)
+	}
+
+	switch c.StopBits {
+	case 0:
+		c.StopBits = 1
+	case 1, 2:
+	default:
+		return fmt.Errorf("invalid stop bits %d", c.StopBits)
+	}
+
+	return nil
+}
+
+// newClientHandler creates the handler for a controller address. TCP controllers (tcp://host:port) support
+// the transmission modes 'TCP', 'RTUoverTCP' and 'ASCIIoverTCP', serial controllers (file:///dev/ttyUSB0)
+// the transmission modes 'RTU' and 'ASCII'.
+func newClientHandler(controller string, transmissionMode string, timeout time.Duration, serialConfig SerialConfig) (modbus.ClientHandler, error) {
+	u, err := url.Parse(controller)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "tcp":
+		host, port, err := net.SplitHostPort(u.Host)
+		if err != nil {
+			return nil, err
+		}
+		switch transmissionMode {
+		case "", "auto", "TCP":
+			handler := modbus.NewTCPClientHandler(host + ":" + port)
+			handler.Timeout = timeout
+			return handler, nil
+		case "RTUoverTCP":
+			handler := modbus.NewRTUOverTCPClientHandler(host + ":" + port)
+			handler.Timeout = timeout
+			return handler, nil
+		case "ASCIIoverTCP":
+			handler := modbus.NewASCIIOverTCPClientHandler(host + ":" + port)
+			handler.Timeout = timeout
+			return handler, nil
+		default:
+			return nil, fmt.Errorf("invalid transmission mode %q for %q", transmissionMode, u.Scheme)
+		}
+	case "file":
+		// file:///dev/ttyUSB0 has the device in the path, file://COM1 in the host
+		address := u.Host + u.Path
+		if address == "" {
+			return nil, fmt.Errorf("missing serial device in controller %q", controller)
+		}
+		if err := serialConfig.normalize(); err != nil {
+			return nil, err
+		}
+		switch transmissionMode {
+		case "", "auto", "RTU":
+			handler := modbus.NewRTUClientHandler(address)
+			serialConfig.apply(&handler.Config)
+			handler.Timeout = timeout
+			return &rtuFrameGapHandler{ClientHandler: handler, gap: rtuFrameGap(serialConfig.BaudRate)}, nil
+		case "ASCII":
+			handler := modbus.NewASCIIClientHandler(address)
+			serialConfig.apply(&handler.Config)
+			handler.Timeout = timeout
+			return handler, nil
+		default:
+			return nil, fmt.Errorf("invalid transmission mode %q for %q", transmissionMode, u.Scheme)
+		}
+	default:
+		return nil, fmt.Errorf("invalid controller %q", controller)
+	}
+}
+
+// apply sets the serial settings in the configuration of a serial handler.
+func (c SerialConfig) apply(config *serial.Config) {
+	config.BaudRate = c.BaudRate
+	config.DataBits = c.DataBits
+	config.Parity = c.Parity
+	config.StopBits = c.StopBits
+	config.RS485 = serial.RS485Config{
+		Enabled:            c.RS485.Enabled,
+		DelayRtsBeforeSend: c.RS485.DelayRtsBeforeSend,
+		DelayRtsAfterSend:  c.RS485.DelayRtsAfterSend,
+		RtsHighDuringSend:  c.RS485.RtsHighDuringSend,
+		RtsHighAfterSend:   c.RS485.RtsHighAfterSend,
+		RxDuringTx:         c.RS485.RxDuringTx,
+	}
+}
+
+// rtuFrameGap returns the silent interval of 3.5 characters that Modbus RTU requires between two frames.
+// A character has 11 bits. Above 19200 baud, the specification recommends a fixed interval of 1.75ms.
+// See MODBUS over Serial Line - Specification and Implementation Guide, section 2.5.1.1.
+func rtuFrameGap(baudRate int) time.Duration {
+	if baudRate <= 0 || baudRate > 19200 {
+		return 1750 * time.Microsecond
+	}
+	return time.Duration(35*11) * time.Second / time.Duration(10*baudRate)
+}
+
+// rtuFrameGapHandler keeps the silent interval between the end of a response and the next request.
+// The RTU handler itself only waits for the response, so without the gap, a fast client can send the
+// next frame before slow slaves detected the end of the previous one.
+type rtuFrameGapHandler struct {
+	modbus.ClientHandler
+	gap time.Duration
+
+	mu        sync.Mutex
+	lastFrame time.Time
+}
+
+func (h *rtuFrameGapHandler) Send(aduRequest []byte) ([]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if wait := time.Until(h.lastFrame.Add(h.gap)); wait > 0 {
+		time.Sleep(wait)
+	}
+	defer func() { h.lastFrame = time.Now() }()
+
+	return h.ClientHandler.Send(aduRequest)
+}
+
+// sendRequest sends a request PDU over the handler and returns the response PDU. It is used for the function
+// codes that the client does not implement. Exception responses are returned as *modbus.Error.
+func sendRequest(handler modbus.ClientHandler, request *modbus.ProtocolDataUnit) (*modbus.ProtocolDataUnit, error) {
+	aduRequest, err := handler.Encode(request)
+	if err != nil {
+		return nil, err
+	}
+	aduResponse, err := handler.Send(aduRequest)
+	if err != nil {
+		return nil, err
+	}
+	if err := handler.Verify(aduRequest, aduResponse); err != nil {
+		return nil, err
+	}
+	response, err := handler.Decode(aduResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.FunctionCode != request.FunctionCode {
+		mbErr := &modbus.Error{FunctionCode: response.FunctionCode}
+		if len(response.Data) > 0 {
+			mbErr.ExceptionCode = response.Data[0]
+		}
+		return nil, mbErr
+	}
+	if len(response.Data) == 0 {
+		return nil, fmt.Errorf("modbus: response data is empty")
+	}
+	return response, nil
+}
+
+// supportsRawRequests checks whether the handler can receive the responses of sendRequest. RTU frames have
+// no delimiter, so the client only knows their length for the function codes that it implements.
+func supportsRawRequests(handler modbus.ClientHandler) bool {
+	switch h := handler.(type) {
+	case *rtuFrameGapHandler:
+		return supportsRawRequests(h.ClientHandler)
+	case *modbus.RTUClientHandler, *modbus.RTUOverTCPClientHandler, *modbus.RTUOverUDPClientHandler:
+		return false
+	}
+	return true
+}
@@ -21,12 +21,12 @@ package modbus_plugin
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"hash/maphash"
 	"math"
 	"net"
-	"net/url"
 	"reflect"
 	"regexp"
 	"strconv"
@@ -37,15 +37,18 @@ import (
 
 	"github.com/grid-x/modbus"
 	"github.com/redpanda-data/benthos/v4/public/service"
+
+	"github.com/united-manufacturing-hub/benthos-umh/internal/report_by_exception"
 )
 
-//The plugin supports connections to PLCs via MODBUS/TCP, RTU over TCP, ASCII over TCP
+//The plugin supports connections to PLCs via MODBUS/TCP, RTU over TCP, ASCII over TCP, and RTU or ASCII over serial lines
 
 // ModbusDataItemWithAddress struct defines the structure for the data items to be read from the Modbus device.
 type ModbusDataItemWithAddress struct {
 	Name     string // Field Name
-	Register string // Register type. Can be "coil", "discrete", "holding" or "input". Defaults to "holding".
-	Address  uint16 // Address of the register to query. For coil and discrete inputs this is the bit address.
+	Register string // Register type. Can be "coil", "discrete", "holding", "input", "fifo" or "file". Defaults to "holding".
+	Address  uint16 // Address of the register to query. For coil and discrete inputs this is the bit address, for files the record number.
+	File     uint16 // File number, ONLY valid for the file register.
 
 	// Type is the type of the modbus field
 	// Can be
@@ -54,6 +57,9 @@ type ModbusDataItemWithAddress struct {
 	//	INT16, UINT16, INT32, UINT32, INT64, UINT64 and
 	//	FLOAT16, FLOAT32, FLOAT64 (IEEE 754 binary representation)
 	//	STRING (byte-sequence converted to string)
+	//	BCD16, BCD32 (binary-coded decimal with 4 or 8 digits)
+	//	UNIXTIME32, UNIXTIME64 (seconds since the epoch converted to RFC3339)
+	//	BITFIELD (named bits of a register converted to a JSON object)
 	Type string
 
 	// Length is the number of registers, ONLY valid for STRING type. Defaults to 1.
@@ -62,6 +68,13 @@ type ModbusDataItemWithAddress struct {
 	// Bit is the bit of the register, ONLY valid for BIT type. Defaults to 0.
 	Bit uint16
 
+	// Bits are the bits of the register by name, ONLY valid for BITFIELD type.
+	Bits map[string]uint16
+
+	// ValueMap maps the decimal values of integer fields to names, e.g. of states. Values without a name
+	// are emitted as decimal string.
+	ValueMap map[string]string
+
 	// Scale is the factor to scale the variable with. Defaults to 1.0.
 	Scale float64
 
@@ -69,6 +82,14 @@ type ModbusDataItemWithAddress struct {
 	// "scale" is provided and to the input "type" class otherwise (i.e. INT* -> INT64, etc).
 	Output string
 
+	// ByteOrder overrides the byte order of the input or slave for this field. Besides the byte orders of the
+	// input, 64-bit types accept byte orders of eight letters like 'CDABGHEF'.
+	ByteOrder string
+
+	// PollGroup is the key of the poll group in which the field is read. Fields without a poll group and
+	// without an interval are read every TimeBetweenReads.
+	PollGroup string
+
 	ConverterFunc converterFunc
 }
 
@@ -80,8 +101,9 @@ type ModbusInput struct {
 	TimeBetweenReads time.Duration // The time between two reads of a Modbus device. Useful if you want to read the device every x seconds. Defaults to 1s. Not to be confused with TimeBetweenRequests.
 
 	// Standard
-	Controller       string        // e.g., "tcp://localhost:502"
-	TransmissionMode string        // Can be "TCP" (default), "RTUOverTCP", "ASCIIOverTCP"\
+	Controller       string        // e.g., "tcp://localhost:502" or "file:///dev/ttyUSB0"
+	TransmissionMode string        // Can be "TCP" (default), "RTUOverTCP", "ASCIIOverTCP" for TCP controllers and "RTU" (default), "ASCII" for serial controllers
+	Serial           SerialConfig  // Line settings for serial controllers
 	SlaveIDs         []byte        // This allows to fetch the same Addresses from different SlaveIDs
 	Timeout          time.Duration // Timeout for the connection
 	BusyRetries      int           // Maximum number of retries when the device is busy
@@ -130,6 +152,19 @@ type ModbusInput struct {
 	// They are creates based on the addresses and the optimization strategy
 	RequestSet RequestSet
 
+	// Slaves are the address sets per slave, which are read after the slaves of SlaveIDs
+	Slaves []*ModbusSlave
+
+	// PollGroups are the named polling intervals. Addresses with their own interval add unnamed groups.
+	PollGroups []*PollGroup
+	schedule   *pollSchedule
+
+	// ReportByException only emits the values of tags that changed since they were last reported
+	ReportByException report_by_exception.ReportByException
+
+	// DeviceIdentification reads the identification of the slaves with FC 43/14
+	DeviceIdentification DeviceIdentification
+
 	// Internal
 	Handler        modbus.ClientHandler
 	SlaveMutex     sync.Mutex // Add a mutex to avoid mixing up slave responses
@@ -137,6 +172,9 @@ type ModbusInput struct {
 	Client         modbus.Client
 	Log            *service.Logger
 
+	// pendingFIFO are the FIFO records that were read but not delivered yet, see readSlaveData
+	pendingFIFO service.MessageBatch
+
 	LastHeartbeatMessageReceived atomic.Uint32
 	LastMessageReceived          atomic.Uint32
 }
@@ -146,10 +184,17 @@ type RequestSet struct {
 	discrete []request
 	holding  []request
 	input    []request
+
+	// fifo and file are read with one request per tag
+	fifo []modbusTag
+	file []modbusTag
+
+	timeBetweenRequests time.Duration
 }
 
 type modbusTag struct {
 	name      string
+	file      uint16
 	address   uint16
 	length    uint16
 	omit      bool
@@ -166,9 +211,14 @@ var errAddressOverflow = errors.New("address overflow")
 var ModbusConfigSpec = service.NewConfigSpec().
 	Summary("Creates an input that reads data from Modbus devices. Created & maintained by the United Manufacturing Hub. About us: www.umh.app").
 	Description("This input plugin enables Benthos to read data directly from Modbus devices using the Modbus protocol.").
-	Field(service.NewDurationField("timeBetweenReads").Description("The time between two reads of a Modbus device. Useful if you want to read the device every x seconds. Not to be confused with TimeBetweenRequests.").Default("1s")).
-	Field(service.NewStringField("controller").Description("The Modbus controller address, e.g., 'tcp://localhost:502'").Default("tcp://localhost:502")).
-	Field(service.NewStringField("transmissionMode").Description("Transmission mode: 'TCP', 'RTUOverTCP', or 'ASCIIOverTCP'").Default("TCP")).
+	Field(service.NewDurationField("timeBetweenReads").Description("The time between two reads of a Modbus device. Useful if you want to read the device every x seconds. Not to be confused with TimeBetweenRequests. It is the interval of all addresses without a poll group or interval.").Default("1s")).
+	Field(service.NewObjectListField("pollGroups",
+		service.NewStringField("name").Description("Name of the poll group, which is referenced by the pollGroup of the addresses"),
+		service.NewDurationField("interval").Description("Polling interval of the addresses of the group")).
+		Description("Named polling intervals. Groups that are due at the same time are read together.").Default([]any{})).
+	Field(service.NewStringField("controller").Description("The Modbus controller address, e.g., 'tcp://localhost:502' or a serial device like 'file:///dev/ttyUSB0'").Default("tcp://localhost:502")).
+	Field(service.NewStringField("transmissionMode").Description("Transmission mode: 'TCP', 'RTUOverTCP', or 'ASCIIOverTCP' for TCP controllers, 'RTU' or 'ASCII' for serial controllers. Defaults to 'auto', which is 'TCP' for TCP controllers and 'RTU' for serial controllers.").Default("auto")).
+	Field(serialConfigField()).
 	Field(service.NewIntField("slaveID").Description("Slave ID of the Modbus device").Default(1)).
 	Field(service.NewIntListField("slaveIDs").Description("Slave ID of the Modbus device").Default([]int{1})).
 	Field(service.NewDurationField("timeout").Description("").Default("1s")).
@@ -184,16 +234,43 @@ var ModbusConfigSpec = service.NewConfigSpec().
 		service.NewStringField("stringRegisterLocation").Description("String byte-location in registers: 'lower', 'upper', or empty for both").Default(""),
 		service.NewDurationField("timeBetweenRequests").Description("imeBetweenRequests is the time between two requests to the same device. Useful to avoid flooding the device. Not to be confused with TimeBetweenReads.").Default("0s")).
 		Description("Modbus workarounds. Required by some devices to work correctly. Should be left alone by default and must not be changed unless necessary.")).
-	Field(service.NewObjectListField("addresses",
+	Field(report_by_exception.ConfigField("Report-by-exception mode. The last emitted values are kept per slave and tag in memory. The first poll after a (re)connect emits all values.")).
+	Field(deviceIdentificationField()).
+	Field(service.NewObjectListField("addresses", addressFields()...).
+		Description("List of Modbus addresses to read from the slaves of slaveIDs").Default([]any{})).
+	Field(service.NewObjectListField("slaves",
+		service.NewIntField("slaveID").Description("Slave ID of the Modbus device"),
+		service.NewStringField("byteOrder").Description("Byte order of the slave: 'ABCD', 'DCBA', 'BADC', or 'CDAB'. Defaults to the byteOrder of the input.").Default(""),
+		service.NewObjectField("workarounds",
+			service.NewBoolField("oneRequestPerField").Description("Send each field in a separate request").Optional(),
+			service.NewBoolField("readCoilsStartingAtZero").Description("Read coils starting at address 0 instead of 1").Optional(),
+			service.NewStringField("stringRegisterLocation").Description("String byte-location in registers: 'lower', 'upper', or empty for both").Optional(),
+			service.NewDurationField("timeBetweenRequests").Description("Time between two requests to the slave").Optional()).
+			Description("Workarounds of the slave. Workarounds that are not set are taken from the input.").Optional(),
+		service.NewObjectListField("addresses", addressFields()...).Description("List of Modbus addresses to read from the slave")).
+		Description("Address sets per slave. All slaves are read over the connection of the input, after the slaves of slaveIDs.").
+		Default([]any{}))
+
+// addressFields are the fields of an entry of the addresses, which are used by the input and by each entry
+// of the slaves list.
+func addressFields() []*service.ConfigField {
+	return []*service.ConfigField{
 		service.NewStringField("name").Description("Field name"),
-		service.NewStringField("register").Description("Register type: 'coil', 'discrete', 'holding', or 'input'").Default("holding"),
-		service.NewIntField("address").Description("Address of the register to query"),
+		service.NewStringField("register").Description("Register type: 'coil', 'discrete', 'holding', 'input', 'fifo' (Read FIFO Queue, FC 24) or 'file' (Read File Record, FC 20)").Default("holding"),
+		service.NewIntField("address").Description("Address of the register to query. For the file register, this is the record number."),
+		service.NewIntField("file").Description("File number, only valid for the file register").Default(0),
 		service.NewStringField("type").Description("Data type of the field"),
 		service.NewIntField("length").Description("Number of registers, only valid for STRING type").Default(0),
 		service.NewIntField("bit").Description("Bit of the register, only valid for BIT type").Default(0),
+		service.NewIntMapField("bits").Description("Bits of the register by name, only valid for BITFIELD type").Optional(),
+		service.NewStringMapField("valueMap").Description("Names of the values of an integer field, e.g. {'0': 'stopped', '1': 'running'}. Values without a name are emitted as decimal string.").Optional(),
 		service.NewFloatField("scale").Description("Factor to scale the variable with").Default(0.0),
-		service.NewStringField("output").Description("Type of resulting field: 'INT64', 'UINT64', 'FLOAT64', or 'native'").Default("")).
-		Description("List of Modbus addresses to read"))
+		service.NewStringField("output").Description("Type of resulting field: 'INT64', 'UINT64', 'FLOAT64', or 'native'").Default(""),
+		service.NewStringField("byteOrder").Description("Byte order of the field: 'ABCD', 'DCBA', 'BADC', or 'CDAB', or eight letters like 'CDABGHEF' for 64-bit types. Defaults to the byteOrder of the slave or input.").Default(""),
+		service.NewStringField("pollGroup").Description("Name of the poll group in which the field is read").Default(""),
+		service.NewDurationField("interval").Description("Polling interval of the field. Cannot be combined with pollGroup. Defaults to timeBetweenReads.").Optional(),
+	}
+}
 
 // newModbusInput is the constructor function for ModbusInput. It parses the plugin configuration,
 // establishes a connection with the Modbus device, and initializes the input plugin instance.
@@ -215,6 +292,9 @@ func newModbusInput(conf *service.ParsedConfig, mgr *service.Resources) (service
 	if m.TransmissionMode, err = conf.FieldString("transmissionMode"); err != nil {
 		return nil, err
 	}
+	if m.Serial, err = parseSerialConfig(conf); err != nil {
+		return nil, err
+	}
 
 	// slaveID only exist for backwards compatibility
 	var slaveIDs []int
@@ -270,13 +350,19 @@ func newModbusInput(conf *service.ParsedConfig, mgr *service.Resources) (service
 		return nil, err
 	}
 
+	if m.ReportByException, err = report_by_exception.Parse(conf); err != nil {
+		return nil, err
+	}
+	if m.DeviceIdentification, err = parseDeviceIdentification(conf); err != nil {
+		return nil, err
+	}
+
 	// These are the general checks for the configuration
-	switch m.ByteOrder {
-	case "":
+	if m.ByteOrder == "" {
 		m.ByteOrder = "ABCD"
-	case "ABCD", "DCBA", "BADC", "CDAB", "MSW-BE", "MSW-LE", "LSW-LE", "LSW-BE":
-	default:
-		return nil, fmt.Errorf("unknown byte-order %q", m.ByteOrder)
+	}
+	if _, err := normalizeByteOrder(m.ByteOrder); err != nil {
+		return nil, err
 	}
 
 	// These are the checks for the workarounds
@@ -301,17 +387,81 @@ func newModbusInput(conf *service.ParsedConfig, mgr *service.Resources) (service
 		return nil, fmt.Errorf("unknown optimization %q", m.Optimization)
 	}
 
+	// Read in the poll groups, which are referenced by the addresses
+	pollGroupsConf, err := conf.FieldObjectList("pollGroups")
+	if err != nil {
+		return nil, err
+	}
+	if m.PollGroups, err = parsePollGroups(pollGroupsConf); err != nil {
+		return nil, err
+	}
+
 	// Read in addresses
 	addressesConf, err := conf.FieldObjectList("addresses")
 	if err != nil {
 		return nil, err
 	}
+	if m.Addresses, err = m.parseAddresses(addressesConf); err != nil {
+		return nil, err
+	}
+
+	// Read in the address sets of the slaves list
+	slavesConf, err := conf.FieldObjectList("slaves")
+	if err != nil {
+		return nil, err
+	}
+	if m.Slaves, err = m.parseSlaves(slavesConf); err != nil {
+		return nil, err
+	}
 
 	// Reject any configuration without fields as it would be pointless
-	if len(addressesConf) == 0 {
+	if len(m.Addresses) == 0 && len(m.Slaves) == 0 {
 		return nil, fmt.Errorf("adresses are empty")
 	}
 
+	// Parse the addresses into batches
+	m.RequestSet, err = m.CreateBatchesFromAddresses(m.Addresses)
+	if err != nil {
+		m.Log.Errorf("Failed to create batches: %v", err)
+		return nil, err
+	}
+	m.logRequestSet(m.RequestSet)
+
+	for _, slave := range m.Slaves {
+		slave.RequestSet, err = m.createRequestSet(slave)
+		if err != nil {
+			m.Log.Errorf("Failed to create batches for slave %d: %v", slave.SlaveID, err)
+			return nil, err
+		}
+		m.Log.Infof("Requests of slave %d:", slave.SlaveID)
+		m.logRequestSet(slave.RequestSet)
+	}
+
+	m.schedule = m.newPollSchedule()
+
+	// Now set up the modbus client
+	m.Handler, err = newClientHandler(m.Controller, m.TransmissionMode, m.Timeout, m.Serial)
+	if err != nil {
+		return nil, err
+	}
+
+	m.Client = modbus.NewClient(m.Handler)
+
+	if m.DeviceIdentification.Enabled && !supportsRawRequests(m.Handler) {
+		return nil, fmt.Errorf("deviceIdentification is not supported with RTU framing, use the transmission mode 'TCP' or 'ASCII'")
+	}
+	if m.readsRawRegisters() && !supportsRawRequests(m.Handler) {
+		return nil, fmt.Errorf("fifo and file registers are not supported with RTU framing, use the transmission mode 'TCP' or 'ASCII'")
+	}
+
+	return service.AutoRetryNacksBatched(m), nil
+}
+
+// parseAddresses parses the addresses of the input or of an entry of the slaves list.
+func (m *ModbusInput) parseAddresses(addressesConf []*service.ParsedConfig) ([]ModbusDataItemWithAddress, error) {
+	var addresses []ModbusDataItemWithAddress
+	var err error
+
 	// used to de-duplicate
 	seenFields := make(map[uint64]bool)
 	seed := maphash.MakeSeed()
@@ -337,7 +487,7 @@ func newModbusInput(conf *service.ParsedConfig, mgr *service.Resources) (service
 		switch item.Register {
 		case "":
 			item.Register = "holding"
-		case "coil", "discrete", "holding", "input":
+		case "coil", "discrete", "holding", "input", "fifo", "file":
 		default:
 			return nil, fmt.Errorf("unknown register-type %q for field %q", item.Register, item.Name)
 		}
@@ -351,6 +501,25 @@ func newModbusInput(conf *service.ParsedConfig, mgr *service.Resources) (service
 			item.Address = uint16(addr) // Convert int to uint16
 		}
 
+		// File
+		if file, err := addrConf.FieldInt("file"); err != nil {
+			return nil, err
+		} else if file < 0 || file > 65535 { // Check if the value is within the range of uint16
+			return nil, fmt.Errorf("value out of range for uint16: %d", file)
+		} else {
+			item.File = uint16(file) // Convert int to uint16
+		}
+		if item.Register == "file" {
+			if item.File == 0 {
+				return nil, fmt.Errorf("missing file number for field %q", item.Name)
+			}
+			if item.Address > maxFileRecordNumber {
+				return nil, fmt.Errorf("record number %d of field %q out of range, must be at most %d", item.Address, item.Name, maxFileRecordNumber)
+			}
+		} else if item.File != 0 {
+			return nil, fmt.Errorf("file option cannot be used for register %q of field %q", item.Register, item.Name)
+		}
+
 		if item.Type, err = addrConf.FieldString("type"); err != nil {
 			return nil, err
 		}
@@ -377,11 +546,32 @@ func newModbusInput(conf *service.ParsedConfig, mgr *service.Resources) (service
 		if item.Output, err = addrConf.FieldString("output"); err != nil {
 			return nil, err
 		}
+		if addrConf.Contains("bits") {
+			if item.Bits, err = parseBits(addrConf, item.Name); err != nil {
+				return nil, err
+			}
+		}
+		if addrConf.Contains("valueMap") {
+			if item.ValueMap, err = parseValueMap(addrConf, item.Name); err != nil {
+				return nil, err
+			}
+		}
+		if item.ByteOrder, err = addrConf.FieldString("byteOrder"); err != nil {
+			return nil, err
+		}
+		if item.ByteOrder != "" {
+			if _, err := normalizeByteOrder(item.ByteOrder); err != nil {
+				return nil, fmt.Errorf("field %q: %w", item.Name, err)
+			}
+		}
+		if item.PollGroup, err = m.parsePollGroup(addrConf, item.Name); err != nil {
+			return nil, err
+		}
 
 		// Check the input and output type for all fields as we later need
 		// it to determine the number of registers to query.
 		switch item.Register {
-		case "holding", "input":
+		case "holding", "input", "fifo", "file":
 			// Check the input type
 			switch item.Type {
 			case "":
@@ -397,6 +587,42 @@ func newModbusInput(conf *service.ParsedConfig, mgr *service.Resources) (service
 				if item.Output == "STRING" {
 					return nil, fmt.Errorf("cannot output field %q as string", item.Name)
 				}
+			case "BCD16", "BCD32":
+				if item.Length != 0 {
+					return nil, fmt.Errorf("length option cannot be used for type %q of field %q", item.Type, item.Name)
+				}
+				if item.Bit != 0 {
+					return nil, fmt.Errorf("bit option cannot be used for type %q of field %q", item.Type, item.Name)
+				}
+				if item.Output == "STRING" {
+					return nil, fmt.Errorf("cannot output field %q as string", item.Name)
+				}
+			case "UNIXTIME32", "UNIXTIME64":
+				if item.Length != 0 {
+					return nil, fmt.Errorf("length option cannot be used for type %q of field %q", item.Type, item.Name)
+				}
+				if item.Bit != 0 {
+					return nil, fmt.Errorf("bit option cannot be used for type %q of field %q", item.Type, item.Name)
+				}
+				if item.Output != "" && item.Output != "STRING" {
+					return nil, fmt.Errorf("invalid output type %q for timestamp field %q", item.Output, item.Name)
+				}
+			case "BITFIELD":
+				if item.Length != 0 {
+					return nil, fmt.Errorf("length option cannot be used for type %q of field %q", item.Type, item.Name)
+				}
+				if item.Bit != 0 {
+					return nil, fmt.Errorf("bit option cannot be used for type %q of field %q, use bits instead", item.Type, item.Name)
+				}
+				if len(item.Bits) == 0 {
+					return nil, fmt.Errorf("missing bits for bitfield field %q", item.Name)
+				}
+				if item.Scale != 0.0 {
+					return nil, fmt.Errorf("scale option cannot be used for bitfield field %q", item.Name)
+				}
+				if item.Output != "" {
+					return nil, fmt.Errorf("output option cannot be used for bitfield field %q", item.Name)
+				}
 			case "STRING":
 				if item.Length < 1 {
 					return nil, fmt.Errorf("missing length for string field %q", item.Name)
@@ -421,12 +647,46 @@ func newModbusInput(conf *service.ParsedConfig, mgr *service.Resources) (service
 				return nil, fmt.Errorf("unknown register data-type %q for field %q", item.Type, item.Name)
 			}
 
+			if len(item.Bits) > 0 && item.Type != "BITFIELD" {
+				return nil, fmt.Errorf("bits option cannot be used for type %q of field %q", item.Type, item.Name)
+			}
+
+			// Value maps name the values of integer fields
+			if len(item.ValueMap) > 0 {
+				switch item.Type {
+				case "INT8L", "INT8H", "INT16", "INT32", "INT64",
+					"UINT8L", "UINT8H", "UINT16", "UINT32", "UINT64", "BCD16", "BCD32":
+				default:
+					return nil, fmt.Errorf("valueMap option cannot be used for type %q of field %q", item.Type, item.Name)
+				}
+				if item.Scale != 0.0 {
+					return nil, fmt.Errorf("valueMap option cannot be combined with scale for field %q", item.Name)
+				}
+				if item.Output == "FLOAT64" {
+					return nil, fmt.Errorf("valueMap option cannot be used with output type %q for field %q", item.Output, item.Name)
+				}
+			}
+
 			// Check output type
 			switch item.Output {
 			case "", "INT64", "UINT64", "FLOAT64", "STRING":
 			default:
 				return nil, fmt.Errorf("unknown output data-type %q for field %q", item.Output, item.Name)
 			}
+
+			// Records of FIFO queues and files have to fit into one response
+			if isRawRegister(item.Register) {
+				length, err := determineTagLength(item.Type, item.Length)
+				if err != nil {
+					return nil, fmt.Errorf("field %q: %w", item.Name, err)
+				}
+				if item.Register == "fifo" && length > maxQuantityFIFO {
+					return nil, fmt.Errorf("records of fifo field %q are too long: %d registers, at most %d are allowed", item.Name, length, maxQuantityFIFO)
+				}
+				if item.Register == "file" && length > maxQuantityFileRecord {
+					return nil, fmt.Errorf("records of file field %q are too long: %d registers, at most %d are allowed", item.Name, length, maxQuantityFileRecord)
+				}
+			}
 		case "coil", "discrete":
 			// Bit register types can only be UINT64 or BOOL
 			switch item.Output {
@@ -444,83 +704,103 @@ func newModbusInput(conf *service.ParsedConfig, mgr *service.Resources) (service
 			seenFields[tagID(seed, item)] = true
 		}
 
-		m.Addresses = append(m.Addresses, item)
+		addresses = append(addresses, item)
 	}
 
-	// Parse the addresses into batches
-	m.RequestSet, err = m.CreateBatchesFromAddresses(m.Addresses)
+	return addresses, nil
+}
+
+// parseBits parses the named bits of a BITFIELD field.
+func parseBits(addrConf *service.ParsedConfig, fieldName string) (map[string]uint16, error) {
+	bitsConf, err := addrConf.FieldIntMap("bits")
+	if err != nil {
+		return nil, err
+	}
+
+	bits := make(map[string]uint16, len(bitsConf))
+	for name, bit := range bitsConf {
+		if name == "" {
+			return nil, fmt.Errorf("empty bit name for field %q", fieldName)
+		}
+		if bit < 0 || bit > 15 {
+			return nil, fmt.Errorf("bit %q of field %q out of range: %d", name, fieldName, bit)
+		}
+		bits[name] = uint16(bit)
+	}
+	return bits, nil
+}
+
+// parseValueMap parses the value names of an integer field. The values are normalized to their decimal
+// representation, which is the key that the converted values are looked up with.
+func parseValueMap(addrConf *service.ParsedConfig, fieldName string) (map[string]string, error) {
+	valueMapConf, err := addrConf.FieldStringMap("valueMap")
 	if err != nil {
-		m.Log.Errorf("Failed to create batches: %v", err)
 		return nil, err
 	}
 
-	// Output debug messages
+	valueMap := make(map[string]string, len(valueMapConf))
+	for value, name := range valueMapConf {
+		var key string
+		if v, err := strconv.ParseInt(value, 0, 64); err == nil {
+			key = strconv.FormatInt(v, 10)
+		} else if v, err := strconv.ParseUint(value, 0, 64); err == nil {
+			key = strconv.FormatUint(v, 10)
+		} else {
+			return nil, fmt.Errorf("value %q in valueMap of field %q is not an integer", value, fieldName)
+		}
+		if _, exists := valueMap[key]; exists {
+			return nil, fmt.Errorf("duplicate value %q in valueMap of field %q", value, fieldName)
+		}
+		valueMap[key] = name
+	}
+	return valueMap, nil
+}
+
+// logRequestSet outputs debug messages about the requests of a request set.
+func (m *ModbusInput) logRequestSet(requests RequestSet) {
 	var nHoldingRegs, nInputsRegs, nDiscreteRegs, nCoilRegs uint16
 	var nHoldingFields, nInputsFields, nDiscreteFields, nCoilFields int
 
-	for _, r := range m.RequestSet.holding {
+	for _, r := range requests.holding {
 		nHoldingRegs += r.length
 		nHoldingFields += len(r.fields)
 	}
-	for _, r := range m.RequestSet.input {
+	for _, r := range requests.input {
 		nInputsRegs += r.length
 		nInputsFields += len(r.fields)
 	}
-	for _, r := range m.RequestSet.discrete {
+	for _, r := range requests.discrete {
 		nDiscreteRegs += r.length
 		nDiscreteFields += len(r.fields)
 	}
-	for _, r := range m.RequestSet.coil {
+	for _, r := range requests.coil {
 		nCoilRegs += r.length
 		nCoilFields += len(r.fields)
 	}
 	m.Log.Infof("Got %d request(s) touching %d holding registers for %d fields",
-		len(m.RequestSet.holding), nHoldingRegs, nHoldingFields)
+		len(requests.holding), nHoldingRegs, nHoldingFields)
 	m.Log.Infof("Got %d request(s) touching %d inputs registers for %d fields",
-		len(m.RequestSet.input), nInputsRegs, nInputsFields)
+		len(requests.input), nInputsRegs, nInputsFields)
 	m.Log.Infof("Got %d request(s) touching %d discrete registers for %d fields",
-		len(m.RequestSet.discrete), nDiscreteRegs, nDiscreteFields)
+		len(requests.discrete), nDiscreteRegs, nDiscreteFields)
 	m.Log.Infof("Got %d request(s) touching %d coil registers for %d fields",
-		len(m.RequestSet.coil), nCoilRegs, nCoilFields)
-
-	// Now set up the modbus client
-	u, err := url.Parse(m.Controller)
-	if err != nil {
-		return nil, err
+		len(requests.coil), nCoilRegs, nCoilFields)
+	if len(requests.fifo) > 0 {
+		m.Log.Infof("Got %d request(s) reading FIFO queues", len(requests.fifo))
 	}
-
-	switch u.Scheme {
-	case "tcp":
-		host, port, err := net.SplitHostPort(u.Host)
-		if err != nil {
-			return nil, err
-		}
-		switch m.TransmissionMode {
-		case "", "auto", "TCP":
-			handler := modbus.NewTCPClientHandler(host + ":" + port)
-			handler.Timeout = m.Timeout
-			m.Handler = handler
-		case "RTUoverTCP":
-			handler := modbus.NewRTUOverTCPClientHandler(host + ":" + port)
-			handler.Timeout = m.Timeout
-			m.Handler = handler
-		case "ASCIIoverTCP":
-			handler := modbus.NewASCIIOverTCPClientHandler(host + ":" + port)
-			handler.Timeout = m.Timeout
-			m.Handler = handler
-		default:
-			return nil, fmt.Errorf("invalid transmission mode %q for %q", m.TransmissionMode, u.Scheme)
-		}
-	default:
-		return nil, fmt.Errorf("invalid controller %q", m.Controller)
+	if len(requests.file) > 0 {
+		m.Log.Infof("Got %d request(s) reading file records", len(requests.file))
 	}
-
-	m.Client = modbus.NewClient(m.Handler)
-
-	return service.AutoRetryNacksBatched(m), nil
 }
 
+// CreateBatchesFromAddresses creates the requests for the addresses of the slaves in SlaveIDs, using the
+// byte order and the workarounds of the input.
 func (m *ModbusInput) CreateBatchesFromAddresses(addresses []ModbusDataItemWithAddress) (RequestSet, error) {
+	return m.createRequestSet(m.inputSlave(0, addresses))
+}
+
+// createRequestSet creates the requests for the addresses of a slave.
+func (m *ModbusInput) createRequestSet(slave *ModbusSlave) (RequestSet, error) {
 
 	// Create a map of requests for each register type
 	collection := make(map[string][]modbusTag)
@@ -528,10 +808,10 @@ func (m *ModbusInput) CreateBatchesFromAddresses(addresses []ModbusDataItemWithA
 	// Collect the requested registers across metrics and transform them into
 	// requests. This will produce one request per slave and register-type
 
-	for _, item := range addresses {
+	for _, item := range slave.Addresses {
 
 		// Create a new tag
-		tag, err := m.newTag(item)
+		tag, err := newTag(item, slave)
 		if err != nil {
 			return RequestSet{}, err
 		}
@@ -540,7 +820,7 @@ func (m *ModbusInput) CreateBatchesFromAddresses(addresses []ModbusDataItemWithA
 		collection[item.Register] = append(collection[item.Register], tag)
 	}
 
-	var result RequestSet
+	result := RequestSet{timeBetweenRequests: slave.TimeBetweenRequests}
 
 	// Create a request for each register type
 	params := groupingParams{
@@ -552,33 +832,37 @@ func (m *ModbusInput) CreateBatchesFromAddresses(addresses []ModbusDataItemWithA
 		switch register {
 		case "coil":
 			params.MaxBatchSize = maxQuantityCoils
-			if m.OneRequestPerField {
+			if slave.OneRequestPerField {
 				params.MaxBatchSize = 1
 			}
-			params.EnforceFromZero = m.ReadCoilsStartingAtZero
+			params.EnforceFromZero = slave.ReadCoilsStartingAtZero
 			requests := m.groupTagsToRequests(tags, params)
 			result.coil = append(result.coil, requests...)
 		case "discrete":
 			params.MaxBatchSize = maxQuantityDiscreteInput
-			if m.OneRequestPerField {
+			if slave.OneRequestPerField {
 				params.MaxBatchSize = 1
 			}
 			requests := m.groupTagsToRequests(tags, params)
 			result.discrete = append(result.discrete, requests...)
 		case "holding":
 			params.MaxBatchSize = maxQuantityHoldingRegisters
-			if m.OneRequestPerField {
+			if slave.OneRequestPerField {
 				params.MaxBatchSize = 1
 			}
 			requests := m.groupTagsToRequests(tags, params)
 			result.holding = append(result.holding, requests...)
 		case "input":
 			params.MaxBatchSize = maxQuantityInputRegisters
-			if m.OneRequestPerField {
+			if slave.OneRequestPerField {
 				params.MaxBatchSize = 1
 			}
 			requests := m.groupTagsToRequests(tags, params)
 			result.input = append(result.input, requests...)
+		case "fifo":
+			result.fifo = append(result.fifo, tags...)
+		case "file":
+			result.file = append(result.file, tags...)
 		default:
 			return RequestSet{}, fmt.Errorf("unknown register type %q", register)
 		}
@@ -587,8 +871,10 @@ func (m *ModbusInput) CreateBatchesFromAddresses(addresses []ModbusDataItemWithA
 	return result, nil
 }
 
-func (m *ModbusInput) newTag(item ModbusDataItemWithAddress) (modbusTag, error) {
-	typed := item.Register == "holding" || item.Register == "input"
+// newTag creates the tag of an address with the converter of its type. The byte order and the string
+// register location of the slave apply unless the address overrides them.
+func newTag(item ModbusDataItemWithAddress, slave *ModbusSlave) (modbusTag, error) {
+	typed := item.Register != "coil" && item.Register != "discrete"
 
 	fieldLength := uint16(1)
 	if typed {
@@ -598,14 +884,15 @@ func (m *ModbusInput) newTag(item ModbusDataItemWithAddress) (modbusTag, error)
 		}
 	}
 
-	// Check for address overflow
-	if item.Address > math.MaxUint16-fieldLength {
+	// Check for address overflow. The address of a FIFO queue is a pointer and does not span the records.
+	if item.Register != "fifo" && item.Address > math.MaxUint16-fieldLength {
 		return modbusTag{}, fmt.Errorf("%w for field %q", errAddressOverflow, item.Name)
 	}
 
 	// Initialize the field
 	f := modbusTag{
 		name:    item.Name,
+		file:    item.File,
 		address: item.Address,
 		length:  fieldLength,
 	}
@@ -632,17 +919,18 @@ func (m *ModbusInput) newTag(item ModbusDataItemWithAddress) (modbusTag, error)
 			}
 		} else {
 			// For scaling cases we always want FLOAT64 by default except for
-			// string fields
-			if item.Type != "STRING" {
-				item.Output = "FLOAT64"
-			} else {
+			// string and timestamp fields
+			switch item.Type {
+			case "STRING", "UNIXTIME32", "UNIXTIME64":
 				item.Output = "STRING"
+			default:
+				item.Output = "FLOAT64"
 			}
 		}
 	}
 
-	// Setting default byte-order
-	byteOrder := m.ByteOrder
+	// Setting default byte-order. The byte order of the field overrides the one of the slave.
+	byteOrder := slave.ByteOrder
 	if byteOrder == "" {
 		byteOrder = "ABCD"
 	}
@@ -656,14 +944,17 @@ func (m *ModbusInput) newTag(item ModbusDataItemWithAddress) (modbusTag, error)
 	if err != nil {
 		return modbusTag{}, err
 	}
-	order, err := normalizeByteOrder(byteOrder)
+	order, err := fieldByteOrder(item.ByteOrder, byteOrder, inType)
 	if err != nil {
-		return modbusTag{}, err
+		return modbusTag{}, fmt.Errorf("field %q: %w", item.Name, err)
 	}
 
-	f.converter, err = determineConverter(inType, order, outType, item.Scale, uint8(item.Bit), m.StringRegisterLocation)
+	f.converter, err = determineConverter(inType, order, outType, item.Scale, uint8(item.Bit), slave.StringRegisterLocation, item.Bits)
 	if err != nil {
-		return modbusTag{}, err
+		return modbusTag{}, fmt.Errorf("field %q: %w", item.Name, err)
+	}
+	if len(item.ValueMap) > 0 {
+		f.converter = determineConverterValueMap(f.converter, item.ValueMap)
 	}
 
 	return f, nil
@@ -682,6 +973,10 @@ func (m *ModbusInput) Connect(context.Context) error {
 
 	m.Log.Infof("Successfully connected to Modbus device at %s", m.Controller)
 
+	// The first poll after a (re)connect reports all values and reads the device identifications
+	m.ReportByException.Reset()
+	m.DeviceIdentification.reset()
+
 	return nil
 }
 
@@ -700,18 +995,36 @@ func (m *ModbusInput) ReadBatch(ctx context.Context) (service.MessageBatch, serv
 		return nil, nil, service.ErrNotConnected
 	}
 
-	// Wait at the beginning of each read cycle
-	if m.TimeBetweenReads > 0 {
-		time.Sleep(m.TimeBetweenReads)
+	// FIFO records that were kept from a failed read are delivered first, as they might not be read again
+	if len(m.pendingFIFO) > 0 {
+		msgs := m.pendingFIFO
+		m.pendingFIFO = nil
+		return msgs, func(ctx context.Context, err error) error {
+			return nil
+		}, nil
+	}
+
+	// Wait until the next poll groups are due
+	slaves, err := m.nextCycle(ctx)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// Initialize an empty MessageBatch to collect results from all slaves
 	var mergedBatch service.MessageBatch
+	gathered := 0
 
 	// Loop through all slaves
-	for _, slaveID := range m.SlaveIDs {
+	for _, slave := range slaves {
+		slaveID := slave.SlaveID
 		m.Log.Debugf("Reading slave %d for %s...", slaveID, m.Controller)
-		msgBatch, err := m.readSlaveData(slaveID, m.RequestSet)
+		if identityMessage := m.identifySlave(slaveID); identityMessage != nil {
+			mergedBatch = append(mergedBatch, identityMessage)
+		}
+		msgBatch, fifoBatch, err := m.readSlaveData(slaveID, slave.RequestSet)
+		gathered += len(fifoBatch)
+		m.attachDeviceIdentification(slaveID, fifoBatch)
+		m.pendingFIFO = append(m.pendingFIFO, fifoBatch...)
 		if err != nil {
 			m.Log.Errorf("slave %d encountered an error: %v", slaveID, err)
 
@@ -737,11 +1050,19 @@ func (m *ModbusInput) ReadBatch(ctx context.Context) (service.MessageBatch, serv
 			}
 		}
 
-		// Append the current slave's message batch to the merged batch
+		// Append the reported values of the current slave to the merged batch
+		gathered += len(msgBatch)
+		msgBatch = filterReported(&m.ReportByException, slaveID, msgBatch)
+		m.attachDeviceIdentification(slaveID, msgBatch)
 		mergedBatch = append(mergedBatch, msgBatch...)
 	}
 
-	if len(mergedBatch) > 0 {
+	// The FIFO records are delivered after the other values
+	mergedBatch = append(mergedBatch, m.pendingFIFO...)
+	m.pendingFIFO = nil
+
+	// The heartbeat is also sent if no value changed, as long as values were read
+	if gathered > 0 {
 		// Update the last heartbeat message received time
 		m.LastMessageReceived.Store(uint32(time.Now().Unix()))
 
@@ -764,24 +1085,27 @@ func (m *ModbusInput) ReadBatch(ctx context.Context) (service.MessageBatch, serv
 	}, nil
 }
 
-func (m *ModbusInput) readSlaveData(slaveID byte, requests RequestSet) (msgBatch service.MessageBatch, err error) {
+// readSlaveData reads the tags of a slave and then its FIFO queues. FIFO queues are read last and only once, as
+// devices may advance a queue with every read. Their records are returned separately, even if reading one of the
+// queues fails, so that the caller can keep them until they are delivered.
+func (m *ModbusInput) readSlaveData(slaveID byte, requests RequestSet) (msgBatch, fifoBatch service.MessageBatch, err error) {
 	m.SlaveMutex.Lock()
 	defer m.SlaveMutex.Unlock()
 
 	m.Handler.SetSlave(slaveID)
 	m.CurrentSlaveID = slaveID
 
-	for retry := 0; retry < m.BusyRetries; retry++ {
+	for retry := 0; ; retry++ {
 		msgBatch, err = m.gatherTags(requests)
 		if err == nil {
 			// Reading was successful
-			return msgBatch, nil
+			break
 		}
 
-		// Exit in case a non-recoverable error occurred
+		// Exit in case a non-recoverable error occurred or no retries are left
 		var mbErr *modbus.Error
-		if !errors.As(err, &mbErr) || mbErr.ExceptionCode != modbus.ExceptionCodeServerDeviceBusy {
-			return nil, err
+		if retry >= m.BusyRetries || !errors.As(err, &mbErr) || mbErr.ExceptionCode != modbus.ExceptionCodeServerDeviceBusy {
+			return nil, nil, err
 		}
 
 		// Wait some time and try again reading the slave.
@@ -789,11 +1113,16 @@ func (m *ModbusInput) readSlaveData(slaveID byte, requests RequestSet) (msgBatch
 		time.Sleep(m.BusyRetriesWait)
 	}
 
-	msgBatch, err = m.gatherTags(requests)
-	return msgBatch, err
+	fifoBatch, err = m.gatherRequestsFIFO(requests.fifo, requests.timeBetweenRequests)
+	return msgBatch, fifoBatch, err
 }
 
 func (m *ModbusInput) createMessageFromValue(item modbusTag, rawValue []byte, registerName string) *service.Message {
+	return newTagMessage(m.Log, item, rawValue, registerName, m.CurrentSlaveID)
+}
+
+// newTagMessage converts the raw value of a tag and creates a message with the metadata of the tag.
+func newTagMessage(log *service.Logger, item modbusTag, rawValue []byte, registerName string, slaveID byte) *service.Message {
 
 	value := item.converter(rawValue)
 
@@ -843,12 +1172,23 @@ func (m *ModbusInput) createMessageFromValue(item modbusTag, rawValue []byte, re
 	case uint64:
 		b = append(b, []byte(strconv.FormatUint(v, 10))...)
 		tagType = "number"
+	case map[string]bool:
+		bitfield, err := json.Marshal(v)
+		if err != nil {
+			log.Errorf("Could not marshal bitfield of item %s: %v", item.name, err)
+			return nil
+		}
+		b = append(b, bitfield...)
+		tagType = "object"
+	case error:
+		log.Warnf("Could not convert item %s in register %s: %v", item.name, registerName, v)
+		return nil
 	default:
-		m.Log.Errorf("Unknown type %T for item %s: %v", v, item.name, v)
+		log.Errorf("Unknown type %T for item %s: %v", v, item.name, v)
 	}
 
 	if b == nil {
-		m.Log.Errorf("Could not create benthos message as payload is empty for item %s in register %s: %v", item.name, registerName, b)
+		log.Errorf("Could not create benthos message as payload is empty for item %s in register %s: %v", item.name, registerName, b)
 		return nil
 	}
 
@@ -856,14 +1196,14 @@ func (m *ModbusInput) createMessageFromValue(item modbusTag, rawValue []byte, re
 	originalDataType := reflect.TypeOf(value).String()
 
 	message := service.NewMessage(b)
-	message.MetaSet("modbus_tag_name", sanitize(item.name))                    // This is the tag name without special characters
-	message.MetaSet("modbus_tag_name_original", item.name)                     // This is the tag name without any changes
-	message.MetaSet("modbus_tag_datatype", originalDataType)                   // This is the original data type in Modbus
-	message.MetaSet("modbus_tag_datatype_json", tagType)                       // This is the data type for JSONs. Either number, bool or string
-	message.MetaSet("modbus_tag_address", strconv.Itoa(int(item.address)))     // This is the address of the tag
-	message.MetaSet("modbus_tag_length", strconv.Itoa(int(item.length)))       // This is the length of the tag
-	message.MetaSet("modbus_tag_register", registerName)                       // This is the register where the tag is located
-	message.MetaSet("modbus_tag_slaveid", strconv.Itoa(int(m.CurrentSlaveID))) // This is the slaveID that we are currently reading
+	message.MetaSet("modbus_tag_name", sanitize(item.name))                // This is the tag name without special characters
+	message.MetaSet("modbus_tag_name_original", item.name)                 // This is the tag name without any changes
+	message.MetaSet("modbus_tag_datatype", originalDataType)               // This is the original data type in Modbus
+	message.MetaSet("modbus_tag_datatype_json", tagType)                   // This is the data type for JSONs. Either number, bool, string or object
+	message.MetaSet("modbus_tag_address", strconv.Itoa(int(item.address))) // This is the address of the tag
+	message.MetaSet("modbus_tag_length", strconv.Itoa(int(item.length)))   // This is the length of the tag
+	message.MetaSet("modbus_tag_register", registerName)                   // This is the register where the tag is located
+	message.MetaSet("modbus_tag_slaveid", strconv.Itoa(int(slaveID)))      // This is the slaveID that we are currently reading
 
 	return message
 }
@@ -874,19 +1214,23 @@ func sanitize(s string) string {
 }
 
 func (m *ModbusInput) gatherTags(requests RequestSet) (service.MessageBatch, error) {
-	msgBatchCoil, err := m.gatherRequestsCoil(requests.coil)
+	msgBatchCoil, err := m.gatherRequestsCoil(requests.coil, requests.timeBetweenRequests)
+	if err != nil {
+		return nil, err
+	}
+	msgBatchDiscrete, err := m.gatherRequestsDiscrete(requests.discrete, requests.timeBetweenRequests)
 	if err != nil {
 		return nil, err
 	}
-	msgBatchDiscrete, err := m.gatherRequestsDiscrete(requests.discrete)
+	msgBatchHolding, err := m.gatherRequestsHolding(requests.holding, requests.timeBetweenRequests)
 	if err != nil {
 		return nil, err
 	}
-	msgBatchHolding, err := m.gatherRequestsHolding(requests.holding)
+	msgBatchInput, err := m.gatherRequestsInput(requests.input, requests.timeBetweenRequests)
 	if err != nil {
 		return nil, err
 	}
-	msgBatchInput, err := m.gatherRequestsInput(requests.input)
+	msgBatchFile, err := m.gatherRequestsFile(requests.file, requests.timeBetweenRequests)
 	if err != nil {
 		return nil, err
 	}
@@ -894,11 +1238,12 @@ func (m *ModbusInput) gatherTags(requests RequestSet) (service.MessageBatch, err
 	msgBatch := append(msgBatchCoil, msgBatchDiscrete...)
 	msgBatch = append(msgBatch, msgBatchHolding...)
 	msgBatch = append(msgBatch, msgBatchInput...)
+	msgBatch = append(msgBatch, msgBatchFile...)
 
 	return msgBatch, nil
 }
 
-func (m *ModbusInput) gatherRequestsCoil(requests []request) (service.MessageBatch, error) {
+func (m *ModbusInput) gatherRequestsCoil(requests []request, timeBetweenRequests time.Duration) (service.MessageBatch, error) {
 	msgs := service.MessageBatch{}
 
 	for _, request := range requests {
@@ -927,14 +1272,14 @@ func (m *ModbusInput) gatherRequestsCoil(requests []request) (service.MessageBat
 		}
 
 		// Sleep between requests to avoid flooding the device
-		if m.TimeBetweenRequests > 0 {
-			time.Sleep(m.TimeBetweenRequests)
+		if timeBetweenRequests > 0 {
+			time.Sleep(timeBetweenRequests)
 		}
 	}
 	return msgs, nil
 }
 
-func (m *ModbusInput) gatherRequestsDiscrete(requests []request) (service.MessageBatch, error) {
+func (m *ModbusInput) gatherRequestsDiscrete(requests []request, timeBetweenRequests time.Duration) (service.MessageBatch, error) {
 	msgs := service.MessageBatch{}
 
 	for _, request := range requests {
@@ -963,14 +1308,14 @@ func (m *ModbusInput) gatherRequestsDiscrete(requests []request) (service.Messag
 		}
 
 		// Sleep between requests to avoid flooding the device
-		if m.TimeBetweenRequests > 0 {
-			time.Sleep(m.TimeBetweenRequests)
+		if timeBetweenRequests > 0 {
+			time.Sleep(timeBetweenRequests)
 		}
 	}
 	return msgs, nil
 }
 
-func (m *ModbusInput) gatherRequestsHolding(requests []request) (service.MessageBatch, error) {
+func (m *ModbusInput) gatherRequestsHolding(requests []request, timeBetweenRequests time.Duration) (service.MessageBatch, error) {
 	msgs := service.MessageBatch{}
 
 	for _, request := range requests {
@@ -999,14 +1344,14 @@ func (m *ModbusInput) gatherRequestsHolding(requests []request) (service.Message
 		}
 
 		// Sleep between requests to avoid flooding the device
-		if m.TimeBetweenRequests > 0 {
-			time.Sleep(m.TimeBetweenRequests)
+		if timeBetweenRequests > 0 {
+			time.Sleep(timeBetweenRequests)
 		}
 	}
 	return msgs, nil
 }
 
-func (m *ModbusInput) gatherRequestsInput(requests []request) (service.MessageBatch, error) {
+func (m *ModbusInput) gatherRequestsInput(requests []request, timeBetweenRequests time.Duration) (service.MessageBatch, error) {
 	msgs := service.MessageBatch{}
 
 	for _, request := range requests {
@@ -1035,8 +1380,8 @@ func (m *ModbusInput) gatherRequestsInput(requests []request) (service.MessageBa
 		}
 
 		// Sleep between requests to avoid flooding the device
-		if m.TimeBetweenRequests > 0 {
-			time.Sleep(m.TimeBetweenRequests)
+		if timeBetweenRequests > 0 {
+			time.Sleep(timeBetweenRequests)
 		}
 	}
 	return msgs, nil
@@ -0,0 +1,115 @@
+package modbus_plugin_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	_ "github.com/redpanda-data/benthos/v4/public/components/pure"
+	"github.com/redpanda-data/benthos/v4/public/service"
+
+	_ "github.com/united-manufacturing-hub/benthos-umh/modbus_plugin"
+)
+
+var _ = Describe("Byte order per address", func() {
+	It("should override the byte order of the input for single addresses", func() {
+		slave, controller := startTCPSlave()
+		// 1.5 as FLOAT32 in CDAB
+		slave.holding[0] = 0x0000
+		slave.holding[1] = 0x3FC0
+		// 0x0102030405060708 in ABCD
+		slave.holding[10] = 0x0102
+		slave.holding[11] = 0x0304
+		slave.holding[12] = 0x0506
+		slave.holding[13] = 0x0708
+		// 0x0102030405060708 as two 32-bit values with swapped words
+		slave.holding[20] = 0x0304
+		slave.holding[21] = 0x0102
+		slave.holding[22] = 0x0708
+		slave.holding[23] = 0x0506
+
+		mu, msgs := runModbusStream(fmt.Sprintf(`
+modbus:
+  controller: '%s'
+  timeBetweenReads: '100ms'
+  byteOrder: 'ABCD'
+  addresses:
+    - name: speed
+      register: holding
+      address: 0
+      type: FLOAT32
+      byteOrder: 'CDAB'
+    - name: counter
+      register: holding
+      address: 10
+      type: UINT64
+    - name: energy
+      register: holding
+      address: 20
+      type: UINT64
+      byteOrder: 'CDABGHEF'
+`, controller))
+
+		Eventually(func() map[string]string {
+			return valuesByTag(mu, msgs)
+		}, 5*time.Second, 100*time.Millisecond).Should(And(
+			HaveKeyWithValue("speed", "1.5"),
+			HaveKeyWithValue("counter", "72623859790382856"),
+			HaveKeyWithValue("energy", "72623859790382856"),
+		))
+	})
+
+	It("should write 64-bit values in the word order of the address", func() {
+		slave, controller := startTCPSlave()
+		produce := runModbusOutput(fmt.Sprintf(`
+modbus:
+  controller: '%s'
+  addresses:
+    - name: energy
+      register: holding
+      address: 20
+      type: UINT64
+      byteOrder: 'EFGHABCD'
+`, controller))
+
+		Expect(writeMessage(produce, "72623859790382856", map[string]string{"modbus_tag_name": "energy"})).To(Succeed())
+		Expect([]uint16{
+			slave.holdingRegister(20), slave.holdingRegister(21), slave.holdingRegister(22), slave.holdingRegister(23),
+		}).To(Equal([]uint16{0x0506, 0x0708, 0x0102, 0x0304}))
+	})
+
+	DescribeTable("should reject invalid byte orders", func(inputYAML, expectedErr string) {
+		builder := service.NewStreamBuilder()
+		Expect(builder.AddInputYAML("modbus:\n" + inputYAML)).To(Succeed())
+		Expect(builder.SetLoggerYAML(`level: off`)).To(Succeed())
+		Expect(builder.AddConsumerFunc(func(context.Context, *service.Message) error { return nil })).To(Succeed())
+
+		stream, err := builder.Build()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stream.Run(context.Background())).To(MatchError(ContainSubstring(expectedErr)))
+	},
+		Entry("unknown byte order", `
+  addresses:
+    - {name: a, address: 1, type: UINT16, byteOrder: 'ABDC'}
+`, `field "a": unknown byte-order "ABDC"`),
+		Entry("repeated letter", `
+  addresses:
+    - {name: a, address: 1, type: UINT64, byteOrder: 'AACDEFGH'}
+`, `field "a": unknown byte-order "AACDEFGH"`),
+		Entry("64-bit byte order for a 32-bit type", `
+  addresses:
+    - {name: a, address: 1, type: UINT32, byteOrder: 'CDABGHEF'}
+`, `field "a": byte-order "CDABGHEF" is only valid for 64-bit types`),
+		Entry("64-bit byte order with a 32-bit equivalent for a 32-bit type", `
+  addresses:
+    - {name: a, address: 1, type: INT32, byteOrder: 'ABCDEFGH'}
+`, `field "a": byte-order "ABCDEFGH" is only valid for 64-bit types`),
+		Entry("inherited 64-bit byte order without a 32-bit equivalent", `
+  byteOrder: 'CDABGHEF'
+  addresses:
+    - {name: a, address: 1, type: UINT32}
+`, `field "a": byte-order "CDABGHEF" has no equivalent for types other than 64-bit types`),
+	)
+})
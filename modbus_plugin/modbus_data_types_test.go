@@ -0,0 +1,122 @@
+package modbus_plugin_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	_ "github.com/redpanda-data/benthos/v4/public/components/pure"
+	"github.com/redpanda-data/benthos/v4/public/service"
+
+	_ "github.com/united-manufacturing-hub/benthos-umh/modbus_plugin"
+)
+
+var _ = Describe("BCD, timestamp, bitfield and value map types", func() {
+	It("should convert the registers of the special types", func() {
+		slave, controller := startTCPSlave()
+		slave.holding[0] = 0x1234
+		slave.holding[1] = 0x0012
+		slave.holding[2] = 0x3456
+		slave.holding[3] = 0x12A4
+		// 1700000000 seconds
+		slave.holding[10] = 0x6553
+		slave.holding[11] = 0xF100
+		// 1700000000123 milliseconds
+		slave.holding[20] = 0x0000
+		slave.holding[21] = 0x018B
+		slave.holding[22] = 0xCFE5
+		slave.holding[23] = 0x687B
+		slave.holding[30] = 0x0009
+		slave.holding[31] = 1
+		slave.holding[32] = 7
+
+		mu, msgs := runModbusStream(fmt.Sprintf(`
+modbus:
+  controller: '%s'
+  timeBetweenReads: '100ms'
+  addresses:
+    - {name: bcd16, address: 0, type: BCD16}
+    - {name: bcd32, address: 1, type: BCD32, scale: 0.01}
+    - {name: invalidBcd, address: 3, type: BCD16}
+    - {name: seconds, address: 10, type: UNIXTIME32}
+    - {name: milliseconds, address: 20, type: UNIXTIME64, scale: 0.001}
+    - name: status
+      address: 30
+      type: BITFIELD
+      bits:
+        running: 0
+        warning: 1
+        fault: 3
+    - name: state
+      address: 31
+      type: UINT16
+      valueMap:
+        0: stopped
+        1: running
+    - name: unknownState
+      address: 32
+      type: UINT16
+      valueMap:
+        0: stopped
+`, controller))
+
+		Eventually(func() map[string]string {
+			return valuesByTag(mu, msgs)
+		}, 5*time.Second, 100*time.Millisecond).Should(And(
+			HaveKeyWithValue("bcd16", "1234"),
+			HaveKeyWithValue("bcd32", "1234.56"),
+			HaveKeyWithValue("seconds", "2023-11-14T22:13:20Z"),
+			HaveKeyWithValue("milliseconds", "2023-11-14T22:13:20.123Z"),
+			HaveKeyWithValue("status", `{"fault":true,"running":true,"warning":false}`),
+			HaveKeyWithValue("state", "running"),
+			HaveKeyWithValue("unknownState", "7"),
+		))
+
+		// Invalid BCD digits are dropped instead of being emitted as wrong value
+		Expect(valuesByTag(mu, msgs)).NotTo(HaveKey("invalidBcd"))
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, msg := range *msgs {
+			if tagName, _ := msg.MetaGet("modbus_tag_name"); tagName == "status" {
+				dataType, _ := msg.MetaGet("modbus_tag_datatype_json")
+				Expect(dataType).To(Equal("object"))
+			}
+		}
+	})
+
+	DescribeTable("should reject invalid options of the special types", func(addressYAML, expectedErr string) {
+		builder := service.NewStreamBuilder()
+		Expect(builder.AddInputYAML(`
+modbus:
+  addresses:
+` + addressYAML)).To(Succeed())
+		Expect(builder.SetLoggerYAML(`level: off`)).To(Succeed())
+		Expect(builder.AddConsumerFunc(func(context.Context, *service.Message) error { return nil })).To(Succeed())
+
+		stream, err := builder.Build()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stream.Run(context.Background())).To(MatchError(ContainSubstring(expectedErr)))
+	},
+		Entry("bitfield without bits", `
+    - {name: a, address: 1, type: BITFIELD}
+`, `missing bits for bitfield field "a"`),
+		Entry("bit out of range", `
+    - {name: a, address: 1, type: BITFIELD, bits: {fault: 16}}
+`, `bit "fault" of field "a" out of range: 16`),
+		Entry("bits for another type", `
+    - {name: a, address: 1, type: UINT16, bits: {fault: 1}}
+`, `bits option cannot be used for type "UINT16" of field "a"`),
+		Entry("value map for a float", `
+    - {name: a, address: 1, type: FLOAT32, valueMap: {1: on}}
+`, `valueMap option cannot be used for type "FLOAT32" of field "a"`),
+		Entry("value map with a non-integer value", `
+    - {name: a, address: 1, type: UINT16, valueMap: {one: on}}
+`, `value "one" in valueMap of field "a" is not an integer`),
+		Entry("timestamp as number", `
+    - {name: a, address: 1, type: UNIXTIME32, output: INT64}
+`, `invalid output type "INT64" for timestamp field "a"`),
+	)
+})
@@ -0,0 +1,168 @@
+package modbus_plugin_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	_ "github.com/redpanda-data/benthos/v4/public/components/pure"
+	"github.com/redpanda-data/benthos/v4/public/service"
+
+	_ "github.com/united-manufacturing-hub/benthos-umh/modbus_plugin"
+)
+
+// runModbusInputError builds a stream with the input and returns the error of running it.
+func runModbusInputError(inputYAML string) error {
+	builder := service.NewStreamBuilder()
+	Expect(builder.AddInputYAML(inputYAML)).To(Succeed())
+	Expect(builder.SetLoggerYAML(`level: off`)).To(Succeed())
+	Expect(builder.AddConsumerFunc(func(context.Context, *service.Message) error { return nil })).To(Succeed())
+
+	stream, err := builder.Build()
+	Expect(err).NotTo(HaveOccurred())
+	return stream.Run(context.Background())
+}
+
+// deviceIDRequests returns the number of Read Device Identification requests by slave ID.
+func deviceIDRequests(slave *testSlave) map[byte]int {
+	requests := map[byte]int{}
+	for _, request := range slave.requestLog() {
+		if request.functionCode == 0x2B {
+			requests[request.slaveID]++
+		}
+	}
+	return requests
+}
+
+var _ = Describe("Device identification", func() {
+	It("should attach the identification of the slave to every message", func() {
+		slave, controller := startTCPSlave()
+		slave.holding[10] = 42
+		slave.deviceID[1] = map[byte]string{
+			0x00: "UMH",
+			0x01: "PM-100",
+			0x02: "1.2",
+			0x04: "Power Meter",
+			0x05: "PM-100-A",
+		}
+
+		mu, msgs := runModbusStream(fmt.Sprintf(`
+modbus:
+  controller: '%s'
+  timeBetweenReads: '50ms'
+  deviceIdentification:
+    enabled: true
+  addresses:
+    - name: power
+      register: holding
+      address: 10
+      type: UINT16
+`, controller))
+
+		Eventually(func() int {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(*msgs)
+		}, 5*time.Second, 50*time.Millisecond).Should(BeNumerically(">=", 6))
+
+		mu.Lock()
+		for _, msg := range *msgs {
+			if tagName, _ := msg.MetaGet("modbus_tag_name"); tagName != "power" {
+				continue
+			}
+			metadata := map[string]string{}
+			Expect(msg.MetaWalk(func(key, value string) error {
+				metadata[key] = value
+				return nil
+			})).To(Succeed())
+			Expect(metadata).To(And(
+				HaveKeyWithValue("modbus_device_vendor_name", "UMH"),
+				HaveKeyWithValue("modbus_device_product_code", "PM-100"),
+				HaveKeyWithValue("modbus_device_major_minor_revision", "1.2"),
+				HaveKeyWithValue("modbus_device_product_name", "Power Meter"),
+				HaveKeyWithValue("modbus_device_model_name", "PM-100-A"),
+			))
+		}
+		mu.Unlock()
+
+		// The objects are split into two responses, and the identification is only read after connecting
+		Expect(deviceIDRequests(slave)).To(Equal(map[byte]int{1: 2}))
+	})
+
+	It("should emit the identification periodically and skip slaves without FC 43", func() {
+		slave, controller := startTCPSlave()
+		slave.holding[10] = 42
+		slave.deviceID[1] = map[byte]string{0x00: "UMH", 0x01: "PM-100", 0x02: "1.2"}
+
+		mu, msgs := runModbusStream(fmt.Sprintf(`
+modbus:
+  controller: '%s'
+  timeBetweenReads: '50ms'
+  slaveIDs: [1, 2]
+  deviceIdentification:
+    enabled: true
+    interval: '200ms'
+    output: message
+  addresses:
+    - name: power
+      register: holding
+      address: 10
+      type: UINT16
+`, controller))
+
+		time.Sleep(700 * time.Millisecond)
+
+		identities := map[string][]string{}
+		mu.Lock()
+		for _, msg := range *msgs {
+			if tagName, _ := msg.MetaGet("modbus_tag_name"); tagName != "deviceIdentification" {
+				continue
+			}
+			slaveID, _ := msg.MetaGet("modbus_tag_slaveid")
+			b, err := msg.AsBytes()
+			Expect(err).NotTo(HaveOccurred())
+			identities[slaveID] = append(identities[slaveID], string(b))
+		}
+		mu.Unlock()
+
+		Expect(identities).To(HaveLen(1))
+		Expect(len(identities["1"])).To(BeNumerically("~", 4, 1))
+		Expect(identities["1"][0]).To(MatchJSON(`{"VendorName": "UMH", "ProductCode": "PM-100", "MajorMinorRevision": "1.2"}`))
+
+		// The values of the slave without device identification are still read, but it is only asked once
+		Expect(valuesBySlaveAndTag(mu, msgs)).To(HaveKeyWithValue("2/power", "42"))
+		Expect(deviceIDRequests(slave)[2]).To(Equal(1))
+	})
+
+	It("should reject device identification with RTU framing", func() {
+		builder := service.NewStreamBuilder()
+		Expect(builder.AddInputYAML(`
+modbus:
+  controller: 'tcp://127.0.0.1:502'
+  transmissionMode: 'RTUoverTCP'
+  deviceIdentification:
+    enabled: true
+  addresses:
+    - {name: a, address: 1, type: UINT16}
+`)).To(Succeed())
+		Expect(builder.SetLoggerYAML(`level: off`)).To(Succeed())
+		Expect(builder.AddConsumerFunc(func(context.Context, *service.Message) error { return nil })).To(Succeed())
+
+		stream, err := builder.Build()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stream.Run(context.Background())).To(MatchError(ContainSubstring("deviceIdentification is not supported with RTU framing")))
+	})
+
+	It("should reject device identification on serial RTU lines", func() {
+		Expect(runModbusInputError(`
+modbus:
+  controller: 'file:///dev/ttyUSB0'
+  deviceIdentification:
+    enabled: true
+  addresses:
+    - {name: a, address: 1, type: UINT16}
+`)).To(MatchError(ContainSubstring("deviceIdentification is not supported with RTU framing")))
+	})
+})
@@ -0,0 +1,152 @@
+package modbus_plugin_test
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	_ "github.com/redpanda-data/benthos/v4/public/components/pure"
+
+	_ "github.com/united-manufacturing-hub/benthos-umh/modbus_plugin"
+)
+
+var _ = Describe("FIFO queue and file record", func() {
+	It("should emit one message per queued record", func() {
+		slave, controller := startTCPSlave()
+		// Three UINT32 records, the last two equal, followed by an incomplete record
+		slave.fifo[100] = []uint16{0x0001, 0x0002, 0x0000, 0x0007, 0x0000, 0x0007, 0x0009}
+
+		mu, msgs := runModbusStream(fmt.Sprintf(`
+modbus:
+  controller: '%s'
+  timeBetweenReads: '50ms'
+  reportByException:
+    enabled: true
+  addresses:
+    - name: events
+      register: fifo
+      address: 100
+      type: UINT32
+`, controller))
+
+		Eventually(func() int {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(*msgs)
+		}, 5*time.Second, 50*time.Millisecond).Should(BeNumerically(">=", 3))
+
+		mu.Lock()
+		var records []string
+		for _, msg := range (*msgs)[:3] {
+			tagName, _ := msg.MetaGet("modbus_tag_name")
+			Expect(tagName).To(Equal("events"))
+			register, _ := msg.MetaGet("modbus_tag_register")
+			Expect(register).To(Equal("fifo"))
+			index, _ := msg.MetaGet("modbus_tag_fifo_index")
+			b, err := msg.AsBytes()
+			Expect(err).NotTo(HaveOccurred())
+			records = append(records, index+":"+string(b))
+		}
+		mu.Unlock()
+
+		// Equal records are not suppressed by the report-by-exception mode
+		Expect(records).To(Equal([]string{"0:65538", "1:7", "2:7"}))
+	})
+
+	It("should deliver the records of a queue if reading a later queue fails", func() {
+		slave, controller := startTCPSlave()
+		slave.fifo[100] = []uint16{0x0001, 0x0002}
+
+		// The second queue does not exist, so every read of the slave fails after the first queue
+		mu, msgs := runModbusStream(fmt.Sprintf(`
+modbus:
+  controller: '%s'
+  timeBetweenReads: '50ms'
+  addresses:
+    - name: events
+      register: fifo
+      address: 100
+      type: UINT16
+    - name: missing
+      register: fifo
+      address: 200
+      type: UINT16
+`, controller))
+
+		Eventually(func() map[string]string {
+			return valuesByTag(mu, msgs)
+		}, 5*time.Second, 50*time.Millisecond).Should(HaveKeyWithValue("events", "2"))
+	})
+
+	It("should read file records", func() {
+		slave, controller := startTCPSlave()
+		slave.files[4] = map[uint16]uint16{10: 0x0001, 11: 0xE240, 20: 0x4049, 21: 0x0FDB}
+
+		mu, msgs := runModbusStream(fmt.Sprintf(`
+modbus:
+  controller: '%s'
+  timeBetweenReads: '50ms'
+  addresses:
+    - name: counter
+      register: file
+      file: 4
+      address: 10
+      type: UINT32
+    - name: pi
+      register: file
+      file: 4
+      address: 20
+      type: FLOAT32
+`, controller))
+
+		Eventually(func() map[string]string {
+			return valuesByTag(mu, msgs)
+		}, 5*time.Second, 50*time.Millisecond).Should(And(
+			HaveKeyWithValue("counter", "123456"),
+			HaveKeyWithValue("pi", HavePrefix("3.14159")),
+		))
+
+		mu.Lock()
+		file, _ := (*msgs)[0].MetaGet("modbus_tag_file")
+		mu.Unlock()
+		Expect(file).To(Equal("4"))
+	})
+
+	It("should reject file records without a file number", func() {
+		Expect(runModbusInputError(`
+modbus:
+  controller: 'tcp://127.0.0.1:502'
+  addresses:
+    - {name: a, register: file, address: 1, type: UINT16}
+`)).To(MatchError(ContainSubstring(`missing file number for field "a"`)))
+	})
+
+	It("should reject FIFO records longer than the queue", func() {
+		Expect(runModbusInputError(`
+modbus:
+  controller: 'tcp://127.0.0.1:502'
+  addresses:
+    - {name: a, register: fifo, address: 1, type: STRING, length: 32}
+`)).To(MatchError(ContainSubstring(`records of fifo field "a" are too long`)))
+	})
+
+	It("should reject FIFO queues with RTU framing", func() {
+		Expect(runModbusInputError(`
+modbus:
+  controller: 'tcp://127.0.0.1:502'
+  transmissionMode: 'RTUoverTCP'
+  addresses:
+    - {name: a, register: fifo, address: 1, type: UINT16}
+`)).To(MatchError(ContainSubstring("fifo and file registers are not supported with RTU framing")))
+	})
+
+	It("should reject file records on serial RTU lines", func() {
+		Expect(runModbusInputError(`
+modbus:
+  controller: 'file:///dev/ttyUSB0'
+  addresses:
+    - {name: a, register: file, file: 4, address: 1, type: UINT16}
+`)).To(MatchError(ContainSubstring("fifo and file registers are not supported with RTU framing")))
+	})
+})
@@ -0,0 +1,220 @@
+package modbus_plugin_test
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	_ "github.com/redpanda-data/benthos/v4/public/components/pure"
+	"github.com/redpanda-data/benthos/v4/public/service"
+
+	_ "github.com/united-manufacturing-hub/benthos-umh/modbus_plugin"
+)
+
+// runModbusOutput runs a stream with the given modbus output configuration and returns a function that
+// writes a message and waits until the output acknowledged it.
+func runModbusOutput(outputYAML string) service.MessageHandlerFunc {
+	builder := service.NewStreamBuilder()
+	Expect(builder.AddOutputYAML(outputYAML)).To(Succeed())
+	Expect(builder.SetLoggerYAML(`level: off`)).To(Succeed())
+
+	produce, err := builder.AddProducerFunc()
+	Expect(err).NotTo(HaveOccurred())
+
+	stream, err := builder.Build()
+	Expect(err).NotTo(HaveOccurred())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = stream.Run(ctx)
+	}()
+	DeferCleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return produce
+}
+
+// writeMessage writes a message with the given payload and metadata and returns the result of the output.
+func writeMessage(produce service.MessageHandlerFunc, payload string, metadata map[string]string) error {
+	msg := service.NewMessage([]byte(payload))
+	for key, value := range metadata {
+		msg.MetaSet(key, value)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return produce(ctx, msg)
+}
+
+// functionCodes returns the function codes of the requests that the slave answered.
+func functionCodes(slave *testSlave) []byte {
+	var codes []byte
+	for _, request := range slave.requestLog() {
+		codes = append(codes, request.functionCode)
+	}
+	return codes
+}
+
+var _ = Describe("Modbus output", func() {
+	addresses := `
+  addresses:
+    - name: setpoint
+      register: holding
+      address: 10
+      type: INT16
+      scale: 0.1
+    - name: counter
+      register: holding
+      address: 20
+      type: UINT32
+    - name: speed
+      register: holding
+      address: 22
+      type: FLOAT32
+    - name: label
+      register: holding
+      address: 30
+      type: STRING
+      length: 2
+    - name: flag
+      register: holding
+      address: 40
+      type: BIT
+      bit: 3
+    - name: enable
+      register: coil
+      address: 5
+    - name: enable2
+      register: coil
+      address: 6
+`
+
+	It("should write the value of a single address selected by the tag name", func() {
+		slave, controller := startTCPSlave()
+		produce := runModbusOutput(fmt.Sprintf(`
+modbus:
+  controller: '%s'
+%s`, controller, addresses))
+
+		Expect(writeMessage(produce, "-12.3", map[string]string{"modbus_tag_name": "setpoint"})).To(Succeed())
+		Expect(int16(slave.holdingRegister(10))).To(Equal(int16(-123)))
+
+		Expect(writeMessage(produce, "true", map[string]string{"modbus_tag_name": "enable"})).To(Succeed())
+		Expect(slave.coil(5)).To(BeTrue())
+
+		// FC 6 for the register and FC 5 for the coil
+		Expect(functionCodes(slave)).To(Equal([]byte{0x06, 0x05}))
+	})
+
+	It("should write multiple addresses of a JSON object with as few requests as possible", func() {
+		slave, controller := startTCPSlave()
+		slave.holding[40] = 0x0101
+		produce := runModbusOutput(fmt.Sprintf(`
+modbus:
+  controller: '%s'
+  tagName: ''
+%s`, controller, addresses))
+
+		Expect(writeMessage(produce, `{"counter": 4000000000, "speed": 1.5, "label": "abc", "flag": true, "enable": true, "enable2": false}`, nil)).To(Succeed())
+
+		Expect(uint32(slave.holdingRegister(20))<<16 | uint32(slave.holdingRegister(21))).To(Equal(uint32(4000000000)))
+		Expect(math.Float32frombits(uint32(slave.holdingRegister(22))<<16 | uint32(slave.holdingRegister(23)))).To(Equal(float32(1.5)))
+		Expect(slave.holdingRegister(30)).To(Equal(uint16('a')<<8 | uint16('b')))
+		Expect(slave.holdingRegister(31)).To(Equal(uint16('c') << 8))
+		Expect(slave.holdingRegister(40)).To(Equal(uint16(0x0109)))
+		Expect(slave.coil(5)).To(BeTrue())
+		Expect(slave.coil(6)).To(BeFalse())
+
+		// The bit is read before it is merged. The coils are written with FC 15, the counter and the speed
+		// with one FC 16, the label with FC 16 and the register of the bit with FC 6.
+		Expect(functionCodes(slave)).To(Equal([]byte{0x03, 0x0F, 0x10, 0x10, 0x06}))
+	})
+
+	It("should write to the slave ID of the message", func() {
+		slave, controller := startTCPSlave()
+		produce := runModbusOutput(fmt.Sprintf(`
+modbus:
+  controller: '%s'
+%s`, controller, addresses))
+
+		Expect(writeMessage(produce, "1", map[string]string{"modbus_tag_name": "counter", "modbus_tag_slaveid": "7"})).To(Succeed())
+		Expect(writeMessage(produce, "2", map[string]string{"modbus_tag_name": "counter"})).To(Succeed())
+
+		requests := slave.requestLog()
+		Expect(requests).To(HaveLen(2))
+		Expect(requests[0].slaveID).To(Equal(byte(7)))
+		Expect(requests[1].slaveID).To(Equal(byte(1)))
+	})
+
+	It("should write holding registers with FC 23 and verify the result", func() {
+		slave, controller := startTCPSlave()
+		produce := runModbusOutput(fmt.Sprintf(`
+modbus:
+  controller: '%s'
+  readWriteMultiple: true
+  verify: true
+%s`, controller, addresses))
+
+		Expect(writeMessage(produce, "42", map[string]string{"modbus_tag_name": "counter"})).To(Succeed())
+		Expect(slave.holdingRegister(21)).To(Equal(uint16(42)))
+		Expect(functionCodes(slave)).To(Equal([]byte{0x17}))
+
+		slave.readOnly[20] = true
+		Expect(writeMessage(produce, "70000", map[string]string{"modbus_tag_name": "counter"})).To(MatchError(ContainSubstring("verification of holding@20[2] failed")))
+	})
+
+	It("should read written values back if verify is enabled", func() {
+		slave, controller := startTCPSlave()
+		slave.readOnly[10] = true
+		produce := runModbusOutput(fmt.Sprintf(`
+modbus:
+  controller: '%s'
+  verify: true
+%s`, controller, addresses))
+
+		Expect(writeMessage(produce, "1", map[string]string{"modbus_tag_name": "enable"})).To(Succeed())
+		Expect(writeMessage(produce, "1", map[string]string{"modbus_tag_name": "setpoint"})).To(MatchError(ContainSubstring("verification of holding@10[1] failed")))
+		Expect(functionCodes(slave)).To(Equal([]byte{0x05, 0x01, 0x06, 0x03}))
+	})
+
+	It("should reject messages that do not match the addresses", func() {
+		slave, controller := startTCPSlave()
+		produce := runModbusOutput(fmt.Sprintf(`
+modbus:
+  controller: '%s'
+%s`, controller, addresses))
+
+		Expect(writeMessage(produce, "1", map[string]string{"modbus_tag_name": "unknown"})).To(MatchError(ContainSubstring(`unknown address "unknown"`)))
+		Expect(writeMessage(produce, "100000", map[string]string{"modbus_tag_name": "setpoint"})).To(MatchError(ContainSubstring(`invalid value for "setpoint"`)))
+		Expect(writeMessage(produce, "abcdef", map[string]string{"modbus_tag_name": "label"})).To(MatchError(ContainSubstring(`invalid value for "label"`)))
+		Expect(writeMessage(produce, "1", map[string]string{"modbus_tag_name": "counter", "modbus_tag_slaveid": "300"})).To(MatchError(ContainSubstring(`invalid slave ID "300"`)))
+		Expect(writeMessage(produce, "[1, 2]", nil)).To(MatchError(ContainSubstring("payload is no JSON object")))
+		Expect(writeMessage(produce, "{}", nil)).To(MatchError(ContainSubstring("payload contains no values")))
+		Expect(slave.requestLog()).To(BeEmpty())
+	})
+
+	It("should reject read-only registers", func() {
+		builder := service.NewStreamBuilder()
+		Expect(builder.AddOutputYAML(`
+modbus:
+  addresses:
+    - name: temperature
+      register: input
+      address: 1
+      type: INT16
+`)).To(Succeed())
+		Expect(builder.SetLoggerYAML(`level: off`)).To(Succeed())
+		_, err := builder.AddProducerFunc()
+		Expect(err).NotTo(HaveOccurred())
+		stream, err := builder.Build()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stream.Run(context.Background())).To(MatchError(ContainSubstring(`register-type "input" of field "temperature" is read-only`)))
+	})
+})
@@ -0,0 +1,131 @@
+package modbus_plugin_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	_ "github.com/redpanda-data/benthos/v4/public/components/pure"
+	"github.com/redpanda-data/benthos/v4/public/service"
+
+	_ "github.com/united-manufacturing-hub/benthos-umh/modbus_plugin"
+)
+
+// payloadsByTag returns all payloads of the collected messages by tag name, in the order they were emitted.
+func payloadsByTag(mu *sync.Mutex, msgs *[]*service.Message) map[string][]string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	payloads := map[string][]string{}
+	for _, msg := range *msgs {
+		tagName, _ := msg.MetaGet("modbus_tag_name")
+		b, err := msg.AsBytes()
+		Expect(err).NotTo(HaveOccurred())
+		payloads[tagName] = append(payloads[tagName], string(b))
+	}
+	return payloads
+}
+
+var _ = Describe("Report by exception", func() {
+	It("should only emit values that changed by more than the deadband", func() {
+		slave, controller := startTCPSlave()
+		slave.holding[10] = 1
+		slave.holding[11] = 100
+
+		mu, msgs := runModbusStream(fmt.Sprintf(`
+modbus:
+  controller: '%s'
+  timeBetweenReads: '50ms'
+  reportByException:
+    enabled: true
+    deadband: 5
+  addresses:
+    - name: state
+      register: holding
+      address: 10
+      type: UINT16
+    - name: level
+      register: holding
+      address: 11
+      type: UINT16
+`, controller))
+
+		time.Sleep(300 * time.Millisecond)
+		payloads := payloadsByTag(mu, msgs)
+		Expect(payloads["state"]).To(Equal([]string{"1"}))
+		Expect(payloads["level"]).To(Equal([]string{"100"}))
+
+		// The heartbeat is still sent on every poll
+		Expect(len(payloads["heartbeat"])).To(BeNumerically(">", 3))
+
+		slave.setHoldingRegister(10, 20)
+		slave.setHoldingRegister(11, 104)
+		// Only the state changed by more than the deadband
+		Eventually(func() []string {
+			return payloadsByTag(mu, msgs)["state"]
+		}, time.Second, 10*time.Millisecond).Should(Equal([]string{"1", "20"}))
+
+		Expect(payloadsByTag(mu, msgs)["level"]).To(Equal([]string{"100"}))
+
+		// The deadband is relative to the last emitted value, so slow drifts are emitted as well
+		slave.setHoldingRegister(11, 106)
+		Eventually(func() []string {
+			return payloadsByTag(mu, msgs)["level"]
+		}, time.Second, 10*time.Millisecond).Should(Equal([]string{"100", "106"}))
+	})
+
+	It("should emit unchanged values after the maximum silence interval", func() {
+		slave, controller := startTCPSlave()
+		slave.holding[10] = 1
+
+		mu, msgs := runModbusStream(fmt.Sprintf(`
+modbus:
+  controller: '%s'
+  timeBetweenReads: '50ms'
+  slaveIDs: [1, 2]
+  reportByException:
+    enabled: true
+    maxSilenceInterval: '300ms'
+  addresses:
+    - name: state
+      register: holding
+      address: 10
+      type: UINT16
+`, controller))
+
+		time.Sleep(1050 * time.Millisecond)
+
+		// The value is kept per slave ID, so each slave emits it on the first poll and every 300ms
+		counts := map[string]int{}
+		mu.Lock()
+		for _, msg := range *msgs {
+			tagName, _ := msg.MetaGet("modbus_tag_name")
+			slaveID, _ := msg.MetaGet("modbus_tag_slaveid")
+			counts[slaveID+"/"+tagName]++
+		}
+		mu.Unlock()
+		Expect(counts["1/state"]).To(BeNumerically("~", 4, 1))
+		Expect(counts["2/state"]).To(BeNumerically("~", 4, 1))
+	})
+
+	It("should reject a negative deadband", func() {
+		builder := service.NewStreamBuilder()
+		Expect(builder.AddInputYAML(`
+modbus:
+  reportByException:
+    enabled: true
+    deadband: -1
+  addresses:
+    - {name: a, address: 1, type: UINT16}
+`)).To(Succeed())
+		Expect(builder.SetLoggerYAML(`level: off`)).To(Succeed())
+		Expect(builder.AddConsumerFunc(func(context.Context, *service.Message) error { return nil })).To(Succeed())
+
+		stream, err := builder.Build()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stream.Run(context.Background())).To(MatchError(ContainSubstring("deadband of reportByException must not be negative")))
+	})
+})
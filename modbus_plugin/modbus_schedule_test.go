@@ -0,0 +1,103 @@
+package modbus_plugin_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	_ "github.com/redpanda-data/benthos/v4/public/components/pure"
+	"github.com/redpanda-data/benthos/v4/public/service"
+
+	_ "github.com/united-manufacturing-hub/benthos-umh/modbus_plugin"
+)
+
+var _ = Describe("Polling intervals", func() {
+	It("should read each poll group at its own interval and merge due groups", func() {
+		slave, controller := startTCPSlave()
+		slave.holding[10] = 1
+		slave.holding[11] = 2
+		slave.holding[12] = 3
+
+		mu, msgs := runModbusStream(fmt.Sprintf(`
+modbus:
+  controller: '%s'
+  timeBetweenReads: '300ms'
+  pollGroups:
+    - name: slow
+      interval: '10s'
+  addresses:
+    - name: fast
+      register: holding
+      address: 10
+      type: UINT16
+      interval: '100ms'
+    - name: default
+      register: holding
+      address: 11
+      type: UINT16
+    - name: slow
+      register: holding
+      address: 12
+      type: UINT16
+      pollGroup: slow
+`, controller))
+
+		time.Sleep(1050 * time.Millisecond)
+
+		counts := map[string]int{}
+		mu.Lock()
+		for _, msg := range *msgs {
+			tagName, _ := msg.MetaGet("modbus_tag_name")
+			counts[tagName]++
+		}
+		mu.Unlock()
+
+		Expect(counts["fast"]).To(BeNumerically("~", 11, 2))
+		Expect(counts["default"]).To(BeNumerically("~", 4, 1))
+		Expect(counts["slow"]).To(Equal(1))
+
+		// Every cycle reads the consecutive addresses of all due groups with a single request
+		Expect(len(slave.requestLog())).To(BeNumerically("~", counts["heartbeat"], 1))
+	})
+
+	DescribeTable("should reject invalid poll groups", func(inputYAML, expectedErr string) {
+		builder := service.NewStreamBuilder()
+		Expect(builder.AddInputYAML(inputYAML)).To(Succeed())
+		Expect(builder.SetLoggerYAML(`level: off`)).To(Succeed())
+		Expect(builder.AddConsumerFunc(func(context.Context, *service.Message) error { return nil })).To(Succeed())
+
+		stream, err := builder.Build()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stream.Run(context.Background())).To(MatchError(ContainSubstring(expectedErr)))
+	},
+		Entry("unknown poll group", `
+modbus:
+  addresses:
+    - {name: a, address: 1, type: UINT16, pollGroup: fast}
+`, `unknown poll group "fast" for field "a"`),
+		Entry("poll group and interval", `
+modbus:
+  pollGroups:
+    - {name: fast, interval: '100ms'}
+  addresses:
+    - {name: a, address: 1, type: UINT16, pollGroup: fast, interval: '1s'}
+`, `pollGroup and interval cannot be combined for field "a"`),
+		Entry("duplicate poll group", `
+modbus:
+  pollGroups:
+    - {name: fast, interval: '100ms'}
+    - {name: fast, interval: '200ms'}
+  addresses:
+    - {name: a, address: 1, type: UINT16}
+`, `duplicate name "fast"`),
+		Entry("zero interval", `
+modbus:
+  pollGroups:
+    - {name: fast, interval: '0s'}
+  addresses:
+    - {name: a, address: 1, type: UINT16}
+`, "poll group fast: interval must be greater than 0"),
+	)
+})
@@ -0,0 +1,124 @@
+//go:build linux
+
+package modbus_plugin_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	_ "github.com/redpanda-data/benthos/v4/public/components/pure"
+	"github.com/redpanda-data/benthos/v4/public/service"
+
+	_ "github.com/united-manufacturing-hub/benthos-umh/modbus_plugin"
+)
+
+// openPTY opens a pseudo-terminal pair. The test slave is served on the master, the input opens the
+// returned slave device like a real serial port.
+func openPTY() (*os.File, string) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		Skip(fmt.Sprintf("pseudo-terminals are not available: %v", err))
+	}
+	DeferCleanup(master.Close)
+
+	conn, err := master.SyscallConn()
+	Expect(err).NotTo(HaveOccurred())
+
+	var number uint32
+	var ioctlErr error
+	Expect(conn.Control(func(fd uintptr) {
+		unlock := int32(0)
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+			ioctlErr = errno
+			return
+		}
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&number))); errno != 0 {
+			ioctlErr = errno
+		}
+	})).To(Succeed())
+	Expect(ioctlErr).NotTo(HaveOccurred())
+
+	return master, fmt.Sprintf("/dev/pts/%d", number)
+}
+
+var _ = Describe("Modbus over a serial line", func() {
+	DescribeTable("should read a slave", func(transmissionMode string, serve func(*testSlave, io.ReadWriter)) {
+		master, device := openPTY()
+
+		slave := newTestSlave()
+		slave.holding[10] = 1234
+		slave.input[3] = 0xFFFF
+		slave.coils[7] = true
+		go serve(slave, master)
+
+		mu, msgs := runModbusStream(fmt.Sprintf(`
+modbus:
+  controller: 'file://%s'
+  transmissionMode: '%s'
+  slaveIDs: [3]
+  timeBetweenReads: '100ms'
+  serial:
+    baudRate: 9600
+    parity: 'N'
+    stopBits: 2
+  addresses:
+    - name: holding
+      register: holding
+      address: 10
+      type: UINT16
+    - name: input
+      register: input
+      address: 3
+      type: INT16
+    - name: coil
+      register: coil
+      address: 7
+      type: BIT
+      output: BOOL
+`, device, transmissionMode))
+
+		Eventually(func() map[string]string {
+			return valuesByTag(mu, msgs)
+		}, 10*time.Second, 100*time.Millisecond).Should(And(
+			HaveKeyWithValue("holding", "1234"),
+			HaveKeyWithValue("input", "-1"),
+			HaveKeyWithValue("coil", "true"),
+		))
+
+		mu.Lock()
+		slaveID, _ := (*msgs)[0].MetaGet("modbus_tag_slaveid")
+		mu.Unlock()
+		Expect(slaveID).To(Equal("3"))
+	},
+		Entry("in RTU mode", "RTU", (*testSlave).serveRTU),
+		Entry("in RTU mode by default", "auto", (*testSlave).serveRTU),
+		Entry("in ASCII mode", "ASCII", (*testSlave).serveASCII),
+	)
+
+	It("should reject invalid serial settings", func() {
+		builder := service.NewStreamBuilder()
+		Expect(builder.AddInputYAML(`
+modbus:
+  controller: 'file:///dev/ttyUSB0'
+  serial:
+    parity: 'X'
+  addresses:
+    - name: holding
+      address: 10
+      type: UINT16
+`)).To(Succeed())
+		stream, err := builder.Build()
+		Expect(err).NotTo(HaveOccurred())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		Expect(stream.Run(ctx)).To(MatchError(ContainSubstring(`invalid parity "X"`)))
+	})
+})
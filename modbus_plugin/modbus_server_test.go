@@ -0,0 +1,304 @@
+package modbus_plugin_test
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grid-x/modbus"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	_ "github.com/redpanda-data/benthos/v4/public/components/pure"
+	"github.com/redpanda-data/benthos/v4/public/service"
+
+	_ "github.com/united-manufacturing-hub/benthos-umh/modbus_plugin"
+)
+
+// freeListenAddress returns a local address with a port that is currently not in use.
+func freeListenAddress() string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// newServerClient connects a Modbus TCP client to the server.
+func newServerClient(listenAddress string, slaveID byte) modbus.Client {
+	handler := modbus.NewTCPClientHandler(listenAddress)
+	handler.Timeout = time.Second
+	handler.SetSlave(slaveID)
+	Eventually(handler.Connect, 5*time.Second, 50*time.Millisecond).Should(Succeed())
+	DeferCleanup(handler.Close)
+	return modbus.NewClient(handler)
+}
+
+// indent prefixes every non-empty line of the YAML snippet.
+func indent(yaml, prefix string) string {
+	lines := strings.Split(yaml, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// runModbusServerWrites runs a stream with the modbus_server_writes input and the modbus_server output as a
+// resource, as both have to be in the same stream to share the write-back queue. It returns the writes.
+func runModbusServerWrites(inputYAML, serverYAML string) (*sync.Mutex, *[]*service.Message) {
+	builder := service.NewStreamBuilder()
+	Expect(builder.AddInputYAML(inputYAML)).To(Succeed())
+	Expect(builder.AddResourcesYAML(serverYAML)).To(Succeed())
+	Expect(builder.SetLoggerYAML(`level: off`)).To(Succeed())
+
+	var mu sync.Mutex
+	var msgs []*service.Message
+	Expect(builder.AddConsumerFunc(func(_ context.Context, msg *service.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		msgs = append(msgs, msg)
+		return nil
+	})).To(Succeed())
+
+	stream, err := builder.Build()
+	Expect(err).NotTo(HaveOccurred())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = stream.Run(ctx)
+	}()
+	DeferCleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return &mu, &msgs
+}
+
+var _ = Describe("Modbus server", func() {
+	addresses := `
+  addresses:
+    - name: temperature
+      register: input
+      address: 0
+      type: INT16
+      scale: 0.1
+    - name: counter
+      register: holding
+      address: 10
+      type: UINT32
+    - name: speed
+      register: holding
+      address: 12
+      type: FLOAT32
+      byteOrder: CDAB
+    - name: flag
+      register: holding
+      address: 20
+      type: BIT
+      bit: 3
+    - name: running
+      register: discrete
+      address: 1
+    - name: enable
+      register: coil
+      address: 5
+`
+
+	It("should serve the values of messages", func() {
+		listenAddress := freeListenAddress()
+		produce := runModbusOutput(fmt.Sprintf(`
+modbus_server:
+  listenAddress: '%s'
+  tagName: ''
+%s`, listenAddress, addresses))
+
+		Expect(writeMessage(produce, `{"temperature": -12.3, "counter": 4000000000, "speed": 1.5, "flag": true, "running": true}`, nil)).To(Succeed())
+		Expect(writeMessage(produce, `{"enable": true}`, nil)).To(Succeed())
+
+		client := newServerClient(listenAddress, 1)
+
+		registers, err := client.ReadInputRegisters(0, 1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(int16(binary.BigEndian.Uint16(registers))).To(Equal(int16(-123)))
+
+		registers, err = client.ReadHoldingRegisters(10, 11)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(binary.BigEndian.Uint32(registers)).To(Equal(uint32(4000000000)))
+		speed := uint32(binary.BigEndian.Uint16(registers[6:]))<<16 | uint32(binary.BigEndian.Uint16(registers[4:]))
+		Expect(math.Float32frombits(speed)).To(Equal(float32(1.5)))
+		Expect(binary.BigEndian.Uint16(registers[20:])).To(Equal(uint16(0x0008)))
+
+		discrete, err := client.ReadDiscreteInputs(0, 2)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(discrete).To(Equal([]byte{0x02}))
+
+		coils, err := client.ReadCoils(5, 1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(coils).To(Equal([]byte{0x01}))
+
+		// Other slave IDs are not served
+		_, err = newServerClient(listenAddress, 2).ReadCoils(5, 1)
+		Expect(err).To(MatchError(ContainSubstring("gateway target device failed to respond")))
+	})
+
+	It("should be readable by the modbus input", func() {
+		listenAddress := freeListenAddress()
+		produce := runModbusOutput(fmt.Sprintf(`
+modbus_server:
+  listenAddress: '%s'
+%s`, listenAddress, addresses))
+
+		Expect(writeMessage(produce, "123456", map[string]string{"modbus_tag_name": "counter"})).To(Succeed())
+
+		mu, msgs := runModbusStream(fmt.Sprintf(`
+modbus:
+  controller: 'tcp://%s'
+  timeBetweenReads: '50ms'
+  addresses:
+    - name: counter
+      register: holding
+      address: 10
+      type: UINT32
+`, listenAddress))
+
+		Eventually(func() map[string]string {
+			return valuesByTag(mu, msgs)
+		}, 5*time.Second, 50*time.Millisecond).Should(HaveKeyWithValue("counter", "123456"))
+	})
+
+	It("should emit the writes of clients with the companion input", func() {
+		listenAddress := freeListenAddress()
+		mu, msgs := runModbusServerWrites(`
+modbus_server_writes:
+  writeBack: 'modbus-setpoints'
+`, fmt.Sprintf(`
+output_resources:
+  - label: server
+    modbus_server:
+      listenAddress: '%s'
+      writeBack: 'modbus-setpoints'
+%s`, listenAddress, indent(addresses, "    ")))
+
+		client := newServerClient(listenAddress, 1)
+		_, err := client.WriteSingleCoil(5, 0xFF00)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = client.WriteMultipleRegisters(10, 4, []byte{0x00, 0x01, 0xE2, 0x40, 0x00, 0x00, 0x3F, 0xC0})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = client.WriteSingleRegister(20, 0x0008)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(func() map[string]string {
+			return valuesByTag(mu, msgs)
+		}, 5*time.Second, 50*time.Millisecond).Should(And(
+			HaveKeyWithValue("enable", "true"),
+			HaveKeyWithValue("counter", "123456"),
+			HaveKeyWithValue("speed", "1.5"),
+			HaveKeyWithValue("flag", "1"),
+		))
+
+		mu.Lock()
+		register, _ := (*msgs)[0].MetaGet("modbus_tag_register")
+		clientAddress, _ := (*msgs)[0].MetaGet("modbus_client_address")
+		mu.Unlock()
+		Expect(register).To(Equal("coil"))
+		Expect(clientAddress).To(HavePrefix("127.0.0.1:"))
+
+		// The written values are served as well
+		registers, err := newServerClient(listenAddress, 1).ReadHoldingRegisters(10, 2)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(registers).To(Equal([]byte{0x00, 0x01, 0xE2, 0x40}))
+	})
+
+	It("should reject the writes of clients without writeBack", func() {
+		listenAddress := freeListenAddress()
+		_ = runModbusOutput(fmt.Sprintf(`
+modbus_server:
+  listenAddress: '%s'
+%s`, listenAddress, addresses))
+
+		_, err := newServerClient(listenAddress, 1).WriteSingleRegister(10, 1)
+		Expect(err).To(MatchError(ContainSubstring("illegal function")))
+	})
+
+	It("should not share the writes with other streams", func() {
+		// Both streams use the same writeBack name
+		otherAddress := freeListenAddress()
+		_, otherMsgs := runModbusServerWrites(`
+modbus_server_writes:
+  writeBack: 'modbus-shared'
+`, fmt.Sprintf(`
+output_resources:
+  - label: server
+    modbus_server:
+      listenAddress: '%s'
+      writeBack: 'modbus-shared'
+%s`, otherAddress, indent(addresses, "    ")))
+
+		listenAddress := freeListenAddress()
+		mu, msgs := runModbusServerWrites(`
+modbus_server_writes:
+  writeBack: 'modbus-shared'
+`, fmt.Sprintf(`
+output_resources:
+  - label: server
+    modbus_server:
+      listenAddress: '%s'
+      writeBack: 'modbus-shared'
+%s`, listenAddress, indent(addresses, "    ")))
+
+		_, err := newServerClient(listenAddress, 1).WriteSingleCoil(5, 0xFF00)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(func() map[string]string {
+			return valuesByTag(mu, msgs)
+		}, 5*time.Second, 50*time.Millisecond).Should(HaveKeyWithValue("enable", "true"))
+		Consistently(func() map[string]string {
+			return valuesByTag(mu, otherMsgs)
+		}, 500*time.Millisecond, 50*time.Millisecond).Should(BeEmpty())
+	})
+
+	It("should reject two outputs with the same writeBack name", func() {
+		builder := service.NewStreamBuilder()
+		Expect(builder.AddInputYAML(`
+modbus_server_writes:
+  writeBack: 'modbus-duplicate'
+`)).To(Succeed())
+		Expect(builder.AddResourcesYAML(fmt.Sprintf(`
+output_resources:
+  - label: first
+    modbus_server:
+      listenAddress: '%s'
+      writeBack: 'modbus-duplicate'
+%s
+  - label: second
+    modbus_server:
+      listenAddress: '%s'
+      writeBack: 'modbus-duplicate'
+%s`, freeListenAddress(), indent(addresses, "    "), freeListenAddress(), indent(addresses, "    ")))).To(Succeed())
+		Expect(builder.SetLoggerYAML(`level: off`)).To(Succeed())
+		Expect(builder.AddConsumerFunc(func(context.Context, *service.Message) error { return nil })).To(Succeed())
+
+		_, err := builder.Build()
+		Expect(err).To(MatchError(ContainSubstring(`writeBack "modbus-duplicate" is already used by another modbus_server output`)))
+	})
+
+	It("should return an error for messages that cannot be served", func() {
+		produce := runModbusOutput(fmt.Sprintf(`
+modbus_server:
+  listenAddress: '%s'
+%s`, freeListenAddress(), addresses))
+
+		Expect(writeMessage(produce, "1", map[string]string{"modbus_tag_name": "unknown"})).To(MatchError(`unknown address "unknown"`))
+		Expect(writeMessage(produce, "[1, 2]", map[string]string{"modbus_tag_name": ""})).To(HaveOccurred())
+		Expect(writeMessage(produce, "70000", map[string]string{"modbus_tag_name": "temperature"})).To(MatchError(ContainSubstring(`"temperature"`)))
+		Expect(writeMessage(produce, "yes", map[string]string{"modbus_tag_name": "enable"})).To(MatchError(ContainSubstring(`"enable"`)))
+	})
+})
@@ -0,0 +1,127 @@
+package modbus_plugin_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	_ "github.com/redpanda-data/benthos/v4/public/components/pure"
+	"github.com/redpanda-data/benthos/v4/public/service"
+
+	_ "github.com/united-manufacturing-hub/benthos-umh/modbus_plugin"
+)
+
+// valuesBySlaveAndTag returns the latest payload of every tag of the collected messages by "slaveID/tag".
+func valuesBySlaveAndTag(mu *sync.Mutex, msgs *[]*service.Message) map[string]string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	values := map[string]string{}
+	for _, msg := range *msgs {
+		slaveID, _ := msg.MetaGet("modbus_tag_slaveid")
+		tagName, _ := msg.MetaGet("modbus_tag_name")
+		b, err := msg.AsBytes()
+		Expect(err).NotTo(HaveOccurred())
+		values[slaveID+"/"+tagName] = string(b)
+	}
+	return values
+}
+
+var _ = Describe("Address sets per slave", func() {
+	It("should read the address set of each slave over one connection", func() {
+		slave, controller := startTCPSlave()
+		slave.holding[10] = 0x0001
+		slave.holding[11] = 0x0002
+		slave.holding[12] = 0x0007
+		slave.holding[13] = 0x0100
+
+		mu, msgs := runModbusStream(fmt.Sprintf(`
+modbus:
+  controller: '%s'
+  timeBetweenReads: '100ms'
+  slaveIDs: [5]
+  addresses:
+    - name: shared
+      register: holding
+      address: 10
+      type: UINT16
+  slaves:
+    - slaveID: 1
+      addresses:
+        - name: energy
+          register: holding
+          address: 10
+          type: UINT32
+    - slaveID: 2
+      byteOrder: 'DCBA'
+      workarounds:
+        oneRequestPerField: true
+      addresses:
+        - name: current
+          register: holding
+          address: 12
+          type: UINT16
+        - name: voltage
+          register: holding
+          address: 13
+          type: UINT16
+`, controller))
+
+		Eventually(func() map[string]string {
+			return valuesBySlaveAndTag(mu, msgs)
+		}, 5*time.Second, 100*time.Millisecond).Should(And(
+			HaveKeyWithValue("5/shared", "1"),
+			HaveKeyWithValue("1/energy", "65538"),
+			HaveKeyWithValue("2/current", "1792"),
+			HaveKeyWithValue("2/voltage", "1"),
+		))
+
+		values := valuesBySlaveAndTag(mu, msgs)
+		Expect(values).NotTo(HaveKey("5/energy"))
+		Expect(values).NotTo(HaveKey("1/shared"))
+
+		// The slaves of slaveIDs are read first. Slave 2 sends one request per field.
+		requests := slave.requestLog()
+		Expect(len(requests)).To(BeNumerically(">=", 4))
+		Expect(requests[:4]).To(Equal([]testRequest{
+			{slaveID: 5, functionCode: 0x03},
+			{slaveID: 1, functionCode: 0x03},
+			{slaveID: 2, functionCode: 0x03},
+			{slaveID: 2, functionCode: 0x03},
+		}))
+	})
+
+	DescribeTable("should reject invalid slaves", func(slavesYAML, expectedErr string) {
+		builder := service.NewStreamBuilder()
+		Expect(builder.AddInputYAML(`
+modbus:
+  addresses: []
+  slaves:
+` + slavesYAML)).To(Succeed())
+		Expect(builder.SetLoggerYAML(`level: off`)).To(Succeed())
+		Expect(builder.AddConsumerFunc(func(context.Context, *service.Message) error { return nil })).To(Succeed())
+
+		stream, err := builder.Build()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stream.Run(context.Background())).To(MatchError(ContainSubstring(expectedErr)))
+	},
+		Entry("duplicate slave ID", `
+    - slaveID: 1
+      addresses: [{name: a, address: 1, type: UINT16}]
+    - slaveID: 1
+      addresses: [{name: b, address: 2, type: UINT16}]
+`, "duplicate slaveID 1"),
+		Entry("invalid byte order", `
+    - slaveID: 1
+      byteOrder: 'XYZ'
+      addresses: [{name: a, address: 1, type: UINT16}]
+`, `slave 1: unknown byte-order "XYZ"`),
+		Entry("no addresses", `
+    - slaveID: 3
+      addresses: []
+`, "slave 3: addresses are empty"),
+	)
+})
@@ -0,0 +1,436 @@
+package modbus_plugin_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// testSlave is a simulated Modbus slave with an in-memory register image. It answers the requests of all
+// slave IDs and can be served over a serial line in RTU or ASCII mode or over TCP.
+type testSlave struct {
+	mu       sync.Mutex
+	coils    map[uint16]bool
+	discrete map[uint16]bool
+	holding  map[uint16]uint16
+	input    map[uint16]uint16
+	// readOnly holding registers ignore writes, like registers that are clamped by the device
+	readOnly map[uint16]bool
+	requests int
+	// log records the slave ID and function code of every request
+	log []testRequest
+	// deviceID are the objects of Read Device Identification by slave ID. Other slaves do not support it.
+	deviceID map[byte]map[byte]string
+	// fifo are the queued registers by FIFO pointer address and files the records by file and record number
+	fifo  map[uint16][]uint16
+	files map[uint16]map[uint16]uint16
+}
+
+type testRequest struct {
+	slaveID      byte
+	functionCode byte
+}
+
+func newTestSlave() *testSlave {
+	return &testSlave{
+		coils:    make(map[uint16]bool),
+		discrete: make(map[uint16]bool),
+		holding:  make(map[uint16]uint16),
+		input:    make(map[uint16]uint16),
+		readOnly: make(map[uint16]bool),
+		deviceID: make(map[byte]map[byte]string),
+		fifo:     make(map[uint16][]uint16),
+		files:    make(map[uint16]map[uint16]uint16),
+	}
+}
+
+// requestCount returns the number of requests that the slave answered.
+func (s *testSlave) requestCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+// requestLog returns the requests that the slave answered.
+func (s *testSlave) requestLog() []testRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]testRequest(nil), s.log...)
+}
+
+// holdingRegister returns the value of a holding register.
+func (s *testSlave) holdingRegister(address uint16) uint16 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.holding[address]
+}
+
+// setHoldingRegister sets the value of a holding register while the slave is served.
+func (s *testSlave) setHoldingRegister(address uint16, value uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.holding[address] = value
+}
+
+// coil returns the state of a coil.
+func (s *testSlave) coil(address uint16) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.coils[address]
+}
+
+// handle processes a request PDU (function code and data) of a slave and returns the response PDU.
+func (s *testSlave) handle(slaveID byte, pdu []byte) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	s.log = append(s.log, testRequest{slaveID: slaveID, functionCode: pdu[0]})
+
+	functionCode := pdu[0]
+	data := pdu[1:]
+	exception := func(code byte) []byte { return []byte{functionCode | 0x80, code} }
+
+	switch functionCode {
+	case 0x01, 0x02:
+		if len(data) != 4 {
+			return exception(0x03)
+		}
+		bits := s.coils
+		if functionCode == 0x02 {
+			bits = s.discrete
+		}
+		address, quantity := binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+		response := make([]byte, 2+(quantity+7)/8)
+		response[0], response[1] = functionCode, byte((quantity+7)/8)
+		for i := uint16(0); i < quantity; i++ {
+			if bits[address+i] {
+				response[2+i/8] |= 1 << (i % 8)
+			}
+		}
+		return response
+	case 0x03, 0x04:
+		if len(data) != 4 {
+			return exception(0x03)
+		}
+		registers := s.holding
+		if functionCode == 0x04 {
+			registers = s.input
+		}
+		address, quantity := binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+		response := make([]byte, 2+2*quantity)
+		response[0], response[1] = functionCode, byte(2*quantity)
+		for i := uint16(0); i < quantity; i++ {
+			binary.BigEndian.PutUint16(response[2+2*i:], registers[address+i])
+		}
+		return response
+	case 0x05:
+		if len(data) != 4 {
+			return exception(0x03)
+		}
+		address, value := binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+		if value != 0x0000 && value != 0xFF00 {
+			return exception(0x03)
+		}
+		s.coils[address] = value == 0xFF00
+		return pdu
+	case 0x06:
+		if len(data) != 4 {
+			return exception(0x03)
+		}
+		s.writeRegisters(binary.BigEndian.Uint16(data), data[2:4])
+		return pdu
+	case 0x0F:
+		if len(data) < 5 || len(data) != 5+int(data[4]) {
+			return exception(0x03)
+		}
+		address, quantity := binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+		for i := uint16(0); i < quantity; i++ {
+			s.coils[address+i] = data[5+i/8]&(1<<(i%8)) != 0
+		}
+		return pdu[:5]
+	case 0x10:
+		if len(data) < 5 || len(data) != 5+int(data[4]) {
+			return exception(0x03)
+		}
+		s.writeRegisters(binary.BigEndian.Uint16(data), data[5:])
+		return pdu[:5]
+	case 0x17:
+		if len(data) < 9 || len(data) != 9+int(data[8]) {
+			return exception(0x03)
+		}
+		s.writeRegisters(binary.BigEndian.Uint16(data[4:]), data[9:])
+		address, quantity := binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+		response := make([]byte, 2+2*quantity)
+		response[0], response[1] = functionCode, byte(2*quantity)
+		for i := uint16(0); i < quantity; i++ {
+			binary.BigEndian.PutUint16(response[2+2*i:], s.holding[address+i])
+		}
+		return response
+	case 0x14:
+		// A single sub-request is sufficient for the input
+		if len(data) != 8 || data[0] != 7 || data[1] != 0x06 {
+			return exception(0x03)
+		}
+		file, ok := s.files[binary.BigEndian.Uint16(data[2:])]
+		if !ok {
+			return exception(0x02)
+		}
+		record, length := binary.BigEndian.Uint16(data[4:]), binary.BigEndian.Uint16(data[6:])
+		response := []byte{functionCode, byte(2 + 2*length), byte(1 + 2*length), 0x06}
+		for i := uint16(0); i < length; i++ {
+			response = binary.BigEndian.AppendUint16(response, file[record+i])
+		}
+		return response
+	case 0x18:
+		if len(data) != 2 {
+			return exception(0x03)
+		}
+		queue, ok := s.fifo[binary.BigEndian.Uint16(data)]
+		if !ok {
+			return exception(0x02)
+		}
+		response := []byte{functionCode}
+		response = binary.BigEndian.AppendUint16(response, uint16(2+2*len(queue)))
+		response = binary.BigEndian.AppendUint16(response, uint16(len(queue)))
+		for _, value := range queue {
+			response = binary.BigEndian.AppendUint16(response, value)
+		}
+		return response
+	case 0x2B:
+		objects, ok := s.deviceID[slaveID]
+		if !ok {
+			return exception(0x01)
+		}
+		if len(data) != 3 || data[0] != 0x0E {
+			return exception(0x03)
+		}
+		var lastID byte
+		switch data[1] {
+		case 0x01:
+			lastID = 0x02
+		case 0x02:
+			lastID = 0x06
+		default:
+			return exception(0x03)
+		}
+		// Three objects per response, to make the client follow "more follows"
+		response := []byte{functionCode, 0x0E, data[1], data[1], 0x00, 0x00, 0x00}
+		for id := data[2]; id <= lastID; id++ {
+			value, ok := objects[id]
+			if !ok {
+				continue
+			}
+			if response[6] == 3 {
+				response[4], response[5] = 0xFF, id
+				break
+			}
+			response = append(response, id, byte(len(value)))
+			response = append(response, value...)
+			response[6]++
+		}
+		return response
+	default:
+		return exception(0x01)
+	}
+}
+
+// writeRegisters writes big-endian register values to the holding registers, except for read-only ones.
+func (s *testSlave) writeRegisters(address uint16, values []byte) {
+	for i := 0; i+1 < len(values); i += 2 {
+		if !s.readOnly[address] {
+			s.holding[address] = binary.BigEndian.Uint16(values[i:])
+		}
+		address++
+	}
+}
+
+// serveRTU answers RTU frames on a serial line until it is closed.
+func (s *testSlave) serveRTU(port io.ReadWriter) {
+	reader := bufio.NewReader(port)
+	for {
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return
+		}
+
+		var body []byte
+		switch header[1] {
+		case 0x01, 0x02, 0x03, 0x04, 0x05, 0x06:
+			body = make([]byte, 4+2)
+			if _, err := io.ReadFull(reader, body); err != nil {
+				return
+			}
+		case 0x0F, 0x10, 0x17:
+			// the byte count follows the fixed part of the request
+			fixed := 5
+			if header[1] == 0x17 {
+				fixed = 9
+			}
+			body = make([]byte, fixed)
+			if _, err := io.ReadFull(reader, body); err != nil {
+				return
+			}
+			body = append(body, make([]byte, int(body[fixed-1])+2)...)
+			if _, err := io.ReadFull(reader, body[fixed:]); err != nil {
+				return
+			}
+		default:
+			return
+		}
+
+		frame := append(header, body...)
+		if crc16(frame[:len(frame)-2]) != binary.LittleEndian.Uint16(frame[len(frame)-2:]) {
+			continue // a real slave ignores frames with a wrong CRC
+		}
+
+		response := append([]byte{frame[0]}, s.handle(frame[0], frame[1:len(frame)-2])...)
+		response = binary.LittleEndian.AppendUint16(response, crc16(response))
+		if _, err := port.Write(response); err != nil {
+			return
+		}
+	}
+}
+
+// serveASCII answers ASCII frames on a serial line until it is closed.
+func (s *testSlave) serveASCII(port io.ReadWriter) {
+	reader := bufio.NewReader(port)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		frame, err := hex.DecodeString(strings.TrimSuffix(strings.TrimPrefix(line, ":"), "\r\n"))
+		if err != nil || len(frame) < 3 || lrc(frame[:len(frame)-1]) != frame[len(frame)-1] {
+			continue
+		}
+
+		response := append([]byte{frame[0]}, s.handle(frame[0], frame[1:len(frame)-1])...)
+		response = append(response, lrc(response))
+		if _, err := io.WriteString(port, ":"+strings.ToUpper(hex.EncodeToString(response))+"\r\n"); err != nil {
+			return
+		}
+	}
+}
+
+// serveTCP answers Modbus TCP requests of all connections to the listener until it is closed.
+func (s *testSlave) serveTCP(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				// MBAP header: transaction ID, protocol ID, length and unit ID
+				header := make([]byte, 7)
+				if _, err := io.ReadFull(conn, header); err != nil {
+					return
+				}
+				pdu := make([]byte, int(binary.BigEndian.Uint16(header[4:]))-1)
+				if _, err := io.ReadFull(conn, pdu); err != nil {
+					return
+				}
+
+				response := s.handle(header[6], pdu)
+				binary.BigEndian.PutUint16(header[4:], uint16(len(response)+1))
+				if _, err := conn.Write(append(header, response...)); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// startTCPSlave serves a new test slave on a random local port and returns its controller address.
+func startTCPSlave() (*testSlave, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	DeferCleanup(listener.Close)
+
+	slave := newTestSlave()
+	go slave.serveTCP(listener)
+	return slave, "tcp://" + listener.Addr().String()
+}
+
+// runModbusStream runs a stream with the given modbus input configuration and collects its messages.
+func runModbusStream(inputYAML string) (*sync.Mutex, *[]*service.Message) {
+	builder := service.NewStreamBuilder()
+	Expect(builder.AddInputYAML(inputYAML)).To(Succeed())
+	Expect(builder.SetLoggerYAML(`level: off`)).To(Succeed())
+
+	var mu sync.Mutex
+	var msgs []*service.Message
+	Expect(builder.AddConsumerFunc(func(_ context.Context, msg *service.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		msgs = append(msgs, msg)
+		return nil
+	})).To(Succeed())
+
+	stream, err := builder.Build()
+	Expect(err).NotTo(HaveOccurred())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = stream.Run(ctx)
+	}()
+	DeferCleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return &mu, &msgs
+}
+
+// valuesByTag returns the latest payload of every tag of the collected messages.
+func valuesByTag(mu *sync.Mutex, msgs *[]*service.Message) map[string]string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	values := map[string]string{}
+	for _, msg := range *msgs {
+		tagName, _ := msg.MetaGet("modbus_tag_name")
+		b, err := msg.AsBytes()
+		Expect(err).NotTo(HaveOccurred())
+		values[tagName] = string(b)
+	}
+	return values
+}
+
+// crc16 calculates the Modbus RTU CRC.
+func crc16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// lrc calculates the Modbus ASCII longitudinal redundancy check.
+func lrc(data []byte) byte {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+	return -sum
+}
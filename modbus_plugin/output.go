@@ -0,0 +1,677 @@
+// Copyright 2024 UMH Systems GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus_plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grid-x/modbus"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// ModbusOutputConfigSpec defines the configuration options of the modbus output. The addresses use the
+// schema of the modbus input, but only coils and holding registers can be written.
+var ModbusOutputConfigSpec = service.NewConfigSpec().
+	Summary("Creates an output that writes values to the coils and holding registers of Modbus devices. Created & maintained by the United Manufacturing Hub. About us: www.umh.app").
+	Description("Each message either contains the value of the address that is selected by tagName, or, if tagName is empty, a JSON object with the values of multiple addresses by their name.").
+	Field(service.NewStringField("controller").Description("The Modbus controller address, e.g., 'tcp://localhost:502' or a serial device like 'file:///dev/ttyUSB0'").Default("tcp://localhost:502")).
+	Field(service.NewStringField("transmissionMode").Description("Transmission mode: 'TCP', 'RTUOverTCP', or 'ASCIIOverTCP' for TCP controllers, 'RTU' or 'ASCII' for serial controllers. Defaults to 'auto', which is 'TCP' for TCP controllers and 'RTU' for serial controllers.").Default("auto")).
+	Field(serialConfigField()).
+	Field(service.NewInterpolatedStringField("slaveID").Description("Slave ID of the Modbus device that the message is written to").Default(`${! @modbus_tag_slaveid | "1" }`)).
+	Field(service.NewInterpolatedStringField("tagName").Description("Name of the address that the payload is written to. If empty, the payload must be a JSON object with the values by address name.").Default(`${! @modbus_tag_name | "" }`)).
+	Field(service.NewDurationField("timeout").Description("Timeout of a request").Default("1s")).
+	Field(service.NewIntField("busyRetries").Description("Maximum number of retries when the device is busy").Default(3)).
+	Field(service.NewDurationField("busyRetriesWait").Description("Time to wait between retries when the device is busy").Default("200ms")).
+	Field(service.NewStringField("byteOrder").Description("Byte order: 'ABCD', 'DCBA', 'BADC', or 'CDAB'").Default("ABCD")).
+	Field(service.NewBoolField("readWriteMultiple").Description("Write holding registers with 'Read/Write Multiple Registers' (FC 23) instead of FC 6 and FC 16. The device returns the registers after the write, which is used for the verification.").Default(false)).
+	Field(service.NewBoolField("verify").Description("Read the written coils and registers back and fail the write if they do not contain the written values").Default(false)).
+	Field(service.NewObjectField("workarounds",
+		service.NewDurationField("pauseAfterConnect").Description("Pause after connect to delay the first request").Default("0s"),
+		service.NewStringField("stringRegisterLocation").Description("String byte-location in registers: 'lower', 'upper', or empty for both").Default(""),
+		service.NewDurationField("timeBetweenRequests").Description("Time between two requests to the same device. Useful to avoid flooding the device.").Default("0s")).
+		Description("Modbus workarounds. Required by some devices to work correctly. Should be left alone by default and must not be changed unless necessary.")).
+	Field(service.NewObjectListField("addresses",
+		service.NewStringField("name").Description("Field name"),
+		service.NewStringField("register").Description("Register type: 'coil' or 'holding'").Default("holding"),
+		service.NewIntField("address").Description("Address of the register to write"),
+		service.NewStringField("type").Description("Data type of the field. Not used for coils.").Default(""),
+		service.NewIntField("length").Description("Number of registers, only valid for STRING type").Default(0),
+		service.NewIntField("bit").Description("Bit of the register, only valid for BIT type").Default(0),
+		service.NewFloatField("scale").Description("Factor to scale the variable with. The value is divided by the scale before it is written.").Default(0.0),
+		service.NewStringField("byteOrder").Description("Byte order of the field: 'ABCD', 'DCBA', 'BADC', or 'CDAB', or eight letters like 'CDABGHEF' for 64-bit types. Defaults to the byteOrder of the output.").Default("")).
+		Description("List of Modbus addresses that can be written"))
+
+// ModbusOutput writes the values of messages to Modbus devices.
+type ModbusOutput struct {
+	Controller        string
+	TransmissionMode  string
+	Serial            SerialConfig
+	SlaveID           *service.InterpolatedString
+	TagName           *service.InterpolatedString
+	Timeout           time.Duration
+	BusyRetries       int
+	BusyRetriesWait   time.Duration
+	ByteOrder         string
+	ReadWriteMultiple bool // Write holding registers with FC 23
+	Verify            bool // Read the written values back
+
+	// Modbus Workarounds. Required by some devices to work correctly
+	PauseAfterConnect      time.Duration
+	StringRegisterLocation string
+	TimeBetweenRequests    time.Duration
+
+	// Addresses is the list of Modbus addresses that can be written
+	Addresses []ModbusDataItemWithAddress
+
+	// Internal
+	Handler    modbus.ClientHandler
+	SlaveMutex sync.Mutex // Avoids mixing up the writes to different slaves
+	Client     modbus.Client
+	Log        *service.Logger
+
+	targets map[string]*writeTarget
+}
+
+// writeTarget is an address of the output with its encoder.
+type writeTarget struct {
+	name     string
+	register string
+	address  uint16
+	length   uint16 // in registers, 1 for coils
+	partial  bool   // the field only occupies a part of the register
+	encoder  encoderFunc
+}
+
+// writeBlock is a range of consecutive coils or registers that is written with one request.
+type writeBlock struct {
+	register string
+	address  uint16
+	quantity uint16
+	coils    []bool
+	data     []byte // the registers in wire format
+}
+
+func init() {
+	err := service.RegisterOutput(
+		"modbus", ModbusOutputConfigSpec,
+		func(conf *service.ParsedConfig, mgr *service.Resources) (service.Output, int, error) {
+			output, err := newModbusOutput(conf, mgr)
+			// The writes to the device have to keep the order of the messages
+			return output, 1, err
+		})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// newModbusOutput parses the configuration of the modbus output and creates the encoders of the addresses.
+func newModbusOutput(conf *service.ParsedConfig, mgr *service.Resources) (*ModbusOutput, error) {
+	m := &ModbusOutput{
+		Log: mgr.Logger(),
+	}
+
+	var err error
+
+	if m.Controller, err = conf.FieldString("controller"); err != nil {
+		return nil, err
+	}
+	if m.TransmissionMode, err = conf.FieldString("transmissionMode"); err != nil {
+		return nil, err
+	}
+	if m.Serial, err = parseSerialConfig(conf); err != nil {
+		return nil, err
+	}
+	if m.SlaveID, err = conf.FieldInterpolatedString("slaveID"); err != nil {
+		return nil, err
+	}
+	if m.TagName, err = conf.FieldInterpolatedString("tagName"); err != nil {
+		return nil, err
+	}
+	if m.Timeout, err = conf.FieldDuration("timeout"); err != nil {
+		return nil, err
+	}
+	if m.BusyRetries, err = conf.FieldInt("busyRetries"); err != nil {
+		return nil, err
+	}
+	if m.BusyRetriesWait, err = conf.FieldDuration("busyRetriesWait"); err != nil {
+		return nil, err
+	}
+	if m.ByteOrder, err = conf.FieldString("byteOrder"); err != nil {
+		return nil, err
+	}
+	if m.ReadWriteMultiple, err = conf.FieldBool("readWriteMultiple"); err != nil {
+		return nil, err
+	}
+	if m.Verify, err = conf.FieldBool("verify"); err != nil {
+		return nil, err
+	}
+
+	workarounds := conf.Namespace("workarounds")
+	if m.PauseAfterConnect, err = workarounds.FieldDuration("pauseAfterConnect"); err != nil {
+		return nil, err
+	}
+	if m.StringRegisterLocation, err = workarounds.FieldString("stringRegisterLocation"); err != nil {
+		return nil, err
+	}
+	if m.TimeBetweenRequests, err = workarounds.FieldDuration("timeBetweenRequests"); err != nil {
+		return nil, err
+	}
+
+	if m.ByteOrder == "" {
+		m.ByteOrder = "ABCD"
+	}
+	order, err := normalizeByteOrder(m.ByteOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	switch m.StringRegisterLocation {
+	case "", "both", "lower", "upper":
+	default:
+		return nil, fmt.Errorf("invalid 'string_register_location' %q", m.StringRegisterLocation)
+	}
+
+	addressesConf, err := conf.FieldObjectList("addresses")
+	if err != nil {
+		return nil, err
+	}
+	if len(addressesConf) == 0 {
+		return nil, fmt.Errorf("addresses are empty")
+	}
+
+	m.targets = make(map[string]*writeTarget, len(addressesConf))
+	for _, addrConf := range addressesConf {
+		item, err := parseOutputAddress(addrConf, false)
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := m.targets[item.Name]; exists {
+			return nil, fmt.Errorf("duplicate field name %q", item.Name)
+		}
+
+		target, err := newWriteTarget(item, order, m.StringRegisterLocation)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", item.Name, err)
+		}
+		m.targets[item.Name] = target
+		m.Addresses = append(m.Addresses, item)
+	}
+
+	m.Handler, err = newClientHandler(m.Controller, m.TransmissionMode, m.Timeout, m.Serial)
+	if err != nil {
+		return nil, err
+	}
+	m.Client = modbus.NewClient(m.Handler)
+
+	return m, nil
+}
+
+// parseOutputAddress parses and checks an entry of the addresses of the output. Discrete inputs and input
+// registers are only accepted with readOnlyRegisters, e.g. for the register map of the server.
+func parseOutputAddress(addrConf *service.ParsedConfig, readOnlyRegisters bool) (ModbusDataItemWithAddress, error) {
+	item := ModbusDataItemWithAddress{}
+	var err error
+
+	if item.Name, err = addrConf.FieldString("name"); err != nil {
+		return item, err
+	}
+	if item.Name == "" {
+		return item, fmt.Errorf("empty field name in request")
+	}
+
+	if item.Register, err = addrConf.FieldString("register"); err != nil {
+		return item, err
+	}
+	switch item.Register {
+	case "":
+		item.Register = "holding"
+	case "coil", "holding":
+	case "discrete", "input":
+		if !readOnlyRegisters {
+			return item, fmt.Errorf("register-type %q of field %q is read-only", item.Register, item.Name)
+		}
+	default:
+		return item, fmt.Errorf("unknown register-type %q for field %q", item.Register, item.Name)
+	}
+
+	if addr, err := addrConf.FieldInt("address"); err != nil {
+		return item, err
+	} else if addr < 0 || addr > 65535 {
+		return item, fmt.Errorf("value out of range for uint16: %d", addr)
+	} else {
+		item.Address = uint16(addr)
+	}
+
+	if item.Type, err = addrConf.FieldString("type"); err != nil {
+		return item, err
+	}
+
+	if length, err := addrConf.FieldInt("length"); err != nil {
+		return item, err
+	} else if length < 0 || length > 65535 {
+		return item, fmt.Errorf("value out of range for uint16: %d", length)
+	} else {
+		item.Length = uint16(length)
+	}
+
+	if bit, err := addrConf.FieldInt("bit"); err != nil {
+		return item, err
+	} else if bit < 0 || bit > 15 {
+		return item, fmt.Errorf("bit out of range for field %q: %d", item.Name, bit)
+	} else {
+		item.Bit = uint16(bit)
+	}
+
+	if item.Scale, err = addrConf.FieldFloat("scale"); err != nil {
+		return item, err
+	}
+	if item.ByteOrder, err = addrConf.FieldString("byteOrder"); err != nil {
+		return item, err
+	}
+
+	if item.Register == "coil" || item.Register == "discrete" {
+		return item, nil
+	}
+
+	switch item.Type {
+	case "INT8L", "INT8H", "INT16", "INT32", "INT64",
+		"UINT8L", "UINT8H", "UINT16", "UINT32", "UINT64",
+		"FLOAT16", "FLOAT32", "FLOAT64":
+		if item.Length != 0 {
+			return item, fmt.Errorf("length option cannot be used for type %q of field %q", item.Type, item.Name)
+		}
+		if item.Bit != 0 {
+			return item, fmt.Errorf("bit option cannot be used for type %q of field %q", item.Type, item.Name)
+		}
+	case "STRING":
+		if item.Length < 1 {
+			return item, fmt.Errorf("missing length for string field %q", item.Name)
+		}
+		if item.Bit != 0 {
+			return item, fmt.Errorf("bit option cannot be used for type %q of field %q", item.Type, item.Name)
+		}
+		if item.Scale != 0.0 {
+			return item, fmt.Errorf("scale option cannot be used for string field %q", item.Name)
+		}
+	case "BIT":
+		if item.Length != 0 {
+			return item, fmt.Errorf("length option cannot be used for type %q of field %q", item.Type, item.Name)
+		}
+	default:
+		return item, fmt.Errorf("unknown register data-type %q for field %q", item.Type, item.Name)
+	}
+
+	return item, nil
+}
+
+// newWriteTarget creates the target of an address with the encoder of its type. The byte order of the
+// address overrides the given one.
+func newWriteTarget(item ModbusDataItemWithAddress, order string, strloc string) (*writeTarget, error) {
+	target := &writeTarget{
+		name:     item.Name,
+		register: item.Register,
+		address:  item.Address,
+		length:   1,
+	}
+
+	if item.Register == "coil" || item.Register == "discrete" {
+		return target, nil
+	}
+
+	length, err := determineTagLength(item.Type, item.Length)
+	if err != nil {
+		return nil, err
+	}
+	if item.Address > math.MaxUint16-length+1 {
+		return nil, errAddressOverflow
+	}
+	target.length = length
+	target.partial = isPartialRegisterType(item.Type)
+
+	// The byte order of the field overrides the one of the output
+	if order, err = fieldByteOrder(item.ByteOrder, order, item.Type); err != nil {
+		return nil, fmt.Errorf("field %q: %w", item.Name, err)
+	}
+
+	target.encoder, err = determineEncoder(item.Type, order, item.Scale, uint8(item.Bit), length, strloc)
+	if err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+func (m *ModbusOutput) Connect(context.Context) error {
+	if err := m.Handler.Connect(); err != nil {
+		m.Log.Errorf("Failed to connect to Modbus device at %s: %v", m.Controller, err)
+		return err
+	}
+
+	if m.PauseAfterConnect != 0 {
+		time.Sleep(m.PauseAfterConnect)
+	}
+
+	m.Log.Infof("Successfully connected to Modbus device at %s", m.Controller)
+	return nil
+}
+
+// Write writes the values of a message to the slave of the message. Messages that do not match the
+// addresses or whose values cannot be encoded are rejected with an error, like errors of the device.
+func (m *ModbusOutput) Write(ctx context.Context, msg *service.Message) error {
+	slaveIDString, err := m.SlaveID.TryString(msg)
+	if err != nil {
+		return fmt.Errorf("failed to interpolate slaveID: %w", err)
+	}
+	tagName, err := m.TagName.TryString(msg)
+	if err != nil {
+		return fmt.Errorf("failed to interpolate tagName: %w", err)
+	}
+	payload, err := msg.AsBytes()
+	if err != nil {
+		return err
+	}
+
+	slaveID, err := strconv.ParseUint(strings.TrimSpace(slaveIDString), 10, 8)
+	if err != nil {
+		return fmt.Errorf("invalid slave ID %q", slaveIDString)
+	}
+
+	values, err := decodeWriteValues(tagName, payload)
+	if err != nil {
+		return err
+	}
+
+	targets := make([]*writeTarget, 0, len(values))
+	for name := range values {
+		target, ok := m.targets[name]
+		if !ok {
+			return fmt.Errorf("unknown address %q", name)
+		}
+		targets = append(targets, target)
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].register != targets[j].register {
+			return targets[i].register < targets[j].register
+		}
+		return targets[i].address < targets[j].address
+	})
+
+	err = m.writeSlaveData(byte(slaveID), targets, values)
+	if isBrokenPipeError(err) {
+		m.Log.Errorf("Broken pipe error detected for slave %d, reconnecting...", slaveID)
+		if err := m.Close(ctx); err != nil {
+			m.Log.Errorf("Failed to close Modbus connection: %v", err)
+		}
+		return service.ErrNotConnected
+	}
+	return err
+}
+
+// decodeWriteValues returns the values of a message by address name. With a tag name, the payload is the
+// value of this address. Payloads that are no JSON value are written as a string.
+func decodeWriteValues(tagName string, payload []byte) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+
+	if tagName != "" {
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil || decoder.More() {
+			value = string(payload)
+		}
+		return map[string]interface{}{tagName: value}, nil
+	}
+
+	var values map[string]interface{}
+	if err := decoder.Decode(&values); err != nil {
+		return nil, fmt.Errorf("payload is no JSON object with values by address name: %w", err)
+	}
+	if len(values) == 0 {
+		return nil, errors.New("payload contains no values")
+	}
+	return values, nil
+}
+
+// encodeError is returned if a value cannot be encoded for its address.
+type encodeError struct {
+	name string
+	err  error
+}
+
+func (e *encodeError) Error() string {
+	return fmt.Sprintf("invalid value for %q: %v", e.name, e.err)
+}
+
+// writeSlaveData writes the values to a slave and retries if the slave is busy.
+func (m *ModbusOutput) writeSlaveData(slaveID byte, targets []*writeTarget, values map[string]interface{}) (err error) {
+	m.SlaveMutex.Lock()
+	defer m.SlaveMutex.Unlock()
+
+	m.Handler.SetSlave(slaveID)
+
+	for retry := 0; retry < m.BusyRetries; retry++ {
+		err = m.writeTargets(targets, values)
+		if err == nil {
+			return nil
+		}
+
+		var mbErr *modbus.Error
+		if !errors.As(err, &mbErr) || mbErr.ExceptionCode != modbus.ExceptionCodeServerDeviceBusy {
+			return err
+		}
+
+		m.Log.Infof("Slave %d busy! Retrying %d more time(s)...", slaveID, m.BusyRetries-retry)
+		time.Sleep(m.BusyRetriesWait)
+	}
+
+	return m.writeTargets(targets, values)
+}
+
+// writeTargets encodes the values into an image of the coils and registers, which is written with as few
+// requests as possible. Fields that only occupy a part of a register are merged into its current content.
+func (m *ModbusOutput) writeTargets(targets []*writeTarget, values map[string]interface{}) error {
+	coils := make(map[uint16]bool)
+	registers := make(map[uint16][]byte)
+
+	for _, target := range targets {
+		value := values[target.name]
+
+		if target.register == "coil" {
+			v, err := encodeBool(value)
+			if err != nil {
+				return &encodeError{name: target.name, err: err}
+			}
+			coils[target.address] = v
+			continue
+		}
+
+		var current []byte
+		if target.partial {
+			current = registers[target.address]
+			if current == nil {
+				var err error
+				if current, err = m.Client.ReadHoldingRegisters(target.address, 1); err != nil {
+					return err
+				}
+				m.pause()
+			}
+		}
+
+		data, err := target.encoder(value, current)
+		if err != nil {
+			return &encodeError{name: target.name, err: err}
+		}
+		for i := uint16(0); i < target.length; i++ {
+			registers[target.address+i] = data[2*i : 2*i+2]
+		}
+	}
+
+	for _, block := range buildWriteBlocks(coils, registers, m.ReadWriteMultiple) {
+		if err := m.writeBlock(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildWriteBlocks merges consecutive coils and registers into blocks that fit into one request.
+func buildWriteBlocks(coils map[uint16]bool, registers map[uint16][]byte, readWriteMultiple bool) []writeBlock {
+	var blocks []writeBlock
+
+	coilAddresses := make([]uint16, 0, len(coils))
+	for address := range coils {
+		coilAddresses = append(coilAddresses, address)
+	}
+	sort.Slice(coilAddresses, func(i, j int) bool { return coilAddresses[i] < coilAddresses[j] })
+	for _, address := range coilAddresses {
+		n := len(blocks)
+		if n > 0 && blocks[n-1].register == "coil" && blocks[n-1].address+blocks[n-1].quantity == address &&
+			blocks[n-1].quantity < maxQuantityWriteCoils {
+			blocks[n-1].quantity++
+			blocks[n-1].coils = append(blocks[n-1].coils, coils[address])
+			continue
+		}
+		blocks = append(blocks, writeBlock{register: "coil", address: address, quantity: 1, coils: []bool{coils[address]}})
+	}
+
+	maxRegisters := maxQuantityWriteRegisters
+	if readWriteMultiple {
+		maxRegisters = maxQuantityReadWriteRegisters
+	}
+	registerAddresses := make([]uint16, 0, len(registers))
+	for address := range registers {
+		registerAddresses = append(registerAddresses, address)
+	}
+	sort.Slice(registerAddresses, func(i, j int) bool { return registerAddresses[i] < registerAddresses[j] })
+	for _, address := range registerAddresses {
+		n := len(blocks)
+		if n > 0 && blocks[n-1].register == "holding" && blocks[n-1].address+blocks[n-1].quantity == address &&
+			blocks[n-1].quantity < maxRegisters {
+			blocks[n-1].quantity++
+			blocks[n-1].data = append(blocks[n-1].data, registers[address]...)
+			continue
+		}
+		blocks = append(blocks, writeBlock{register: "holding", address: address, quantity: 1, data: append([]byte(nil), registers[address]...)})
+	}
+
+	return blocks
+}
+
+// writeBlock writes a block with FC 5 or FC 15 for coils and FC 6, FC 16 or FC 23 for holding registers,
+// and verifies it if requested.
+func (m *ModbusOutput) writeBlock(block writeBlock) error {
+	defer m.pause()
+
+	if block.register == "coil" {
+		packed := packCoils(block.coils)
+		m.Log.Debugf("writing coil@%v[%v]: %v", block.address, block.quantity, block.coils)
+		if block.quantity == 1 {
+			value := uint16(0x0000)
+			if block.coils[0] {
+				value = 0xFF00
+			}
+			if _, err := m.Client.WriteSingleCoil(block.address, value); err != nil {
+				return err
+			}
+		} else if _, err := m.Client.WriteMultipleCoils(block.address, block.quantity, packed); err != nil {
+			return err
+		}
+
+		if !m.Verify {
+			return nil
+		}
+		m.pause()
+		readBack, err := m.Client.ReadCoils(block.address, block.quantity)
+		if err != nil {
+			return err
+		}
+		if len(readBack) < len(packed) || !bytes.Equal(maskCoils(readBack[:len(packed)], block.quantity), packed) {
+			return fmt.Errorf("verification of coil@%v[%v] failed: wrote %v, read %v", block.address, block.quantity, packed, readBack)
+		}
+		return nil
+	}
+
+	m.Log.Debugf("writing holding@%v[%v]: %v", block.address, block.quantity, block.data)
+	var readBack []byte
+	var err error
+	switch {
+	case m.ReadWriteMultiple:
+		readBack, err = m.Client.ReadWriteMultipleRegisters(block.address, block.quantity, block.address, block.quantity, block.data)
+	case block.quantity == 1:
+		_, err = m.Client.WriteSingleRegister(block.address, binary.BigEndian.Uint16(block.data))
+	default:
+		_, err = m.Client.WriteMultipleRegisters(block.address, block.quantity, block.data)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !m.Verify {
+		return nil
+	}
+	if !m.ReadWriteMultiple {
+		m.pause()
+		if readBack, err = m.Client.ReadHoldingRegisters(block.address, block.quantity); err != nil {
+			return err
+		}
+	}
+	if !bytes.Equal(readBack, block.data) {
+		return fmt.Errorf("verification of holding@%v[%v] failed: wrote %v, read %v", block.address, block.quantity, block.data, readBack)
+	}
+	return nil
+}
+
+// packCoils packs coil states into bytes with the first coil in the least significant bit.
+func packCoils(coils []bool) []byte {
+	packed := make([]byte, (len(coils)+7)/8)
+	for i, coil := range coils {
+		if coil {
+			packed[i/8] |= 1 << (i % 8)
+		}
+	}
+	return packed
+}
+
+// maskCoils clears the bits after the last coil, whose content is undefined in a read response.
+func maskCoils(packed []byte, quantity uint16) []byte {
+	masked := append([]byte(nil), packed...)
+	if rest := quantity % 8; rest != 0 {
+		masked[len(masked)-1] &= byte(1)<<rest - 1
+	}
+	return masked
+}
+
+// pause sleeps between two requests to avoid flooding the device.
+func (m *ModbusOutput) pause() {
+	if m.TimeBetweenRequests > 0 {
+		time.Sleep(m.TimeBetweenRequests)
+	}
+}
+
+func (m *ModbusOutput) Close(context.Context) error {
+	if m.Handler != nil {
+		return m.Handler.Close()
+	}
+	return nil
+}
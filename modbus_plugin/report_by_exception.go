@@ -0,0 +1,56 @@
+// Copyright 2024 UMH Systems GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus_plugin
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/redpanda-data/benthos/v4/public/service"
+
+	"github.com/united-manufacturing-hub/benthos-umh/internal/report_by_exception"
+)
+
+// filterReported returns the messages of a slave whose values have to be reported by the report-by-exception
+// mode and remembers their values by "slaveID/tag".
+func filterReported(rbe *report_by_exception.ReportByException, slaveID byte, msgs service.MessageBatch) service.MessageBatch {
+	if !rbe.Enabled {
+		return msgs
+	}
+
+	now := time.Now()
+	filtered := msgs[:0]
+	for _, msg := range msgs {
+		// Every record of a FIFO queue is an event of its own, even if it equals the previous one
+		if register, _ := msg.MetaGet("modbus_tag_register"); register == "fifo" {
+			filtered = append(filtered, msg)
+			continue
+		}
+
+		tagName, _ := msg.MetaGet("modbus_tag_name_original")
+		key := strconv.Itoa(int(slaveID)) + "/" + tagName
+
+		payload, err := msg.AsBytes()
+		if err != nil {
+			continue
+		}
+		dataType, _ := msg.MetaGet("modbus_tag_datatype_json")
+
+		if rbe.Report(key, payload, dataType == "number", now) {
+			filtered = append(filtered, msg)
+		}
+	}
+	return filtered
+}
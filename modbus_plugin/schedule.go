@@ -0,0 +1,268 @@
+// Copyright 2024 UMH Systems GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus_plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// PollGroup is a polling interval for a group of addresses. Addresses with their own interval are put into
+// unnamed groups, whose name is the interval prefixed with "@".
+type PollGroup struct {
+	Name     string
+	Interval time.Duration
+}
+
+// pollSchedule decides which poll groups are read in the next cycle.
+type pollSchedule struct {
+	groups  []*scheduledGroup
+	started bool
+
+	// cycles caches the slaves with the requests of the due groups by their keys, so that the requests
+	// of a combination of groups are only created once
+	cycles map[string][]*ModbusSlave
+}
+
+type scheduledGroup struct {
+	key      string
+	interval time.Duration
+	next     time.Time
+}
+
+// parsePollGroups parses the pollGroups list.
+func parsePollGroups(confs []*service.ParsedConfig) ([]*PollGroup, error) {
+	pollGroups := make([]*PollGroup, 0, len(confs))
+	names := make(map[string]bool)
+
+	for i, conf := range confs {
+		name, err := conf.FieldString("name")
+		if err != nil {
+			return nil, err
+		}
+
+		interval, err := conf.FieldDuration("interval")
+		if err != nil {
+			return nil, err
+		}
+
+		if name == "" || strings.HasPrefix(name, "@") {
+			return nil, fmt.Errorf("poll group %d: name must be set and must not start with '@'", i)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("poll group %d: duplicate name %q", i, name)
+		}
+		names[name] = true
+
+		if interval <= 0 {
+			return nil, fmt.Errorf("poll group %s: interval must be greater than 0", name)
+		}
+
+		pollGroups = append(pollGroups, &PollGroup{Name: name, Interval: interval})
+	}
+
+	return pollGroups, nil
+}
+
+// parsePollGroup returns the key of the poll group of an address. An interval of the address adds an unnamed
+// group with this interval, if it does not exist yet.
+func (m *ModbusInput) parsePollGroup(addrConf *service.ParsedConfig, fieldName string) (string, error) {
+	pollGroup, err := addrConf.FieldString("pollGroup")
+	if err != nil {
+		return "", err
+	}
+
+	if addrConf.Contains("interval") {
+		if pollGroup != "" {
+			return "", fmt.Errorf("pollGroup and interval cannot be combined for field %q", fieldName)
+		}
+
+		interval, err := addrConf.FieldDuration("interval")
+		if err != nil {
+			return "", err
+		}
+		if interval <= 0 {
+			return "", fmt.Errorf("interval of field %q must be greater than 0", fieldName)
+		}
+
+		key := "@" + interval.String()
+		if m.pollGroup(key) == nil {
+			m.PollGroups = append(m.PollGroups, &PollGroup{Name: key, Interval: interval})
+		}
+		return key, nil
+	}
+
+	if pollGroup != "" && m.pollGroup(pollGroup) == nil {
+		return "", fmt.Errorf("unknown poll group %q for field %q", pollGroup, fieldName)
+	}
+	return pollGroup, nil
+}
+
+// pollGroup returns the poll group with the given name, or nil if it does not exist.
+func (m *ModbusInput) pollGroup(name string) *PollGroup {
+	for _, pollGroup := range m.PollGroups {
+		if pollGroup.Name == name {
+			return pollGroup
+		}
+	}
+	return nil
+}
+
+// newPollSchedule creates the schedule of all poll groups that contain at least one address. Addresses without
+// a poll group are in the default group, which is read every TimeBetweenReads.
+func (m *ModbusInput) newPollSchedule() *pollSchedule {
+	used := make(map[string]bool)
+	for _, slave := range m.slavesToRead() {
+		for _, item := range slave.Addresses {
+			used[item.PollGroup] = true
+		}
+	}
+
+	schedule := &pollSchedule{cycles: make(map[string][]*ModbusSlave)}
+	if used[""] {
+		schedule.groups = append(schedule.groups, &scheduledGroup{interval: m.TimeBetweenReads})
+	}
+	for _, pollGroup := range m.PollGroups {
+		if !used[pollGroup.Name] {
+			m.Log.Warnf("Poll group %s is not read, as no address is assigned to it", pollGroup.Name)
+			continue
+		}
+		schedule.groups = append(schedule.groups, &scheduledGroup{key: pollGroup.Name, interval: pollGroup.Interval})
+	}
+
+	return schedule
+}
+
+// nextCycle waits until the next poll groups are due and returns the slaves with the requests of their
+// addresses. Inputs that are not created from a configuration have no schedule and read all addresses
+// every TimeBetweenReads.
+func (m *ModbusInput) nextCycle(ctx context.Context) ([]*ModbusSlave, error) {
+	if m.schedule == nil {
+		if m.TimeBetweenReads > 0 {
+			time.Sleep(m.TimeBetweenReads)
+		}
+		return m.slavesToRead(), nil
+	}
+
+	due, err := m.schedule.wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return m.cycleSlaves(due)
+}
+
+// wait waits until the first poll group is due and returns the keys of all groups that are due by then,
+// so that they are read together.
+func (s *pollSchedule) wait(ctx context.Context) ([]string, error) {
+	if len(s.groups) == 0 {
+		return nil, nil
+	}
+
+	if !s.started {
+		// All groups are read in the first cycle and keep their cadence from then on
+		now := time.Now()
+		for _, group := range s.groups {
+			group.next = now
+		}
+		s.started = true
+	}
+
+	next := s.groups[0].next
+	for _, group := range s.groups[1:] {
+		if group.next.Before(next) {
+			next = group.next
+		}
+	}
+
+	if wait := time.Until(next); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	now := time.Now()
+	var due []string
+	for _, group := range s.groups {
+		if group.next.After(now) {
+			continue
+		}
+		due = append(due, group.key)
+		group.advance(now)
+	}
+	return due, nil
+}
+
+// advance schedules the next poll of the group one interval after the current one. The polls stay on
+// the cadence of the first poll, so the time that a cycle takes does not add up. Polls that were missed
+// because a cycle took longer than the interval are skipped instead of being read in a burst.
+func (g *scheduledGroup) advance(now time.Time) {
+	if g.interval <= 0 {
+		g.next = now
+		return
+	}
+
+	g.next = g.next.Add(g.interval)
+	if !g.next.After(now) {
+		missed := now.Sub(g.next)/g.interval + 1
+		g.next = g.next.Add(missed * g.interval)
+	}
+}
+
+// cycleSlaves returns the slaves with the requests for the addresses of the due poll groups. The addresses
+// of all due groups are merged before the requests are created, so that the optimization can combine them.
+func (m *ModbusInput) cycleSlaves(due []string) ([]*ModbusSlave, error) {
+	key := strings.Join(due, "\x00")
+	if slaves, ok := m.schedule.cycles[key]; ok {
+		return slaves, nil
+	}
+
+	isDue := make(map[string]bool, len(due))
+	for _, group := range due {
+		isDue[group] = true
+	}
+
+	var slaves []*ModbusSlave
+	for _, slave := range m.slavesToRead() {
+		var addresses []ModbusDataItemWithAddress
+		for _, item := range slave.Addresses {
+			if isDue[item.PollGroup] {
+				addresses = append(addresses, item)
+			}
+		}
+		if len(addresses) == 0 {
+			continue
+		}
+
+		cycleSlave := *slave
+		cycleSlave.Addresses = addresses
+		requestSet, err := m.createRequestSet(&cycleSlave)
+		if err != nil {
+			return nil, err
+		}
+		cycleSlave.RequestSet = requestSet
+		slaves = append(slaves, &cycleSlave)
+	}
+
+	m.schedule.cycles[key] = slaves
+	return slaves, nil
+}
@@ -0,0 +1,699 @@
+// Copyright 2024 UMH Systems GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus_plugin
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/grid-x/modbus"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+const (
+	// maxServerFrameLength is the maximum value of the length field of a Modbus TCP frame (unit ID and PDU)
+	maxServerFrameLength = 254
+
+	// exceptionCodeGatewayTargetDeviceFailedToRespond is returned for requests to other slave IDs
+	exceptionCodeGatewayTargetDeviceFailedToRespond = 0x0B
+
+	// serverImageSize is the number of addresses of each register type
+	serverImageSize = 1 << 16
+
+	// serverWriteBackQueueSize is the number of client writes that are buffered until the modbus_server_writes
+	// input reads them
+	serverWriteBackQueueSize = 1000
+)
+
+// ModbusServerConfigSpec defines the configuration options of the modbus_server output. The addresses use
+// the schema of the modbus input and form the register map that clients can read.
+var ModbusServerConfigSpec = service.NewConfigSpec().
+	Summary("Creates an output that serves the values of messages to Modbus TCP clients. Created & maintained by the United Manufacturing Hub. About us: www.umh.app").
+	Description("The output is a Modbus TCP server with a register map of coils, discrete inputs, holding registers and input registers. Each message either contains the value of the address that is selected by tagName, or, if tagName is empty, a JSON object with the values of multiple addresses by their name. With writeBack, clients can write the coils and holding registers and the writes are emitted by the modbus_server_writes input.").
+	Field(service.NewStringField("listenAddress").Description("Address that the server listens on, e.g., '0.0.0.0:502'").Default("0.0.0.0:502")).
+	Field(service.NewIntField("slaveID").Description("Slave ID that the server answers. Requests to the slave IDs 0 and 255 are answered as well.").Default(1)).
+	Field(service.NewInterpolatedStringField("tagName").Description("Name of the address that the payload is written to. If empty, the payload must be a JSON object with the values by address name.").Default(`${! @modbus_tag_name | "" }`)).
+	Field(service.NewStringField("byteOrder").Description("Byte order: 'ABCD', 'DCBA', 'BADC', or 'CDAB'").Default("ABCD")).
+	Field(service.NewStringField("writeBack").Description("If set, clients can write the coils and holding registers and every write is emitted by the modbus_server_writes input of the same stream with the same writeBack name. If not set, writes are rejected.").Default("")).
+	Field(service.NewObjectListField("addresses",
+		service.NewStringField("name").Description("Field name"),
+		service.NewStringField("register").Description("Register type: 'coil', 'discrete', 'holding', or 'input'").Default("holding"),
+		service.NewIntField("address").Description("Address of the register"),
+		service.NewStringField("type").Description("Data type of the field. Not used for coils and discrete inputs.").Default(""),
+		service.NewIntField("length").Description("Number of registers, only valid for STRING type").Default(0),
+		service.NewIntField("bit").Description("Bit of the register, only valid for BIT type").Default(0),
+		service.NewFloatField("scale").Description("Factor to scale the variable with. The value is divided by the scale before it is served, and writes of clients are multiplied with it.").Default(0.0),
+		service.NewStringField("byteOrder").Description("Byte order of the field: 'ABCD', 'DCBA', 'BADC', or 'CDAB', or eight letters like 'CDABGHEF' for 64-bit types. Defaults to the byteOrder of the output.").Default("")).
+		Description("Register map of the server"))
+
+// ModbusServerWritesConfigSpec defines the configuration options of the modbus_server_writes input.
+var ModbusServerWritesConfigSpec = service.NewConfigSpec().
+	Summary("Creates an input that emits the values that Modbus clients write to a modbus_server output. Created & maintained by the United Manufacturing Hub. About us: www.umh.app").
+	Description("Each write of a client emits a batch with a message per address of the register map that the write touched. The values are decoded like in the modbus input.").
+	Field(service.NewStringField("writeBack").Description("The writeBack name of the modbus_server output of the same stream."))
+
+// ModbusServer serves the values of messages to Modbus TCP clients.
+type ModbusServer struct {
+	ListenAddress string
+	SlaveID       byte
+	TagName       *service.InterpolatedString
+	ByteOrder     string
+	WriteBack     string // Name of the queue of the client writes. Writes are rejected if empty.
+
+	// Addresses is the register map of the server
+	Addresses []ModbusDataItemWithAddress
+
+	Log *service.Logger
+
+	// fields are the addresses by name, sorted are the same addresses ordered by register and address
+	fields map[string]*serverField
+	sorted []*serverField
+
+	writeBack      *serverWriteBack
+	writeBackQueue chan service.MessageBatch
+
+	// mu guards the register image
+	mu       sync.Mutex
+	coils    []bool
+	discrete []bool
+	holding  []uint16
+	input    []uint16
+
+	// connMu guards the listener and the client connections
+	connMu   sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+// serverField is an address of the register map with the encoder for the messages and the converter for
+// the writes of clients.
+type serverField struct {
+	target *writeTarget
+	tag    modbusTag
+}
+
+func init() {
+	err := service.RegisterOutput(
+		"modbus_server", ModbusServerConfigSpec,
+		func(conf *service.ParsedConfig, mgr *service.Resources) (service.Output, int, error) {
+			output, err := newModbusServer(conf, mgr)
+			// The values have to be updated in the order of the messages
+			return output, 1, err
+		})
+	if err != nil {
+		panic(err)
+	}
+
+	err = service.RegisterBatchInput(
+		"modbus_server_writes", ModbusServerWritesConfigSpec,
+		func(conf *service.ParsedConfig, mgr *service.Resources) (service.BatchInput, error) {
+			writeBack, err := conf.FieldString("writeBack")
+			if err != nil {
+				return nil, err
+			}
+			if writeBack == "" {
+				return nil, errors.New("writeBack must be set")
+			}
+			return service.AutoRetryNacksBatched(&ModbusServerWritesInput{WriteBack: writeBack, resources: mgr}), nil
+		})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// serverWriteBackKey is the key of a write-back queue in the resources of a stream.
+type serverWriteBackKey string
+
+// serverWriteBack is the queue between the modbus_server output and the modbus_server_writes inputs of a
+// stream with the same writeBack name.
+type serverWriteBack struct {
+	queue chan service.MessageBatch
+	owned atomic.Bool // set while a modbus_server output writes to the queue
+}
+
+// serverWriteBackQueue returns the write-back queue of a writeBack name and creates it if necessary, so that
+// the output and the input can be created in any order. The queues are kept in the resources of the stream,
+// so streams with the same writeBack name do not share their writes.
+func serverWriteBackQueue(mgr *service.Resources, name string) *serverWriteBack {
+	writeBack, _ := mgr.GetOrSetGeneric(serverWriteBackKey(name), &serverWriteBack{
+		queue: make(chan service.MessageBatch, serverWriteBackQueueSize),
+	})
+	return writeBack.(*serverWriteBack)
+}
+
+// newModbusServer parses the configuration of the modbus_server output and creates the register map.
+func newModbusServer(conf *service.ParsedConfig, mgr *service.Resources) (*ModbusServer, error) {
+	m := &ModbusServer{
+		Log:      mgr.Logger(),
+		coils:    make([]bool, serverImageSize),
+		discrete: make([]bool, serverImageSize),
+		holding:  make([]uint16, serverImageSize),
+		input:    make([]uint16, serverImageSize),
+		conns:    make(map[net.Conn]struct{}),
+	}
+
+	var err error
+
+	if m.ListenAddress, err = conf.FieldString("listenAddress"); err != nil {
+		return nil, err
+	}
+	if slaveID, err := conf.FieldInt("slaveID"); err != nil {
+		return nil, err
+	} else if slaveID < 1 || slaveID > 247 {
+		return nil, fmt.Errorf("slave ID %d out of range, must be between 1 and 247", slaveID)
+	} else {
+		m.SlaveID = byte(slaveID)
+	}
+	if m.TagName, err = conf.FieldInterpolatedString("tagName"); err != nil {
+		return nil, err
+	}
+	if m.ByteOrder, err = conf.FieldString("byteOrder"); err != nil {
+		return nil, err
+	}
+	if m.WriteBack, err = conf.FieldString("writeBack"); err != nil {
+		return nil, err
+	}
+	if m.WriteBack != "" {
+		// Every write has to be emitted once, so only one output may write to the queue
+		m.writeBack = serverWriteBackQueue(mgr, m.WriteBack)
+		if m.writeBack.owned.Swap(true) {
+			return nil, fmt.Errorf("writeBack %q is already used by another modbus_server output", m.WriteBack)
+		}
+		m.writeBackQueue = m.writeBack.queue
+	}
+
+	if m.ByteOrder == "" {
+		m.ByteOrder = "ABCD"
+	}
+	order, err := normalizeByteOrder(m.ByteOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	addressesConf, err := conf.FieldObjectList("addresses")
+	if err != nil {
+		return nil, err
+	}
+	if len(addressesConf) == 0 {
+		return nil, fmt.Errorf("addresses are empty")
+	}
+
+	m.fields = make(map[string]*serverField, len(addressesConf))
+	for _, addrConf := range addressesConf {
+		item, err := parseOutputAddress(addrConf, true)
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := m.fields[item.Name]; exists {
+			return nil, fmt.Errorf("duplicate field name %q", item.Name)
+		}
+
+		field := &serverField{}
+		if field.target, err = newWriteTarget(item, order, ""); err != nil {
+			return nil, fmt.Errorf("field %q: %w", item.Name, err)
+		}
+
+		// The writes of clients are decoded like the values of the input, coils as booleans
+		tagItem := item
+		if tagItem.Register == "coil" || tagItem.Register == "discrete" {
+			tagItem.Output = "BOOL"
+		}
+		if field.tag, err = newTag(tagItem, &ModbusSlave{ByteOrder: m.ByteOrder}); err != nil {
+			return nil, err
+		}
+
+		m.fields[item.Name] = field
+		m.sorted = append(m.sorted, field)
+		m.Addresses = append(m.Addresses, item)
+	}
+	sort.SliceStable(m.sorted, func(i, j int) bool {
+		if m.sorted[i].target.register != m.sorted[j].target.register {
+			return m.sorted[i].target.register < m.sorted[j].target.register
+		}
+		return m.sorted[i].target.address < m.sorted[j].target.address
+	})
+
+	return m, nil
+}
+
+func (m *ModbusServer) Connect(context.Context) error {
+	m.connMu.Lock()
+	defer m.connMu.Unlock()
+
+	if m.listener != nil {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", m.ListenAddress)
+	if err != nil {
+		m.Log.Errorf("Failed to listen on %s: %v", m.ListenAddress, err)
+		return err
+	}
+	m.listener = listener
+
+	m.wg.Add(1)
+	go m.accept(listener)
+
+	m.Log.Infof("Modbus server is listening on %s", listener.Addr())
+	return nil
+}
+
+// accept serves the clients of the listener until it is closed.
+func (m *ModbusServer) accept(listener net.Listener) {
+	defer m.wg.Done()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				m.Log.Errorf("Failed to accept Modbus client: %v", err)
+			}
+			return
+		}
+
+		// Close may have closed the connections already, then this one would never be closed
+		m.connMu.Lock()
+		if m.listener != listener {
+			m.connMu.Unlock()
+			_ = conn.Close()
+			return
+		}
+		m.conns[conn] = struct{}{}
+		m.wg.Add(1)
+		m.connMu.Unlock()
+
+		go m.serve(conn)
+	}
+}
+
+// serve answers the requests of a client until the connection is closed. Malformed frames close the
+// connection, as the start of the next frame cannot be determined.
+func (m *ModbusServer) serve(conn net.Conn) {
+	defer m.wg.Done()
+	defer func() {
+		m.connMu.Lock()
+		delete(m.conns, conn)
+		m.connMu.Unlock()
+		_ = conn.Close()
+	}()
+
+	client := conn.RemoteAddr().String()
+	m.Log.Debugf("Modbus client %s connected", client)
+
+	// Transaction ID, protocol ID, length and unit ID
+	header := make([]byte, 7)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				m.Log.Debugf("Modbus client %s disconnected: %v", client, err)
+			}
+			return
+		}
+		length := binary.BigEndian.Uint16(header[4:])
+		if binary.BigEndian.Uint16(header[2:]) != 0 || length < 2 || length > maxServerFrameLength {
+			m.Log.Warnf("Closing connection of Modbus client %s after invalid frame header % x", client, header)
+			return
+		}
+		pdu := make([]byte, length-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			m.Log.Debugf("Modbus client %s disconnected: %v", client, err)
+			return
+		}
+
+		response := m.handle(header[6], pdu, client)
+
+		frame := make([]byte, 7, 7+len(response))
+		copy(frame, header)
+		binary.BigEndian.PutUint16(frame[4:], uint16(len(response)+1))
+		frame = append(frame, response...)
+		if _, err := conn.Write(frame); err != nil {
+			m.Log.Debugf("Failed to answer Modbus client %s: %v", client, err)
+			return
+		}
+	}
+}
+
+// handle processes the request PDU of a client and returns the response PDU. The values that the client
+// wrote are queued for the modbus_server_writes input. If the queue is full, writes are rejected as busy.
+func (m *ModbusServer) handle(slaveID byte, pdu []byte, client string) []byte {
+	functionCode, data := pdu[0], pdu[1:]
+	exception := func(code byte) []byte { return []byte{functionCode | 0x80, code} }
+
+	if slaveID != m.SlaveID && slaveID != 0 && slaveID != 0xFF {
+		return exception(exceptionCodeGatewayTargetDeviceFailedToRespond)
+	}
+
+	switch functionCode {
+	case modbus.FuncCodeWriteSingleCoil, modbus.FuncCodeWriteSingleRegister, modbus.FuncCodeWriteMultipleCoils,
+		modbus.FuncCodeWriteMultipleRegisters, modbus.FuncCodeReadWriteMultipleRegisters:
+		if m.writeBackQueue == nil {
+			return exception(modbus.ExceptionCodeIllegalFunction)
+		}
+		if len(m.writeBackQueue) == cap(m.writeBackQueue) {
+			m.Log.Warnf("Rejecting write of Modbus client %s as the write-back queue %s is full", client, m.WriteBack)
+			return exception(modbus.ExceptionCodeServerDeviceBusy)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch functionCode {
+	case modbus.FuncCodeReadCoils, modbus.FuncCodeReadDiscreteInputs:
+		if len(data) != 4 {
+			return exception(modbus.ExceptionCodeIllegalDataValue)
+		}
+		address, quantity := binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+		if quantity < 1 || quantity > maxQuantityCoils {
+			return exception(modbus.ExceptionCodeIllegalDataValue)
+		}
+		if int(address)+int(quantity) > serverImageSize {
+			return exception(modbus.ExceptionCodeIllegalDataAddress)
+		}
+		image := m.coils
+		if functionCode == modbus.FuncCodeReadDiscreteInputs {
+			image = m.discrete
+		}
+		packed := packCoils(image[address : int(address)+int(quantity)])
+		return append([]byte{functionCode, byte(len(packed))}, packed...)
+
+	case modbus.FuncCodeReadHoldingRegisters, modbus.FuncCodeReadInputRegisters:
+		if len(data) != 4 {
+			return exception(modbus.ExceptionCodeIllegalDataValue)
+		}
+		address, quantity := binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+		if quantity < 1 || quantity > maxQuantityHoldingRegisters {
+			return exception(modbus.ExceptionCodeIllegalDataValue)
+		}
+		if int(address)+int(quantity) > serverImageSize {
+			return exception(modbus.ExceptionCodeIllegalDataAddress)
+		}
+		image := m.holding
+		if functionCode == modbus.FuncCodeReadInputRegisters {
+			image = m.input
+		}
+		return append([]byte{functionCode, byte(2 * quantity)}, m.registerBytes(image, address, quantity)...)
+
+	case modbus.FuncCodeWriteSingleCoil:
+		if len(data) != 4 {
+			return exception(modbus.ExceptionCodeIllegalDataValue)
+		}
+		address := binary.BigEndian.Uint16(data)
+		switch binary.BigEndian.Uint16(data[2:]) {
+		case 0xFF00:
+			m.coils[address] = true
+		case 0x0000:
+			m.coils[address] = false
+		default:
+			return exception(modbus.ExceptionCodeIllegalDataValue)
+		}
+		m.publishWrites("coil", address, 1, slaveID, client)
+		return pdu
+
+	case modbus.FuncCodeWriteSingleRegister:
+		if len(data) != 4 {
+			return exception(modbus.ExceptionCodeIllegalDataValue)
+		}
+		address := binary.BigEndian.Uint16(data)
+		m.holding[address] = binary.BigEndian.Uint16(data[2:])
+		m.publishWrites("holding", address, 1, slaveID, client)
+		return pdu
+
+	case modbus.FuncCodeWriteMultipleCoils:
+		if len(data) < 5 {
+			return exception(modbus.ExceptionCodeIllegalDataValue)
+		}
+		address, quantity := binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+		if quantity < 1 || quantity > maxQuantityWriteCoils || int(data[4]) != int(quantity+7)/8 || len(data) != 5+int(data[4]) {
+			return exception(modbus.ExceptionCodeIllegalDataValue)
+		}
+		if int(address)+int(quantity) > serverImageSize {
+			return exception(modbus.ExceptionCodeIllegalDataAddress)
+		}
+		for i := uint16(0); i < quantity; i++ {
+			m.coils[address+i] = data[5+i/8]&(1<<(i%8)) != 0
+		}
+		m.publishWrites("coil", address, quantity, slaveID, client)
+		return pdu[:5]
+
+	case modbus.FuncCodeWriteMultipleRegisters:
+		if len(data) < 5 {
+			return exception(modbus.ExceptionCodeIllegalDataValue)
+		}
+		address, quantity := binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+		if quantity < 1 || quantity > maxQuantityWriteRegisters || int(data[4]) != 2*int(quantity) || len(data) != 5+int(data[4]) {
+			return exception(modbus.ExceptionCodeIllegalDataValue)
+		}
+		if int(address)+int(quantity) > serverImageSize {
+			return exception(modbus.ExceptionCodeIllegalDataAddress)
+		}
+		m.setRegisters(address, data[5:])
+		m.publishWrites("holding", address, quantity, slaveID, client)
+		return pdu[:5]
+
+	case modbus.FuncCodeReadWriteMultipleRegisters:
+		if len(data) < 9 {
+			return exception(modbus.ExceptionCodeIllegalDataValue)
+		}
+		readAddress, readQuantity := binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+		writeAddress, writeQuantity := binary.BigEndian.Uint16(data[4:]), binary.BigEndian.Uint16(data[6:])
+		if readQuantity < 1 || readQuantity > maxQuantityHoldingRegisters ||
+			writeQuantity < 1 || writeQuantity > maxQuantityReadWriteRegisters ||
+			int(data[8]) != 2*int(writeQuantity) || len(data) != 9+int(data[8]) {
+			return exception(modbus.ExceptionCodeIllegalDataValue)
+		}
+		if int(readAddress)+int(readQuantity) > serverImageSize || int(writeAddress)+int(writeQuantity) > serverImageSize {
+			return exception(modbus.ExceptionCodeIllegalDataAddress)
+		}
+		// The write is performed before the read
+		m.setRegisters(writeAddress, data[9:])
+		m.publishWrites("holding", writeAddress, writeQuantity, slaveID, client)
+		return append([]byte{functionCode, byte(2 * readQuantity)}, m.registerBytes(m.holding, readAddress, readQuantity)...)
+
+	default:
+		return exception(modbus.ExceptionCodeIllegalFunction)
+	}
+}
+
+// registerBytes returns registers of the image in wire format.
+func (m *ModbusServer) registerBytes(image []uint16, address, quantity uint16) []byte {
+	data := make([]byte, 0, 2*int(quantity))
+	for i := uint16(0); i < quantity; i++ {
+		data = binary.BigEndian.AppendUint16(data, image[address+i])
+	}
+	return data
+}
+
+// setRegisters sets holding registers from data in wire format.
+func (m *ModbusServer) setRegisters(address uint16, data []byte) {
+	for i := 0; i+1 < len(data); i += 2 {
+		m.holding[address+uint16(i/2)] = binary.BigEndian.Uint16(data[i:])
+	}
+}
+
+// publishWrites queues the values of the addresses that overlap with the coils or registers written by a
+// client. The register image has to be locked.
+func (m *ModbusServer) publishWrites(register string, address, quantity uint16, slaveID byte, client string) {
+	var msgs service.MessageBatch
+	for _, field := range m.sorted {
+		target := field.target
+		if target.register != register ||
+			int(target.address)+int(target.length) <= int(address) || int(target.address) >= int(address)+int(quantity) {
+			continue
+		}
+
+		var raw []byte
+		if register == "coil" {
+			raw = []byte{0}
+			if m.coils[target.address] {
+				raw[0] = 1
+			}
+		} else {
+			raw = m.registerBytes(m.holding, target.address, target.length)
+		}
+
+		message := newTagMessage(m.Log, field.tag, raw, register, slaveID)
+		if message == nil {
+			continue
+		}
+		message.MetaSet("modbus_client_address", client)
+		msgs = append(msgs, message)
+	}
+
+	if len(msgs) == 0 {
+		return
+	}
+	m.Log.Debugf("Modbus client %s wrote %s@%v[%v]", client, register, address, quantity)
+	select {
+	case m.writeBackQueue <- msgs:
+	default:
+		m.Log.Warnf("Dropping write of Modbus client %s to %s@%v as the write-back queue %s is full", client, register, address, m.WriteBack)
+	}
+}
+
+// Write updates the register map with the values of a message. Messages that do not match the addresses
+// or whose values cannot be encoded are logged and dropped.
+func (m *ModbusServer) Write(_ context.Context, msg *service.Message) error {
+	tagName, err := m.TagName.TryString(msg)
+	if err != nil {
+		return fmt.Errorf("failed to interpolate tagName: %w", err)
+	}
+	payload, err := msg.AsBytes()
+	if err != nil {
+		return err
+	}
+
+	values, err := decodeWriteValues(tagName, payload)
+	if err != nil {
+		return err
+	}
+
+	fields := make([]*serverField, 0, len(values))
+	for name := range values {
+		field, ok := m.fields[name]
+		if !ok {
+			return fmt.Errorf("unknown address %q", name)
+		}
+		fields = append(fields, field)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Encode all values first, so that a message is either applied completely or not at all
+	bits := make(map[string]map[uint16]bool)
+	registers := make(map[string]map[uint16][]byte)
+	for _, field := range fields {
+		target := field.target
+		value := values[target.name]
+
+		if target.register == "coil" || target.register == "discrete" {
+			v, err := encodeBool(value)
+			if err != nil {
+				return &encodeError{name: target.name, err: err}
+			}
+			if bits[target.register] == nil {
+				bits[target.register] = make(map[uint16]bool)
+			}
+			bits[target.register][target.address] = v
+			continue
+		}
+
+		if registers[target.register] == nil {
+			registers[target.register] = make(map[uint16][]byte)
+		}
+		var current []byte
+		if target.partial {
+			current = registers[target.register][target.address]
+			if current == nil {
+				current = m.registerBytes(m.registerImage(target.register), target.address, 1)
+			}
+		}
+
+		data, err := target.encoder(value, current)
+		if err != nil {
+			return &encodeError{name: target.name, err: err}
+		}
+		for i := uint16(0); i < target.length; i++ {
+			registers[target.register][target.address+i] = data[2*i : 2*i+2]
+		}
+	}
+
+	for register, values := range bits {
+		image := m.coils
+		if register == "discrete" {
+			image = m.discrete
+		}
+		for address, value := range values {
+			image[address] = value
+		}
+	}
+	for register, values := range registers {
+		image := m.registerImage(register)
+		for address, value := range values {
+			image[address] = binary.BigEndian.Uint16(value)
+		}
+	}
+	return nil
+}
+
+// registerImage returns the image of the holding or input registers.
+func (m *ModbusServer) registerImage(register string) []uint16 {
+	if register == "input" {
+		return m.input
+	}
+	return m.holding
+}
+
+func (m *ModbusServer) Close(context.Context) error {
+	m.connMu.Lock()
+	listener := m.listener
+	m.listener = nil
+	var err error
+	if listener != nil {
+		err = listener.Close()
+	}
+	for conn := range m.conns {
+		_ = conn.Close()
+	}
+	m.connMu.Unlock()
+
+	m.wg.Wait()
+	return err
+}
+
+// ModbusServerWritesInput emits the values that clients write to the register map of a modbus_server output.
+type ModbusServerWritesInput struct {
+	WriteBack string
+
+	resources *service.Resources
+	queue     chan service.MessageBatch
+}
+
+// Connect attaches the input to the write-back queue.
+func (w *ModbusServerWritesInput) Connect(ctx context.Context) error {
+	w.queue = serverWriteBackQueue(w.resources, w.WriteBack).queue
+	return nil
+}
+
+// ReadBatch waits for the next client write.
+func (w *ModbusServerWritesInput) ReadBatch(ctx context.Context) (service.MessageBatch, service.AckFunc, error) {
+	if w.queue == nil {
+		return nil, nil, service.ErrNotConnected
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	case msgs := <-w.queue:
+		return msgs, func(ctx context.Context, err error) error {
+			// Nacks are retried automatically when we use service.AutoRetryNacksBatched
+			return nil
+		}, nil
+	}
+}
+
+// Close detaches the input. Writes that arrive in the meantime stay queued.
+func (w *ModbusServerWritesInput) Close(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2024 UMH Systems GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus_plugin
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// ModbusSlave is an address set of a slave. Entries of the slaves list have their own byte order and
+// workarounds, so that devices of different vendors behind one gateway can be read by a single input.
+type ModbusSlave struct {
+	SlaveID byte
+
+	// ByteOrder and the workarounds default to the settings of the input
+	ByteOrder               string
+	OneRequestPerField      bool
+	ReadCoilsStartingAtZero bool
+	TimeBetweenRequests     time.Duration
+	StringRegisterLocation  string
+
+	Addresses  []ModbusDataItemWithAddress
+	RequestSet RequestSet
+}
+
+// parseSlaves parses the slaves list. Settings that are not set in an entry are taken from the input,
+// so the byte order and the workarounds of the input have to be parsed before.
+func (m *ModbusInput) parseSlaves(slavesConf []*service.ParsedConfig) ([]*ModbusSlave, error) {
+	slaves := make([]*ModbusSlave, 0, len(slavesConf))
+	seen := make(map[byte]bool)
+
+	for i, slaveConf := range slavesConf {
+		slaveID, err := slaveConf.FieldInt("slaveID")
+		if err != nil {
+			return nil, err
+		}
+		if slaveID < 0 || slaveID > 255 {
+			return nil, fmt.Errorf("slave %d: slaveID %d out of range", i, slaveID)
+		}
+		if seen[byte(slaveID)] {
+			return nil, fmt.Errorf("slave %d: duplicate slaveID %d", i, slaveID)
+		}
+		seen[byte(slaveID)] = true
+
+		slave := m.inputSlave(byte(slaveID), nil)
+
+		byteOrder, err := slaveConf.FieldString("byteOrder")
+		if err != nil {
+			return nil, err
+		}
+		if byteOrder != "" {
+			if _, err := normalizeByteOrder(byteOrder); err != nil {
+				return nil, fmt.Errorf("slave %d: %w", slaveID, err)
+			}
+			slave.ByteOrder = byteOrder
+		}
+
+		if slaveConf.Contains("workarounds") {
+			workarounds := slaveConf.Namespace("workarounds")
+			if workarounds.Contains("oneRequestPerField") {
+				if slave.OneRequestPerField, err = workarounds.FieldBool("oneRequestPerField"); err != nil {
+					return nil, err
+				}
+			}
+			if workarounds.Contains("readCoilsStartingAtZero") {
+				if slave.ReadCoilsStartingAtZero, err = workarounds.FieldBool("readCoilsStartingAtZero"); err != nil {
+					return nil, err
+				}
+			}
+			if workarounds.Contains("stringRegisterLocation") {
+				if slave.StringRegisterLocation, err = workarounds.FieldString("stringRegisterLocation"); err != nil {
+					return nil, err
+				}
+				switch slave.StringRegisterLocation {
+				case "", "both", "lower", "upper":
+				default:
+					return nil, fmt.Errorf("slave %d: invalid 'string_register_location' %q", slaveID, slave.StringRegisterLocation)
+				}
+			}
+			if workarounds.Contains("timeBetweenRequests") {
+				if slave.TimeBetweenRequests, err = workarounds.FieldDuration("timeBetweenRequests"); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		addressesConf, err := slaveConf.FieldObjectList("addresses")
+		if err != nil {
+			return nil, err
+		}
+		if len(addressesConf) == 0 {
+			return nil, fmt.Errorf("slave %d: addresses are empty", slaveID)
+		}
+		if slave.Addresses, err = m.parseAddresses(addressesConf); err != nil {
+			return nil, fmt.Errorf("slave %d: %w", slaveID, err)
+		}
+
+		slaves = append(slaves, slave)
+	}
+
+	return slaves, nil
+}
+
+// slavesToRead returns the address sets that are read in every cycle: the addresses of the input for each
+// slave of SlaveIDs, followed by the entries of the slaves list.
+func (m *ModbusInput) slavesToRead() []*ModbusSlave {
+	slaves := make([]*ModbusSlave, 0, len(m.SlaveIDs)+len(m.Slaves))
+	if len(m.Addresses) > 0 || len(m.Slaves) == 0 {
+		for _, slaveID := range m.SlaveIDs {
+			slave := m.inputSlave(slaveID, m.Addresses)
+			slave.RequestSet = m.RequestSet
+			slaves = append(slaves, slave)
+		}
+	}
+	return append(slaves, m.Slaves...)
+}
+
+// inputSlave returns a slave with the given addresses and the byte order and workarounds of the input.
+func (m *ModbusInput) inputSlave(slaveID byte, addresses []ModbusDataItemWithAddress) *ModbusSlave {
+	return &ModbusSlave{
+		SlaveID:                 slaveID,
+		ByteOrder:               m.ByteOrder,
+		OneRequestPerField:      m.OneRequestPerField,
+		ReadCoilsStartingAtZero: m.ReadCoilsStartingAtZero,
+		TimeBetweenRequests:     m.TimeBetweenRequests,
+		StringRegisterLocation:  m.StringRegisterLocation,
+		Addresses:               addresses,
+	}
+}
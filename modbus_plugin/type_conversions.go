@@ -18,7 +18,7 @@ func determineUntypedConverter(outType string) (converterFunc, error) {
 	return nil, fmt.Errorf("invalid output data-type: %s", outType)
 }
 
-func determineConverter(inType, byteOrder, outType string, scale float64, bit uint8, strloc string) (converterFunc, error) {
+func determineConverter(inType, byteOrder, outType string, scale float64, bit uint8, strloc string, bits map[string]uint16) (converterFunc, error) {
 	switch inType {
 	case "STRING":
 		switch strloc {
@@ -31,6 +31,12 @@ func determineConverter(inType, byteOrder, outType string, scale float64, bit ui
 		}
 	case "BIT":
 		return determineConverterBit(byteOrder, bit)
+	case "BITFIELD":
+		return determineConverterBitfield(byteOrder, bits)
+	case "BCD16", "BCD32":
+		return determineConverterBCD(inType, byteOrder, outType, scale)
+	case "UNIXTIME32", "UNIXTIME64":
+		return determineConverterUnixTime(inType, byteOrder, scale)
 	}
 
 	if scale != 0.0 {
@@ -102,3 +108,19 @@ func determineConverterNoScale(inType, byteOrder, outType string) (converterFunc
 	}
 	return nil, fmt.Errorf("invalid input data-type: %s", inType)
 }
+
+// determineConverterValueMap wraps the converter of an integer field, so that the values are replaced by their
+// names. Values without a name are converted to their decimal representation.
+func determineConverterValueMap(converter converterFunc, valueMap map[string]string) converterFunc {
+	return func(b []byte) interface{} {
+		v := converter(b)
+		if err, ok := v.(error); ok {
+			return err
+		}
+		s := fmt.Sprint(v)
+		if name, ok := valueMap[s]; ok {
+			return name
+		}
+		return s
+	}
+}
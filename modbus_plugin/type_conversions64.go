@@ -31,9 +31,25 @@ func endiannessConverter64(byteOrder string) (convert64, error) {
 	case "DCBA": // Little endian (Intel)
 		return binary.LittleEndian.Uint64, nil
 	}
+	if isByteOrder64(byteOrder) {
+		return binaryOrderedU64(byteOrder), nil
+	}
 	return nil, fmt.Errorf("invalid byte-order: %s", byteOrder)
 }
 
+// binaryOrderedU64 returns a converter for a byte order of eight letters. The letter at each position names
+// the byte of the value that is stored there, A being the most significant one.
+func binaryOrderedU64(byteOrder string) convert64 {
+	return func(b []byte) uint64 {
+		_ = b[7] // bounds check hint to compiler; see golang.org/issue/14808
+		var v uint64
+		for i, c := range byteOrder {
+			v |= uint64(b[i]) << (56 - 8*(c-'A'))
+		}
+		return v
+	}
+}
+
 // I64 - no scale
 func determineConverterI64(outType, byteOrder string) (converterFunc, error) {
 	tohost, err := endiannessConverter64(byteOrder)
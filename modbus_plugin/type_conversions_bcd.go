@@ -0,0 +1,76 @@
+package modbus_plugin
+
+import (
+	"fmt"
+)
+
+// decodeBCD decodes the given number of binary-coded decimal digits, starting with the most significant one.
+func decodeBCD(v uint64, digits int) (uint64, error) {
+	var result uint64
+	for i := digits - 1; i >= 0; i-- {
+		digit := (v >> (4 * i)) & 0x0F
+		if digit > 9 {
+			return 0, fmt.Errorf("invalid BCD value 0x%0*X", digits, v)
+		}
+		result = result*10 + digit
+	}
+	return result, nil
+}
+
+// BCD16 and BCD32 - with and without scale
+func determineConverterBCD(inType, byteOrder, outType string, scale float64) (converterFunc, error) {
+	var decode func([]byte) (uint64, error)
+	var native func(uint64) interface{}
+	switch inType {
+	case "BCD16":
+		tohost, err := endiannessConverter16(byteOrder)
+		if err != nil {
+			return nil, err
+		}
+		decode = func(b []byte) (uint64, error) { return decodeBCD(uint64(tohost(b)), 4) }
+		native = func(v uint64) interface{} { return uint16(v) }
+	case "BCD32":
+		tohost, err := endiannessConverter32(byteOrder)
+		if err != nil {
+			return nil, err
+		}
+		decode = func(b []byte) (uint64, error) { return decodeBCD(uint64(tohost(b)), 8) }
+		native = func(v uint64) interface{} { return uint32(v) }
+	default:
+		return nil, fmt.Errorf("invalid input data-type: %s", inType)
+	}
+
+	var output func(uint64) interface{}
+	switch outType {
+	case "native":
+		output = native
+		if scale != 0.0 {
+			output = func(v uint64) interface{} { return native(uint64(float64(v) * scale)) }
+		}
+	case "INT64":
+		output = func(v uint64) interface{} { return int64(v) }
+		if scale != 0.0 {
+			output = func(v uint64) interface{} { return int64(float64(v) * scale) }
+		}
+	case "UINT64":
+		output = func(v uint64) interface{} { return v }
+		if scale != 0.0 {
+			output = func(v uint64) interface{} { return uint64(float64(v) * scale) }
+		}
+	case "FLOAT64":
+		output = func(v uint64) interface{} { return float64(v) }
+		if scale != 0.0 {
+			output = func(v uint64) interface{} { return float64(v) * scale }
+		}
+	default:
+		return nil, fmt.Errorf("invalid output data-type: %s", outType)
+	}
+
+	return func(b []byte) interface{} {
+		v, err := decode(b)
+		if err != nil {
+			return err
+		}
+		return output(v)
+	}, nil
+}
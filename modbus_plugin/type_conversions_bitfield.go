@@ -0,0 +1,19 @@
+package modbus_plugin
+
+// BITFIELD - every named bit of the register is converted to a bool. The result is emitted as JSON object.
+func determineConverterBitfield(byteOrder string, bits map[string]uint16) (converterFunc, error) {
+	tohost, err := endiannessConverter16(byteOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(b []byte) interface{} {
+		// Swap the bytes according to endianness
+		v := tohost(b)
+		values := make(map[string]bool, len(bits))
+		for name, bit := range bits {
+			values[name] = v>>bit&0x01 != 0
+		}
+		return values
+	}, nil
+}
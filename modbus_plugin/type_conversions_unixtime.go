@@ -0,0 +1,44 @@
+package modbus_plugin
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// UNIXTIME32 and UNIXTIME64 - the timestamp is formatted as RFC3339 string in UTC. The scale is the unit of
+// the timestamp in seconds, e.g. 0.001 for milliseconds since the epoch. Without a scale, it is seconds.
+func determineConverterUnixTime(inType, byteOrder string, scale float64) (converterFunc, error) {
+	var tohost func([]byte) int64
+	switch inType {
+	case "UNIXTIME32":
+		tohost32, err := endiannessConverter32(byteOrder)
+		if err != nil {
+			return nil, err
+		}
+		// Unsigned, so that the timestamps do not overflow in 2038
+		tohost = func(b []byte) int64 { return int64(tohost32(b)) }
+	case "UNIXTIME64":
+		tohost64, err := endiannessConverter64(byteOrder)
+		if err != nil {
+			return nil, err
+		}
+		tohost = func(b []byte) int64 { return int64(tohost64(b)) }
+	default:
+		return nil, fmt.Errorf("invalid input data-type: %s", inType)
+	}
+
+	if scale == 0.0 {
+		return func(b []byte) interface{} {
+			return time.Unix(tohost(b), 0).UTC().Format(time.RFC3339Nano)
+		}, nil
+	}
+
+	unit := time.Duration(math.Round(scale * float64(time.Second)))
+	if unit <= 0 {
+		return nil, fmt.Errorf("invalid scale %v for timestamps, it must be at least one nanosecond", scale)
+	}
+	return func(b []byte) interface{} {
+		return time.Unix(0, 0).Add(time.Duration(tohost(b)) * unit).UTC().Format(time.RFC3339Nano)
+	}, nil
+}
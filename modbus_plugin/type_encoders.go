@@ -0,0 +1,438 @@
+package modbus_plugin
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/x448/float16"
+)
+
+// encoderFunc is the inverse of a converterFunc. It converts a value into the raw bytes of the registers
+// of a field. Fields that only occupy a part of a register (BIT, INT8L, INT8H, UINT8L and UINT8H) merge
+// the value into current, which is the present content of the register.
+type encoderFunc func(value interface{}, current []byte) ([]byte, error)
+
+// isPartialRegisterType reports whether a type only occupies a part of a register, so that the register
+// has to be read before it can be written.
+func isPartialRegisterType(inType string) bool {
+	switch inType {
+	case "BIT", "INT8L", "INT8H", "UINT8L", "UINT8H":
+		return true
+	}
+	return false
+}
+
+type fromhost16 func([]byte, uint16)
+type fromhost32 func([]byte, uint32)
+type fromhost64 func([]byte, uint64)
+
+func putBinaryMSWLEU32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint16(b[0:], uint16(v>>16))
+	binary.LittleEndian.PutUint16(b[2:], uint16(v))
+}
+
+func putBinaryLSWBEU32(b []byte, v uint32) {
+	binary.BigEndian.PutUint16(b[2:], uint16(v>>16))
+	binary.BigEndian.PutUint16(b[0:], uint16(v))
+}
+
+func putBinaryMSWLEU64(b []byte, v uint64) {
+	binary.LittleEndian.PutUint16(b[0:], uint16(v>>48))
+	binary.LittleEndian.PutUint16(b[2:], uint16(v>>32))
+	binary.LittleEndian.PutUint16(b[4:], uint16(v>>16))
+	binary.LittleEndian.PutUint16(b[6:], uint16(v))
+}
+
+func putBinaryLSWBEU64(b []byte, v uint64) {
+	binary.BigEndian.PutUint16(b[6:], uint16(v>>48))
+	binary.BigEndian.PutUint16(b[4:], uint16(v>>32))
+	binary.BigEndian.PutUint16(b[2:], uint16(v>>16))
+	binary.BigEndian.PutUint16(b[0:], uint16(v))
+}
+
+// endiannessEncoder16 is the inverse of endiannessConverter16.
+func endiannessEncoder16(byteOrder string) (fromhost16, error) {
+	switch byteOrder {
+	case "ABCD", "CDAB": // Big endian (Motorola)
+		return binary.BigEndian.PutUint16, nil
+	case "DCBA", "BADC": // Little endian (Intel)
+		return binary.LittleEndian.PutUint16, nil
+	}
+	return nil, fmt.Errorf("invalid byte-order: %s", byteOrder)
+}
+
+// endiannessEncoder32 is the inverse of endiannessConverter32.
+func endiannessEncoder32(byteOrder string) (fromhost32, error) {
+	switch byteOrder {
+	case "ABCD": // Big endian (Motorola)
+		return binary.BigEndian.PutUint32, nil
+	case "BADC": // Big endian with bytes swapped
+		return putBinaryMSWLEU32, nil
+	case "CDAB": // Little endian with bytes swapped
+		return putBinaryLSWBEU32, nil
+	case "DCBA": // Little endian (Intel)
+		return binary.LittleEndian.PutUint32, nil
+	}
+	return nil, fmt.Errorf("invalid byte-order: %s", byteOrder)
+}
+
+// endiannessEncoder64 is the inverse of endiannessConverter64.
+func endiannessEncoder64(byteOrder string) (fromhost64, error) {
+	switch byteOrder {
+	case "ABCD": // Big endian (Motorola)
+		return binary.BigEndian.PutUint64, nil
+	case "BADC": // Big endian with bytes swapped
+		return putBinaryMSWLEU64, nil
+	case "CDAB": // Little endian with bytes swapped
+		return putBinaryLSWBEU64, nil
+	case "DCBA": // Little endian (Intel)
+		return binary.LittleEndian.PutUint64, nil
+	}
+	if isByteOrder64(byteOrder) {
+		return putBinaryOrderedU64(byteOrder), nil
+	}
+	return nil, fmt.Errorf("invalid byte-order: %s", byteOrder)
+}
+
+// putBinaryOrderedU64 is the inverse of binaryOrderedU64.
+func putBinaryOrderedU64(byteOrder string) fromhost64 {
+	return func(b []byte, v uint64) {
+		_ = b[7] // bounds check hint to compiler; see golang.org/issue/14808
+		for i, c := range byteOrder {
+			b[i] = byte(v >> (56 - 8*(c-'A')))
+		}
+	}
+}
+
+// determineEncoder returns the encoder of a register field. Like the converters, a scale divides the value
+// before it is encoded, and integer types round the scaled value.
+func determineEncoder(inType, byteOrder string, scale float64, bit uint8, length uint16, strloc string) (encoderFunc, error) {
+	switch inType {
+	case "STRING":
+		return determineEncoderString(byteOrder, length, strloc)
+	case "BIT":
+		return determineEncoderBit(byteOrder, bit)
+	case "INT8L", "INT8H", "UINT8L", "UINT8H":
+		return determineEncoder8(inType, byteOrder, scale)
+	case "INT16", "UINT16":
+		fromhost, err := endiannessEncoder16(byteOrder)
+		if err != nil {
+			return nil, err
+		}
+		signed := inType == "INT16"
+		return func(value interface{}, _ []byte) ([]byte, error) {
+			v, err := encodeInteger(value, scale, 16, signed)
+			if err != nil {
+				return nil, err
+			}
+			b := make([]byte, 2)
+			fromhost(b, uint16(v))
+			return b, nil
+		}, nil
+	case "INT32", "UINT32":
+		fromhost, err := endiannessEncoder32(byteOrder)
+		if err != nil {
+			return nil, err
+		}
+		signed := inType == "INT32"
+		return func(value interface{}, _ []byte) ([]byte, error) {
+			v, err := encodeInteger(value, scale, 32, signed)
+			if err != nil {
+				return nil, err
+			}
+			b := make([]byte, 4)
+			fromhost(b, uint32(v))
+			return b, nil
+		}, nil
+	case "INT64", "UINT64":
+		fromhost, err := endiannessEncoder64(byteOrder)
+		if err != nil {
+			return nil, err
+		}
+		signed := inType == "INT64"
+		return func(value interface{}, _ []byte) ([]byte, error) {
+			v, err := encodeInteger(value, scale, 64, signed)
+			if err != nil {
+				return nil, err
+			}
+			b := make([]byte, 8)
+			fromhost(b, v)
+			return b, nil
+		}, nil
+	case "FLOAT16":
+		fromhost, err := endiannessEncoder16(byteOrder)
+		if err != nil {
+			return nil, err
+		}
+		return func(value interface{}, _ []byte) ([]byte, error) {
+			v, err := encodeFloat(value, scale)
+			if err != nil {
+				return nil, err
+			}
+			b := make([]byte, 2)
+			fromhost(b, float16.Fromfloat32(float32(v)).Bits())
+			return b, nil
+		}, nil
+	case "FLOAT32":
+		fromhost, err := endiannessEncoder32(byteOrder)
+		if err != nil {
+			return nil, err
+		}
+		return func(value interface{}, _ []byte) ([]byte, error) {
+			v, err := encodeFloat(value, scale)
+			if err != nil {
+				return nil, err
+			}
+			b := make([]byte, 4)
+			fromhost(b, math.Float32bits(float32(v)))
+			return b, nil
+		}, nil
+	case "FLOAT64":
+		fromhost, err := endiannessEncoder64(byteOrder)
+		if err != nil {
+			return nil, err
+		}
+		return func(value interface{}, _ []byte) ([]byte, error) {
+			v, err := encodeFloat(value, scale)
+			if err != nil {
+				return nil, err
+			}
+			b := make([]byte, 8)
+			fromhost(b, math.Float64bits(v))
+			return b, nil
+		}, nil
+	}
+	return nil, fmt.Errorf("invalid input data-type: %s", inType)
+}
+
+func determineEncoder8(inType, byteOrder string, scale float64) (encoderFunc, error) {
+	low := inType == "INT8L" || inType == "UINT8L"
+	idx, err := endiannessIndex8(byteOrder, low)
+	if err != nil {
+		return nil, err
+	}
+	signed := inType == "INT8L" || inType == "INT8H"
+
+	return func(value interface{}, current []byte) ([]byte, error) {
+		v, err := encodeInteger(value, scale, 8, signed)
+		if err != nil {
+			return nil, err
+		}
+		b := append([]byte(nil), current...)
+		b[idx] = byte(v)
+		return b, nil
+	}, nil
+}
+
+func determineEncoderBit(byteOrder string, bit uint8) (encoderFunc, error) {
+	tohost, err := endiannessConverter16(byteOrder)
+	if err != nil {
+		return nil, err
+	}
+	fromhost, err := endiannessEncoder16(byteOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(value interface{}, current []byte) ([]byte, error) {
+		set, err := encodeBool(value)
+		if err != nil {
+			return nil, err
+		}
+		v := tohost(current)
+		if set {
+			v |= 1 << bit
+		} else {
+			v &^= 1 << bit
+		}
+		b := make([]byte, 2)
+		fromhost(b, v)
+		return b, nil
+	}, nil
+}
+
+// determineEncoderString is the inverse of the string converters. Strings that are shorter than the
+// registers are padded with null bytes.
+func determineEncoderString(byteOrder string, length uint16, strloc string) (encoderFunc, error) {
+	fromhost, err := endiannessEncoder16(byteOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	bytesPerRegister := 2
+	switch strloc {
+	case "", "both":
+	case "lower", "upper":
+		bytesPerRegister = 1
+	default:
+		return nil, fmt.Errorf("invalid string register location: %s", strloc)
+	}
+
+	return func(value interface{}, _ []byte) ([]byte, error) {
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a string, got %T", value)
+		}
+		if len(s) > int(length)*bytesPerRegister {
+			return nil, fmt.Errorf("string of %d bytes does not fit into %d register(s)", len(s), length)
+		}
+
+		padded := make([]byte, int(length)*bytesPerRegister)
+		copy(padded, s)
+
+		b := make([]byte, 2*int(length))
+		for i := 0; i < int(length); i++ {
+			var v uint16
+			switch strloc {
+			case "lower":
+				v = uint16(padded[i])
+			case "upper":
+				v = uint16(padded[i]) << 8
+			default:
+				v = uint16(padded[2*i])<<8 | uint16(padded[2*i+1])
+			}
+			fromhost(b[2*i:], v)
+		}
+		return b, nil
+	}, nil
+}
+
+// encodeInteger converts a value into an integer of the given bit size and returns its two's complement.
+// Without a scale, the value must be an integer; with a scale, the scaled value is rounded.
+func encodeInteger(value interface{}, scale float64, bits int, signed bool) (uint64, error) {
+	if scale != 0.0 {
+		f, err := encodeFloat(value, scale)
+		if err != nil {
+			return 0, err
+		}
+		value = math.Round(f)
+	}
+
+	if s, ok := value.(string); ok {
+		value = json.Number(strings.TrimSpace(s))
+	}
+
+	var (
+		i   int64
+		u   uint64
+		neg bool
+	)
+	switch v := value.(type) {
+	case bool:
+		if v {
+			u = 1
+		}
+	case int64:
+		i, neg = v, v < 0
+		u = uint64(v)
+	case uint64:
+		u = v
+	case float64:
+		if v != math.Trunc(v) || math.IsInf(v, 0) || math.IsNaN(v) {
+			return 0, fmt.Errorf("%v is not an integer", v)
+		}
+		if v < 0 {
+			if v < math.MinInt64 {
+				return 0, fmt.Errorf("%v is out of range", v)
+			}
+			i, neg = int64(v), true
+			u = uint64(i)
+		} else {
+			if v >= math.MaxUint64 {
+				return 0, fmt.Errorf("%v is out of range", v)
+			}
+			u = uint64(v)
+		}
+	case json.Number:
+		if parsed, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			i, neg = parsed, parsed < 0
+			u = uint64(parsed)
+		} else if parsed, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			u = parsed
+		} else if parsed, err := strconv.ParseFloat(string(v), 64); err == nil {
+			return encodeInteger(parsed, 0, bits, signed)
+		} else {
+			return 0, fmt.Errorf("%q is not a number", string(v))
+		}
+	default:
+		return 0, fmt.Errorf("expected a number, got %T", value)
+	}
+
+	if signed {
+		limit := int64(1) << (bits - 1)
+		if bits == 64 {
+			if !neg && u > math.MaxInt64 {
+				return 0, fmt.Errorf("%d is out of range for %d bit", u, bits)
+			}
+		} else if neg && i < -limit || !neg && u >= uint64(limit) {
+			return 0, fmt.Errorf("%v is out of range for %d bit", value, bits)
+		}
+	} else {
+		if neg || bits < 64 && u >= uint64(1)<<bits {
+			return 0, fmt.Errorf("%v is out of range for unsigned %d bit", value, bits)
+		}
+	}
+
+	if bits < 64 {
+		u &= uint64(1)<<bits - 1
+	}
+	return u, nil
+}
+
+// encodeFloat converts a value into a float and divides it by the scale.
+func encodeFloat(value interface{}, scale float64) (float64, error) {
+	var f float64
+	switch v := value.(type) {
+	case bool:
+		if v {
+			f = 1
+		}
+	case int64:
+		f = float64(v)
+	case uint64:
+		f = float64(v)
+	case float64:
+		f = v
+	case json.Number:
+		parsed, err := v.Float64()
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a number", string(v))
+		}
+		f = parsed
+	case string:
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a number", v)
+		}
+		f = parsed
+	default:
+		return 0, fmt.Errorf("expected a number, got %T", value)
+	}
+
+	if scale != 0.0 {
+		f /= scale
+	}
+	return f, nil
+}
+
+// encodeBool converts a value of a coil or a BIT field. Numbers other than 0 are true.
+func encodeBool(value interface{}) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		if b, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+			return b, nil
+		}
+	}
+
+	f, err := encodeFloat(value, 0)
+	if err != nil {
+		return false, fmt.Errorf("expected a boolean, got %v", value)
+	}
+	return f != 0, nil
+}
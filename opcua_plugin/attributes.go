@@ -0,0 +1,417 @@
+package opcua_plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gopcua/opcua"
+	"github.com/gopcua/opcua/id"
+	"github.com/gopcua/opcua/ua"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// attributeHandleBase is the first client handle that is used for monitored items of attribute snapshots.
+// The handle of such an item is attributeHandleBase + nodeIndex<<8 + itemIndex, where nodeIndex is the
+// position in NodeList and itemIndex i is Attributes[i] for i < len(Attributes) and a property otherwise.
+const attributeHandleBase uint32 = 1 << 30
+
+// maxAttributeItemsPerNode is the number of attributes and properties that fit into the itemIndex part of a client handle.
+const maxAttributeItemsPerNode = 1 << 8
+
+// maxNodesWithMonitoredAttributes is the number of nodes that fit into the nodeIndex part of a client handle.
+const maxNodesWithMonitoredAttributes = int((triggerHandleBase - attributeHandleBase) / maxAttributeItemsPerNode)
+
+// attributeIDs maps the names of all attributes except Value to their AttributeID.
+var attributeIDs = func() map[string]ua.AttributeID {
+	ids := make(map[string]ua.AttributeID)
+	for attributeID := ua.AttributeIDNodeID; attributeID <= ua.AttributeIDAccessLevelEx; attributeID++ {
+		if attributeID != ua.AttributeIDValue {
+			ids[attributeName(attributeID)] = attributeID
+		}
+	}
+	return ids
+}()
+
+// attributeName returns the name of an attribute as used in the configuration, e.g., DisplayName.
+func attributeName(attributeID ua.AttributeID) string {
+	return strings.TrimPrefix(attributeID.String(), "AttributeID")
+}
+
+// ParseAttributes parses attribute names like DisplayName into AttributeIDs. The Value attribute
+// is not allowed, as it is read as the data of the node anyway.
+func ParseAttributes(names []string) ([]ua.AttributeID, error) {
+	attributes := make([]ua.AttributeID, 0, len(names))
+	seen := make(map[ua.AttributeID]bool)
+
+	for _, name := range names {
+		attributeID, ok := attributeIDs[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown attribute %q", name)
+		}
+		if seen[attributeID] {
+			continue
+		}
+		seen[attributeID] = true
+		attributes = append(attributes, attributeID)
+	}
+
+	if len(attributes) > maxAttributeItemsPerNode {
+		return nil, fmt.Errorf("at most %d attributes are supported", maxAttributeItemsPerNode)
+	}
+
+	return attributes, nil
+}
+
+// AttributeSnapshot is the payload of an attribute snapshot message.
+type AttributeSnapshot struct {
+	NodeID     string         `json:"nodeId"`
+	BrowseName string         `json:"browseName"`
+	Attributes map[string]any `json:"attributes"`
+	Properties map[string]any `json:"properties,omitempty"`
+}
+
+// attributeSnapshot holds the last known attributes and properties of a node.
+type attributeSnapshot struct {
+	node          NodeDef
+	attributes    map[string]any
+	propertyNames []string
+	propertyIDs   []*ua.NodeID
+	properties    map[string]any
+}
+
+// usesAttributeSnapshots reports whether attribute snapshot messages are enabled.
+func (g *OPCUAInput) usesAttributeSnapshots() bool {
+	return len(g.Attributes) > 0 || g.IncludeProperties
+}
+
+// readAttributeSnapshots reads the attributes and properties of all nodes and queues a snapshot message per node.
+// Attributes and properties that can not be read are left out of the snapshot.
+func (g *OPCUAInput) readAttributeSnapshots(ctx context.Context, nodeList []NodeDef) error {
+	snapshots := make([]*attributeSnapshot, len(nodeList))
+	for i, node := range nodeList {
+		// The heartbeat node is only part of the node list for the heartbeat
+		if g.UseHeartbeat && !g.HeartbeatManualSubscribed && node.NodeID.String() == g.HeartbeatNodeId.String() {
+			continue
+		}
+		snapshots[i] = &attributeSnapshot{node: node, attributes: make(map[string]any), properties: make(map[string]any)}
+	}
+
+	if g.IncludeProperties {
+		if err := g.browseProperties(ctx, snapshots); err != nil {
+			return err
+		}
+	}
+
+	// Read the attributes and property values of all nodes at once and remember where each result belongs to
+	type target struct {
+		snapshot *attributeSnapshot
+		name     string
+		property bool
+	}
+	var nodesToRead []*ua.ReadValueID
+	var targets []target
+	for _, snapshot := range snapshots {
+		if snapshot == nil {
+			continue
+		}
+		for _, attributeID := range g.Attributes {
+			nodesToRead = append(nodesToRead, &ua.ReadValueID{NodeID: snapshot.node.NodeID, AttributeID: attributeID})
+			targets = append(targets, target{snapshot: snapshot, name: attributeName(attributeID)})
+		}
+		for i, propertyID := range snapshot.propertyIDs {
+			nodesToRead = append(nodesToRead, &ua.ReadValueID{NodeID: propertyID, AttributeID: ua.AttributeIDValue})
+			targets = append(targets, target{snapshot: snapshot, name: snapshot.propertyNames[i], property: true})
+		}
+	}
+
+	for _, chunk := range SplitIntoChunks(len(nodesToRead), g.maxNodesPerRead()) {
+		resp, err := g.Client.Read(ctx, &ua.ReadRequest{
+			NodesToRead:        nodesToRead[chunk[0]:chunk[1]],
+			TimestampsToReturn: ua.TimestampsToReturnNeither,
+		})
+		if err != nil {
+			return fmt.Errorf("reading attributes failed: %w", err)
+		}
+		if len(resp.Results) != chunk[1]-chunk[0] {
+			return fmt.Errorf("expected %d results, got %d", chunk[1]-chunk[0], len(resp.Results))
+		}
+
+		for i, result := range resp.Results {
+			t := targets[chunk[0]+i]
+			value, ok := attributeJSONValue(result)
+			if !ok {
+				g.Log.Debugf("Could not read %s of node %s: %v", t.name, t.snapshot.node.NodeID, result.Status)
+				continue
+			}
+			if t.property {
+				t.snapshot.properties[t.name] = value
+			} else {
+				t.snapshot.attributes[t.name] = value
+			}
+		}
+	}
+
+	msgs := service.MessageBatch{}
+	for _, snapshot := range snapshots {
+		if snapshot == nil {
+			continue
+		}
+		if message := g.createAttributeSnapshotMessage(snapshot, nil); message != nil {
+			msgs = append(msgs, message)
+		}
+	}
+
+	g.attributeMu.Lock()
+	g.attributeSnapshots = snapshots
+	g.pendingAttributeMessages = append(g.pendingAttributeMessages, msgs...)
+	g.attributeMu.Unlock()
+
+	g.Log.Infof("Read the attribute snapshots of %d nodes", len(msgs))
+	return nil
+}
+
+// browseProperties finds the property children (HasProperty references) of the nodes of the snapshots.
+func (g *OPCUAInput) browseProperties(ctx context.Context, snapshots []*attributeSnapshot) error {
+	var nodesToBrowse []*ua.BrowseDescription
+	var browsed []*attributeSnapshot
+	for _, snapshot := range snapshots {
+		if snapshot == nil {
+			continue
+		}
+		nodesToBrowse = append(nodesToBrowse, &ua.BrowseDescription{
+			NodeID:          snapshot.node.NodeID,
+			BrowseDirection: ua.BrowseDirectionForward,
+			ReferenceTypeID: ua.NewNumericNodeID(0, id.HasProperty),
+			IncludeSubtypes: true,
+			NodeClassMask:   uint32(ua.NodeClassVariable),
+			ResultMask:      uint32(ua.BrowseResultMaskAll),
+		})
+		browsed = append(browsed, snapshot)
+	}
+
+	maxProperties := maxAttributeItemsPerNode - len(g.Attributes)
+	for _, chunk := range SplitIntoChunks(len(nodesToBrowse), g.maxNodesPerRead()) {
+		resp, err := g.Client.Browse(ctx, &ua.BrowseRequest{
+			View:          &ua.ViewDescription{ViewID: ua.NewTwoByteNodeID(0), Timestamp: time.Now()},
+			NodesToBrowse: nodesToBrowse[chunk[0]:chunk[1]],
+		})
+		if err != nil {
+			return fmt.Errorf("browsing properties failed: %w", err)
+		}
+		if len(resp.Results) != chunk[1]-chunk[0] {
+			return fmt.Errorf("expected %d results, got %d", chunk[1]-chunk[0], len(resp.Results))
+		}
+
+		for i, result := range resp.Results {
+			snapshot := browsed[chunk[0]+i]
+			if !errors.Is(result.StatusCode, ua.StatusOK) {
+				g.Log.Debugf("Could not browse the properties of node %s: %v", snapshot.node.NodeID, result.StatusCode)
+				continue
+			}
+			for _, ref := range result.References {
+				if len(snapshot.propertyIDs) >= maxProperties {
+					g.Log.Warnf("Node %s has more than %d properties, ignoring the others", snapshot.node.NodeID, maxProperties)
+					break
+				}
+				if ref.NodeID == nil || ref.NodeID.NodeID == nil || ref.BrowseName == nil {
+					continue
+				}
+				snapshot.propertyNames = append(snapshot.propertyNames, ref.BrowseName.Name)
+				snapshot.propertyIDs = append(snapshot.propertyIDs, ref.NodeID.NodeID)
+			}
+		}
+	}
+
+	return nil
+}
+
+// MonitorAttributes monitors the attributes and properties of the subscribed nodes, so that a new snapshot
+// is emitted whenever they change. Items that the server can not monitor are skipped, their snapshot
+// values are only read after browsing.
+func (g *OPCUAInput) MonitorAttributes(ctx context.Context) error {
+	if g.Subscription == nil {
+		return errors.New("monitoring attributes requires a subscription")
+	}
+
+	var requests []*ua.MonitoredItemCreateRequest
+	for nodeIndex, snapshot := range g.attributeSnapshots {
+		if snapshot == nil || g.nodeMode(snapshot.node) != NodeModeSubscribe {
+			continue
+		}
+		if nodeIndex >= maxNodesWithMonitoredAttributes {
+			g.Log.Warnf("The attributes of at most %d nodes can be monitored, ignoring the others", maxNodesWithMonitoredAttributes)
+			break
+		}
+		for i, attributeID := range g.Attributes {
+			requests = append(requests, opcua.NewMonitoredItemCreateRequestWithDefaults(snapshot.node.NodeID, attributeID, attributeHandle(nodeIndex, i)))
+		}
+		for i, propertyID := range snapshot.propertyIDs {
+			requests = append(requests, opcua.NewMonitoredItemCreateRequestWithDefaults(propertyID, ua.AttributeIDValue, attributeHandle(nodeIndex, len(g.Attributes)+i)))
+		}
+	}
+
+	monitored := 0
+	for _, chunk := range SplitIntoChunks(len(requests), g.maxMonitoredItemsPerCall()) {
+		resp, err := g.Subscription.Monitor(ctx, ua.TimestampsToReturnBoth, requests[chunk[0]:chunk[1]]...)
+		if err != nil {
+			return fmt.Errorf("monitoring attributes failed: %w", err)
+		}
+		for i, result := range resp.Results {
+			if !errors.Is(result.StatusCode, ua.StatusOK) {
+				request := requests[chunk[0]+i]
+				g.Log.Debugf("Server does not monitor attribute %s of node %s: %v", attributeName(request.ItemToMonitor.AttributeID), request.ItemToMonitor.NodeID, result.StatusCode)
+				continue
+			}
+			monitored++
+		}
+	}
+
+	g.Log.Infof("Monitoring %d of %d attributes and properties", monitored, len(requests))
+	return nil
+}
+
+// attributeHandle returns the client handle of the item at itemIndex of the node at nodeIndex.
+func attributeHandle(nodeIndex int, itemIndex int) uint32 {
+	return attributeHandleBase + uint32(nodeIndex)*maxAttributeItemsPerNode + uint32(itemIndex)
+}
+
+// splitAttributeHandle is the inverse of attributeHandle.
+func splitAttributeHandle(handle uint32) (nodeIndex int, itemIndex int, ok bool) {
+	if handle < attributeHandleBase || handle >= triggerHandleBase {
+		return 0, 0, false
+	}
+	handle -= attributeHandleBase
+	return int(handle / maxAttributeItemsPerNode), int(handle % maxAttributeItemsPerNode), true
+}
+
+// handleAttributeNotifications updates the snapshots with the notifications of attribute items and
+// returns a new snapshot message for every node whose attributes or properties changed.
+func (g *OPCUAInput) handleAttributeNotifications(items []*ua.MonitoredItemNotification) service.MessageBatch {
+	g.attributeMu.Lock()
+	snapshots := g.attributeSnapshots
+	g.attributeMu.Unlock()
+
+	changed := make(map[int][]string)
+	var order []int
+	for _, item := range items {
+		nodeIndex, itemIndex, ok := splitAttributeHandle(item.ClientHandle)
+		if !ok || nodeIndex >= len(snapshots) || snapshots[nodeIndex] == nil {
+			continue
+		}
+		snapshot := snapshots[nodeIndex]
+
+		value, ok := attributeJSONValue(item.Value)
+		if !ok {
+			continue
+		}
+
+		values, name := snapshot.attributes, ""
+		switch {
+		case itemIndex < len(g.Attributes):
+			name = attributeName(g.Attributes[itemIndex])
+		case itemIndex-len(g.Attributes) < len(snapshot.propertyNames):
+			values, name = snapshot.properties, snapshot.propertyNames[itemIndex-len(g.Attributes)]
+		default:
+			continue
+		}
+
+		// The first notification of an item repeats the value that was read after browsing
+		if old, exists := values[name]; exists && reflect.DeepEqual(old, value) {
+			continue
+		}
+		values[name] = value
+
+		if _, exists := changed[nodeIndex]; !exists {
+			order = append(order, nodeIndex)
+		}
+		changed[nodeIndex] = append(changed[nodeIndex], name)
+	}
+
+	msgs := service.MessageBatch{}
+	for _, nodeIndex := range order {
+		if message := g.createAttributeSnapshotMessage(snapshots[nodeIndex], changed[nodeIndex]); message != nil {
+			msgs = append(msgs, message)
+		}
+	}
+	return msgs
+}
+
+// createAttributeSnapshotMessage creates the snapshot message of a node. changed are the names of the
+// attributes and properties that caused the message, or nil for the snapshot after browsing.
+func (g *OPCUAInput) createAttributeSnapshotMessage(snapshot *attributeSnapshot, changed []string) *service.Message {
+	b, err := json.Marshal(AttributeSnapshot{
+		NodeID:     snapshot.node.NodeID.String(),
+		BrowseName: snapshot.node.BrowseName,
+		Attributes: snapshot.attributes,
+		Properties: snapshot.properties,
+	})
+	if err != nil {
+		g.Log.Errorf("Error marshaling the attribute snapshot of node %s to JSON: %v", snapshot.node.NodeID, err)
+		return nil
+	}
+
+	tagGroup, tagName := tagNames(snapshot.node)
+
+	message := service.NewMessage(b)
+	message.MetaSet("opcua_tag_group", tagGroup)
+	message.MetaSet("opcua_tag_name", tagName)
+	message.MetaSet("opcua_tag_type", "string")
+	message.MetaSet("opcua_attr_nodeid", snapshot.node.NodeID.String())
+	message.MetaSet("opcua_attr_browsename", snapshot.node.BrowseName)
+	message.MetaSet("opcua_attributes_message", "true")
+	message.MetaSet("opcua_attributes_changed", strings.Join(changed, ","))
+
+	if snapshot.node.Config != nil {
+		for key, value := range snapshot.node.Config.Metadata {
+			message.MetaSet(key, value)
+		}
+	}
+
+	return message
+}
+
+// appendPendingAttributeMessages appends the snapshot messages that were created after browsing.
+func (g *OPCUAInput) appendPendingAttributeMessages(msgs service.MessageBatch) service.MessageBatch {
+	g.attributeMu.Lock()
+	defer g.attributeMu.Unlock()
+
+	if len(g.pendingAttributeMessages) == 0 {
+		return msgs
+	}
+	msgs = append(msgs, g.pendingAttributeMessages...)
+	g.pendingAttributeMessages = nil
+	return msgs
+}
+
+// attributeJSONValue converts the value of an attribute into a value that can be marshalled to JSON.
+// It returns false if the attribute could not be read.
+func attributeJSONValue(dataValue *ua.DataValue) (any, bool) {
+	if dataValue == nil || !errors.Is(dataValue.Status, ua.StatusOK) || dataValue.Value == nil {
+		return nil, false
+	}
+
+	switch v := dataValue.Value.Value().(type) {
+	case *ua.LocalizedText:
+		if v == nil {
+			return nil, true
+		}
+		return map[string]any{"locale": v.Locale, "text": v.Text}, true
+	case *ua.QualifiedName:
+		if v == nil {
+			return nil, true
+		}
+		return fmt.Sprintf("%d:%s", v.NamespaceIndex, v.Name), true
+	case *ua.NodeID:
+		if v == nil {
+			return nil, true
+		}
+		return v.String(), true
+	default:
+		return JSONSafeValue(v), true
+	}
+}
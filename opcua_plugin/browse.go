@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -25,8 +26,14 @@ type NodeDef struct {
 	DataType     string
 	ParentNodeID string // custom, not an official opcua attribute
 	Path         string // custom, not an official opcua attribute
+	// Config is the entry of the nodes list that selected the node, nil for nodes of nodeIDs
+	Config  *NodeConfig `json:"-"`
+	TagName string      // custom, overrides the tag name that is derived from the browse name
 }
 
+// maxBrowseLevel is the deepest level below a configured node that browse descends to.
+const maxBrowseLevel = 10
+
 // join concatenates two strings with a dot separator.
 //
 // This function is used to construct hierarchical paths by joining parent and child
@@ -82,7 +89,7 @@ func browse(ctx context.Context, n *opcua.Node, path string, level int, logger *
 	defer wg.Done()
 
 	logger.Debugf("node:%s path:%q level:%d parentNodeId:%s\n", n, path, level, parentNodeId)
-	if level > 10 {
+	if level > maxBrowseLevel {
 		return
 	}
 
@@ -296,103 +303,6 @@ func browse(ctx context.Context, n *opcua.Node, path string, level int, logger *
 	return
 }
 
-// Node represents a node in the tree structure
-type Node struct {
-	NodeId   *ua.NodeID `json:"nodeId"`
-	Name     string     `json:"name"`
-	Children []*Node    `json:"children,omitempty"`
-}
-
-// GetNodeTree returns the tree structure of the OPC UA server nodes
-// GetNodeTree is currently used by united-manufacturing-hub/ManagementConsole repo for the BrowseOPCUA tags functionality
-func (g *OPCUAInput) GetNodeTree(ctx context.Context, msgChan chan<- string, rootNode *Node) (*Node, error) {
-	if g.Client == nil {
-		err := g.connect(ctx)
-		if err != nil {
-			g.Log.Infof("error setting up connection while getting the OPCUA nodes: %v", err)
-			return nil, err
-		}
-	}
-	defer func() {
-		if errors.Is(ctx.Err(), context.Canceled) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
-			// Create a new context to close the connection if the existing context is canceled or timed out
-			ctx = context.Background()
-		}
-		err := g.Client.Close(ctx)
-		if err != nil {
-			g.Log.Infof("error closing the connection while getting the OPCUA nodes: %v", err)
-		}
-	}()
-
-	var wg sync.WaitGroup
-	wg.Add(1)
-	g.browseChildren(ctx, &wg, 0, rootNode, id.HierarchicalReferences, msgChan)
-	wg.Wait()
-	close(msgChan)
-	return rootNode, nil
-}
-
-// browseChildren recursively browses the OPC UA server nodes and builds a tree structure
-func (g *OPCUAInput) browseChildren(ctx context.Context, wg *sync.WaitGroup, level int, parent *Node, referenceType uint32, msgChan chan<- string) {
-	defer wg.Done()
-	// Recursion limit only goes up to 10 levels
-	if level >= 10 {
-		return
-	}
-
-	// Check if the context is cancelled
-	select {
-	case <-ctx.Done():
-		return
-	default:
-		// continue to do other operation
-	}
-
-	go func() {
-		msgChan <- fmt.Sprintf("Fetching result for node:  %s", parent.Name)
-	}()
-
-	node := g.Client.Node(parent.NodeId)
-	nodeClass, err := node.NodeClass(ctx)
-	if err != nil {
-		g.Log.Warnf("error getting nodeClass for node ID %s: %v", parent.NodeId, err)
-	}
-
-	refs, err := node.ReferencedNodes(ctx, referenceType, ua.BrowseDirectionForward, ua.NodeClassAll, true)
-	if err != nil {
-		g.Log.Warnf("error browsing children: %v", err)
-		return
-	}
-
-	for _, ref := range refs {
-		newNode := g.Client.Node(ref.ID)
-		newNodeName, err := newNode.BrowseName(ctx)
-		if err != nil {
-			g.Log.Warnf("error browsing children: %v", err)
-			continue
-
-		}
-		child := &Node{
-			NodeId:   ref.ID,
-			Name:     newNodeName.Name,
-			Children: make([]*Node, 0),
-		}
-		parent.Children = append(parent.Children, child)
-		if nodeClass == ua.NodeClassVariable {
-			wg.Add(1)
-			go g.browseChildren(ctx, wg, level+1, child, id.HasComponent, msgChan)
-		}
-
-		if nodeClass == ua.NodeClassObject {
-			wg.Add(4)
-			go g.browseChildren(ctx, wg, level+1, child, id.HasComponent, msgChan)
-			go g.browseChildren(ctx, wg, level+1, child, id.Organizes, msgChan)
-			go g.browseChildren(ctx, wg, level+1, child, id.FolderType, msgChan)
-			go g.browseChildren(ctx, wg, level+1, child, id.HasNotifier, msgChan)
-		}
-	}
-}
-
 // discoverNodes retrieves a list of nodes from an OPC UA server.
 // It starts a goroutine for each nodeID to browse the nodes concurrently.
 // The function collects the nodes into a slice and returns it along with any error encountered.
@@ -453,7 +363,32 @@ func (g *OPCUAInput) discoverNodes(ctx context.Context) ([]NodeDef, map[string]s
 		nodeList = append(nodeList, node)
 	}
 
-	UpdateNodePaths(nodeList)
+	// Add the nodes of the nodes list. They take precedence over the same nodes found via nodeIDs,
+	// as their entry configures them explicitly.
+	if len(g.NodeConfigs) > 0 {
+		configuredNodes, err := g.discoverConfiguredNodes(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		configured := make(map[string]bool, len(configuredNodes))
+		for _, node := range configuredNodes {
+			configured[node.NodeID.String()] = true
+		}
+		for _, node := range nodeList {
+			if !configured[node.NodeID.String()] {
+				configuredNodes = append(configuredNodes, node)
+			}
+		}
+		nodeList = configuredNodes
+	}
+
+	// Sort the nodes, so that their order (and with that their client handles) does not depend on the browse order
+	sort.SliceStable(nodeList, func(i, j int) bool {
+		return nodeList[i].NodeID.String() < nodeList[j].NodeID.String()
+	})
+
+	UpdateNodePathsWithRegistry(nodeList, g.PathRegistry)
 
 	// Check for any errors collected during browsing
 	if len(errChan) > 0 {
@@ -513,7 +448,7 @@ func (g *OPCUAInput) BrowseAndSubscribeIfNeeded(ctx context.Context) error {
 			for node := range nodeHeartbeatChan {
 				nodeList = append(nodeList, node)
 			}
-			UpdateNodePaths(nodeList)
+			UpdateNodePathsWithRegistry(nodeList, g.PathRegistry)
 			if len(errChanHeartbeat) > 0 {
 				return <-errChanHeartbeat
 			}
@@ -523,35 +458,58 @@ func (g *OPCUAInput) BrowseAndSubscribeIfNeeded(ctx context.Context) error {
 	b, err := json.Marshal(nodeList)
 	if err != nil {
 		g.Log.Errorf("Unmarshalling failed: %s", err)
-		_ = g.Close(ctx) // ensure that if something fails here, the connection is always safely closed
 		return err
 	}
 
 	g.Log.Infof("Detected nodes: %s", b)
 
-	g.NodeList = nodeList
+	// Persist the paths of new nodes, so that they keep their tag names after a restart
+	if err := g.PathRegistry.Save(); err != nil {
+		g.Log.Warnf("Failed to save path registry: %v", err)
+	}
+
+	// Register the nodes for pull mode if needed. This is done on every (re-)connect,
+	// as registered nodes are only valid for the current session.
+	g.setNodes(nodeList, g.registerNodesIfNeeded(ctx, nodeList))
 
-	// If subscription is enabled, start subscribing to the nodes
-	if g.SubscribeEnabled {
+	if g.usesAttributeSnapshots() {
+		if err := g.readAttributeSnapshots(ctx, nodeList); err != nil {
+			g.Log.Errorf("Reading attribute snapshots failed: %s", err)
+			return err
+		}
+	}
+
+	// If subscription is enabled for any node, start subscribing to the nodes
+	if g.usesMode(NodeModeSubscribe) {
 		g.Log.Infof("Subscription is enabled, therefore start subscribing to the selected notes...")
 
-		g.Subscription, err = g.Client.Subscribe(ctx, &opcua.SubscriptionParameters{
-			Interval: opcua.DefaultSubscriptionInterval,
-		}, g.SubNotifyChan)
-		if err != nil {
+		if err := g.createSubscriptions(ctx, nodeList); err != nil {
 			g.Log.Errorf("Subscribing failed: %s", err)
-			_ = g.Close(ctx) // ensure that if something fails here, the connection is always safely closed
 			return err
 		}
 
-		monitoredNodes, err := g.MonitorBatched(ctx, nodeList)
-		if err != nil {
-			g.Log.Errorf("Monitoring failed: %s", err)
-			_ = g.Close(ctx) // ensure that if something fails here, the connection is always safely closed
-			return err
+		// If all nodes are polled, the subscription is only used by the trigger groups
+		if len(g.nodesInMode(nodeList, NodeModeSubscribe)) > 0 || len(g.nodesInMode(nodeList, NodeModePoll)) == 0 {
+			monitoredNodes, err := g.MonitorBatched(ctx, nodeList)
+			if err != nil {
+				g.Log.Errorf("Monitoring failed: %s", err)
+				return err
+			}
+
+			g.Log.Infof("Subscribed to %d nodes!", monitoredNodes)
 		}
 
-		g.Log.Infof("Subscribed to %d nodes!", monitoredNodes)
+		if g.usesAttributeSnapshots() {
+			if err := g.MonitorAttributes(ctx); err != nil {
+				g.Log.Errorf("Monitoring attributes failed: %s", err)
+				return err
+			}
+		}
+
+		if err := g.MonitorTriggerGroups(ctx); err != nil {
+			g.Log.Errorf("Monitoring trigger groups failed: %s", err)
+			return err
+		}
 
 	}
 
@@ -561,17 +519,20 @@ func (g *OPCUAInput) BrowseAndSubscribeIfNeeded(ctx context.Context) error {
 // MonitorBatched splits the nodes into manageable batches and starts monitoring them.
 // This approach prevents the server from returning BadTcpMessageTooLarge by avoiding oversized monitoring requests.
 // It returns the total number of nodes that were successfully monitored or an error if monitoring fails.
+// Nodes in poll mode are skipped, but the client handles stay the positions in nodes.
+// Each node is monitored in the subscription that is selected by subscriptionName.
 func (g *OPCUAInput) MonitorBatched(ctx context.Context, nodes []NodeDef) (int, error) {
-	const maxBatchSize = 100
+	maxBatchSize := g.maxMonitoredItemsPerCall()
 	totalMonitored := 0
 	totalNodes := len(nodes)
+	subscribedNodes := len(g.nodesInMode(nodes, NodeModeSubscribe))
 
-	if len(nodes) == 0 {
+	if subscribedNodes == 0 {
 		g.Log.Errorf("Did not subscribe to any nodes. This can happen if the nodes that are selected are incompatible with this benthos version. Aborting...")
 		return 0, fmt.Errorf("no valid nodes selected")
 	}
 
-	g.Log.Infof("Starting to monitor %d nodes in batches of %d", totalNodes, maxBatchSize)
+	g.Log.Infof("Starting to monitor %d nodes in batches of %d", subscribedNodes, maxBatchSize)
 
 	for startIdx := 0; startIdx < totalNodes; startIdx += maxBatchSize {
 		endIdx := startIdx + maxBatchSize
@@ -582,77 +543,156 @@ func (g *OPCUAInput) MonitorBatched(ctx context.Context, nodes []NodeDef) (int,
 		batch := nodes[startIdx:endIdx]
 		g.Log.Infof("Creating monitor for nodes %d to %d", startIdx, endIdx-1)
 
-		monitoredRequests := make([]*ua.MonitoredItemCreateRequest, 0, len(batch))
+		monitoredRequests := make(map[string][]*ua.MonitoredItemCreateRequest)
+		monitoredNodes := make(map[string][]NodeDef)
 
 		for pos, nodeDef := range batch {
+			if g.nodeMode(nodeDef) != NodeModeSubscribe {
+				continue
+			}
 			request := opcua.NewMonitoredItemCreateRequestWithDefaults(
 				nodeDef.NodeID,
 				ua.AttributeIDValue,
 				uint32(startIdx+pos),
 			)
-			monitoredRequests = append(monitoredRequests, request)
+			applyMonitoringParameters(request, nodeDef.Config)
+			name := g.subscriptionName(nodeDef)
+			monitoredRequests[name] = append(monitoredRequests[name], request)
+			monitoredNodes[name] = append(monitoredNodes[name], nodeDef)
 		}
 
-		response, err := g.Subscription.Monitor(ctx, ua.TimestampsToReturnBoth, monitoredRequests...)
-		if err != nil {
-			g.Log.Errorf("Failed to monitor batch %d-%d: %v", startIdx, endIdx-1, err)
-			if closeErr := g.Close(ctx); closeErr != nil {
-				g.Log.Errorf("Failed to close OPC UA connection: %v", closeErr)
+		for _, subscriptionConfig := range g.subscriptionConfigs() {
+			name := subscriptionConfig.Name
+			if len(monitoredRequests[name]) == 0 {
+				continue
 			}
-			return totalMonitored, fmt.Errorf("monitoring failed for batch %d-%d: %w", startIdx, endIdx-1, err)
-		}
 
-		if response == nil {
-			g.Log.Error("Received nil response from Monitor call")
-			if closeErr := g.Close(ctx); closeErr != nil {
-				g.Log.Errorf("Failed to close OPC UA connection: %v", closeErr)
+			response, err := g.Subscriptions[name].Monitor(ctx, ua.TimestampsToReturnBoth, monitoredRequests[name]...)
+			if err != nil {
+				g.Log.Errorf("Failed to monitor batch %d-%d in subscription %s: %v", startIdx, endIdx-1, name, err)
+				return totalMonitored, fmt.Errorf("monitoring failed for batch %d-%d: %w", startIdx, endIdx-1, err)
 			}
-			return totalMonitored, errors.New("received nil response from Monitor")
-		}
 
-		for i, result := range response.Results {
-			if !errors.Is(result.StatusCode, ua.StatusOK) {
-				failedNode := batch[i].NodeID.String()
-				g.Log.Errorf("Failed to monitor node %s: %v", failedNode, result.StatusCode)
-				// Depending on requirements, you might choose to continue monitoring other nodes
-				// instead of aborting. Here, we abort on the first failure.
-				if closeErr := g.Close(ctx); closeErr != nil {
-					g.Log.Errorf("Failed to close OPC UA connection: %v", closeErr)
+			if response == nil {
+				g.Log.Error("Received nil response from Monitor call")
+				return totalMonitored, errors.New("received nil response from Monitor")
+			}
+
+			for i, result := range response.Results {
+				if !errors.Is(result.StatusCode, ua.StatusOK) {
+					failedNode := monitoredNodes[name][i].NodeID.String()
+					g.Log.Errorf("Failed to monitor node %s: %v", failedNode, result.StatusCode)
+					// Depending on requirements, you might choose to continue monitoring other nodes
+					// instead of aborting. Here, we abort on the first failure.
+					return totalMonitored, fmt.Errorf("monitoring failed for node %s: %v", failedNode, result.StatusCode)
 				}
-				return totalMonitored, fmt.Errorf("monitoring failed for node %s: %v", failedNode, result.StatusCode)
 			}
+
+			totalMonitored += len(response.Results)
+			g.Log.Infof("Successfully monitored %d nodes of the current batch in subscription %s", len(response.Results), name)
 		}
 
-		monitoredNodes := len(response.Results)
-		totalMonitored += monitoredNodes
-		g.Log.Infof("Successfully monitored %d nodes in current batch", monitoredNodes)
 		time.Sleep(time.Second) // Sleep for some time to prevent overloading the server
 	}
 
-	g.Log.Infof("Monitoring completed. Total nodes monitored: %d/%d", totalMonitored, totalNodes)
+	g.Log.Infof("Monitoring completed. Total nodes monitored: %d/%d", totalMonitored, subscribedNodes)
 	return totalMonitored, nil
 }
 
 // UpdateNodePaths updates the node paths to use the nodeID instead of the browseName
 // if the browseName is not unique
+//
+// The result only depends on the set of nodes and not on their order, so that the same
+// node gets the same path regardless of the (concurrent) browse order. See also
+// UpdateNodePathsWithRegistry, which keeps the paths stable across restarts.
 func UpdateNodePaths(nodes []NodeDef) {
+	UpdateNodePathsWithRegistry(nodes, nil)
+}
+
+// UpdateNodePathsWithRegistry makes the paths of all nodes unique.
+//
+// Nodes that are already part of the registry keep their registered path. For all other nodes,
+// the path is made unique in two rounds:
+//  1. If multiple nodes share a path (or a path is registered for another node), the last
+//     element of their paths is replaced with their sanitized NodeID.
+//  2. If this still leads to duplicates (e.g., two NodeIDs that sanitize to the same string),
+//     the nodes are ordered by their NodeID and a counter is appended to their paths.
+//
+// Afterwards, the paths of the new nodes are added to the registry. The registry may be nil.
+func UpdateNodePathsWithRegistry(nodes []NodeDef, registry *PathRegistry) {
+	// taken contains all paths that are already assigned to a node, mapped to the NodeID of that node
+	taken := make(map[string]string, len(nodes))
+	registeredPaths := registry.Paths()
+	for nodeID, path := range registeredPaths {
+		taken[path] = nodeID
+	}
+
+	pending := make([]int, 0, len(nodes))
 	for i, node := range nodes {
-		for j, otherNode := range nodes {
-			if i == j {
-				continue
-			}
-			if node.Path == otherNode.Path {
-				// update only the last element of the path, after the last dot
-				nodePathSplit := strings.Split(node.Path, ".")
-				nodePath := strings.Join(nodePathSplit[:len(nodePathSplit)-1], ".")
-				nodePath = nodePath + "." + sanitize(node.NodeID.String())
-				nodes[i].Path = nodePath
-
-				otherNodePathSplit := strings.Split(otherNode.Path, ".")
-				otherNodePath := strings.Join(otherNodePathSplit[:len(otherNodePathSplit)-1], ".")
-				otherNodePath = otherNodePath + "." + sanitize(otherNode.NodeID.String())
-				nodes[j].Path = otherNodePath
+		if path, ok := registeredPaths[node.NodeID.String()]; ok {
+			nodes[i].Path = path
+			continue
+		}
+		pending = append(pending, i)
+	}
+
+	// isUnique reports whether the path of the node at index i is only used by this node
+	isUnique := func(i int, counts map[string]int) bool {
+		path := nodes[i].Path
+		if owner, ok := taken[path]; ok && owner != nodes[i].NodeID.String() {
+			return false
+		}
+		return counts[path] <= 1
+	}
+
+	// Round 1: replace the last element of duplicate paths with the sanitized NodeID
+	counts := make(map[string]int, len(pending))
+	for _, i := range pending {
+		counts[nodes[i].Path]++
+	}
+	for _, i := range pending {
+		if !isUnique(i, counts) {
+			nodes[i].Path = replaceLastPathElement(nodes[i].Path, sanitize(nodes[i].NodeID.String()))
+		}
+	}
+
+	// Round 2: append a counter to paths that are still not unique
+	counts = make(map[string]int, len(pending))
+	duplicates := make(map[string][]int)
+	for _, i := range pending {
+		counts[nodes[i].Path]++
+	}
+	for _, i := range pending {
+		if !isUnique(i, counts) {
+			duplicates[nodes[i].Path] = append(duplicates[nodes[i].Path], i)
+		}
+	}
+	for path, indices := range duplicates {
+		sort.Slice(indices, func(a, b int) bool {
+			return nodes[indices[a]].NodeID.String() < nodes[indices[b]].NodeID.String()
+		})
+		suffix := 1
+		for _, i := range indices {
+			for {
+				candidate := fmt.Sprintf("%s_%d", path, suffix)
+				suffix++
+				if _, ok := taken[candidate]; !ok && counts[candidate] == 0 {
+					nodes[i].Path = candidate
+					taken[candidate] = nodes[i].NodeID.String()
+					break
+				}
 			}
 		}
 	}
+
+	registry.Register(nodes)
+}
+
+// replaceLastPathElement replaces the last element of a dot-separated path.
+func replaceLastPathElement(path string, element string) string {
+	idx := strings.LastIndex(path, ".")
+	if idx == -1 {
+		return "." + element
+	}
+	return path[:idx] + "." + element
 }
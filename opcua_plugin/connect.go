@@ -4,7 +4,6 @@ import (
 	"context"
 	"crypto/rsa"
 	"crypto/tls"
-	"crypto/x509"
 	"encoding/pem"
 	"errors"
 	"fmt"
@@ -71,6 +70,9 @@ func (g *OPCUAInput) GetOPCUAClientOptions(selectedEndpoint *ua.EndpointDescript
 		opts = append(opts, opcua.SessionTimeout(SessionTimeout))
 	}
 	opts = append(opts, opcua.ApplicationName("benthos-umh"))
+	if len(g.Locales) > 0 {
+		opts = append(opts, opcua.Locales(g.Locales...))
+	}
 	//opts = append(opts, opcua.ApplicationURI("urn:benthos-umh"))
 	//opts = append(opts, opcua.ProductURI("urn:benthos-umh"))
 
@@ -297,7 +299,7 @@ func (g *OPCUAInput) logCertificateInfo(certBytes []byte) {
 	}
 
 	// Parse the DER-format certificate
-	cert, err := x509.ParseCertificate(block.Bytes)
+	cert, err := parseCertificateInfo(block.Bytes)
 	if err != nil {
 		g.Log.Errorf("Failed to parse certificate: " + err.Error())
 		return
@@ -0,0 +1,240 @@
+package opcua_plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gopcua/opcua/id"
+	"github.com/gopcua/opcua/ua"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// ServerDiagnostics is the payload of the periodic diagnostics message.
+// It combines the ServerStatus and ServerDiagnosticsSummary of the server with
+// the state of this client's own session and subscriptions.
+type ServerDiagnostics struct {
+	ManufacturerName    string                    `json:"manufacturerName"`
+	ProductName         string                    `json:"productName"`
+	SoftwareVersion     string                    `json:"softwareVersion"`
+	State               string                    `json:"state"`
+	StartTime           time.Time                 `json:"startTime"`
+	CurrentTime         time.Time                 `json:"currentTime"`
+	SecondsTillShutdown uint32                    `json:"secondsTillShutdown"`
+	ShutdownReason      string                    `json:"shutdownReason,omitempty"`
+	ServerRestarted     bool                      `json:"serverRestarted"`
+	Summary             *ServerDiagnosticsSummary `json:"summary,omitempty"`
+	Client              ClientDiagnostics         `json:"client"`
+}
+
+// ServerDiagnosticsSummary mirrors ua.ServerDiagnosticsSummaryDataType with JSON field names.
+// It is only available if the server has diagnostics enabled.
+type ServerDiagnosticsSummary struct {
+	ServerViewCount               uint32 `json:"serverViewCount"`
+	CurrentSessionCount           uint32 `json:"currentSessionCount"`
+	CumulatedSessionCount         uint32 `json:"cumulatedSessionCount"`
+	SecurityRejectedSessionCount  uint32 `json:"securityRejectedSessionCount"`
+	RejectedSessionCount          uint32 `json:"rejectedSessionCount"`
+	SessionTimeoutCount           uint32 `json:"sessionTimeoutCount"`
+	SessionAbortCount             uint32 `json:"sessionAbortCount"`
+	CurrentSubscriptionCount      uint32 `json:"currentSubscriptionCount"`
+	CumulatedSubscriptionCount    uint32 `json:"cumulatedSubscriptionCount"`
+	PublishingIntervalCount       uint32 `json:"publishingIntervalCount"`
+	SecurityRejectedRequestsCount uint32 `json:"securityRejectedRequestsCount"`
+	RejectedRequestsCount         uint32 `json:"rejectedRequestsCount"`
+}
+
+// ClientDiagnostics describes the session and subscriptions of this client.
+// The session ID itself is not exposed by the OPC UA client library, therefore only
+// the revised session timeout is reported.
+type ClientDiagnostics struct {
+	RevisedSessionTimeoutMs int64                     `json:"revisedSessionTimeoutMs"`
+	Subscriptions           []SubscriptionDiagnostics `json:"subscriptions"`
+	NotificationBacklog     int                       `json:"notificationBacklog"`
+	NotificationBacklogCap  int                       `json:"notificationBacklogCapacity"`
+}
+
+// SubscriptionDiagnostics describes a single subscription of this client with the values revised by the server.
+type SubscriptionDiagnostics struct {
+	Name                        string `json:"name"`
+	SubscriptionID              uint32 `json:"subscriptionId"`
+	RevisedPublishingIntervalMs int64  `json:"revisedPublishingIntervalMs"`
+	RevisedLifetimeCount        uint32 `json:"revisedLifetimeCount"`
+	RevisedMaxKeepAliveCount    uint32 `json:"revisedMaxKeepAliveCount"`
+}
+
+// GetServerDiagnostics reads the ServerStatus and ServerDiagnosticsSummary of the server and
+// combines them with the diagnostics of this client.
+//
+// ServerDiagnosticsSummary is optional: many servers only provide it if diagnostics are enabled,
+// in which case Summary stays nil. A missing ServerStatus is treated as an error, as every
+// server has to provide it.
+func (g *OPCUAInput) GetServerDiagnostics(ctx context.Context) (ServerDiagnostics, error) {
+	if g.Client == nil {
+		return ServerDiagnostics{}, errors.New("client is nil")
+	}
+
+	req := &ua.ReadRequest{
+		NodesToRead: []*ua.ReadValueID{
+			{NodeID: ua.NewNumericNodeID(0, id.Server_ServerStatus), AttributeID: ua.AttributeIDValue},
+			{NodeID: ua.NewNumericNodeID(0, id.Server_ServerDiagnostics_ServerDiagnosticsSummary), AttributeID: ua.AttributeIDValue},
+		},
+		TimestampsToReturn: ua.TimestampsToReturnNeither,
+	}
+
+	resp, err := g.Client.Read(ctx, req)
+	if err != nil {
+		return ServerDiagnostics{}, err
+	}
+
+	if len(resp.Results) != len(req.NodesToRead) {
+		return ServerDiagnostics{}, fmt.Errorf("expected %d results, got %d", len(req.NodesToRead), len(resp.Results))
+	}
+
+	diagnostics := ServerDiagnostics{
+		ManufacturerName: g.ServerInfo.ManufacturerName,
+		ProductName:      g.ServerInfo.ProductName,
+		SoftwareVersion:  g.ServerInfo.SoftwareVersion,
+	}
+
+	status, ok := extensionObjectValue[*ua.ServerStatusDataType](resp.Results[0])
+	if !ok {
+		return ServerDiagnostics{}, fmt.Errorf("could not read ServerStatus: %v", resp.Results[0].Status)
+	}
+	diagnostics.State = strings.TrimPrefix(status.State.String(), "ServerState")
+	diagnostics.StartTime = status.StartTime
+	diagnostics.CurrentTime = status.CurrentTime
+	diagnostics.SecondsTillShutdown = status.SecondsTillShutdown
+	if status.ShutdownReason != nil {
+		diagnostics.ShutdownReason = status.ShutdownReason.Text
+	}
+
+	if summary, ok := extensionObjectValue[*ua.ServerDiagnosticsSummaryDataType](resp.Results[1]); ok {
+		diagnostics.Summary = &ServerDiagnosticsSummary{
+			ServerViewCount:               summary.ServerViewCount,
+			CurrentSessionCount:           summary.CurrentSessionCount,
+			CumulatedSessionCount:         summary.CumulatedSessionCount,
+			SecurityRejectedSessionCount:  summary.SecurityRejectedSessionCount,
+			RejectedSessionCount:          summary.RejectedSessionCount,
+			SessionTimeoutCount:           summary.SessionTimeoutCount,
+			SessionAbortCount:             summary.SessionAbortCount,
+			CurrentSubscriptionCount:      summary.CurrentSubscriptionCount,
+			CumulatedSubscriptionCount:    summary.CumulatedSubscriptionCount,
+			PublishingIntervalCount:       summary.PublishingIntervalCount,
+			SecurityRejectedRequestsCount: summary.SecurityRejectedRequestsCount,
+			RejectedRequestsCount:         summary.RejectedRequestsCount,
+		}
+	} else {
+		g.Log.Debugf("ServerDiagnosticsSummary is not available (%v). This is normal if diagnostics are disabled on the server.", resp.Results[1].Status)
+	}
+
+	diagnostics.Client = g.clientDiagnostics()
+
+	return diagnostics, nil
+}
+
+// clientDiagnostics collects the diagnostics of this client's session and subscriptions.
+func (g *OPCUAInput) clientDiagnostics() ClientDiagnostics {
+	clientDiagnostics := ClientDiagnostics{
+		Subscriptions: make([]SubscriptionDiagnostics, 0),
+	}
+	for _, channel := range g.SubNotifyChans {
+		clientDiagnostics.NotificationBacklog += len(channel.Notifications)
+		clientDiagnostics.NotificationBacklogCap += cap(channel.Notifications)
+	}
+
+	if g.Client != nil && g.Client.Session() != nil {
+		clientDiagnostics.RevisedSessionTimeoutMs = g.Client.Session().RevisedTimeout().Milliseconds()
+	}
+
+	for _, subscriptionConfig := range g.subscriptionConfigs() {
+		subscription, ok := g.Subscriptions[subscriptionConfig.Name]
+		if !ok {
+			continue
+		}
+		clientDiagnostics.Subscriptions = append(clientDiagnostics.Subscriptions, SubscriptionDiagnostics{
+			Name:                        subscriptionConfig.Name,
+			SubscriptionID:              subscription.SubscriptionID,
+			RevisedPublishingIntervalMs: subscription.RevisedPublishingInterval.Milliseconds(),
+			RevisedLifetimeCount:        subscription.RevisedLifetimeCount,
+			RevisedMaxKeepAliveCount:    subscription.RevisedMaxKeepAliveCount,
+		})
+	}
+
+	return clientDiagnostics
+}
+
+// extensionObjectValue extracts a decoded extension object of type T from a DataValue.
+func extensionObjectValue[T any](dataValue *ua.DataValue) (T, bool) {
+	var empty T
+	if dataValue == nil || !errors.Is(dataValue.Status, ua.StatusOK) || dataValue.Value == nil {
+		return empty, false
+	}
+
+	extensionObject, ok := dataValue.Value.Value().(*ua.ExtensionObject)
+	if !ok || extensionObject == nil {
+		return empty, false
+	}
+
+	value, ok := extensionObject.Value.(T)
+	return value, ok
+}
+
+// diagnosticsInterval returns the configured diagnostics interval, or 0 if diagnostics are disabled.
+func (g *OPCUAInput) diagnosticsInterval() time.Duration {
+	if g.DiagnosticsInterval <= 0 {
+		return 0
+	}
+	return time.Duration(g.DiagnosticsInterval) * time.Millisecond
+}
+
+// appendDiagnosticsMessageIfDue appends a diagnostics message to the batch if diagnostics are
+// enabled and the diagnostics interval has passed since the last diagnostics message.
+// Failing to read the diagnostics is only logged, as it should not interrupt the data flow.
+func (g *OPCUAInput) appendDiagnosticsMessageIfDue(ctx context.Context, msgs service.MessageBatch) service.MessageBatch {
+	interval := g.diagnosticsInterval()
+	if interval == 0 || time.Now().Before(g.nextDiagnostics) {
+		return msgs
+	}
+	g.nextDiagnostics = time.Now().Add(interval)
+
+	diagnostics, err := g.GetServerDiagnostics(ctx)
+	if err != nil {
+		g.Log.Warnf("Failed to read OPC UA server diagnostics: %v", err)
+		return msgs
+	}
+
+	// A changed StartTime means that the server was restarted since the last diagnostics message
+	if !g.lastServerStartTime.IsZero() && !diagnostics.StartTime.Equal(g.lastServerStartTime) {
+		g.Log.Warnf("OPC UA server was restarted at %s", diagnostics.StartTime)
+		diagnostics.ServerRestarted = true
+	}
+	g.lastServerStartTime = diagnostics.StartTime
+
+	message, err := createDiagnosticsMessage(diagnostics)
+	if err != nil {
+		g.Log.Errorf("Error marshaling diagnostics to JSON: %v", err)
+		return msgs
+	}
+
+	return append(msgs, message)
+}
+
+// createDiagnosticsMessage creates a message for the diagnostics tag group from the given diagnostics.
+func createDiagnosticsMessage(diagnostics ServerDiagnostics) (*service.Message, error) {
+	b, err := json.Marshal(diagnostics)
+	if err != nil {
+		return nil, err
+	}
+
+	message := service.NewMessage(b)
+	message.MetaSet("opcua_tag_group", "diagnostics")
+	message.MetaSet("opcua_tag_name", "server")
+	message.MetaSet("opcua_tag_type", "string")
+	message.MetaSet("opcua_diagnostics_message", "true")
+	message.MetaSet("opcua_server_timestamp", diagnostics.CurrentTime.Format("2006-01-02T15:04:05.000000Z07:00"))
+	return message, nil
+}
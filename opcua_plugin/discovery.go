@@ -0,0 +1,302 @@
+package opcua_plugin
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gopcua/opcua"
+	"github.com/gopcua/opcua/ua"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// DiscoveryTimeout is the timeout for each single request during discovery.
+const DiscoveryTimeout = 10 * time.Second
+
+// DiscoveryReport is the result of a discovery run.
+type DiscoveryReport struct {
+	Timestamp     time.Time          `json:"timestamp"`
+	DiscoveryURLs []string           `json:"discoveryUrls"`
+	Servers       []DiscoveredServer `json:"servers"`
+	Errors        []string           `json:"errors,omitempty"`
+}
+
+// DiscoveredServer describes an OPC UA server that was found during discovery.
+type DiscoveredServer struct {
+	ApplicationURI     string               `json:"applicationUri"`
+	ProductURI         string               `json:"productUri"`
+	ApplicationName    string               `json:"applicationName"`
+	ApplicationType    string               `json:"applicationType"`
+	DiscoveryURLs      []string             `json:"discoveryUrls"`
+	ServerCapabilities []string             `json:"serverCapabilities,omitempty"`
+	SecurityPolicies   []string             `json:"securityPolicies"`
+	UserTokenTypes     []string             `json:"userTokenTypes"`
+	Endpoints          []DiscoveredEndpoint `json:"endpoints"`
+	Error              string               `json:"error,omitempty"`
+}
+
+// DiscoveredEndpoint contains the details of an endpoint that LogEndpoint logs.
+type DiscoveredEndpoint struct {
+	EndpointURL         string           `json:"endpointUrl"`
+	SecurityMode        string           `json:"securityMode"`
+	SecurityPolicyURI   string           `json:"securityPolicyUri"`
+	SecurityPolicy      string           `json:"securityPolicy"`
+	TransportProfileURI string           `json:"transportProfileUri"`
+	SecurityLevel       uint8            `json:"securityLevel"`
+	UserTokenTypes      []string         `json:"userTokenTypes"`
+	Certificate         *CertificateInfo `json:"certificate,omitempty"`
+}
+
+// CertificateInfo contains the details of a server certificate that logCertificateInfo logs.
+type CertificateInfo struct {
+	NotBefore   time.Time `json:"notBefore"`
+	NotAfter    time.Time `json:"notAfter"`
+	DNSNames    []string  `json:"dnsNames"`
+	IPAddresses []string  `json:"ipAddresses"`
+	URIs        []string  `json:"uris"`
+}
+
+// parseCertificateInfo parses a DER-encoded certificate into a CertificateInfo.
+func parseCertificateInfo(der []byte) (*CertificateInfo, error) {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &CertificateInfo{
+		NotBefore:   cert.NotBefore,
+		NotAfter:    cert.NotAfter,
+		DNSNames:    cert.DNSNames,
+		IPAddresses: make([]string, 0, len(cert.IPAddresses)),
+		URIs:        make([]string, 0, len(cert.URIs)),
+	}
+	for _, ip := range cert.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		info.URIs = append(info.URIs, uri.String())
+	}
+	return info, nil
+}
+
+// DescribeEndpoint converts an endpoint description into a DiscoveredEndpoint.
+func DescribeEndpoint(endpoint *ua.EndpointDescription) DiscoveredEndpoint {
+	discoveredEndpoint := DiscoveredEndpoint{
+		EndpointURL:         endpoint.EndpointURL,
+		SecurityMode:        strings.TrimPrefix(endpoint.SecurityMode.String(), "MessageSecurityMode"),
+		SecurityPolicyURI:   endpoint.SecurityPolicyURI,
+		SecurityPolicy:      securityPolicyName(endpoint.SecurityPolicyURI),
+		TransportProfileURI: endpoint.TransportProfileURI,
+		SecurityLevel:       endpoint.SecurityLevel,
+		UserTokenTypes:      make([]string, 0, len(endpoint.UserIdentityTokens)),
+	}
+
+	for _, token := range endpoint.UserIdentityTokens {
+		discoveredEndpoint.UserTokenTypes = appendUnique(discoveredEndpoint.UserTokenTypes, userTokenTypeName(token.TokenType))
+	}
+
+	if len(endpoint.ServerCertificate) > 0 {
+		if info, err := parseCertificateInfo(endpoint.ServerCertificate); err == nil {
+			discoveredEndpoint.Certificate = info
+		}
+	}
+
+	return discoveredEndpoint
+}
+
+// securityPolicyName returns the short name of a security policy URI, e.g., Basic256Sha256.
+func securityPolicyName(uri string) string {
+	if idx := strings.LastIndex(uri, "#"); idx != -1 {
+		return uri[idx+1:]
+	}
+	return uri
+}
+
+// userTokenTypeName returns the short name of a user token type, e.g., UserName.
+func userTokenTypeName(tokenType ua.UserTokenType) string {
+	return strings.TrimPrefix(tokenType.String(), "UserTokenType")
+}
+
+// appendUnique appends s to list if it is not part of it yet.
+func appendUnique(list []string, s string) []string {
+	for _, existing := range list {
+		if existing == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// Discover finds all OPC UA servers that are known to the given discovery URLs and fetches their endpoints.
+//
+// Each discovery URL can either point to a Local Discovery Server (LDS), which returns all servers
+// registered at it, or to a server itself, which returns only itself. Additionally, FindServersOnNetwork
+// is queried, which is supported by LDS with multicast extension (LDS-ME). Errors for single URLs or
+// servers are collected in the report instead of aborting the discovery.
+func (g *OPCUAInput) Discover(ctx context.Context, discoveryURLs []string) DiscoveryReport {
+	report := DiscoveryReport{
+		Timestamp:     time.Now().UTC(),
+		DiscoveryURLs: discoveryURLs,
+		Servers:       make([]DiscoveredServer, 0),
+	}
+
+	serversByURI := make(map[string]int)
+	addServer := func(server DiscoveredServer) {
+		key := server.ApplicationURI
+		if key == "" && len(server.DiscoveryURLs) > 0 {
+			key = server.DiscoveryURLs[0]
+		}
+		if idx, ok := serversByURI[key]; ok {
+			for _, url := range server.DiscoveryURLs {
+				report.Servers[idx].DiscoveryURLs = appendUnique(report.Servers[idx].DiscoveryURLs, url)
+			}
+			for _, capability := range server.ServerCapabilities {
+				report.Servers[idx].ServerCapabilities = appendUnique(report.Servers[idx].ServerCapabilities, capability)
+			}
+			return
+		}
+		serversByURI[key] = len(report.Servers)
+		report.Servers = append(report.Servers, server)
+	}
+
+	for _, discoveryURL := range discoveryURLs {
+		g.Log.Infof("Discovering OPC UA servers at %s", discoveryURL)
+
+		findCtx, cancel := context.WithTimeout(ctx, DiscoveryTimeout)
+		applications, err := opcua.FindServers(findCtx, discoveryURL)
+		cancel()
+		if err != nil {
+			g.Log.Warnf("FindServers failed for %s: %v", discoveryURL, err)
+			report.Errors = append(report.Errors, "FindServers "+discoveryURL+": "+err.Error())
+		}
+
+		for _, application := range applications {
+			if application == nil || application.ApplicationType == ua.ApplicationTypeDiscoveryServer {
+				continue
+			}
+			server := DiscoveredServer{
+				ApplicationURI:  application.ApplicationURI,
+				ProductURI:      application.ProductURI,
+				ApplicationType: strings.TrimPrefix(application.ApplicationType.String(), "ApplicationType"),
+				DiscoveryURLs:   make([]string, 0, len(application.DiscoveryURLs)),
+			}
+			if application.ApplicationName != nil {
+				server.ApplicationName = application.ApplicationName.Text
+			}
+			for _, url := range application.DiscoveryURLs {
+				server.DiscoveryURLs = appendUnique(server.DiscoveryURLs, url)
+			}
+			addServer(server)
+		}
+
+		findCtx, cancel = context.WithTimeout(ctx, DiscoveryTimeout)
+		serversOnNetwork, err := opcua.FindServersOnNetwork(findCtx, discoveryURL)
+		cancel()
+		if err != nil {
+			// Most servers and plain LDS do not support FindServersOnNetwork, so this is not reported as an error
+			g.Log.Debugf("FindServersOnNetwork failed for %s: %v", discoveryURL, err)
+			continue
+		}
+
+		for _, serverOnNetwork := range serversOnNetwork {
+			if serverOnNetwork == nil || serverOnNetwork.DiscoveryURL == "" {
+				continue
+			}
+			addServer(DiscoveredServer{
+				ApplicationName:    serverOnNetwork.ServerName,
+				DiscoveryURLs:      []string{serverOnNetwork.DiscoveryURL},
+				ServerCapabilities: serverOnNetwork.ServerCapabilities,
+			})
+		}
+	}
+
+	for i := range report.Servers {
+		g.describeServerEndpoints(ctx, &report.Servers[i])
+	}
+
+	return report
+}
+
+// describeServerEndpoints fetches the endpoints of a discovered server from the first of its discovery URLs that responds.
+func (g *OPCUAInput) describeServerEndpoints(ctx context.Context, server *DiscoveredServer) {
+	server.Endpoints = make([]DiscoveredEndpoint, 0)
+	server.SecurityPolicies = make([]string, 0)
+	server.UserTokenTypes = make([]string, 0)
+
+	var lastErr error
+	for _, discoveryURL := range server.DiscoveryURLs {
+		getCtx, cancel := context.WithTimeout(ctx, DiscoveryTimeout)
+		endpoints, err := opcua.GetEndpoints(getCtx, discoveryURL)
+		cancel()
+		if err != nil {
+			g.Log.Infof("Fetching endpoints from %s failed: %v", discoveryURL, err)
+			lastErr = err
+			continue
+		}
+
+		for _, endpoint := range endpoints {
+			if endpoint == nil {
+				continue
+			}
+			discoveredEndpoint := DescribeEndpoint(endpoint)
+			server.Endpoints = append(server.Endpoints, discoveredEndpoint)
+			server.SecurityPolicies = appendUnique(server.SecurityPolicies, discoveredEndpoint.SecurityPolicy)
+			for _, tokenType := range discoveredEndpoint.UserTokenTypes {
+				server.UserTokenTypes = appendUnique(server.UserTokenTypes, tokenType)
+			}
+
+			// Servers found with FindServersOnNetwork only announce their name and discovery URL
+			if server.ApplicationURI == "" && endpoint.Server != nil {
+				server.ApplicationURI = endpoint.Server.ApplicationURI
+				server.ProductURI = endpoint.Server.ProductURI
+				server.ApplicationType = strings.TrimPrefix(endpoint.Server.ApplicationType.String(), "ApplicationType")
+			}
+		}
+		return
+	}
+
+	if lastErr == nil {
+		lastErr = errors.New("server has no discovery URLs")
+	}
+	server.Error = lastErr.Error()
+}
+
+// discoveryURLs returns the URLs that are used in discover mode.
+func (g *OPCUAInput) discoveryURLs() []string {
+	if len(g.DiscoveryURLs) > 0 {
+		return g.DiscoveryURLs
+	}
+	return []string{g.Endpoint}
+}
+
+// ReadBatchDiscover runs the discovery once and returns the report as a single message.
+// Afterwards, it returns service.ErrEndOfInput, so that the input shuts down.
+func (g *OPCUAInput) ReadBatchDiscover(ctx context.Context) (service.MessageBatch, service.AckFunc, error) {
+	if g.discoveryDone {
+		return nil, nil, service.ErrEndOfInput
+	}
+
+	report := g.Discover(ctx, g.discoveryURLs())
+	g.discoveryDone = true
+
+	b, err := json.Marshal(report)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	g.Log.Infof("Discovered %d OPC UA servers", len(report.Servers))
+
+	message := service.NewMessage(b)
+	message.MetaSet("opcua_tag_group", "discovery")
+	message.MetaSet("opcua_tag_name", "servers")
+	message.MetaSet("opcua_tag_type", "string")
+	message.MetaSet("opcua_discovery_message", "true")
+
+	return service.MessageBatch{message}, func(ctx context.Context, err error) error {
+		// Nacks are retried automatically when we use service.AutoRetryNacks
+		return nil
+	}, nil
+}
@@ -0,0 +1,59 @@
+package opcua_plugin
+
+import (
+	"github.com/gopcua/opcua/ua"
+)
+
+// SetFaultHooks sets the hooks of the server that the tests use to inject faults. They have to be set before Start.
+//
+// onCreateMonitoredItem is called before a monitored item is created, onSetTriggering before monitored items are
+// linked to the triggering item of the given node. Returning a bad status code rejects the request. onRegisterNodes
+// is called when a client registers (register is true) or unregisters nodes.
+func (s *OPCUAServer) SetFaultHooks(
+	onCreateMonitoredItem func(node *ServerNode, item *ua.ReadValueID) ua.StatusCode,
+	onSetTriggering func(node *ServerNode) ua.StatusCode,
+	onRegisterNodes func(nodeIDs []*ua.NodeID, register bool),
+) {
+	s.onCreateMonitoredItem = onCreateMonitoredItem
+	s.onSetTriggering = onSetTriggering
+	s.onRegisterNodes = onRegisterNodes
+}
+
+// SessionCount returns the number of sessions, including sessions whose channel was closed.
+func (s *OPCUAServer) SessionCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
+
+// DropSessions closes all sessions and client connections while the server keeps running,
+// as clients see it after a restart of the server. Clients have to reconnect and create new sessions.
+func (s *OPCUAServer) DropSessions() {
+	s.mu.Lock()
+	sessions := s.sessions
+	s.sessions = make(map[string]*serverSession)
+	channels := make([]*serverChannel, 0, len(s.channels))
+	for _, ch := range s.channels {
+		channels = append(channels, ch)
+	}
+	s.mu.Unlock()
+
+	for _, session := range sessions {
+		session.close()
+	}
+	for _, ch := range channels {
+		ch.close()
+	}
+}
+
+// CheckSequenceNumbers checks the sequence numbers of the chunks that a client sends on a new channel
+// and returns the error of the first invalid one.
+func CheckSequenceNumbers(numbers ...uint32) error {
+	c := &serverChannel{}
+	for i, number := range numbers {
+		if err := c.checkSequenceNumber(number, i == 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,10 +1,11 @@
 package opcua_plugin
 
 import (
-	"golang.org/x/exp/slices"
+	"fmt"
 	"time"
 
 	"github.com/redpanda-data/benthos/v4/public/service"
+	"golang.org/x/exp/slices"
 )
 
 // updateHeartbeatInMessageBatch processes the heartbeat message in a batch of messages.
@@ -19,7 +20,7 @@ func (g *OPCUAInput) updateHeartbeatInMessageBatch(msgs service.MessageBatch) se
 	}
 
 	if len(msgs) != 0 {
-		g.LastMessageReceived.Store(uint32(time.Now().Unix()))
+		g.LastMessageReceived.Store(time.Now().UnixMilli())
 	}
 
 	idx := slices.IndexFunc(msgs, func(msg *service.Message) bool {
@@ -31,7 +32,7 @@ func (g *OPCUAInput) updateHeartbeatInMessageBatch(msgs service.MessageBatch) se
 		return msgs
 	}
 
-	g.LastHeartbeatMessageReceived.Store(uint32(time.Now().Unix()))
+	g.LastHeartbeatMessageReceived.Store(time.Now().UnixMilli())
 
 	if g.HeartbeatManualSubscribed {
 		g.Log.Debugf("Got heartbeat message. Duplicating it to a new message.")
@@ -48,3 +49,75 @@ func (g *OPCUAInput) updateHeartbeatInMessageBatch(msgs service.MessageBatch) se
 		return msgs
 	}
 }
+
+// DefaultHeartbeatTimeout is used if no heartbeat timeout is configured.
+const DefaultHeartbeatTimeout = 10 * time.Second
+
+const (
+	ConnectionStateConnected    = "connected"
+	ConnectionStateDisconnected = "disconnected"
+)
+
+// heartbeatTimeout returns the configured heartbeat timeout or DefaultHeartbeatTimeout if none is set.
+func (g *OPCUAInput) heartbeatTimeout() time.Duration {
+	if g.HeartbeatTimeout > 0 {
+		return time.Duration(g.HeartbeatTimeout) * time.Millisecond
+	}
+	return DefaultHeartbeatTimeout
+}
+
+// checkHeartbeatTimeout checks whether the connection needs to be re-established because the
+// server stopped publishing. It returns the reason for re-connecting, or an empty string if the
+// connection is healthy.
+//
+// The connection is considered stale if no heartbeat was received within the heartbeat timeout.
+// The only exception are servers that are known to not update the heartbeat node in a subscription
+// (Prosys), where the connection is only considered stale if also no other message was received.
+func (g *OPCUAInput) checkHeartbeatTimeout(now time.Time) string {
+	lastHeartbeat := g.LastHeartbeatMessageReceived.Load()
+	// 0 means that browsing has not finished yet, so no heartbeat can be expected
+	if !g.UseHeartbeat || lastHeartbeat == 0 {
+		return ""
+	}
+
+	timeout := g.heartbeatTimeout()
+	if now.Sub(time.UnixMilli(lastHeartbeat)) <= timeout {
+		return ""
+	}
+
+	if now.Sub(time.UnixMilli(g.LastMessageReceived.Load())) > timeout {
+		return fmt.Sprintf("No messages received (including heartbeat) for over %v", timeout)
+	}
+
+	if g.ServerInfo.ManufacturerName == "Prosys OPC Ltd." {
+		g.Log.Infof("No heartbeat message (ServerTime) received for over %v. This is normal for your Prosys OPC UA server. Other messages are being received; continuing operations.", timeout)
+		return ""
+	}
+
+	return fmt.Sprintf("No heartbeat message (ServerTime) received for over %v, although other messages are being received", timeout)
+}
+
+// createConnectionStateMessage creates a message that informs downstream systems about the
+// connection state, e.g., to flag the data of this input as stale.
+func (g *OPCUAInput) createConnectionStateMessage(state string, reason string) *service.Message {
+	message := service.NewMessage([]byte(state))
+	message.MetaSet("opcua_tag_group", "connection")
+	message.MetaSet("opcua_tag_name", "state")
+	message.MetaSet("opcua_tag_type", "string")
+	message.MetaSet("opcua_connection_state_message", "true")
+	message.MetaSet("opcua_connection_state_reason", reason)
+	message.MetaSet("opcua_server_timestamp", time.Now().UTC().Format("2006-01-02T15:04:05.000000Z07:00"))
+	return message
+}
+
+// prependConnectionStateMessage adds a pending connection state message (set by Connect) to the
+// beginning of the batch, so that it is delivered before the first values of the new connection.
+func (g *OPCUAInput) prependConnectionStateMessage(msgs service.MessageBatch) service.MessageBatch {
+	if g.pendingConnectionState == "" {
+		return msgs
+	}
+
+	message := g.createConnectionStateMessage(g.pendingConnectionState, "")
+	g.pendingConnectionState = ""
+	return append(service.MessageBatch{message}, msgs...)
+}
@@ -0,0 +1,368 @@
+package opcua_plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopcua/opcua/id"
+	"github.com/gopcua/opcua/ua"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+const (
+	// NodeModeSubscribe monitors a node in the subscription of the input.
+	NodeModeSubscribe = "subscribe"
+	// NodeModePoll reads a node every pollRate milliseconds.
+	NodeModePoll = "poll"
+)
+
+// NodeConfig is an entry of the nodes list. It selects a node by its NodeID or browse path and configures
+// how the node and the nodes below it are read and named.
+type NodeConfig struct {
+	NodeID *ua.NodeID
+	// BrowsePath is resolved relative to the Objects folder on every (re-)connect
+	BrowsePath []*ua.QualifiedName
+	TagName    string // overrides opcua_tag_name of the configured node itself
+	TagGroup   string // replaces the first element of opcua_tag_group of all nodes found by this entry
+	// Mode is NodeModeSubscribe or NodeModePoll. An empty mode in the configuration defaults to subscribeEnabled.
+	Mode             string
+	SamplingInterval float64 // in milliseconds, only used in subscribe mode
+	Deadband         float64 // absolute deadband, only used in subscribe mode
+	Subscription     string  // name of the subscription in which the nodes are monitored, only used in subscribe mode
+	Recursive        bool
+	Metadata         map[string]string
+}
+
+// String returns the NodeID or the browse path of the entry.
+func (c *NodeConfig) String() string {
+	if c.NodeID != nil {
+		return c.NodeID.String()
+	}
+
+	elements := make([]string, 0, len(c.BrowsePath))
+	for _, name := range c.BrowsePath {
+		elements = append(elements, fmt.Sprintf("%d:%s", name.NamespaceIndex, name.Name))
+	}
+	return "/" + strings.Join(elements, "/")
+}
+
+// ParseNodeConfigs parses the nodes configuration into NodeConfigs. Entries without a mode get the mode
+// that is selected by subscribeEnabled.
+func ParseNodeConfigs(confs []*service.ParsedConfig, subscribeEnabled bool) ([]*NodeConfig, error) {
+	nodeConfigs := make([]*NodeConfig, 0, len(confs))
+
+	for i, conf := range confs {
+		nodeIDString, err := conf.FieldString("nodeID")
+		if err != nil {
+			return nil, err
+		}
+
+		browsePathString, err := conf.FieldString("browsePath")
+		if err != nil {
+			return nil, err
+		}
+
+		tagName, err := conf.FieldString("tagName")
+		if err != nil {
+			return nil, err
+		}
+
+		tagGroup, err := conf.FieldString("tagGroup")
+		if err != nil {
+			return nil, err
+		}
+
+		mode, err := conf.FieldString("mode")
+		if err != nil {
+			return nil, err
+		}
+
+		samplingInterval, err := conf.FieldInt("samplingInterval")
+		if err != nil {
+			return nil, err
+		}
+
+		deadband, err := conf.FieldFloat("deadband")
+		if err != nil {
+			return nil, err
+		}
+
+		subscription, err := conf.FieldString("subscription")
+		if err != nil {
+			return nil, err
+		}
+
+		recursive, err := conf.FieldBool("recursive")
+		if err != nil {
+			return nil, err
+		}
+
+		metadata, err := conf.FieldStringMap("metadata")
+		if err != nil {
+			return nil, err
+		}
+
+		nodeConfig := &NodeConfig{
+			TagName:          tagName,
+			TagGroup:         tagGroup,
+			Mode:             mode,
+			SamplingInterval: float64(samplingInterval),
+			Deadband:         deadband,
+			Subscription:     subscription,
+			Recursive:        recursive,
+			Metadata:         metadata,
+		}
+
+		switch {
+		case nodeIDString != "" && browsePathString != "":
+			return nil, fmt.Errorf("node %d: only one of nodeID and browsePath can be set", i)
+		case nodeIDString != "":
+			nodeConfig.NodeID, err = ua.ParseNodeID(nodeIDString)
+			if err != nil {
+				return nil, fmt.Errorf("node %d: invalid nodeID %q: %w", i, nodeIDString, err)
+			}
+		case browsePathString != "":
+			nodeConfig.BrowsePath, err = ParseBrowsePath(browsePathString)
+			if err != nil {
+				return nil, fmt.Errorf("node %d: invalid browsePath %q: %w", i, browsePathString, err)
+			}
+		default:
+			return nil, fmt.Errorf("node %d: either nodeID or browsePath must be set", i)
+		}
+
+		switch mode {
+		case "":
+			nodeConfig.Mode = NodeModePoll
+			if subscribeEnabled {
+				nodeConfig.Mode = NodeModeSubscribe
+			}
+		case NodeModeSubscribe, NodeModePoll:
+		default:
+			return nil, fmt.Errorf("node %s: invalid mode %q, must be %q or %q", nodeConfig, mode, NodeModeSubscribe, NodeModePoll)
+		}
+
+		if samplingInterval < -1 {
+			return nil, fmt.Errorf("node %s: samplingInterval must be -1 or greater", nodeConfig)
+		}
+
+		if deadband < 0 {
+			return nil, fmt.Errorf("node %s: deadband must not be negative", nodeConfig)
+		}
+
+		if nodeConfig.Mode == NodeModePoll && (samplingInterval != 0 || deadband != 0 || subscription != "") {
+			return nil, fmt.Errorf("node %s: samplingInterval, deadband and subscription require mode %q", nodeConfig, NodeModeSubscribe)
+		}
+
+		nodeConfigs = append(nodeConfigs, nodeConfig)
+	}
+
+	return nodeConfigs, nil
+}
+
+// ParseBrowsePath parses a browse path like /2:Machine/2:Temperature into its browse names.
+// The leading slash is optional. Browse names without a namespace index are in namespace 0.
+func ParseBrowsePath(path string) ([]*ua.QualifiedName, error) {
+	path = strings.TrimPrefix(path, "/")
+	if path == "" {
+		return nil, errors.New("browse path is empty")
+	}
+
+	elements := strings.Split(path, "/")
+	names := make([]*ua.QualifiedName, 0, len(elements))
+	for _, element := range elements {
+		name := &ua.QualifiedName{Name: element}
+		if prefix, rest, found := strings.Cut(element, ":"); found {
+			if ns, err := strconv.ParseUint(prefix, 10, 16); err == nil {
+				name = &ua.QualifiedName{NamespaceIndex: uint16(ns), Name: rest}
+			}
+		}
+		if name.Name == "" {
+			return nil, fmt.Errorf("browse path %q contains an empty browse name", path)
+		}
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+// nodeMode returns the mode of a node, which is the mode of its entry in the nodes list,
+// or the mode selected by subscribeEnabled for nodes of nodeIDs.
+func (g *OPCUAInput) nodeMode(nodeDef NodeDef) string {
+	if nodeDef.Config != nil {
+		return nodeDef.Config.Mode
+	}
+	if g.SubscribeEnabled {
+		return NodeModeSubscribe
+	}
+	return NodeModePoll
+}
+
+// usesMode reports whether any node of the input can be read in the given mode.
+func (g *OPCUAInput) usesMode(mode string) bool {
+	if g.nodeMode(NodeDef{}) == mode {
+		return true
+	}
+	for _, nodeConfig := range g.NodeConfigs {
+		if nodeConfig.Mode == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// nodesInMode returns the nodes of nodeList that are read in the given mode.
+func (g *OPCUAInput) nodesInMode(nodeList []NodeDef, mode string) []NodeDef {
+	if len(g.NodeConfigs) == 0 {
+		if g.nodeMode(NodeDef{}) == mode {
+			return nodeList
+		}
+		return nil
+	}
+
+	nodes := make([]NodeDef, 0, len(nodeList))
+	for _, node := range nodeList {
+		if g.nodeMode(node) == mode {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// discoverConfiguredNodes browses the entries of the nodes list and links the found nodes to their entry.
+// The entries are browsed concurrently. If a node is found by multiple entries, the first entry wins.
+func (g *OPCUAInput) discoverConfiguredNodes(ctx context.Context) ([]NodeDef, error) {
+	results := make([][]NodeDef, len(g.NodeConfigs))
+	errs := make([]error, len(g.NodeConfigs))
+
+	var wg sync.WaitGroup
+	for i, nodeConfig := range g.NodeConfigs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = g.browseNodeConfig(ctx, nodeConfig)
+		}()
+	}
+	wg.Wait()
+
+	nodeList := make([]NodeDef, 0)
+	seen := make(map[string]bool)
+	for i, nodes := range results {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		for _, node := range nodes {
+			key := node.NodeID.String()
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			nodeList = append(nodeList, node)
+		}
+	}
+
+	return nodeList, nil
+}
+
+// browseNodeConfig resolves the node of an entry of the nodes list and browses it. Without recursive,
+// only the node itself and its direct children are returned.
+func (g *OPCUAInput) browseNodeConfig(ctx context.Context, nodeConfig *NodeConfig) ([]NodeDef, error) {
+	nodeID := nodeConfig.NodeID
+	if nodeID == nil {
+		var err error
+		nodeID, err = g.Client.Node(ua.NewNumericNodeID(0, id.ObjectsFolder)).TranslateBrowsePathsToNodeIDs(ctx, nodeConfig.BrowsePath)
+		if err != nil {
+			return nil, fmt.Errorf("resolving browse path %s failed: %w", nodeConfig, err)
+		}
+		g.Log.Debugf("Resolved browse path %s to %s", nodeConfig, nodeID)
+	}
+
+	level := 0
+	if !nodeConfig.Recursive {
+		// browse stops below maxBrowseLevel, so this only reaches the direct children
+		level = maxBrowseLevel - 1
+	}
+
+	nodeChan := make(chan NodeDef)
+	errChan := make(chan error)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go browse(ctx, g.Client.Node(nodeID), "", level, g.Log, nodeID.String(), nodeChan, errChan, nil, &wg)
+	go func() {
+		wg.Wait()
+		close(nodeChan)
+		close(errChan)
+	}()
+
+	var (
+		nodes    []NodeDef
+		firstErr error
+	)
+	for nodeChan != nil || errChan != nil {
+		select {
+		case node, ok := <-nodeChan:
+			if !ok {
+				nodeChan = nil
+				continue
+			}
+			node.Config = nodeConfig
+			if nodeConfig.TagName != "" && node.NodeID.String() == nodeID.String() {
+				node.TagName = nodeConfig.TagName
+			}
+			nodes = append(nodes, node)
+		case err, ok := <-errChan:
+			if !ok {
+				errChan = nil
+				continue
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	if firstErr != nil {
+		return nil, fmt.Errorf("browsing node %s failed: %w", nodeConfig, firstErr)
+	}
+	return nodes, nil
+}
+
+// applyMonitoringParameters sets the sampling interval and the deadband of an entry of the nodes list
+// in a monitored item request.
+func applyMonitoringParameters(request *ua.MonitoredItemCreateRequest, nodeConfig *NodeConfig) {
+	if nodeConfig == nil {
+		return
+	}
+
+	if nodeConfig.SamplingInterval != 0 {
+		request.RequestedParameters.SamplingInterval = nodeConfig.SamplingInterval
+	}
+
+	if nodeConfig.Deadband > 0 {
+		request.RequestedParameters.Filter = ua.NewExtensionObject(&ua.DataChangeFilter{
+			Trigger:       ua.DataChangeTriggerStatusValue,
+			DeadbandType:  uint32(ua.DeadbandTypeAbsolute),
+			DeadbandValue: nodeConfig.Deadband,
+		})
+	}
+}
+
+// readBatchMixed is used if some nodes are subscribed and others are polled. It waits for notifications
+// of the subscription until the next poll is due and then reads the polled nodes.
+func (g *OPCUAInput) readBatchMixed(ctx context.Context) (service.MessageBatch, service.AckFunc, error) {
+	wait := SubscribeTimeoutContext
+	if !g.nextPoll.IsZero() {
+		wait = min(wait, time.Until(g.nextPoll))
+	}
+	if g.nextPoll.IsZero() || wait <= 0 {
+		return g.ReadBatchPull(ctx)
+	}
+
+	ctxSubscribe, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	return g.ReadBatchSubscribe(ctxSubscribe)
+}
@@ -0,0 +1,352 @@
+package opcua_plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/gopcua/opcua/id"
+	"github.com/gopcua/opcua/ua"
+)
+
+const (
+	// DefaultBrowseMaxDepth is the depth below the root node up to which GetNodeTree browses.
+	// Nodes at this depth are part of the tree, but their children are not browsed.
+	DefaultBrowseMaxDepth = 10
+	// DefaultBrowseConcurrency is the number of nodes GetNodeTree browses at the same time.
+	DefaultBrowseConcurrency = 10
+)
+
+// Node represents a node in the tree structure
+type Node struct {
+	NodeId   *ua.NodeID `json:"nodeId"`
+	Name     string     `json:"name"`
+	Children []*Node    `json:"children,omitempty"`
+}
+
+// BrowseEventType is the type of a BrowseEvent.
+type BrowseEventType string
+
+const (
+	// BrowseEventNode is sent for every node that was added to the tree, so that partial trees can be shown.
+	BrowseEventNode BrowseEventType = "node"
+	// BrowseEventError is sent if browsing a node failed. The node stays in the tree without children.
+	BrowseEventError BrowseEventType = "error"
+	// BrowseEventProgress is sent after the children of a node were browsed.
+	BrowseEventProgress BrowseEventType = "progress"
+)
+
+// BrowseProgress counts the nodes of a GetNodeTree call. The counts of a resumed call include the
+// nodes of the calls before.
+type BrowseProgress struct {
+	// Discovered is the number of nodes in the tree, including the root node.
+	Discovered int `json:"discovered"`
+	// Visited is the number of nodes whose children were browsed, including the failed ones.
+	Visited int `json:"visited"`
+	// Queued is the number of nodes whose children are not browsed yet.
+	Queued int `json:"queued"`
+	// Failed is the number of nodes that could not be browsed.
+	Failed int `json:"failed"`
+	// Depth is the depth of the deepest visited node below the root node.
+	Depth int `json:"depth"`
+}
+
+// BrowseOperation is the step of browsing a node that failed.
+type BrowseOperation string
+
+const (
+	BrowseOperationNodeClass  BrowseOperation = "nodeClass"
+	BrowseOperationReferences BrowseOperation = "references"
+	BrowseOperationBrowseName BrowseOperation = "browseName"
+)
+
+// BrowseError is the error of browsing a single node. It does not stop GetNodeTree.
+type BrowseError struct {
+	NodeID    *ua.NodeID      `json:"nodeId"`
+	Name      string          `json:"name"`
+	Depth     int             `json:"depth"`
+	Operation BrowseOperation `json:"operation"`
+	Err       error           `json:"-"`
+}
+
+func (e *BrowseError) Error() string {
+	return fmt.Sprintf("browsing %s of node %s failed: %v", e.Operation, e.NodeID, e.Err)
+}
+
+func (e *BrowseError) Unwrap() error {
+	return e.Err
+}
+
+// BrowseEvent reports the progress of GetNodeTree.
+type BrowseEvent struct {
+	Type     BrowseEventType `json:"type"`
+	Progress BrowseProgress  `json:"progress"`
+	// Node is the node that was added to the tree (BrowseEventNode) or that was browsed. It is a copy without children.
+	Node *Node `json:"node,omitempty"`
+	// ParentNodeID is the parent of a node that was added to the tree.
+	ParentNodeID *ua.NodeID   `json:"parentNodeId,omitempty"`
+	Error        *BrowseError `json:"error,omitempty"`
+}
+
+// BrowseOptions configures GetNodeTree.
+type BrowseOptions struct {
+	// MaxDepth defaults to DefaultBrowseMaxDepth and Concurrency to DefaultBrowseConcurrency.
+	MaxDepth    int
+	Concurrency int
+	// Events receives the progress of the browse. It must be read until GetNodeTree closes it.
+	Events chan<- BrowseEvent
+	// Resume continues a cancelled browse from its checkpoint instead of starting at the root node.
+	Resume *BrowseCheckpoint
+}
+
+// BrowseCheckpoint holds the state of a cancelled GetNodeTree call.
+type BrowseCheckpoint struct {
+	root     *Node
+	progress BrowseProgress
+	errors   []*BrowseError
+	pending  []browseTask
+}
+
+// Pending returns the number of nodes that are left to browse.
+func (c *BrowseCheckpoint) Pending() int {
+	return len(c.pending)
+}
+
+// BrowseResult is the result of GetNodeTree.
+type BrowseResult struct {
+	Root     *Node
+	Progress BrowseProgress
+	Errors   []*BrowseError
+	// Checkpoint is set if the browse was cancelled before all nodes were browsed.
+	// Pass it as BrowseOptions.Resume to continue.
+	Checkpoint *BrowseCheckpoint
+}
+
+// browseTask is a node whose children are not browsed yet.
+type browseTask struct {
+	node  *Node
+	depth int
+	// referenceTypes overrides the reference types that are derived from the node class, e.g., for the root node
+	referenceTypes []uint32
+}
+
+// GetNodeTree returns the tree structure of the OPC UA server nodes below rootNode.
+// GetNodeTree is currently used by united-manufacturing-hub/ManagementConsole repo for the BrowseOPCUA tags functionality
+//
+// The nodes are browsed breadth-first by a pool of workers. Errors of single nodes are reported as
+// BrowseEventError and in BrowseResult.Errors without stopping the browse. If ctx is cancelled, the partial
+// tree is returned together with ctx.Err() and a checkpoint to resume from. The connection is closed when
+// GetNodeTree returns, so that a resumed call connects again.
+func (g *OPCUAInput) GetNodeTree(ctx context.Context, rootNode *Node, opts BrowseOptions) (*BrowseResult, error) {
+	if opts.Events != nil {
+		defer close(opts.Events)
+	}
+
+	if g.Client == nil {
+		err := g.connect(ctx)
+		if err != nil {
+			g.Log.Infof("error setting up connection while getting the OPCUA nodes: %v", err)
+			return nil, err
+		}
+	}
+	defer func() {
+		// Use a new context to close the connection if the existing context is canceled or timed out
+		err := g.Client.Close(context.Background())
+		if err != nil {
+			g.Log.Infof("error closing the connection while getting the OPCUA nodes: %v", err)
+		}
+		g.Client = nil
+	}()
+
+	b := &treeBrowser{g: g, opts: opts}
+	if b.opts.MaxDepth <= 0 {
+		b.opts.MaxDepth = DefaultBrowseMaxDepth
+	}
+	if b.opts.Concurrency <= 0 {
+		b.opts.Concurrency = DefaultBrowseConcurrency
+	}
+	b.cond = sync.NewCond(&b.mu)
+
+	if opts.Resume != nil {
+		b.root = opts.Resume.root
+		b.progress = opts.Resume.progress
+		b.errors = opts.Resume.errors
+		b.queue = append([]browseTask(nil), opts.Resume.pending...)
+	} else {
+		if rootNode == nil {
+			return nil, errors.New("rootNode must be set")
+		}
+		b.root = rootNode
+		b.progress.Discovered = 1
+		b.queue = []browseTask{{node: rootNode, referenceTypes: []uint32{id.HierarchicalReferences}}}
+	}
+	b.progress.Queued = len(b.queue)
+
+	// Wake up idle workers when the context is cancelled
+	stop := context.AfterFunc(ctx, func() {
+		b.mu.Lock()
+		b.cond.Broadcast()
+		b.mu.Unlock()
+	})
+	defer stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < b.opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.work(ctx)
+		}()
+	}
+	wg.Wait()
+
+	b.progress.Queued = len(b.queue)
+	result := &BrowseResult{Root: b.root, Progress: b.progress, Errors: b.errors}
+	if len(b.queue) > 0 {
+		result.Checkpoint = &BrowseCheckpoint{root: b.root, progress: b.progress, errors: b.errors, pending: b.queue}
+		return result, ctx.Err()
+	}
+	return result, nil
+}
+
+// treeBrowser holds the state of a GetNodeTree call.
+type treeBrowser struct {
+	g    *OPCUAInput
+	opts BrowseOptions
+	root *Node
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	queue    []browseTask
+	inFlight int
+	progress BrowseProgress
+	errors   []*BrowseError
+}
+
+// work browses queued nodes until all nodes are browsed or the context is cancelled.
+func (b *treeBrowser) work(ctx context.Context) {
+	for {
+		b.mu.Lock()
+		for len(b.queue) == 0 && b.inFlight > 0 && ctx.Err() == nil {
+			b.cond.Wait()
+		}
+		if len(b.queue) == 0 || ctx.Err() != nil {
+			b.mu.Unlock()
+			return
+		}
+		task := b.queue[0]
+		b.queue = b.queue[1:]
+		b.inFlight++
+		b.mu.Unlock()
+
+		children, browseErr := b.browse(ctx, task)
+
+		b.mu.Lock()
+		b.inFlight--
+		if ctx.Err() != nil {
+			// The browse was interrupted, so the node is browsed again when resuming
+			b.queue = append(b.queue, task)
+			b.cond.Broadcast()
+			b.mu.Unlock()
+			return
+		}
+
+		var events []BrowseEvent
+		task.node.Children = append(task.node.Children, children...)
+		b.progress.Visited++
+		if task.depth > b.progress.Depth {
+			b.progress.Depth = task.depth
+		}
+		if browseErr != nil {
+			b.progress.Failed++
+			b.errors = append(b.errors, browseErr)
+		}
+		for _, child := range children {
+			b.progress.Discovered++
+			if task.depth+1 < b.opts.MaxDepth {
+				b.queue = append(b.queue, browseTask{node: child, depth: task.depth + 1})
+			}
+		}
+		b.progress.Queued = len(b.queue) + b.inFlight
+
+		if b.opts.Events != nil {
+			progress := b.progress
+			for _, child := range children {
+				events = append(events, BrowseEvent{Type: BrowseEventNode, Progress: progress, Node: &Node{NodeId: child.NodeId, Name: child.Name}, ParentNodeID: task.node.NodeId})
+			}
+			if browseErr != nil {
+				events = append(events, BrowseEvent{Type: BrowseEventError, Progress: progress, Node: &Node{NodeId: task.node.NodeId, Name: task.node.Name}, Error: browseErr})
+			}
+			events = append(events, BrowseEvent{Type: BrowseEventProgress, Progress: progress, Node: &Node{NodeId: task.node.NodeId, Name: task.node.Name}})
+		}
+		b.cond.Broadcast()
+		b.mu.Unlock()
+
+		for _, event := range events {
+			select {
+			case b.opts.Events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// browse returns the children of a node. Objects are browsed for components, organized nodes and notifiers,
+// variables for components. Children whose browse name cannot be read are skipped and reported as error.
+func (b *treeBrowser) browse(ctx context.Context, task browseTask) ([]*Node, *BrowseError) {
+	newError := func(operation BrowseOperation, err error) *BrowseError {
+		b.g.Log.Warnf("error browsing %s of node %s: %v", operation, task.node.NodeId, err)
+		return &BrowseError{NodeID: task.node.NodeId, Name: task.node.Name, Depth: task.depth, Operation: operation, Err: err}
+	}
+
+	node := b.g.Client.Node(task.node.NodeId)
+
+	nodeClass, err := node.NodeClass(ctx)
+	if err != nil {
+		return nil, newError(BrowseOperationNodeClass, err)
+	}
+
+	referenceTypes := task.referenceTypes
+	if referenceTypes == nil {
+		switch nodeClass {
+		case ua.NodeClassObject:
+			referenceTypes = []uint32{id.HasComponent, id.Organizes, id.HasNotifier}
+		case ua.NodeClassVariable:
+			referenceTypes = []uint32{id.HasComponent}
+		default:
+			return nil, nil
+		}
+	}
+
+	var children []*Node
+	var browseErr *BrowseError
+	seen := make(map[string]bool)
+	for _, referenceType := range referenceTypes {
+		refs, err := node.ReferencedNodes(ctx, referenceType, ua.BrowseDirectionForward, ua.NodeClassAll, true)
+		if err != nil {
+			return children, newError(BrowseOperationReferences, err)
+		}
+
+		for _, ref := range refs {
+			key := ref.ID.String()
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			browseName, err := b.g.Client.Node(ref.ID).BrowseName(ctx)
+			if err != nil {
+				browseErr = newError(BrowseOperationBrowseName, err)
+				continue
+			}
+			children = append(children, &Node{
+				NodeId:   ref.ID,
+				Name:     browseName.Name,
+				Children: make([]*Node, 0),
+			})
+		}
+	}
+	return children, browseErr
+}
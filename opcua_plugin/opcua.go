@@ -16,6 +16,8 @@ package opcua_plugin
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -28,6 +30,7 @@ import (
 
 const SessionTimeout = 5 * time.Second
 const SubscribeTimeoutContext = 3 * time.Second
+const DefaultPollRate = 1 * time.Second
 
 var OPCUAConfigSpec = service.NewConfigSpec().
 	Summary("Creates an input that reads data from OPC-UA servers. Created & maintained by the United Manufacturing Hub. About us: www.umh.app").
@@ -35,13 +38,53 @@ var OPCUAConfigSpec = service.NewConfigSpec().
 	Field(service.NewStringField("username").Description("Username for server access. If not set, no username is used.").Default("")).
 	Field(service.NewStringField("password").Description("Password for server access. If not set, no password is used.").Default("")).
 	Field(service.NewStringField("sessionTimeout").Description("The duration in milliseconds that a OPC UA session will last. Is used to ensure that older failed sessions will timeout and that we will not get a TooManySession error.").Default(10000)).
-	Field(service.NewStringListField("nodeIDs").Description("List of OPC-UA node IDs to begin browsing.")).
+	Field(service.NewStringListField("nodeIDs").Description("List of OPC-UA node IDs to begin browsing. Required unless nodes is set or discover is enabled.").Default([]any{})).
+	Field(service.NewObjectListField("nodes",
+		service.NewStringField("nodeID").Description("The NodeID of the node, e.g., 'ns=2;s=IoTSensors'. Either nodeID or browsePath must be set.").Default(""),
+		service.NewStringField("browsePath").Description("The path of browse names from the Objects folder to the node, e.g., '/2:Machine/2:Temperature'. Browse names without a namespace index are in namespace 0. The path is resolved on every connect.").Default(""),
+		service.NewStringField("tagName").Description("Overrides opcua_tag_name of the node itself. The nodes that are found by browsing it keep their names.").Default(""),
+		service.NewStringField("tagGroup").Description("Replaces the first element of opcua_tag_group, which is the name of the node, for the node and all nodes that are found by browsing it.").Default(""),
+		service.NewStringField("mode").Description("'subscribe' to monitor the nodes in a subscription or 'poll' to read them every pollRate milliseconds. Defaults to the mode selected by subscribeEnabled.").Default(""),
+		service.NewIntField("samplingInterval").Description("The sampling interval in milliseconds that is requested for the monitored items in subscribe mode. 0 requests the fastest rate of the server, -1 the publishing interval of the subscription. Defaults to 0.").Default(0),
+		service.NewFloatField("deadband").Description("An absolute deadband for numeric nodes in subscribe mode. Changes that are not greater than the deadband are not reported. Defaults to 0, which reports every change.").Default(0.0),
+		service.NewStringField("subscription").Description("The name of the entry in subscriptions in which the nodes are monitored in subscribe mode. Defaults to the first subscription with a matching pattern, or the default subscription.").Default(""),
+		service.NewBoolField("recursive").Description("Set to false to only read the node itself and its direct children instead of browsing the whole tree below it. Defaults to true.").Default(true),
+		service.NewStringMapField("metadata").Description("Static metadata that is added to the messages of the node and all nodes that are found by browsing it.").Default(map[string]any{}),
+	).Description("Per-node configuration as an alternative (or in addition) to nodeIDs. If a node is found by multiple entries, the first entry is used. Entries take precedence over nodeIDs.").Default([]any{})).
 	Field(service.NewStringField("securityMode").Description("Security mode to use. If not set, a reasonable security mode will be set depending on the discovered endpoints.").Default("")).
 	Field(service.NewStringField("securityPolicy").Description("The security policy to use.  If not set, a reasonable security policy will be set depending on the discovered endpoints.").Default("")).
 	Field(service.NewBoolField("insecure").Description("Set to true to bypass secure connections, useful in case of SSL or certificate issues. Default is secure (false).").Default(false)).
 	Field(service.NewBoolField("subscribeEnabled").Description("Set to true to subscribe to OPC UA nodes instead of fetching them every seconds. Default is pulling messages every second (false).").Default(false)).
 	Field(service.NewBoolField("directConnect").Description("Set this to true to directly connect to an OPC UA endpoint. This can be necessary in cases where the OPC UA server does not allow 'endpoint discovery'. This requires having the full endpoint name in endpoint, and securityMode and securityPolicy set. Defaults to 'false'").Default(false)).
-	Field(service.NewBoolField("useHeartbeat").Description("Set to true to provide an extra message with the servers timestamp as a heartbeat").Default(false))
+	Field(service.NewBoolField("useHeartbeat").Description("Set to true to provide an extra message with the servers timestamp as a heartbeat").Default(false)).
+	Field(service.NewIntField("pollRate").Description("The interval in milliseconds between two reads in pull mode (subscribeEnabled: false). The interval is measured from the start of one read to the start of the next one, so slow reads do not shift the schedule. Defaults to 1000.").Default(1000)).
+	Field(service.NewIntField("maxNodesPerRead").Description("The maximum number of nodes that are read within a single read request in pull mode. If set to 0, the MaxNodesPerRead operation limit of the server is used, or 100 if the server does not announce one. If both are set, the lower value is used.").Default(0)).
+	Field(service.NewIntField("readConcurrency").Description("The number of read requests that are sent in parallel in pull mode when the nodes have to be split into multiple requests. Defaults to 1.").Default(1)).
+	Field(service.NewIntField("heartbeatTimeout").Description("The duration in milliseconds after which the connection is closed and re-established if no heartbeat or no other message was received. Only used if useHeartbeat is enabled. Defaults to 10000.").Default(10000)).
+	Field(service.NewBoolField("emitConnectionState").Description("Set to true to emit a message whenever the connection to the OPC UA server is established or closed because of a heartbeat timeout, so that downstream systems can flag stale data. Defaults to 'false'").Default(false)).
+	Field(service.NewIntField("diagnosticsInterval").Description("The interval in milliseconds in which a diagnostics message with the ServerStatus and ServerDiagnosticsSummary of the server and the state of this client's session and subscriptions is emitted in the tag group 'diagnostics'. Set to 0 to disable. Defaults to 0.").Default(0)).
+	Field(service.NewObjectListField("triggerGroups",
+		service.NewStringField("name").Description("The name of the trigger group, which is used as opcua_tag_group. Defaults to the sanitized triggerNodeID.").Default(""),
+		service.NewStringField("triggerNodeID").Description("The NodeID of the trigger node, e.g., a 'part finished' flag."),
+		service.NewStringField("triggerValue").Description("If set, the nodes are only read when the trigger changes to this value (e.g., 'true'). If not set, every change of the trigger reads the nodes.").Default(""),
+		service.NewStringListField("nodeIDs").Description("The NodeIDs of the nodes that are read when the trigger fires."),
+	).Description("Groups of nodes that are read together whenever a trigger node changes and emitted as one combined JSON message with a shared timestamp. Requires subscribeEnabled.").Default([]any{}).Advanced()).
+	Field(service.NewObjectListField("subscriptions",
+		service.NewStringField("name").Description("The name of the subscription, which is added as opcua_subscription to its messages. The subscription named 'default' receives all subscribed nodes that are not assigned to another subscription, as well as trigger groups and attributes."),
+		service.NewIntField("publishingInterval").Description("The publishing interval of the subscription in milliseconds. Defaults to 100.").Default(100),
+		service.NewIntField("maxKeepAliveCount").Description("The number of publishing intervals without changes after which the server sends a keep-alive message. Defaults to 0, which uses the default of the client.").Default(0),
+		service.NewIntField("lifetimeCount").Description("The number of publishing intervals without a publish request after which the server deletes the subscription. Defaults to 0, which uses the default of the client.").Default(0),
+		service.NewIntField("priority").Description("The relative priority of the subscription (0-255). If several subscriptions have notifications, the server sends the ones of the subscription with the highest priority first. Defaults to 0.").Default(0),
+		service.NewStringListField("patterns").Description("Regular expressions that are matched against the NodeID (e.g., 'ns=2;s=Fast.Temperature') and the path (e.g., 'Machine.Fast.Temperature') of every subscribed node. Nodes are assigned to the first subscription with a matching pattern. Defaults to none.").Default([]any{}),
+	).Description("Additional subscriptions with their own publishing interval, keep-alive and priority, so that fast and slow nodes do not share one publishing interval. Nodes are assigned by patterns or by the subscription of their entry in nodes. All other nodes are monitored in the default subscription, whose parameters can be set by an entry named 'default'.").Default([]any{}).Advanced()).
+	Field(service.NewStringListField("attributes").Description("Attributes besides Value that are read for every node after browsing, e.g., ['DisplayName', 'Description', 'UserAccessLevel', 'MinimumSamplingInterval', 'Historizing']. They are emitted as one JSON attribute snapshot message per node with the metadata opcua_attributes_message. In subscribe mode, the attributes are also monitored and a new snapshot is emitted whenever one of them changes. Defaults to none.").Default([]any{}).Advanced()).
+	Field(service.NewBoolField("includeProperties").Description("Set to true to add the values of the properties of every node (HasProperty references, e.g., EngineeringUnits) to its attribute snapshot. Defaults to 'false'").Default(false).Advanced()).
+	Field(service.NewStringListField("locales").Description("The preferred locales of the session, e.g., ['de-DE', 'en-US']. The server returns localized attributes like DisplayName and Description in the first locale that it supports. Defaults to the default locale of the server.").Default([]any{}).Advanced()).
+	Field(service.NewStringField("pathRegistryFile").Description("Path to a JSON file in which the path (opcua_tag_group and opcua_tag_name) of every published node is stored. Nodes that are already in the file keep their path, even if other nodes with the same name appear later. If not set, the paths are only made unique within the current browse result.").Default("").Advanced()).
+	Field(service.NewBoolField("discover").Description("Set to true to run in discover mode: instead of reading data, the input queries the discovery URLs (or the endpoint) with FindServers and FindServersOnNetwork, emits a single JSON report with all found servers, their endpoints, security policies and user token types, and shuts down. Defaults to 'false'").Default(false)).
+	Field(service.NewStringListField("discoveryURLs").Description("List of discovery URLs, e.g., of Local Discovery Servers (opc.tcp://host:4840), that are queried in discover mode. Defaults to the endpoint.").Default([]any{})).
+	Field(service.NewStringEnumField("payloadFormat", PayloadFormatRaw, PayloadFormatJSON).Description("The format of the message payload. 'raw' writes the value as plain text. 'json' writes a JSON document with the value (keeping its native JSON type, 64-bit integers are encoded as strings), data type, source and server timestamps, status code and node identity. The metadata is the same for both formats. Defaults to 'raw'.").Default(PayloadFormatRaw)).
+	Field(service.NewBoolField("useRegisteredNodes").Description("Set to true to register all browsed nodes at the server (RegisterNodes service) and to read them by the returned handles in pull mode. This can significantly reduce the read latency for servers with many long string NodeIDs. Defaults to 'false'").Default(false))
 
 func ParseNodeIDs(incomingNodes []string) []*ua.NodeID {
 
@@ -117,8 +160,135 @@ func newOPCUAInput(conf *service.ParsedConfig, mgr *service.Resources) (service.
 		return nil, err
 	}
 
+	pollRate, err := conf.FieldInt("pollRate")
+	if err != nil {
+		return nil, err
+	}
+
+	maxNodesPerRead, err := conf.FieldInt("maxNodesPerRead")
+	if err != nil {
+		return nil, err
+	}
+
+	readConcurrency, err := conf.FieldInt("readConcurrency")
+	if err != nil {
+		return nil, err
+	}
+
+	useRegisteredNodes, err := conf.FieldBool("useRegisteredNodes")
+	if err != nil {
+		return nil, err
+	}
+
+	heartbeatTimeout, err := conf.FieldInt("heartbeatTimeout")
+	if err != nil {
+		return nil, err
+	}
+
+	emitConnectionState, err := conf.FieldBool("emitConnectionState")
+	if err != nil {
+		return nil, err
+	}
+
+	diagnosticsInterval, err := conf.FieldInt("diagnosticsInterval")
+	if err != nil {
+		return nil, err
+	}
+
+	nodeConfs, err := conf.FieldObjectList("nodes")
+	if err != nil {
+		return nil, err
+	}
+
+	nodeConfigs, err := ParseNodeConfigs(nodeConfs, subscribeEnabled)
+	if err != nil {
+		return nil, err
+	}
+
+	triggerGroupConfs, err := conf.FieldObjectList("triggerGroups")
+	if err != nil {
+		return nil, err
+	}
+
+	triggerGroups, err := ParseTriggerGroups(triggerGroupConfs)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(triggerGroups) > 0 && !subscribeEnabled {
+		return nil, errors.New("triggerGroups require subscribeEnabled to be true")
+	}
+
+	subscriptionConfs, err := conf.FieldObjectList("subscriptions")
+	if err != nil {
+		return nil, err
+	}
+
+	subscriptionConfigs, err := ParseSubscriptionConfigs(subscriptionConfs)
+	if err != nil {
+		return nil, err
+	}
+
+	subscriptionNames := make(map[string]bool)
+	for _, subscriptionConfig := range subscriptionConfigs {
+		subscriptionNames[subscriptionConfig.Name] = true
+	}
+	for _, nodeConfig := range nodeConfigs {
+		if nodeConfig.Subscription != "" && !subscriptionNames[nodeConfig.Subscription] {
+			return nil, fmt.Errorf("node %s: unknown subscription %q", nodeConfig, nodeConfig.Subscription)
+		}
+	}
+
+	attributeNames, err := conf.FieldStringList("attributes")
+	if err != nil {
+		return nil, err
+	}
+
+	attributes, err := ParseAttributes(attributeNames)
+	if err != nil {
+		return nil, err
+	}
+
+	includeProperties, err := conf.FieldBool("includeProperties")
+	if err != nil {
+		return nil, err
+	}
+
+	locales, err := conf.FieldStringList("locales")
+	if err != nil {
+		return nil, err
+	}
+
+	pathRegistryFile, err := conf.FieldString("pathRegistryFile")
+	if err != nil {
+		return nil, err
+	}
+
+	var pathRegistry *PathRegistry
+	if pathRegistryFile != "" {
+		pathRegistry, err = LoadPathRegistry(pathRegistryFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	discover, err := conf.FieldBool("discover")
+	if err != nil {
+		return nil, err
+	}
+
+	discoveryURLs, err := conf.FieldStringList("discoveryURLs")
+	if err != nil {
+		return nil, err
+	}
+
+	payloadFormat, err := conf.FieldString("payloadFormat")
+	if err != nil {
+		return nil, err
+	}
+
 	// fail if no nodeIDs are provided
-	if len(nodeIDs) == 0 {
+	if len(nodeIDs) == 0 && len(nodeConfigs) == 0 && !discover {
 		return nil, errors.New("no nodeIDs provided")
 	}
 
@@ -129,6 +299,7 @@ func newOPCUAInput(conf *service.ParsedConfig, mgr *service.Resources) (service.
 		Username:                     username,
 		Password:                     password,
 		NodeIDs:                      parsedNodeIDs,
+		NodeConfigs:                  nodeConfigs,
 		Log:                          mgr.Logger(),
 		SecurityMode:                 securityMode,
 		SecurityPolicy:               securityPolicy,
@@ -137,10 +308,26 @@ func newOPCUAInput(conf *service.ParsedConfig, mgr *service.Resources) (service.
 		SessionTimeout:               sessionTimeout,
 		DirectConnect:                directConnect,
 		UseHeartbeat:                 useHeartbeat,
-		LastHeartbeatMessageReceived: atomic.Uint32{},
-		LastMessageReceived:          atomic.Uint32{},
+		LastHeartbeatMessageReceived: atomic.Int64{},
+		LastMessageReceived:          atomic.Int64{},
 		HeartbeatManualSubscribed:    false,
 		HeartbeatNodeId:              ua.NewNumericNodeID(0, 2258), // 2258 is the nodeID for CurrentTime, only in tests this is different
+		PollRate:                     pollRate,
+		MaxNodesPerRead:              maxNodesPerRead,
+		ReadConcurrency:              readConcurrency,
+		UseRegisteredNodes:           useRegisteredNodes,
+		HeartbeatTimeout:             heartbeatTimeout,
+		EmitConnectionState:          emitConnectionState,
+		DiagnosticsInterval:          diagnosticsInterval,
+		TriggerGroups:                triggerGroups,
+		SubscriptionConfigs:          subscriptionConfigs,
+		Attributes:                   attributes,
+		IncludeProperties:            includeProperties,
+		Locales:                      locales,
+		PathRegistry:                 pathRegistry,
+		PayloadFormat:                payloadFormat,
+		DiscoverMode:                 discover,
+		DiscoveryURLs:                discoveryURLs,
 	}
 
 	return service.AutoRetryNacksBatched(m), nil
@@ -164,7 +351,11 @@ type OPCUAInput struct {
 	Username       string
 	Password       string
 	NodeIDs        []*ua.NodeID
+	NodeConfigs    []*NodeConfig
 	NodeList       []NodeDef
+	nodeMu         sync.RWMutex // guards NodeList and RegisteredNodeIDs, which are set by the browse goroutine
+	PathRegistry   *PathRegistry
+	PayloadFormat  string
 	SecurityMode   string
 	SecurityPolicy string
 	Insecure       bool
@@ -172,16 +363,48 @@ type OPCUAInput struct {
 	Log            *service.Logger
 	// this is required for subscription
 	SubscribeEnabled             bool
-	SubNotifyChan                chan *opcua.PublishNotificationData
+	SubNotifyChans               []SubscriptionChannel // one per subscription, by descending priority
+	TriggerGroups                []*TriggerGroup
+	setTriggeringUnsupported     bool // kept across reconnects, see linkTriggerGroup
 	SessionTimeout               int
 	DirectConnect                bool
 	UseHeartbeat                 bool
-	LastHeartbeatMessageReceived atomic.Uint32
-	LastMessageReceived          atomic.Uint32
+	LastHeartbeatMessageReceived atomic.Int64 // Unix time in milliseconds
+	LastMessageReceived          atomic.Int64 // Unix time in milliseconds
+	browseFailed                 atomic.Bool  // set by the browse goroutine, the connection is then closed by ReadBatch
 	HeartbeatManualSubscribed    bool
 	HeartbeatNodeId              *ua.NodeID
-	Subscription                 *opcua.Subscription
+	HeartbeatTimeout             int // in milliseconds
+	EmitConnectionState          bool
+	pendingConnectionState       string
+	SubscriptionConfigs          []*SubscriptionConfig
+	Subscriptions                map[string]*opcua.Subscription // by the name of their SubscriptionConfig
+	Subscription                 *opcua.Subscription            // the default subscription
 	ServerInfo                   ServerInfo
+	// this is required for diagnostics
+	DiagnosticsInterval int // in milliseconds
+	nextDiagnostics     time.Time
+	lastServerStartTime time.Time
+	// this is required for attribute snapshots
+	Attributes               []ua.AttributeID
+	IncludeProperties        bool
+	Locales                  []string
+	attributeMu              sync.Mutex
+	attributeSnapshots       []*attributeSnapshot
+	pendingAttributeMessages service.MessageBatch
+	// this is required for discover mode
+	DiscoverMode  bool
+	DiscoveryURLs []string
+	discoveryDone bool
+	// this is required for pull mode
+	PollRate        int // in milliseconds
+	MaxNodesPerRead int
+	ReadConcurrency int
+	OperationLimits OperationLimits
+	nextPoll        time.Time
+	// this is required for registered nodes
+	UseRegisteredNodes bool
+	RegisteredNodeIDs  []*ua.NodeID
 }
 
 // Connect establishes a connection to the OPC UA server.
@@ -196,6 +419,11 @@ func (g *OPCUAInput) Connect(ctx context.Context) (err error) {
 		return nil
 	}
 
+	// In discover mode, ReadBatch only connects to the discovery endpoints temporarily
+	if g.DiscoverMode {
+		return nil
+	}
+
 	defer func() {
 		if err != nil {
 			g.Log.Warnf("Connect failed with %v, waiting 5 seconds before retrying to prevent overloading the server", err)
@@ -210,6 +438,10 @@ func (g *OPCUAInput) Connect(ctx context.Context) (err error) {
 
 	g.Log.Infof("Connected to %s", g.Endpoint)
 
+	if g.EmitConnectionState {
+		g.pendingConnectionState = ConnectionStateConnected
+	}
+
 	// Get OPC UA server information
 	serverInfo, err := g.GetOPCUAServerInformation(ctx)
 	if err != nil {
@@ -219,20 +451,33 @@ func (g *OPCUAInput) Connect(ctx context.Context) (err error) {
 		g.ServerInfo = serverInfo
 	}
 
-	// Create a subscription channel if needed
-	if g.SubscribeEnabled {
-		g.SubNotifyChan = make(chan *opcua.PublishNotificationData, 10000)
+	// Get the operation limits of the server, so that reads and monitored item requests can be split accordingly
+	operationLimits, err := g.GetOperationLimits(ctx)
+	if err != nil {
+		g.Log.Infof("Failed to get OPC UA server operation limits, falling back to defaults: %s", err)
+		// Not being able to read the operation limits should not fail the connection
+		err = nil
+	} else {
+		g.Log.Infof("OPC UA Server operation limits: %+v", operationLimits)
+		g.OperationLimits = operationLimits
+	}
+
+	// Create the subscription channels if needed
+	if g.usesMode(NodeModeSubscribe) {
+		g.SubNotifyChans = NewSubscriptionChannels(g.subscriptionConfigs())
 	}
 	// Browse and subscribe to the nodes if needed
 	// Do this asynchronously so that the first messages can already arrive
+	// If this fails, the next ReadBatch closes the connection, as the client must not be closed while it is in use
+	g.browseFailed.Store(false)
 	go func() {
 		g.Log.Infof("Please note that browsing large node trees can take some time")
 		if err := g.BrowseAndSubscribeIfNeeded(ctx); err != nil {
 			g.Log.Errorf("Failed to subscribe: %v", err)
-			_ = g.Close(ctx)
+			g.browseFailed.Store(true)
 		}
 		// Set the heartbeat after browsing, as browsing might take some time
-		g.LastHeartbeatMessageReceived.Store(uint32(time.Now().Unix()))
+		g.LastHeartbeatMessageReceived.Store(time.Now().UnixMilli())
 	}()
 
 	return nil
@@ -241,47 +486,82 @@ func (g *OPCUAInput) Connect(ctx context.Context) (err error) {
 // ReadBatch retrieves a batch of messages from the OPC UA server.
 // It either subscribes to node updates or performs a pull-based read based on the configuration.
 // The function updates heartbeat information and monitors the connection's health.
-// If no messages or heartbeats are received within the expected timeframe, it closes the connection.
+// If no messages or heartbeats are received within the heartbeat timeout, it closes the connection.
 func (g *OPCUAInput) ReadBatch(ctx context.Context) (msgs service.MessageBatch, ackFunc service.AckFunc, err error) {
-	if len(g.NodeList) == 0 {
+	if g.DiscoverMode {
+		return g.ReadBatchDiscover(ctx)
+	}
+
+	if g.Client == nil {
+		return nil, nil, service.ErrNotConnected
+	}
+
+	if g.browseFailed.Load() {
+		_ = g.Close(ctx)
+		return nil, nil, service.ErrNotConnected
+	}
+
+	if nodeList, _ := g.nodes(); len(nodeList) == 0 {
 		g.Log.Debug("ReadBatch is called with empty nodelists. returning early from ReadBatch")
 		return nil, nil, nil
 	}
 
-	if g.SubscribeEnabled {
+	switch {
+	case g.usesMode(NodeModeSubscribe) && g.usesMode(NodeModePoll):
+		msgs, ackFunc, err = g.readBatchMixed(ctx)
+	case g.usesMode(NodeModeSubscribe):
 		// Wait for maximum 3 seconds for a response from the subscription channel
 		// So that this never gets stuck
 		ctxSubscribe, cancel := context.WithTimeout(ctx, SubscribeTimeoutContext)
 		defer cancel()
 
 		msgs, ackFunc, err = g.ReadBatchSubscribe(ctxSubscribe)
-	} else {
+	default:
 		msgs, ackFunc, err = g.ReadBatchPull(ctx)
 	}
 
 	// Heartbeat logic
 	msgs = g.updateHeartbeatInMessageBatch(msgs)
 
-	// if the last heartbeat message was received more than 10 seconds ago, close the connection
+	// if no heartbeat or no message was received within the heartbeat timeout, close the connection
 	// benthos will automatically reconnect
-	if g.UseHeartbeat && g.LastHeartbeatMessageReceived.Load() < uint32(time.Now().Unix()-10) && g.LastHeartbeatMessageReceived.Load() != 0 {
-		if g.LastMessageReceived.Load() < uint32(time.Now().Unix()-10) {
-			g.Log.Error("No messages received (including heartbeat) for over 10 seconds. Closing connection.")
-			_ = g.Close(ctx)
-			return nil, nil, service.ErrNotConnected
-		} else {
-			if g.ServerInfo.ManufacturerName == "Prosys OPC Ltd." {
-				g.Log.Info("No heartbeat message (ServerTime) received for over 10 seconds. This is normal for your Prosys OPC UA server. Other messages are being received; continuing operations. ")
-			} else {
-				g.Log.Warn("No heartbeat message (ServerTime) received for over 10 seconds. Other messages are being received; continuing operations.")
-			}
+	if reason := g.checkHeartbeatTimeout(time.Now()); reason != "" {
+		g.Log.Errorf("%s. Closing connection.", reason)
+		_ = g.Close(ctx)
+
+		// Let downstream systems know that the data is stale. The next call to ReadBatch will then
+		// return service.ErrNotConnected, as the client is closed.
+		if g.EmitConnectionState {
+			return service.MessageBatch{g.createConnectionStateMessage(ConnectionStateDisconnected, reason)}, func(ctx context.Context, err error) error {
+				// Nacks are retried automatically when we use service.AutoRetryNacks
+				return nil
+			}, nil
 		}
+		return nil, nil, service.ErrNotConnected
 	}
 
 	// If context deadline exceeded, print it as debug and ignore it. We don't want to show this to the user.
 	if err != nil && errors.Is(err, context.DeadlineExceeded) {
 		g.Log.Debugf("ReadBatch context.DeadlineExceeded")
-		return nil, nil, nil
+		msgs, ackFunc, err = nil, nil, nil
+	}
+
+	if err == nil {
+		// Prepend a pending connection state message, e.g., after a (re-)connect
+		msgs = g.prependConnectionStateMessage(msgs)
+
+		// Append the attribute snapshots that were read after browsing
+		msgs = g.appendPendingAttributeMessages(msgs)
+
+		// Append the periodic diagnostics message
+		msgs = g.appendDiagnosticsMessageIfDue(ctx, msgs)
+
+		if ackFunc == nil && len(msgs) > 0 {
+			ackFunc = func(ctx context.Context, err error) error {
+				// Nacks are retried automatically when we use service.AutoRetryNacks
+				return nil
+			}
+		}
 	}
 
 	return
@@ -292,13 +572,20 @@ func (g *OPCUAInput) ReadBatch(ctx context.Context) (msgs service.MessageBatch,
 // higher-level functions to manage logging based on context.
 func (g *OPCUAInput) closeRaw(ctx context.Context) {
 	if g.Client != nil {
-		// Unsubscribe from the subscription
-		if g.SubscribeEnabled && g.Subscription != nil {
-			g.Log.Infof("Unsubscribing from OPC UA subscription...")
-			if err := g.Subscription.Cancel(ctx); err != nil {
-				g.Log.Infof("Failed to unsubscribe from OPC UA subscription: %v", err)
-			}
-			g.Subscription = nil
+		// Unsubscribe from the subscriptions
+		if len(g.Subscriptions) > 0 {
+			g.Log.Infof("Unsubscribing from OPC UA subscriptions...")
+			g.cancelSubscriptions(ctx)
+		}
+
+		// Release the registered nodes, they are registered again after re-connecting
+		g.nodeMu.Lock()
+		registeredNodeIDs := g.RegisteredNodeIDs
+		g.RegisteredNodeIDs = nil
+		g.nodeMu.Unlock()
+		if len(registeredNodeIDs) > 0 {
+			g.Log.Infof("Unregistering %d nodes...", len(registeredNodeIDs))
+			g.UnregisterNodes(ctx, registeredNodeIDs)
 		}
 
 		// Attempt to close the OPC UA client
@@ -310,8 +597,8 @@ func (g *OPCUAInput) closeRaw(ctx context.Context) {
 	}
 
 	// Reset the heartbeat
-	g.LastHeartbeatMessageReceived.Store(uint32(0))
-	g.LastMessageReceived.Store(uint32(0))
+	g.LastHeartbeatMessageReceived.Store(0)
+	g.LastMessageReceived.Store(0)
 
 	return
 }
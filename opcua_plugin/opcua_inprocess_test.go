@@ -0,0 +1,900 @@
+package opcua_plugin_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/gopcua/opcua"
+	"github.com/gopcua/opcua/id"
+	"github.com/gopcua/opcua/ua"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/redpanda-data/benthos/v4/public/service"
+
+	. "github.com/united-manufacturing-hub/benthos-umh/opcua_plugin"
+)
+
+// These specs run the opcua input against the in-process test server, so unlike the PLC and simulator
+// tests they need no external OPC UA server.
+var _ = Describe("Test Against In-Process Test Server", func() {
+	var ctx context.Context
+	var cancel context.CancelFunc
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	Describe("Browsing", func() {
+		It("should read all scalar data types", func() {
+			server := startTestServer(testServerConfig{})
+
+			input := &OPCUAInput{
+				Endpoint: server.EndpointURL(),
+				NodeIDs:  []*ua.NodeID{testNodeID("Scalars")},
+			}
+			Expect(input.Connect(ctx)).To(Succeed())
+			defer input.Close(ctx)
+
+			msgs := readServerMessages(ctx, input, "Boolean", "SByte", "Byte", "Int16", "UInt16", "Int32", "UInt32",
+				"Int64", "UInt64", "Float", "Double", "String", "DateTime")
+
+			Expect(payload(msgs["Boolean"])).To(Equal("true"))
+			Expect(metadata(msgs["Boolean"], "opcua_tag_type")).To(Equal("bool"))
+			Expect(payload(msgs["SByte"])).To(Equal("-8"))
+			Expect(payload(msgs["UInt16"])).To(Equal("16"))
+			Expect(payload(msgs["Int32"])).To(Equal("-32"))
+			Expect(metadata(msgs["Int32"], "opcua_tag_type")).To(Equal("number"))
+			Expect(payload(msgs["UInt64"])).To(Equal("64"))
+			Expect(payload(msgs["Float"])).To(Equal("1.5"))
+			Expect(payload(msgs["Double"])).To(Equal("2.5"))
+			Expect(payload(msgs["String"])).To(Equal("hello"))
+			Expect(metadata(msgs["String"], "opcua_tag_type")).To(Equal("string"))
+			Expect(payload(msgs["DateTime"])).To(ContainSubstring("2024-01-01T12:00:00"))
+
+			for _, msg := range msgs {
+				Expect(metadata(msg, "opcua_tag_group")).To(Equal("Scalars"))
+			}
+		})
+
+		It("should read arrays and structures", func() {
+			server := startTestServer(testServerConfig{})
+
+			input := &OPCUAInput{
+				Endpoint: server.EndpointURL(),
+				NodeIDs:  []*ua.NodeID{testNodeID("Arrays"), testNodeID("Structures")},
+			}
+			Expect(input.Connect(ctx)).To(Succeed())
+			defer input.Close(ctx)
+
+			msgs := readServerMessages(ctx, input, "Int32Array", "DoubleArray", "StringArray", "BooleanArray", "Range")
+			Expect(payload(msgs["Int32Array"])).To(Equal("[1,2,3]"))
+			Expect(payload(msgs["DoubleArray"])).To(Equal("[1.5,2.5]"))
+			Expect(payload(msgs["StringArray"])).To(Equal(`["a","b"]`))
+			Expect(payload(msgs["BooleanArray"])).To(Equal("[true,false]"))
+			Expect(payload(msgs["Range"])).To(ContainSubstring(`"High":100`))
+		})
+
+		It("should give nodes with duplicate browse names unique tag names", func() {
+			server := startTestServer(testServerConfig{})
+
+			input := &OPCUAInput{
+				Endpoint: server.EndpointURL(),
+				NodeIDs:  []*ua.NodeID{testNodeID("Duplicates")},
+			}
+			Expect(input.Connect(ctx)).To(Succeed())
+			defer input.Close(ctx)
+
+			var msgs service.MessageBatch
+			Eventually(func() int {
+				batch, _, err := input.ReadBatch(ctx)
+				Expect(err).NotTo(HaveOccurred())
+				if len(batch) > 0 {
+					msgs = batch
+				}
+				return len(msgs)
+			}, 20*time.Second, 100*time.Millisecond).Should(Equal(4))
+
+			tags := map[string]string{}
+			for _, msg := range msgs {
+				tags[metadata(msg, "opcua_tag_group")+"."+metadata(msg, "opcua_tag_name")] = metadata(msg, "opcua_attr_nodeid")
+			}
+			Expect(tags).To(HaveLen(4))
+			Expect(tags).To(HaveKeyWithValue("Duplicates.MachineA.Temperature", testNodeID("Duplicates.MachineA.Temperature").String()))
+			Expect(tags).To(HaveKeyWithValue("Duplicates.MachineB.Temperature", testNodeID("Duplicates.MachineB.Temperature").String()))
+		})
+
+		It("should expose objects with event notifiers", func() {
+			server := startTestServer(testServerConfig{})
+
+			client, err := opcua.NewClient(server.EndpointURL(), opcua.SecurityMode(ua.MessageSecurityModeNone))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(client.Connect(ctx)).To(Succeed())
+			defer client.Close(ctx)
+
+			attrs, err := client.Node(testNodeID("Events.Alarms")).Attributes(ctx, ua.AttributeIDEventNotifier)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(attrs[0].Status).To(Equal(ua.StatusOK))
+			Expect(attrs[0].Value.Value()).To(Equal(byte(1)))
+
+			notifiers, err := client.Node(ua.NewNumericNodeID(0, id.Server)).ReferencedNodes(ctx, id.HasNotifier, ua.BrowseDirectionForward, ua.NodeClassObject, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(notifiers).To(HaveLen(1))
+			Expect(notifiers[0].ID.String()).To(Equal(testNodeID("Events.Alarms").String()))
+		})
+	})
+
+	Describe("GetNodeTree", func() {
+		// treeNames flattens a node tree into the paths of its nodes
+		var treeNames func(node *Node, path string, names map[string]bool)
+		treeNames = func(node *Node, path string, names map[string]bool) {
+			path += "/" + node.Name
+			names[path] = true
+			for _, child := range node.Children {
+				treeNames(child, path, names)
+			}
+		}
+
+		var countNodes func(node *Node) int
+		countNodes = func(node *Node) int {
+			n := 1
+			for _, child := range node.Children {
+				n += countNodes(child)
+			}
+			return n
+		}
+
+		newRoot := func() *Node {
+			return &Node{NodeId: ua.NewStringNodeID(ServerNamespaceIndex, "TestServer"), Name: "TestServer", Children: make([]*Node, 0)}
+		}
+
+		It("should report the progress and stream the discovered nodes", func() {
+			server := startTestServer(testServerConfig{})
+			input := &OPCUAInput{Endpoint: server.EndpointURL()}
+
+			events := make(chan BrowseEvent)
+			var received []BrowseEvent
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for event := range events {
+					received = append(received, event)
+				}
+			}()
+
+			result, err := input.GetNodeTree(ctx, newRoot(), BrowseOptions{Events: events})
+			Expect(err).NotTo(HaveOccurred())
+			<-done
+
+			Expect(result.Checkpoint).To(BeNil())
+			Expect(result.Errors).To(BeEmpty())
+			Expect(result.Progress.Failed).To(Equal(0))
+			Expect(result.Progress.Queued).To(Equal(0))
+			Expect(result.Progress.Visited).To(Equal(result.Progress.Discovered))
+			Expect(result.Progress.Depth).To(Equal(3))
+
+			Expect(countNodes(result.Root)).To(Equal(result.Progress.Discovered))
+			names := map[string]bool{}
+			treeNames(result.Root, "", names)
+			Expect(names).To(HaveKey("/TestServer/Scalars/Int32"))
+			Expect(names).To(HaveKey("/TestServer/Duplicates/MachineB/Temperature"))
+			Expect(names).To(HaveKey("/TestServer/Events/Alarms"))
+
+			var nodeEvents, progressEvents int
+			for _, event := range received {
+				switch event.Type {
+				case BrowseEventNode:
+					nodeEvents++
+					Expect(event.ParentNodeID).NotTo(BeNil())
+				case BrowseEventProgress:
+					progressEvents++
+				}
+			}
+			Expect(nodeEvents).To(Equal(result.Progress.Discovered - 1))
+			Expect(progressEvents).To(Equal(result.Progress.Visited))
+			Expect(received[len(received)-1].Progress).To(Equal(result.Progress))
+		})
+
+		It("should report nodes that cannot be browsed", func() {
+			server := startTestServer(testServerConfig{})
+			input := &OPCUAInput{Endpoint: server.EndpointURL()}
+
+			events := make(chan BrowseEvent, 100)
+			root := &Node{NodeId: testNodeID("Missing"), Name: "Missing"}
+			result, err := input.GetNodeTree(ctx, root, BrowseOptions{Events: events})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Progress.Failed).To(Equal(1))
+			Expect(result.Errors).To(HaveLen(1))
+			Expect(result.Errors[0].NodeID.String()).To(Equal(testNodeID("Missing").String()))
+			Expect(result.Errors[0].Operation).To(Equal(BrowseOperationNodeClass))
+
+			var errorEvents []BrowseEvent
+			for event := range events {
+				if event.Type == BrowseEventError {
+					errorEvents = append(errorEvents, event)
+				}
+			}
+			Expect(errorEvents).To(HaveLen(1))
+			Expect(errorEvents[0].Error).To(Equal(result.Errors[0]))
+		})
+
+		It("should resume a cancelled browse", func() {
+			server := startTestServer(testServerConfig{})
+
+			complete, err := (&OPCUAInput{Endpoint: server.EndpointURL()}).GetNodeTree(ctx, newRoot(), BrowseOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			expected := map[string]bool{}
+			treeNames(complete.Root, "", expected)
+
+			// Cancel the browse after the first node that was browsed
+			input := &OPCUAInput{Endpoint: server.EndpointURL()}
+			browseCtx, cancelBrowse := context.WithCancel(ctx)
+			events := make(chan BrowseEvent)
+			go func() {
+				for event := range events {
+					if event.Type == BrowseEventProgress {
+						cancelBrowse()
+					}
+				}
+			}()
+
+			partial, err := input.GetNodeTree(browseCtx, newRoot(), BrowseOptions{Events: events, Concurrency: 1})
+			Expect(err).To(MatchError(context.Canceled))
+			Expect(partial.Checkpoint).NotTo(BeNil())
+			Expect(partial.Checkpoint.Pending()).To(BeNumerically(">", 0))
+			Expect(partial.Progress.Visited).To(BeNumerically("<", complete.Progress.Visited))
+
+			resumed, err := input.GetNodeTree(ctx, nil, BrowseOptions{Resume: partial.Checkpoint})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resumed.Checkpoint).To(BeNil())
+			Expect(resumed.Progress).To(Equal(complete.Progress))
+
+			names := map[string]bool{}
+			treeNames(resumed.Root, "", names)
+			Expect(names).To(Equal(expected))
+			Expect(countNodes(resumed.Root)).To(Equal(complete.Progress.Discovered))
+		})
+	})
+
+	Describe("Subscriptions", func() {
+		It("should receive data changes", func() {
+			server := startTestServer(testServerConfig{})
+
+			input := &OPCUAInput{
+				Endpoint:         server.EndpointURL(),
+				NodeIDs:          []*ua.NodeID{testNodeID("Dynamic.Counter")},
+				SubscribeEnabled: true,
+			}
+			Expect(input.Connect(ctx)).To(Succeed())
+			defer input.Close(ctx)
+
+			msgs := readServerMessages(ctx, input, "Counter")
+			Expect(payload(msgs["Counter"])).To(Equal("0"))
+
+			server.setValue(testNodeID("Dynamic.Counter"), int32(1))
+			server.setValue(testNodeID("Dynamic.Counter"), int32(2))
+
+			var values []string
+			Eventually(func() []string {
+				batch, _, err := input.ReadBatch(ctx)
+				Expect(err).NotTo(HaveOccurred())
+				for _, msg := range batch {
+					values = append(values, payload(msg))
+				}
+				return values
+			}, 10*time.Second).Should(ContainElement("2"))
+		})
+
+		It("should send heartbeats from the CurrentTime node", func() {
+			server := startTestServer(testServerConfig{})
+
+			input := &OPCUAInput{
+				Endpoint:         server.EndpointURL(),
+				NodeIDs:          []*ua.NodeID{testNodeID("Dynamic.Counter")},
+				SubscribeEnabled: true,
+				UseHeartbeat:     true,
+				HeartbeatNodeId:  ua.NewNumericNodeID(0, id.Server_ServerStatus_CurrentTime),
+			}
+			Expect(input.Connect(ctx)).To(Succeed())
+			defer input.Close(ctx)
+
+			Eventually(func() bool {
+				batch, _, err := input.ReadBatch(ctx)
+				Expect(err).NotTo(HaveOccurred())
+				for _, msg := range batch {
+					if metadata(msg, "opcua_tag_group") == "heartbeat" && metadata(msg, "opcua_tag_name") == "CurrentTime" {
+						return true
+					}
+				}
+				return false
+			}, 20*time.Second, 100*time.Millisecond).Should(BeTrue())
+		})
+	})
+
+	Describe("Multiple subscriptions", func() {
+		It("should monitor nodes in the subscription of their pattern or entry", func() {
+			server := startTestServer(testServerConfig{})
+
+			input := &OPCUAInput{
+				Endpoint:         server.EndpointURL(),
+				NodeIDs:          []*ua.NodeID{testNodeID("Scalars.Double"), testNodeID("Scalars.Int32")},
+				NodeConfigs:      []*NodeConfig{{NodeID: testNodeID("Dynamic.Counter"), Mode: NodeModeSubscribe, Subscription: "fast"}},
+				SubscribeEnabled: true,
+				SubscriptionConfigs: []*SubscriptionConfig{
+					{Name: "fast", PublishingInterval: 50 * time.Millisecond, Priority: 200},
+					{Name: "slow", PublishingInterval: 500 * time.Millisecond, MaxKeepAliveCount: 5, Patterns: []*regexp.Regexp{regexp.MustCompile(`Scalars\.Double$`)}},
+					{Name: "unused", PublishingInterval: time.Second, Patterns: []*regexp.Regexp{regexp.MustCompile(`Unknown`)}},
+				},
+			}
+			Expect(input.Connect(ctx)).To(Succeed())
+			defer input.Close(ctx)
+
+			msgs := readServerMessages(ctx, input, "Counter", "Double", "Int32")
+			Expect(metadata(msgs["Counter"], "opcua_subscription")).To(Equal("fast"))
+			Expect(metadata(msgs["Double"], "opcua_subscription")).To(Equal("slow"))
+			Expect(metadata(msgs["Int32"], "opcua_subscription")).To(Equal(DefaultSubscriptionName))
+
+			// Subscriptions without nodes are not created
+			Expect(input.Subscriptions).To(HaveLen(3))
+			Expect(input.Subscriptions).To(HaveKey(DefaultSubscriptionName))
+			Expect(input.Subscriptions["slow"].RevisedPublishingInterval).To(Equal(500 * time.Millisecond))
+			Expect(input.Subscription).To(BeIdenticalTo(input.Subscriptions[DefaultSubscriptionName]))
+
+			server.setValue(testNodeID("Dynamic.Counter"), int32(5))
+			Eventually(func() string {
+				batch, _, err := input.ReadBatch(ctx)
+				Expect(err).NotTo(HaveOccurred())
+				for _, msg := range batch {
+					if payload(msg) == "5" {
+						return metadata(msg, "opcua_subscription")
+					}
+				}
+				return ""
+			}, 10*time.Second).Should(Equal("fast"))
+		})
+	})
+
+	Describe("Per-node configuration", func() {
+		It("should apply aliases and metadata and browse non-recursively", func() {
+			server := startTestServer(testServerConfig{})
+
+			input := &OPCUAInput{
+				Endpoint: server.EndpointURL(),
+				NodeConfigs: []*NodeConfig{
+					{
+						BrowsePath: []*ua.QualifiedName{{NamespaceIndex: 1, Name: "TestServer"}, {NamespaceIndex: 1, Name: "Duplicates"}},
+						TagGroup:   "line1",
+						Mode:       NodeModePoll,
+						Metadata:   map[string]string{"site": "aachen"},
+					},
+					{
+						NodeID:    testNodeID("Scalars.Int32"),
+						TagName:   "temperature",
+						TagGroup:  "machine",
+						Mode:      NodeModePoll,
+						Recursive: true,
+					},
+				},
+			}
+			Expect(input.Connect(ctx)).To(Succeed())
+			defer input.Close(ctx)
+
+			var msgs service.MessageBatch
+			Eventually(func() int {
+				batch, _, err := input.ReadBatch(ctx)
+				Expect(err).NotTo(HaveOccurred())
+				if len(batch) > 0 {
+					msgs = batch
+				}
+				return len(msgs)
+			}, 20*time.Second, 100*time.Millisecond).Should(Equal(3))
+
+			var speeds []string
+			for _, msg := range msgs {
+				switch metadata(msg, "opcua_attr_nodeid") {
+				case testNodeID("Scalars.Int32").String():
+					Expect(metadata(msg, "opcua_tag_group")).To(Equal("machine"))
+					Expect(metadata(msg, "opcua_tag_name")).To(Equal("temperature"))
+					Expect(payload(msg)).To(Equal("-32"))
+					Expect(metadata(msg, "site")).To(BeEmpty())
+				default:
+					// Only the direct children are read, not the temperatures of MachineA and MachineB
+					Expect(metadata(msg, "opcua_attr_browsename")).To(Equal("Speed"))
+					Expect(metadata(msg, "opcua_tag_group")).To(HavePrefix("line1"))
+					Expect(metadata(msg, "site")).To(Equal("aachen"))
+					speeds = append(speeds, metadata(msg, "opcua_tag_group")+"."+metadata(msg, "opcua_tag_name"))
+				}
+			}
+			Expect(speeds).To(HaveLen(2))
+			Expect(speeds[0]).NotTo(Equal(speeds[1]))
+		})
+
+		It("should fail to connect if a browse path does not exist", func() {
+			server := startTestServer(testServerConfig{})
+
+			input := &OPCUAInput{
+				Endpoint: server.EndpointURL(),
+				NodeConfigs: []*NodeConfig{
+					{BrowsePath: []*ua.QualifiedName{{NamespaceIndex: 1, Name: "TestServer"}, {NamespaceIndex: 1, Name: "Missing"}}, Mode: NodeModePoll},
+				},
+			}
+			Expect(input.Connect(ctx)).To(Succeed())
+			defer input.Close(ctx)
+
+			// Browsing fails in the background and closes the connection
+			Eventually(func() error {
+				_, _, err := input.ReadBatch(ctx)
+				return err
+			}, 10*time.Second, 100*time.Millisecond).Should(MatchError(service.ErrNotConnected))
+		})
+
+		It("should poll and subscribe nodes at the same time and apply the deadband", func() {
+			server := startTestServer(testServerConfig{})
+
+			input := &OPCUAInput{
+				Endpoint: server.EndpointURL(),
+				PollRate: 200,
+				NodeConfigs: []*NodeConfig{
+					{NodeID: testNodeID("Dynamic.Counter"), Mode: NodeModeSubscribe, SamplingInterval: 50, Deadband: 5},
+					{NodeID: testNodeID("Scalars.Double"), Mode: NodeModePoll},
+				},
+			}
+			Expect(input.Connect(ctx)).To(Succeed())
+			defer input.Close(ctx)
+
+			var counters []string
+			polls := 0
+			read := func() {
+				batch, _, err := input.ReadBatch(ctx)
+				Expect(err).NotTo(HaveOccurred())
+				for _, msg := range batch {
+					switch metadata(msg, "opcua_tag_name") {
+					case "Counter":
+						counters = append(counters, payload(msg))
+					case "Double":
+						polls++
+					}
+				}
+			}
+
+			Eventually(func() []string {
+				read()
+				return counters
+			}, 10*time.Second).Should(Equal([]string{"0"}))
+
+			server.setValue(testNodeID("Dynamic.Counter"), int32(3))
+			Consistently(func() []string {
+				read()
+				return counters
+			}, time.Second).Should(Equal([]string{"0"}))
+
+			server.setValue(testNodeID("Dynamic.Counter"), int32(10))
+			Eventually(func() []string {
+				read()
+				return counters
+			}, 10*time.Second).Should(Equal([]string{"0", "10"}))
+
+			// The polled node is read every pollRate, although its value does not change
+			Expect(polls).To(BeNumerically(">=", 3))
+		})
+	})
+
+	Describe("Registered nodes", func() {
+		var server *testServer
+		var input *OPCUAInput
+
+		BeforeEach(func() {
+			server = startTestServer(testServerConfig{})
+			input = &OPCUAInput{
+				Endpoint:           server.EndpointURL(),
+				NodeIDs:            []*ua.NodeID{testNodeID("Scalars.Int32"), testNodeID("Scalars.String")},
+				UseRegisteredNodes: true,
+			}
+		})
+
+		It("should read the registered nodes and unregister them when closing", func() {
+			Expect(input.Connect(ctx)).To(Succeed())
+			defer input.Close(ctx)
+
+			msgs := readServerMessages(ctx, input, "Int32", "String")
+			Expect(payload(msgs["Int32"])).To(Equal("-32"))
+			Expect(payload(msgs["String"])).To(Equal("hello"))
+			Expect(server.registeredNodes()).To(Equal(map[string]int{
+				testNodeID("Scalars.Int32").String():  1,
+				testNodeID("Scalars.String").String(): 1,
+			}))
+
+			Expect(input.Close(ctx)).To(Succeed())
+			Expect(server.registeredNodes()).To(BeEmpty())
+		})
+
+		It("should register the nodes again after reconnecting", func() {
+			Expect(input.Connect(ctx)).To(Succeed())
+			defer input.Close(ctx)
+			readServerMessages(ctx, input, "Int32", "String")
+
+			Expect(input.Close(ctx)).To(Succeed())
+			Expect(input.Connect(ctx)).To(Succeed())
+
+			msgs := readServerMessages(ctx, input, "Int32", "String")
+			Expect(payload(msgs["Int32"])).To(Equal("-32"))
+			Expect(server.registeredNodes()).To(Equal(map[string]int{
+				testNodeID("Scalars.Int32").String():  1,
+				testNodeID("Scalars.String").String(): 1,
+			}))
+		})
+	})
+
+	Describe("Attribute snapshots", func() {
+		// readSnapshots reads until a snapshot message of the node arrives and returns all snapshot messages of the node
+		readSnapshots := func(ctx context.Context, input *OPCUAInput, nodeID *ua.NodeID) []*service.Message {
+			var snapshots []*service.Message
+			Eventually(func() int {
+				batch, _, err := input.ReadBatch(ctx)
+				Expect(err).NotTo(HaveOccurred())
+				for _, msg := range batch {
+					if metadata(msg, "opcua_attributes_message") == "true" && metadata(msg, "opcua_attr_nodeid") == nodeID.String() {
+						snapshots = append(snapshots, msg)
+					}
+				}
+				return len(snapshots)
+			}, 10*time.Second, 100*time.Millisecond).ShouldNot(BeZero())
+			return snapshots
+		}
+
+		parseSnapshot := func(msg *service.Message) AttributeSnapshot {
+			var snapshot AttributeSnapshot
+			Expect(json.Unmarshal([]byte(payload(msg)), &snapshot)).To(Succeed())
+			return snapshot
+		}
+
+		It("should emit attributes and properties after browsing", func() {
+			server := startTestServer(testServerConfig{})
+
+			input := &OPCUAInput{
+				Endpoint:          server.EndpointURL(),
+				NodeIDs:           []*ua.NodeID{testNodeID("Scalars.Double")},
+				Attributes:        []ua.AttributeID{ua.AttributeIDDisplayName, ua.AttributeIDDescription, ua.AttributeIDUserAccessLevel, ua.AttributeIDMinimumSamplingInterval, ua.AttributeIDHistorizing},
+				IncludeProperties: true,
+				PollRate:          100,
+			}
+			Expect(input.Connect(ctx)).To(Succeed())
+			defer input.Close(ctx)
+
+			snapshots := readSnapshots(ctx, input, testNodeID("Scalars.Double"))
+			Expect(snapshots).To(HaveLen(1))
+			Expect(metadata(snapshots[0], "opcua_tag_name")).To(Equal("Double"))
+			Expect(metadata(snapshots[0], "opcua_attributes_changed")).To(BeEmpty())
+
+			snapshot := parseSnapshot(snapshots[0])
+			Expect(snapshot.NodeID).To(Equal(testNodeID("Scalars.Double").String()))
+			Expect(snapshot.Attributes).To(HaveKeyWithValue("DisplayName", HaveKeyWithValue("text", "Double")))
+			Expect(snapshot.Attributes).To(HaveKeyWithValue("Description", HaveKeyWithValue("text", "A double value")))
+			Expect(snapshot.Attributes).To(HaveKeyWithValue("UserAccessLevel", BeNumerically("==", ua.AccessLevelTypeCurrentRead)))
+			Expect(snapshot.Attributes).To(HaveKeyWithValue("MinimumSamplingInterval", BeNumerically("==", 0)))
+			Expect(snapshot.Attributes).To(HaveKeyWithValue("Historizing", false))
+			Expect(snapshot.Properties).To(Equal(map[string]any{"Unit": "mm/s"}))
+
+			// The values are still read and the snapshot is not repeated in pull mode
+			msgs := readServerMessages(ctx, input, "Double")
+			Expect(payload(msgs["Double"])).To(Equal("2.5"))
+			Consistently(func() string {
+				batch, _, err := input.ReadBatch(ctx)
+				Expect(err).NotTo(HaveOccurred())
+				for _, msg := range batch {
+					if metadata(msg, "opcua_attributes_message") == "true" {
+						return payload(msg)
+					}
+				}
+				return ""
+			}, time.Second).Should(BeEmpty())
+		})
+
+		It("should emit a new snapshot when a monitored attribute changes", func() {
+			server := startTestServer(testServerConfig{})
+
+			input := &OPCUAInput{
+				Endpoint:         server.EndpointURL(),
+				NodeIDs:          []*ua.NodeID{testNodeID("Dynamic.Counter")},
+				SubscribeEnabled: true,
+				Attributes:       []ua.AttributeID{ua.AttributeIDDisplayName, ua.AttributeIDDescription},
+			}
+			Expect(input.Connect(ctx)).To(Succeed())
+			defer input.Close(ctx)
+
+			snapshots := readSnapshots(ctx, input, testNodeID("Dynamic.Counter"))
+			Expect(parseSnapshot(snapshots[0]).Attributes).To(HaveKeyWithValue("DisplayName", HaveKeyWithValue("text", "Counter")))
+
+			Expect(server.AddressSpace().UpdateNode(testNodeID("Dynamic.Counter"), func(node *ServerNode) {
+				node.DisplayName = &ua.LocalizedText{EncodingMask: ua.LocalizedTextText | ua.LocalizedTextLocale, Locale: "de-DE", Text: "Zähler"}
+			})).To(Succeed())
+
+			var changed *service.Message
+			Eventually(func() *service.Message {
+				for _, msg := range readSnapshots(ctx, input, testNodeID("Dynamic.Counter")) {
+					if metadata(msg, "opcua_attributes_changed") == "DisplayName" {
+						changed = msg
+					}
+				}
+				return changed
+			}, 10*time.Second).ShouldNot(BeNil())
+
+			snapshot := parseSnapshot(changed)
+			Expect(snapshot.Attributes["DisplayName"]).To(Equal(map[string]any{"locale": "de-DE", "text": "Zähler"}))
+			Expect(snapshot.Attributes).To(HaveKey("Description"))
+		})
+	})
+
+	Describe("Trigger groups", func() {
+		var server *testServer
+		var input *OPCUAInput
+
+		BeforeEach(func() {
+			server = startTestServer(testServerConfig{})
+			input = &OPCUAInput{
+				Endpoint:         server.EndpointURL(),
+				NodeIDs:          []*ua.NodeID{testNodeID("Scalars.Double")},
+				SubscribeEnabled: true,
+				TriggerGroups: []*TriggerGroup{
+					{Name: "integers", TriggerNodeID: testNodeID("Dynamic.Counter"), NodeIDs: []*ua.NodeID{testNodeID("Scalars.Int32")}},
+					{Name: "strings", TriggerNodeID: testNodeID("Dynamic.Counter"), NodeIDs: []*ua.NodeID{testNodeID("Scalars.String")}},
+				},
+			}
+		})
+
+		// readTriggerGroups changes the trigger node until a message of each trigger group was read and
+		// returns the last message of each trigger group. The input is connected again if needed.
+		readTriggerGroups := func() map[string]*service.Message {
+			msgs := map[string]*service.Message{}
+			counter := int32(0)
+			Eventually(func() int {
+				counter++
+				server.setValue(testNodeID("Dynamic.Counter"), counter)
+
+				batch, _, err := input.ReadBatch(ctx)
+				if errors.Is(err, service.ErrNotConnected) {
+					Expect(input.Connect(ctx)).To(Succeed())
+					return len(msgs)
+				}
+				Expect(err).NotTo(HaveOccurred())
+				for _, msg := range batch {
+					group := metadata(msg, "opcua_trigger_group")
+					if group == "" {
+						// The items of trigger groups must not be mistaken for the nodes of the NodeList
+						Expect(metadata(msg, "opcua_tag_name")).To(Equal("Double"))
+						continue
+					}
+					msgs[group] = msg
+				}
+				return len(msgs)
+			}, 20*time.Second, 100*time.Millisecond).Should(Equal(2))
+			return msgs
+		}
+
+		triggerValues := func(msg *service.Message) map[string]any {
+			var values struct {
+				Trigger any            `json:"trigger"`
+				Values  map[string]any `json:"values"`
+			}
+			Expect(json.Unmarshal([]byte(payload(msg)), &values)).To(Succeed())
+			Expect(values.Trigger).NotTo(BeNil())
+			return values.Values
+		}
+
+		It("should link the nodes to the trigger with SetTriggering", func() {
+			Expect(input.Connect(ctx)).To(Succeed())
+			defer input.Close(ctx)
+			server.setValue(testNodeID("Scalars.Int32"), int32(7))
+
+			msgs := readTriggerGroups()
+			Expect(metadata(msgs["integers"], "opcua_trigger_mode")).To(Equal("set_triggering"))
+			Expect(metadata(msgs["integers"], "opcua_tag_name")).To(Equal("Counter"))
+			Expect(triggerValues(msgs["integers"])).To(Equal(map[string]any{"Int32": 7.0}))
+			Expect(metadata(msgs["strings"], "opcua_trigger_mode")).To(Equal("set_triggering"))
+			Expect(triggerValues(msgs["strings"])).To(Equal(map[string]any{"String": "hello"}))
+		})
+
+		It("should compare the trigger value independently of the payload format", func() {
+			input.PayloadFormat = PayloadFormatJSON
+			input.TriggerGroups = input.TriggerGroups[:1]
+			input.TriggerGroups[0].TriggerValue = "3"
+			Expect(input.Connect(ctx)).To(Succeed())
+			defer input.Close(ctx)
+
+			var triggers []any
+			counter := int32(0)
+			Eventually(func() []any {
+				counter++
+				server.setValue(testNodeID("Dynamic.Counter"), counter%5)
+
+				batch, _, err := input.ReadBatch(ctx)
+				Expect(err).NotTo(HaveOccurred())
+				for _, msg := range batch {
+					if metadata(msg, "opcua_trigger_group") == "" {
+						continue
+					}
+					var values struct {
+						Trigger any `json:"trigger"`
+					}
+					Expect(json.Unmarshal([]byte(payload(msg)), &values)).To(Succeed())
+					triggers = append(triggers, values.Trigger)
+				}
+				return triggers
+			}, 20*time.Second, 100*time.Millisecond).Should(HaveLen(2))
+			Expect(triggers).To(HaveEach(3.0))
+		})
+
+		It("should read the nodes if the server does not support SetTriggering", func() {
+			server.rejectSetTriggering(ua.StatusBadServiceUnsupported)
+
+			Expect(input.Connect(ctx)).To(Succeed())
+			defer input.Close(ctx)
+			server.setValue(testNodeID("Scalars.Int32"), int32(7))
+
+			msgs := readTriggerGroups()
+			Expect(metadata(msgs["integers"], "opcua_trigger_mode")).To(Equal("read"))
+			Expect(triggerValues(msgs["integers"])).To(Equal(map[string]any{"Int32": 7.0}))
+			Expect(metadata(msgs["strings"], "opcua_trigger_mode")).To(Equal("read"))
+			Expect(triggerValues(msgs["strings"])).To(Equal(map[string]any{"String": "hello"}))
+		})
+	})
+
+	Describe("Security", func() {
+		It("should log in with an encrypted password", func() {
+			server := startTestServer(testServerConfig{Username: "operator", Password: "secret"})
+
+			input := &OPCUAInput{
+				Endpoint: server.EndpointURL(),
+				NodeIDs:  []*ua.NodeID{testNodeID("Scalars.String")},
+				Username: "operator",
+				Password: "secret",
+			}
+			Expect(input.Connect(ctx)).To(Succeed())
+			defer input.Close(ctx)
+
+			msgs := readServerMessages(ctx, input, "String")
+			Expect(payload(msgs["String"])).To(Equal("hello"))
+		})
+
+		It("should fail with a wrong password", func() {
+			server := startTestServer(testServerConfig{Username: "operator", Password: "secret"})
+
+			input := &OPCUAInput{
+				Endpoint: server.EndpointURL(),
+				NodeIDs:  []*ua.NodeID{testNodeID("Scalars.String")},
+				Username: "operator",
+				Password: "wrong",
+			}
+			Expect(input.Connect(ctx)).NotTo(Succeed())
+		})
+
+		It("should reject replayed or missing chunks", func() {
+			Expect(CheckSequenceNumbers(51, 52, 53)).To(Succeed())
+			Expect(CheckSequenceNumbers(4294966272, 4294966273, 1, 2)).To(Succeed())
+			Expect(CheckSequenceNumbers(51, 52, 52)).To(MatchError(ua.StatusBadSequenceNumberInvalid))
+			Expect(CheckSequenceNumbers(51, 53)).To(MatchError(ua.StatusBadSequenceNumberInvalid))
+			Expect(CheckSequenceNumbers(100, 1)).To(MatchError(ua.StatusBadSequenceNumberInvalid))
+		})
+	})
+
+	Describe("Fault injection", func() {
+		It("should recover after the server dropped all sessions", func() {
+			server := startTestServer(testServerConfig{})
+
+			input := &OPCUAInput{
+				Endpoint:         server.EndpointURL(),
+				NodeIDs:          []*ua.NodeID{testNodeID("Dynamic.Counter")},
+				SubscribeEnabled: true,
+			}
+			Expect(input.Connect(ctx)).To(Succeed())
+			defer input.Close(ctx)
+
+			readServerMessages(ctx, input, "Counter")
+			Expect(server.SessionCount()).To(Equal(1))
+
+			server.DropSessions()
+			Expect(server.SessionCount()).To(Equal(0))
+
+			// Either the client reconnects by itself or the input reports that it needs to be connected again
+			Eventually(func() string {
+				server.setValue(testNodeID("Dynamic.Counter"), int32(42))
+
+				batch, _, err := input.ReadBatch(ctx)
+				if errors.Is(err, service.ErrNotConnected) {
+					Expect(input.Connect(ctx)).To(Succeed())
+					return ""
+				}
+				Expect(err).NotTo(HaveOccurred())
+				for _, msg := range batch {
+					if payload(msg) == "42" {
+						return payload(msg)
+					}
+				}
+				return ""
+			}, 20*time.Second, 100*time.Millisecond).Should(Equal("42"))
+			Expect(server.SessionCount()).To(Equal(1))
+		})
+
+		It("should recover if the server drops all sessions while chunks are read in parallel", func() {
+			server := startTestServer(testServerConfig{})
+
+			input := &OPCUAInput{
+				Endpoint: server.EndpointURL(),
+				NodeIDs: []*ua.NodeID{
+					testNodeID("Scalars.Int32"), testNodeID("Scalars.String"), testNodeID("Scalars.Double"),
+					testNodeID("Scalars.Boolean"), testNodeID("Dynamic.Counter"),
+				},
+				MaxNodesPerRead: 1,
+				ReadConcurrency: 4,
+			}
+			Expect(input.Connect(ctx)).To(Succeed())
+			defer input.Close(ctx)
+
+			readServerMessages(ctx, input, "Int32", "String", "Double", "Boolean", "Counter")
+			server.DropSessions()
+
+			// Either the client reconnects by itself or the input closes the connection once and reports it
+			Eventually(func() string {
+				server.setValue(testNodeID("Dynamic.Counter"), int32(42))
+
+				batch, _, err := input.ReadBatch(ctx)
+				if errors.Is(err, service.ErrNotConnected) {
+					Expect(input.Client).To(BeNil())
+					Expect(input.Connect(ctx)).To(Succeed())
+					return ""
+				}
+				Expect(err).NotTo(HaveOccurred())
+				for _, msg := range batch {
+					if metadata(msg, "opcua_tag_name") == "Counter" {
+						return payload(msg)
+					}
+				}
+				return ""
+			}, 20*time.Second, 100*time.Millisecond).Should(Equal("42"))
+		})
+
+		It("should skip nodes whose value cannot be read", func() {
+			server := startTestServer(testServerConfig{})
+
+			input := &OPCUAInput{
+				Endpoint: server.EndpointURL(),
+				NodeIDs:  []*ua.NodeID{testNodeID("Scalars.Int32"), testNodeID("Scalars.String")},
+			}
+			Expect(input.Connect(ctx)).To(Succeed())
+			defer input.Close(ctx)
+
+			Expect(server.AddressSpace().SetValue(testNodeID("Scalars.Int32"), &ua.DataValue{
+				EncodingMask: ua.DataValueValue | ua.DataValueStatusCode,
+				Value:        ua.MustVariant(int32(0)),
+				Status:       ua.StatusBadNotReadable,
+			})).To(Succeed())
+
+			msgs := readServerMessages(ctx, input, "String")
+			Expect(payload(msgs["String"])).To(Equal("hello"))
+		})
+
+		It("should disconnect if the server rejects a monitored item", func() {
+			server := startTestServer(testServerConfig{})
+			server.rejectMonitoredItems(ua.StatusBadTooManyMonitoredItems, testNodeID("Dynamic.Counter"))
+
+			input := &OPCUAInput{
+				Endpoint:         server.EndpointURL(),
+				NodeIDs:          []*ua.NodeID{testNodeID("Scalars.Int32"), testNodeID("Dynamic.Counter")},
+				SubscribeEnabled: true,
+			}
+			Expect(input.Connect(ctx)).To(Succeed())
+			defer input.Close(ctx)
+
+			Eventually(func() error {
+				_, _, err := input.ReadBatch(ctx)
+				return err
+			}, 20*time.Second, 100*time.Millisecond).Should(MatchError(service.ErrNotConnected))
+		})
+	})
+})
@@ -0,0 +1,243 @@
+package opcua_plugin_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+
+	"github.com/gopcua/opcua/ua"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/redpanda-data/benthos/v4/public/service"
+
+	. "github.com/united-manufacturing-hub/benthos-umh/opcua_plugin"
+)
+
+// encodeUADPNetworkMessage builds a UADP NetworkMessage from PublisherId 42 and WriterGroupId 100 with two DataSetMessages:
+// a key frame of DataSetWriter 1 with two Variant fields, and a delta frame of DataSetWriter 2 with one DataValue field.
+func encodeUADPNetworkMessage(timestamp time.Time) []byte {
+	keyFrame := ua.NewBuffer(nil)
+	keyFrame.WriteByte(0x09) // valid, Variant encoding, sequence number
+	keyFrame.WriteUint16(5)
+	keyFrame.WriteUint16(2)
+	keyFrame.WriteStruct(ua.MustVariant(23.5))
+	keyFrame.WriteStruct(ua.MustVariant(int32(7)))
+
+	deltaFrame := ua.NewBuffer(nil)
+	deltaFrame.WriteByte(0x85) // valid, DataValue encoding, DataSetFlags2
+	deltaFrame.WriteByte(0x11) // delta frame, timestamp
+	deltaFrame.WriteTime(timestamp)
+	deltaFrame.WriteUint16(1)
+	deltaFrame.WriteUint16(3)
+	deltaFrame.WriteStruct(&ua.DataValue{
+		EncodingMask:    ua.DataValueValue | ua.DataValueSourceTimestamp,
+		Value:           ua.MustVariant(true),
+		SourceTimestamp: timestamp.Add(-time.Second),
+	})
+
+	buf := ua.NewBuffer(nil)
+	buf.WriteByte(0xF1) // version 1, PublisherId, group header, payload header, ExtendedFlags1
+	buf.WriteByte(0x21) // UInt16 PublisherId, timestamp
+	buf.WriteUint16(42)
+	buf.WriteByte(0x09) // WriterGroupId, sequence number
+	buf.WriteUint16(100)
+	buf.WriteUint16(7)
+	buf.WriteByte(2)
+	buf.WriteUint16(1)
+	buf.WriteUint16(2)
+	buf.WriteTime(timestamp)
+	buf.WriteUint16(uint16(len(keyFrame.Bytes())))
+	buf.WriteUint16(uint16(len(deltaFrame.Bytes())))
+	buf.Write(keyFrame.Bytes())
+	buf.Write(deltaFrame.Bytes())
+	return buf.Bytes()
+}
+
+// metadata returns the value of a metadata key of a message.
+func metadata(msg *service.Message, key string) string {
+	value, _ := msg.MetaGet(key)
+	return value
+}
+
+var _ = Describe("OPC UA PubSub", func() {
+	timestamp := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	Describe("DecodeUADPNetworkMessage", func() {
+		It("should decode the headers and fields", func() {
+			msg, err := DecodeUADPNetworkMessage(encodeUADPNetworkMessage(timestamp))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(msg.PublisherID).To(Equal("42"))
+			Expect(msg.WriterGroupID).To(Equal(uint16(100)))
+			Expect(msg.SequenceNumber).To(Equal(uint16(7)))
+			Expect(msg.Timestamp).To(Equal(timestamp))
+			Expect(msg.DataSetMessages).To(HaveLen(2))
+
+			keyFrame := msg.DataSetMessages[0]
+			Expect(keyFrame.DataSetWriterID).To(Equal(uint16(1)))
+			Expect(keyFrame.MessageType).To(Equal(DataSetMessageTypeKeyFrame))
+			Expect(keyFrame.SequenceNumber).To(Equal(uint32(5)))
+			Expect(keyFrame.Fields).To(HaveLen(2))
+			Expect(keyFrame.Fields[0].Value).To(Equal(23.5))
+			Expect(keyFrame.Fields[0].DataType).To(Equal("Double"))
+			Expect(keyFrame.Fields[1].Index).To(Equal(1))
+			Expect(keyFrame.Fields[1].Value).To(Equal(int32(7)))
+
+			deltaFrame := msg.DataSetMessages[1]
+			Expect(deltaFrame.DataSetWriterID).To(Equal(uint16(2)))
+			Expect(deltaFrame.MessageType).To(Equal(DataSetMessageTypeDeltaFrame))
+			Expect(deltaFrame.Timestamp).To(Equal(timestamp))
+			Expect(deltaFrame.Fields).To(HaveLen(1))
+			Expect(deltaFrame.Fields[0].Index).To(Equal(3))
+			Expect(deltaFrame.Fields[0].Value).To(Equal(true))
+			Expect(deltaFrame.Fields[0].SourceTimestamp).To(Equal(timestamp.Add(-time.Second)))
+		})
+
+		It("should reject truncated NetworkMessages", func() {
+			b := encodeUADPNetworkMessage(timestamp)
+			_, err := DecodeUADPNetworkMessage(b[:len(b)-3])
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("DecodeJSONNetworkMessage", func() {
+		It("should decode plain, Variant and DataValue encoded fields in order", func() {
+			msg, err := DecodeJSONNetworkMessage([]byte(`{
+				"MessageId": "1",
+				"MessageType": "ua-data",
+				"PublisherId": "Line1",
+				"Messages": [{
+					"DataSetWriterId": 3,
+					"DataSetWriterName": "Press",
+					"SequenceNumber": 12,
+					"Timestamp": "2024-01-01T12:00:00Z",
+					"Payload": {
+						"Temperature": 23.5,
+						"Counter": {"Type": 8, "Body": "9007199254740993"},
+						"Running": {"Value": {"UaType": 1, "Value": true}, "SourceTimestamp": "2024-01-01T11:59:59Z"},
+						"Pressure": {"UaType": 11, "Value": 1.2, "StatusCode": {"Code": 2150891520}}
+					}
+				}]
+			}`))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(msg.PublisherID).To(Equal("Line1"))
+			Expect(msg.DataSetMessages).To(HaveLen(1))
+
+			dataSetMessage := msg.DataSetMessages[0]
+			Expect(dataSetMessage.DataSetWriterID).To(Equal(uint16(3)))
+			Expect(dataSetMessage.DataSetWriterName).To(Equal("Press"))
+			Expect(dataSetMessage.SequenceNumber).To(Equal(uint32(12)))
+			Expect(dataSetMessage.Timestamp).To(Equal(timestamp))
+			Expect(dataSetMessage.Fields).To(HaveLen(4))
+
+			Expect(dataSetMessage.Fields[0].Name).To(Equal("Temperature"))
+			Expect(dataSetMessage.Fields[0].Value).To(Equal(23.5))
+			Expect(dataSetMessage.Fields[1].Name).To(Equal("Counter"))
+			Expect(dataSetMessage.Fields[1].Value).To(Equal("9007199254740993"))
+			Expect(dataSetMessage.Fields[1].DataType).To(Equal("Int64"))
+			Expect(dataSetMessage.Fields[2].Value).To(Equal(true))
+			Expect(dataSetMessage.Fields[2].DataType).To(Equal("Boolean"))
+			Expect(dataSetMessage.Fields[2].SourceTimestamp).To(Equal(timestamp.Add(-time.Second)))
+			Expect(dataSetMessage.Fields[3].Index).To(Equal(3))
+			Expect(dataSetMessage.Fields[3].Status).To(Equal(ua.StatusBadNodeIDUnknown))
+		})
+
+		It("should decode a single DataSetMessage without NetworkMessage header", func() {
+			msg, err := DecodeJSONNetworkMessage([]byte(`{"DataSetWriterId": 1, "PublisherId": 7, "MessageType": "ua-keyframe", "Payload": {"Speed": 3}}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(msg.PublisherID).To(Equal("7"))
+			Expect(msg.DataSetMessages).To(HaveLen(1))
+			Expect(msg.DataSetMessages[0].Fields[0].Value).To(Equal(int64(3)))
+		})
+	})
+
+	Describe("CreateMessages", func() {
+		It("should filter DataSetWriters and map fields to the opcua metadata", func() {
+			msg, err := DecodeUADPNetworkMessage(encodeUADPNetworkMessage(timestamp))
+			Expect(err).NotTo(HaveOccurred())
+
+			input := &OPCUAPubSubInput{
+				PublisherID:   "42",
+				WriterGroupID: 100,
+				DataSetWriters: []PubSubDataSetWriter{
+					{DataSetWriterID: 1, Name: "Line1.Press", Fields: []string{"Temperature"}},
+				},
+			}
+
+			msgs := input.CreateMessages(msg, time.Now())
+			Expect(msgs).To(HaveLen(2))
+
+			b, err := msgs[0].AsBytes()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(b)).To(Equal("23.5"))
+			Expect(metadata(msgs[0], "opcua_tag_group")).To(Equal("Line1.Press"))
+			Expect(metadata(msgs[0], "opcua_tag_name")).To(Equal("Temperature"))
+			Expect(metadata(msgs[0], "opcua_tag_type")).To(Equal("number"))
+			Expect(metadata(msgs[0], "opcua_attr_datatype")).To(Equal("Double"))
+			Expect(metadata(msgs[0], "opcua_pubsub_dataset_writer_id")).To(Equal("1"))
+			Expect(metadata(msgs[0], "opcua_source_timestamp")).To(Equal("2024-01-01T12:00:00.000000Z"))
+			Expect(metadata(msgs[1], "opcua_tag_name")).To(Equal("field_1"))
+
+			input.PublisherID = "43"
+			Expect(input.CreateMessages(msg, time.Now())).To(BeEmpty())
+		})
+	})
+
+	It("should receive UADP NetworkMessages over UDP", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		input := &OPCUAPubSubInput{URL: "opc.udp://127.0.0.1:48401"}
+		Expect(input.Connect(ctx)).To(Succeed())
+		defer input.Close(ctx)
+
+		conn, err := net.Dial("udp", "127.0.0.1:48401")
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("not a NetworkMessage"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Write(encodeUADPNetworkMessage(timestamp))
+		Expect(err).NotTo(HaveOccurred())
+
+		msgs, _, err := input.ReadBatch(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(msgs).To(HaveLen(3))
+		Expect(metadata(msgs[0], "opcua_tag_group")).To(Equal("42.1"))
+		Expect(metadata(msgs[2], "opcua_tag_group")).To(Equal("42.2"))
+		Expect(metadata(msgs[2], "opcua_tag_name")).To(Equal("field_3"))
+		Expect(metadata(msgs[2], "opcua_pubsub_message_type")).To(Equal(DataSetMessageTypeDeltaFrame))
+	})
+
+	It("should stop connecting to an MQTT broker when the context is cancelled", func() {
+		// The broker accepts the connection, but answers the CONNECT packet only after Connect gave up
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer listener.Close()
+		accepted := make(chan net.Conn, 1)
+		go func() {
+			conn, err := listener.Accept()
+			if err == nil {
+				accepted <- conn
+			}
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+
+		input := &OPCUAPubSubInput{URL: "mqtt://" + listener.Addr().String(), Topic: "opcua/json"}
+		Expect(input.Connect(ctx)).To(MatchError(context.DeadlineExceeded))
+
+		var conn net.Conn
+		Eventually(accepted, 5*time.Second).Should(Receive(&conn))
+		defer conn.Close()
+
+		// CONNACK with the return code "accepted". The client must not be connected by it in the background.
+		_, _ = conn.Write([]byte{0x20, 0x02, 0x00, 0x00})
+		Expect(conn.SetReadDeadline(time.Now().Add(2 * time.Second))).To(Succeed())
+		_, err = io.Copy(io.Discard, conn)
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
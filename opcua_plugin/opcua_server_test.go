@@ -0,0 +1,383 @@
+package opcua_plugin_test
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"time"
+
+	"github.com/gopcua/opcua"
+	"github.com/gopcua/opcua/ua"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/redpanda-data/benthos/v4/public/service"
+
+	. "github.com/united-manufacturing-hub/benthos-umh/opcua_plugin"
+)
+
+// newServerOutput creates an opcua_server output on a random port with the default interpolations.
+func newServerOutput(username, password, writeBack string) *OPCUAServerOutput {
+	interpolate := func(expr string) *service.InterpolatedString {
+		s, err := service.NewInterpolatedString(expr)
+		Expect(err).NotTo(HaveOccurred())
+		return s
+	}
+
+	return &OPCUAServerOutput{
+		Config: OPCUAServerConfig{
+			Endpoint: "opc.tcp://127.0.0.1:0",
+			Username: username,
+			Password: password,
+		},
+		TagGroup:        interpolate(`${! @opcua_tag_group | "" }`),
+		TagName:         interpolate(`${! @opcua_tag_name | "" }`),
+		DataType:        interpolate(`${! @opcua_tag_datatype | "" }`),
+		SourceTimestamp: interpolate(`${! @timestamp_ms | "" }`),
+		WriteBack:       writeBack,
+	}
+}
+
+// newServerMessage creates a message for the opcua_server output.
+func newServerMessage(group, name, payload string) *service.Message {
+	msg := service.NewMessage([]byte(payload))
+	msg.MetaSet("opcua_tag_group", group)
+	msg.MetaSet("opcua_tag_name", name)
+	return msg
+}
+
+// readServerMessages reads from the opcua input until it received a message for each of the given tag names
+// and returns the last message of each tag name. The input browses its nodes in the background, so the first
+// reads can be empty.
+func readServerMessages(ctx context.Context, input *OPCUAInput, tagNames ...string) map[string]*service.Message {
+	msgs := map[string]*service.Message{}
+	Eventually(func() int {
+		batch, _, err := input.ReadBatch(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		for _, msg := range batch {
+			msgs[metadata(msg, "opcua_tag_name")] = msg
+		}
+		return len(msgs)
+	}, 20*time.Second, 100*time.Millisecond).Should(BeNumerically(">=", len(tagNames)))
+	Expect(msgs).To(HaveLen(len(tagNames)))
+	for _, tagName := range tagNames {
+		Expect(msgs).To(HaveKey(tagName))
+	}
+	return msgs
+}
+
+// payload returns the payload of a message as string.
+func payload(msg *service.Message) string {
+	b, err := msg.AsBytes()
+	Expect(err).NotTo(HaveOccurred())
+	return string(b)
+}
+
+var _ = Describe("OPC UA Server", func() {
+	Describe("ParseServerDataType", func() {
+		It("should derive the data type from the payload", func() {
+			for payload, expected := range map[string]ua.TypeID{
+				"true":   ua.TypeIDBoolean,
+				"-12.5":  ua.TypeIDDouble,
+				"42":     ua.TypeIDDouble,
+				"ready":  ua.TypeIDString,
+				"":       ua.TypeIDString,
+				"1e3":    ua.TypeIDDouble,
+				"FALSE!": ua.TypeIDString,
+			} {
+				typeID, err := ParseServerDataType("", []byte(payload))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(typeID).To(Equal(expected), payload)
+			}
+		})
+
+		It("should accept OPC UA and Go type names", func() {
+			for name, expected := range map[string]ua.TypeID{
+				"Double":    ua.TypeIDDouble,
+				"float32":   ua.TypeIDFloat,
+				"Int32":     ua.TypeIDInt32,
+				"uint16":    ua.TypeIDUint16,
+				"bool":      ua.TypeIDBoolean,
+				"DateTime":  ua.TypeIDDateTime,
+				"time.Time": ua.TypeIDDateTime,
+			} {
+				typeID, err := ParseServerDataType(name, []byte("1"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(typeID).To(Equal(expected), name)
+			}
+
+			_, err := ParseServerDataType("Decimal", []byte("1"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("ConvertServerValue", func() {
+		It("should convert payloads to the data type", func() {
+			v, err := ConvertServerValue([]byte("-7"), ua.TypeIDInt16)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(v.Value()).To(Equal(int16(-7)))
+
+			v, err = ConvertServerValue([]byte("1.5"), ua.TypeIDFloat)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(v.Value()).To(Equal(float32(1.5)))
+
+			v, err = ConvertServerValue([]byte("1704110400000"), ua.TypeIDDateTime)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(v.Value()).To(Equal(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)))
+
+			v, err = ConvertServerValue([]byte(" text "), ua.TypeIDString)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(v.Value()).To(Equal(" text "))
+		})
+
+		It("should reject values that do not fit the data type", func() {
+			_, err := ConvertServerValue([]byte("300"), ua.TypeIDByte)
+			Expect(err).To(HaveOccurred())
+
+			_, err = ConvertServerValue([]byte("on"), ua.TypeIDBoolean)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("ParseServerTimestamp", func() {
+		It("should parse RFC3339 and unix milliseconds", func() {
+			expected := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+			t, err := ParseServerTimestamp("2024-01-01T12:00:00Z", time.Time{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(t).To(Equal(expected))
+
+			t, err = ParseServerTimestamp("1704110400000", time.Time{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(t).To(Equal(expected))
+
+			t, err = ParseServerTimestamp("", expected)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(t).To(Equal(expected))
+
+			_, err = ParseServerTimestamp("yesterday", time.Time{})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("opcua_server output", func() {
+		var ctx context.Context
+		var cancel context.CancelFunc
+
+		BeforeEach(func() {
+			ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		})
+
+		AfterEach(func() {
+			cancel()
+		})
+
+		It("should serve the values to the opcua input", func() {
+			output := newServerOutput("", "", "")
+			Expect(output.Connect(ctx)).To(Succeed())
+			defer output.Close(ctx)
+
+			msg := newServerMessage("Plant.Line1", "Temperature", "23.5")
+			msg.MetaSet("timestamp_ms", "1704110400000")
+			Expect(output.Write(ctx, msg)).To(Succeed())
+
+			counter := newServerMessage("Plant.Line1", "Counter", "7")
+			counter.MetaSet("opcua_tag_datatype", "Int32")
+			Expect(output.Write(ctx, counter)).To(Succeed())
+
+			// Not an Int32, so the value is skipped
+			Expect(output.Write(ctx, newServerMessage("Plant.Line1", "Counter", "seven"))).To(Succeed())
+
+			// Browsing the folder of the group returns the same tag group and tag names again
+			input := &OPCUAInput{
+				Endpoint:         output.EndpointURL(),
+				NodeIDs:          []*ua.NodeID{ua.NewStringNodeID(ServerNamespaceIndex, "Plant")},
+				SubscribeEnabled: true,
+			}
+			Expect(input.Connect(ctx)).To(Succeed())
+			defer input.Close(ctx)
+
+			msgs := readServerMessages(ctx, input, "Temperature", "Counter")
+			Expect(payload(msgs["Temperature"])).To(Equal("23.5"))
+			Expect(metadata(msgs["Temperature"], "opcua_tag_group")).To(Equal("Plant.Line1"))
+			Expect(metadata(msgs["Temperature"], "opcua_source_timestamp")).To(Equal("2024-01-01T12:00:00.000000Z"))
+			Expect(payload(msgs["Counter"])).To(Equal("7"))
+			Expect(metadata(msgs["Counter"], "opcua_attr_datatype")).To(Equal("int32"))
+
+			Expect(output.Write(ctx, newServerMessage("Plant.Line1", "Temperature", "24"))).To(Succeed())
+
+			Eventually(func() string {
+				msgs, _, err := input.ReadBatch(ctx)
+				Expect(err).NotTo(HaveOccurred())
+				for _, msg := range msgs {
+					if metadata(msg, "opcua_tag_name") == "Temperature" {
+						return payload(msg)
+					}
+				}
+				return ""
+			}, 10*time.Second).Should(Equal("24"))
+		})
+
+		It("should authenticate users with encrypted passwords", func() {
+			output := newServerOutput("operator", "secret", "")
+			Expect(output.Connect(ctx)).To(Succeed())
+			defer output.Close(ctx)
+
+			Expect(output.Write(ctx, newServerMessage("", "Running", "true"))).To(Succeed())
+
+			input := &OPCUAInput{
+				Endpoint: output.EndpointURL(),
+				NodeIDs:  []*ua.NodeID{ServerTagNodeID("", "Running")},
+				Username: "operator",
+				Password: "secret",
+			}
+			Expect(input.Connect(ctx)).To(Succeed())
+			defer input.Close(ctx)
+
+			msgs := readServerMessages(ctx, input, "Running")
+			Expect(payload(msgs["Running"])).To(Equal("true"))
+
+			wrongPassword := &OPCUAInput{
+				Endpoint:       output.EndpointURL(),
+				NodeIDs:        []*ua.NodeID{ServerTagNodeID("", "Running")},
+				Username:       "operator",
+				Password:       "wrong",
+				SecurityMode:   "None",
+				SecurityPolicy: "None",
+			}
+			Expect(wrongPassword.Connect(ctx)).NotTo(Succeed())
+
+			// Without the policy of the token, the client sends the password in plaintext
+			plaintext, err := opcua.NewClient(output.EndpointURL(),
+				opcua.SecurityMode(ua.MessageSecurityModeNone),
+				opcua.AuthUsername("operator", "secret"),
+			)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(plaintext.Connect(ctx)).To(MatchError(ua.StatusBadIdentityTokenInvalid))
+		})
+
+		It("should only offer the security policy None", func() {
+			output := newServerOutput("operator", "secret", "")
+			Expect(output.Connect(ctx)).To(Succeed())
+			defer output.Close(ctx)
+
+			endpoints, err := opcua.GetEndpoints(ctx, output.EndpointURL())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(endpoints).To(HaveLen(1))
+			Expect(endpoints[0].SecurityPolicyURI).To(Equal(ua.SecurityPolicyURINone))
+			Expect(endpoints[0].UserIdentityTokens[0].SecurityPolicyURI).To(Equal(ua.SecurityPolicyURIBasic256Sha256))
+
+			cert, key, err := GenerateCert("client", 2048, time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+			pair, err := tls.X509KeyPair(cert, key)
+			Expect(err).NotTo(HaveOccurred())
+			client, err := opcua.NewClient(output.EndpointURL(),
+				opcua.SecurityPolicy(ua.SecurityPolicyURIBasic256Sha256),
+				opcua.SecurityMode(ua.MessageSecurityModeSignAndEncrypt),
+				opcua.RemoteCertificate(endpoints[0].ServerCertificate),
+				opcua.PrivateKey(pair.PrivateKey.(*rsa.PrivateKey)),
+				opcua.Certificate(pair.Certificate[0]),
+			)
+			Expect(err).NotTo(HaveOccurred())
+			// The server answers with BadSecurityPolicyRejected, which the client only reports as a closed connection
+			Expect(client.Connect(ctx)).NotTo(Succeed())
+		})
+
+		It("should emit client writes on the opcua_server_writes input", func() {
+			output := newServerOutput("", "", "server-test")
+			output.Config.AllowAnonymousWrites = true
+			Expect(output.Connect(ctx)).To(Succeed())
+			defer output.Close(ctx)
+
+			Expect(output.Write(ctx, newServerMessage("Line1", "Setpoint", "10"))).To(Succeed())
+
+			writes := &OPCUAServerWritesInput{WriteBack: "server-test"}
+			Expect(writes.Connect(ctx)).To(Succeed())
+			defer writes.Close(ctx)
+
+			client, err := opcua.NewClient(output.EndpointURL(), opcua.SecurityMode(ua.MessageSecurityModeNone))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(client.Connect(ctx)).To(Succeed())
+			defer client.Close(ctx)
+
+			resp, err := client.Write(ctx, &ua.WriteRequest{
+				NodesToWrite: []*ua.WriteValue{
+					{
+						NodeID:      ServerTagNodeID("Line1", "Setpoint"),
+						AttributeID: ua.AttributeIDValue,
+						Value:       &ua.DataValue{EncodingMask: ua.DataValueValue, Value: ua.MustVariant(12.5)},
+					},
+					{
+						NodeID:      ServerTagNodeID("Line1", "Setpoint"),
+						AttributeID: ua.AttributeIDValue,
+						Value:       &ua.DataValue{EncodingMask: ua.DataValueValue, Value: ua.MustVariant("twelve")},
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Results).To(Equal([]ua.StatusCode{ua.StatusOK, ua.StatusBadTypeMismatch}))
+
+			msgs, _, err := writes.ReadBatch(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(msgs).To(HaveLen(1))
+			b, err := msgs[0].AsBytes()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(b)).To(Equal("12.5"))
+			Expect(metadata(msgs[0], "opcua_tag_group")).To(Equal("Line1"))
+			Expect(metadata(msgs[0], "opcua_tag_name")).To(Equal("Setpoint"))
+			Expect(metadata(msgs[0], "opcua_attr_datatype")).To(Equal("Double"))
+
+			// The written value is visible to other clients
+			dv, err := client.Read(ctx, &ua.ReadRequest{NodesToRead: []*ua.ReadValueID{
+				{NodeID: ServerTagNodeID("Line1", "Setpoint"), AttributeID: ua.AttributeIDValue},
+			}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dv.Results[0].Value.Value()).To(Equal(12.5))
+		})
+
+		It("should reject anonymous client writes unless allowAnonymousWrites is set", func() {
+			output := newServerOutput("", "", "server-anonymous")
+			Expect(output.Connect(ctx)).To(Succeed())
+			defer output.Close(ctx)
+
+			Expect(output.Write(ctx, newServerMessage("Line1", "Setpoint", "10"))).To(Succeed())
+
+			client, err := opcua.NewClient(output.EndpointURL(), opcua.SecurityMode(ua.MessageSecurityModeNone))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(client.Connect(ctx)).To(Succeed())
+			defer client.Close(ctx)
+
+			resp, err := client.Write(ctx, &ua.WriteRequest{
+				NodesToWrite: []*ua.WriteValue{{
+					NodeID:      ServerTagNodeID("Line1", "Setpoint"),
+					AttributeID: ua.AttributeIDValue,
+					Value:       &ua.DataValue{EncodingMask: ua.DataValueValue, Value: ua.MustVariant(12.5)},
+				}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Results).To(Equal([]ua.StatusCode{ua.StatusBadUserAccessDenied}))
+		})
+
+		It("should reject client writes without writeBack", func() {
+			output := newServerOutput("", "", "")
+			Expect(output.Connect(ctx)).To(Succeed())
+			defer output.Close(ctx)
+
+			Expect(output.Write(ctx, newServerMessage("Line1", "Setpoint", "10"))).To(Succeed())
+
+			client, err := opcua.NewClient(output.EndpointURL(), opcua.SecurityMode(ua.MessageSecurityModeNone))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(client.Connect(ctx)).To(Succeed())
+			defer client.Close(ctx)
+
+			resp, err := client.Write(ctx, &ua.WriteRequest{
+				NodesToWrite: []*ua.WriteValue{{
+					NodeID:      ServerTagNodeID("Line1", "Setpoint"),
+					AttributeID: ua.AttributeIDValue,
+					Value:       &ua.DataValue{EncodingMask: ua.DataValueValue, Value: ua.MustVariant(12.5)},
+				}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Results).To(Equal([]ua.StatusCode{ua.StatusBadNotWritable}))
+		})
+	})
+})
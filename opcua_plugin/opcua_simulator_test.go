@@ -1181,6 +1181,56 @@ var _ = Describe("Test Against Microsoft OPC UA simulator", Serial, func() {
 		})
 	})
 
+	Describe("diagnostics", func() {
+		It("should emit a diagnostics message with the server status", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			var nodeIDStrings = []string{"ns=3;s=Basic"}
+
+			parsedNodeIDs := ParseNodeIDs(nodeIDStrings)
+
+			input := &OPCUAInput{
+				Endpoint:            "opc.tcp://localhost:50000",
+				Username:            "",
+				Password:            "",
+				NodeIDs:             parsedNodeIDs,
+				SubscribeEnabled:    false,
+				DiagnosticsInterval: 1000,
+			}
+
+			// Attempt to connect
+			err := input.Connect(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			messageBatch, _, err := input.ReadBatch(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			// The 4 values and the diagnostics message
+			Expect(messageBatch).To(HaveLen(5))
+
+			diagnosticsMessage := messageBatch[len(messageBatch)-1]
+			tagGroup, _ := diagnosticsMessage.MetaGet("opcua_tag_group")
+			Expect(tagGroup).To(Equal("diagnostics"))
+
+			messageBytes, err := diagnosticsMessage.AsBytes()
+			Expect(err).NotTo(HaveOccurred())
+
+			var diagnostics ServerDiagnostics
+			err = json.Unmarshal(messageBytes, &diagnostics)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(diagnostics.State).To(Equal("Running"))
+			Expect(diagnostics.StartTime).NotTo(BeZero())
+			Expect(diagnostics.ServerRestarted).To(BeFalse())
+
+			// Close connection
+			if input.Client != nil {
+				err = input.Close(ctx)
+				Expect(err).NotTo(HaveOccurred())
+			}
+		})
+	})
+
 	When("Subscribing to slow values", func() {
 		It("keeps sending data at least once every 10 seconds", func() {
 			Skip("slow test")
@@ -1394,6 +1444,60 @@ var _ = Describe("Test Against Microsoft OPC UA simulator", Serial, func() {
 				Expect(err).NotTo(HaveOccurred())
 			}
 		})
+
+		It("emits a connection state message before disconnecting if the heartbeat does not come in regular intervals", func() {
+
+			var nodeIDStrings []string
+			parsedNodeIDs := ParseNodeIDs(nodeIDStrings)
+
+			input := &OPCUAInput{
+				Endpoint:            "opc.tcp://localhost:50000",
+				Username:            "",
+				Password:            "",
+				NodeIDs:             parsedNodeIDs,
+				SubscribeEnabled:    true,
+				UseHeartbeat:        true,
+				HeartbeatNodeId:     ua.NewNumericNodeID(0, 2259), // 2259 is State, which will not change
+				HeartbeatTimeout:    5000,
+				EmitConnectionState: true,
+			}
+
+			// Attempt to connect
+			ctx1, cancel1 := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel1()
+			err := input.Connect(ctx1)
+			Expect(err).NotTo(HaveOccurred())
+
+			ctx2, cancel2 := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel2()
+			messageBatch, _, err := input.ReadBatch(ctx2)
+			Expect(err).NotTo(HaveOccurred())
+
+			// The connection state message and the initial value of the heartbeat node
+			Expect(len(messageBatch)).To(Equal(2))
+			messageBytes, err := messageBatch[0].AsBytes()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(messageBytes)).To(Equal(ConnectionStateConnected))
+
+			time.Sleep(7 * time.Second)
+
+			ctx3, cancel3 := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel3()
+			messageBatch, _, err = input.ReadBatch(ctx3)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(len(messageBatch)).To(Equal(1))
+			messageBytes, err = messageBatch[0].AsBytes()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(messageBytes)).To(Equal(ConnectionStateDisconnected))
+			tagGroup, _ := messageBatch[0].MetaGet("opcua_tag_group")
+			Expect(tagGroup).To(Equal("connection"))
+
+			ctx4, cancel4 := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel4()
+			_, _, err = input.ReadBatch(ctx4)
+			Expect(err).To(Equal(service.ErrNotConnected))
+		})
 	})
 })
 
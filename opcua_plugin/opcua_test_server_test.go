@@ -0,0 +1,237 @@
+package opcua_plugin_test
+
+import (
+	"crypto/rsa"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopcua/opcua/id"
+	"github.com/gopcua/opcua/ua"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/united-manufacturing-hub/benthos-umh/opcua_plugin"
+)
+
+// testServerTime is the value of the DateTime variables of the test server.
+var testServerTime = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// The certificate of the test server is created once, as creating RSA keys is slow.
+var (
+	testServerCertOnce sync.Once
+	testServerCert     []byte
+	testServerKey      *rsa.PrivateKey
+)
+
+// testServerConfig configures a testServer. The zero value starts a server with anonymous access.
+type testServerConfig struct {
+	Username string
+	Password string
+	Limits   OperationLimits
+}
+
+// testServer is an OPC UA server with a known address space that runs inside the test process,
+// so that the opcua input can be tested without a PLC or simulator. All its nodes are in namespace 1
+// below the folder TestServer:
+//
+//	Scalars     one variable per builtin data type, e.g., Int32 = -32 and String = "hello",
+//	            Double has a description and the property Unit = "mm/s"
+//	Arrays      Int32Array, DoubleArray, StringArray and BooleanArray
+//	Structures  Range, a structure of the data type Range
+//	Duplicates  MachineA.Temperature and MachineB.Temperature, and two variables with the browse name Speed
+//	Events      Alarms, an object with an event notifier
+//	Dynamic     Counter, an Int32 that the tests change with setValue
+//
+// Use testNodeID to get the NodeID of a node by its path, e.g., testNodeID("Scalars.Int32").
+type testServer struct {
+	*OPCUAServer
+
+	mu         sync.Mutex
+	rejected   map[string]ua.StatusCode
+	registered map[string]int
+
+	rejectTriggering ua.StatusCode
+}
+
+// startTestServer starts a test server on a random port of localhost. It is stopped after the current spec.
+func startTestServer(cfg testServerConfig) *testServer {
+	t := &testServer{rejected: make(map[string]ua.StatusCode), registered: make(map[string]int)}
+
+	serverCfg := OPCUAServerConfig{
+		Endpoint:       "opc.tcp://127.0.0.1:0",
+		ApplicationURI: "urn:benthos-umh:test-server",
+		Username:       cfg.Username,
+		Password:       cfg.Password,
+		Limits:         cfg.Limits,
+	}
+	// Clients encrypt their password with the server certificate
+	if cfg.Username != "" {
+		testServerCertOnce.Do(func() {
+			var err error
+			testServerCert, testServerKey, err = LoadOrCreateServerCertificate("", "", serverCfg.ApplicationURI)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		serverCfg.Certificate = testServerCert
+		serverCfg.PrivateKey = testServerKey
+	}
+
+	server, err := NewOPCUAServer(serverCfg)
+	Expect(err).NotTo(HaveOccurred())
+	t.OPCUAServer = server
+	server.SetFaultHooks(t.onCreateMonitoredItem, t.onSetTriggering, t.onRegisterNodes)
+	t.buildAddressSpace()
+
+	Expect(server.Start()).To(Succeed())
+	DeferCleanup(server.Close)
+	return t
+}
+
+// testNodeID returns the NodeID of a node of the test server by its path below the folder TestServer.
+func testNodeID(path string) *ua.NodeID {
+	return ua.NewStringNodeID(ServerNamespaceIndex, "TestServer."+path)
+}
+
+// buildAddressSpace adds the nodes of the test server.
+func (t *testServer) buildAddressSpace() {
+	root := NewServerObject(ua.NewStringNodeID(ServerNamespaceIndex, "TestServer"), "TestServer", ua.NewNumericNodeID(0, id.FolderType))
+	Expect(t.AddressSpace().AddNode(root, ua.NewNumericNodeID(0, id.ObjectsFolder), id.Organizes)).To(Succeed())
+
+	t.addFolder("Scalars")
+	for _, scalar := range []struct {
+		name     string
+		dataType uint32
+		value    any
+	}{
+		{"Boolean", id.Boolean, true},
+		{"SByte", id.SByte, int8(-8)},
+		{"Byte", id.Byte, uint8(8)},
+		{"Int16", id.Int16, int16(-16)},
+		{"UInt16", id.UInt16, uint16(16)},
+		{"Int32", id.Int32, int32(-32)},
+		{"UInt32", id.UInt32, uint32(32)},
+		{"Int64", id.Int64, int64(-64)},
+		{"UInt64", id.UInt64, uint64(64)},
+		{"Float", id.Float, float32(1.5)},
+		{"Double", id.Double, 2.5},
+		{"String", id.String, "hello"},
+		{"DateTime", id.DateTime, testServerTime},
+	} {
+		t.addVariable("Scalars", scalar.name, scalar.name, scalar.dataType, scalar.value)
+	}
+	Expect(t.AddressSpace().UpdateNode(testNodeID("Scalars.Double"), func(node *ServerNode) {
+		node.Description = ua.NewLocalizedText("A double value")
+	})).To(Succeed())
+	unit := NewServerProperty(testNodeID("Scalars.Double.Unit"), "Unit", ua.NewNumericNodeID(0, id.String), ua.MustVariant("mm/s"))
+	Expect(t.AddressSpace().AddNode(unit, testNodeID("Scalars.Double"), id.HasProperty)).To(Succeed())
+
+	t.addFolder("Arrays")
+	t.addVariable("Arrays", "Int32Array", "Int32Array", id.Int32, []int32{1, 2, 3})
+	t.addVariable("Arrays", "DoubleArray", "DoubleArray", id.Double, []float64{1.5, 2.5})
+	t.addVariable("Arrays", "StringArray", "StringArray", id.String, []string{"a", "b"})
+	t.addVariable("Arrays", "BooleanArray", "BooleanArray", id.Boolean, []bool{true, false})
+
+	t.addFolder("Structures")
+	t.addVariable("Structures", "Range", "Range", id.Range, ua.NewExtensionObject(&ua.Range{Low: 0, High: 100}))
+
+	t.addFolder("Duplicates")
+	t.addFolder("Duplicates.MachineA")
+	t.addVariable("Duplicates.MachineA", "Temperature", "Temperature", id.Double, 20.5)
+	t.addFolder("Duplicates.MachineB")
+	t.addVariable("Duplicates.MachineB", "Temperature", "Temperature", id.Double, 21.5)
+	t.addVariable("Duplicates", "Speed1", "Speed", id.Double, 1.0)
+	t.addVariable("Duplicates", "Speed2", "Speed", id.Double, 2.0)
+
+	t.addFolder("Events")
+	alarms := NewServerObject(testNodeID("Events.Alarms"), "Alarms", ua.NewNumericNodeID(0, id.BaseObjectType))
+	alarms.EventNotifier = 1 // SubscribeToEvents
+	Expect(t.AddressSpace().AddNode(alarms, testNodeID("Events"), id.Organizes)).To(Succeed())
+	Expect(t.AddressSpace().AddReference(ua.NewNumericNodeID(0, id.Server), id.HasNotifier, alarms.ID)).To(Succeed())
+
+	t.addFolder("Dynamic")
+	counter := t.addVariable("Dynamic", "Counter", "Counter", id.Int32, int32(0))
+	counter.AccessLevel |= byte(ua.AccessLevelTypeCurrentWrite)
+}
+
+// addFolder adds a folder by its path below the folder TestServer, e.g., Duplicates.MachineA.
+func (t *testServer) addFolder(path string) {
+	parentID := ua.NewStringNodeID(ServerNamespaceIndex, "TestServer")
+	name := path
+	if i := strings.LastIndex(path, "."); i >= 0 {
+		parentID = testNodeID(path[:i])
+		name = path[i+1:]
+	}
+
+	folder := NewServerObject(testNodeID(path), name, ua.NewNumericNodeID(0, id.FolderType))
+	Expect(t.AddressSpace().AddNode(folder, parentID, id.Organizes)).To(Succeed())
+}
+
+// addVariable adds a variable with the NodeID <folder>.<key> and the given browse name to a folder.
+func (t *testServer) addVariable(folder, key, browseName string, dataType uint32, value any) *ServerNode {
+	variable := NewServerVariable(testNodeID(folder+"."+key), browseName, ua.NewNumericNodeID(0, dataType), ua.MustVariant(value))
+	Expect(t.AddressSpace().AddNode(variable, testNodeID(folder), id.Organizes)).To(Succeed())
+	return variable
+}
+
+// setValue changes the value of a variable and notifies its monitored items.
+func (t *testServer) setValue(nodeID *ua.NodeID, value any) {
+	now := time.Now()
+	dv := &ua.DataValue{Value: ua.MustVariant(value), SourceTimestamp: now, ServerTimestamp: now}
+	Expect(t.AddressSpace().SetValue(nodeID, dv)).To(Succeed())
+}
+
+// rejectMonitoredItems makes the server reject new monitored items of the given nodes with the status code.
+func (t *testServer) rejectMonitoredItems(status ua.StatusCode, nodeIDs ...*ua.NodeID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, nodeID := range nodeIDs {
+		t.rejected[nodeID.String()] = status
+	}
+}
+
+func (t *testServer) onCreateMonitoredItem(node *ServerNode, item *ua.ReadValueID) ua.StatusCode {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if status, ok := t.rejected[node.ID.String()]; ok {
+		return status
+	}
+	return ua.StatusOK
+}
+
+// rejectSetTriggering makes the server reject all SetTriggering calls with the status code.
+func (t *testServer) rejectSetTriggering(status ua.StatusCode) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rejectTriggering = status
+}
+
+func (t *testServer) onSetTriggering(node *ServerNode) ua.StatusCode {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rejectTriggering
+}
+
+// registeredNodes returns how often each node is currently registered, by the string of its NodeID.
+func (t *testServer) registeredNodes() map[string]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	registered := make(map[string]int, len(t.registered))
+	for nodeID, count := range t.registered {
+		if count != 0 {
+			registered[nodeID] = count
+		}
+	}
+	return registered
+}
+
+func (t *testServer) onRegisterNodes(nodeIDs []*ua.NodeID, register bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, nodeID := range nodeIDs {
+		if register {
+			t.registered[nodeID.String()]++
+		} else {
+			t.registered[nodeID.String()]--
+		}
+	}
+}
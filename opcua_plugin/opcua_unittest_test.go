@@ -1,6 +1,14 @@
 package opcua_plugin_test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"path/filepath"
+	"time"
+
+	"github.com/gopcua/opcua"
 	"github.com/gopcua/opcua/ua"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -15,7 +23,7 @@ var _ = Describe("Unit Tests", func() {
 		BeforeEach(func() {
 			endpoints = MockGetEndpoints()
 
-			endpoints = endpoints
+			_ = endpoints
 			Skip("Implement this test")
 		})
 	})
@@ -46,6 +54,364 @@ var _ = Describe("Unit Tests", func() {
 			{Path: "Folder.Tag3", NodeID: ua.MustParseNodeID("ns=1;s=node4")},
 		}),
 	)
+
+	It("should update node paths independently of the node order", func() {
+		nodes := []NodeDef{
+			{Path: "Folder.Tag1", NodeID: ua.MustParseNodeID("ns=1;s=node1")},
+			{Path: "Folder.Tag1", NodeID: ua.MustParseNodeID("ns=1;s=node2")},
+			{Path: "Folder.Tag1", NodeID: ua.MustParseNodeID("ns=1;s=node3")},
+			{Path: "Folder.Tag2", NodeID: ua.MustParseNodeID("ns=1;s=node4")},
+		}
+		reversed := []NodeDef{nodes[3], nodes[2], nodes[1], nodes[0]}
+
+		UpdateNodePaths(nodes)
+		UpdateNodePaths(reversed)
+
+		Expect(nodes[0].Path).To(Equal("Folder.ns_1_s_node1"))
+		Expect(nodes[1].Path).To(Equal("Folder.ns_1_s_node2"))
+		Expect(nodes[2].Path).To(Equal("Folder.ns_1_s_node3"))
+		Expect(nodes[3].Path).To(Equal("Folder.Tag2"))
+		Expect(reversed).To(Equal([]NodeDef{nodes[3], nodes[2], nodes[1], nodes[0]}))
+	})
+
+	It("should make node paths unique if the sanitized NodeIDs collide", func() {
+		nodes := []NodeDef{
+			{Path: "Folder.Tag1", NodeID: ua.MustParseNodeID("ns=1;s=a.b")},
+			{Path: "Folder.Tag1", NodeID: ua.MustParseNodeID("ns=1;s=a_b")},
+		}
+
+		UpdateNodePaths(nodes)
+
+		Expect(nodes[0].Path).To(Equal("Folder.ns_1_s_a_b_1"))
+		Expect(nodes[1].Path).To(Equal("Folder.ns_1_s_a_b_2"))
+	})
+
+	It("should keep registered node paths across restarts", func() {
+		file := filepath.Join(GinkgoT().TempDir(), "paths.json")
+
+		registry, err := LoadPathRegistry(file)
+		Expect(err).NotTo(HaveOccurred())
+
+		nodes := []NodeDef{
+			{Path: "Folder.Tag1", NodeID: ua.MustParseNodeID("ns=1;s=node1")},
+		}
+		UpdateNodePathsWithRegistry(nodes, registry)
+		Expect(nodes[0].Path).To(Equal("Folder.Tag1"))
+		Expect(registry.Save()).To(Succeed())
+
+		// After a restart, a new node with the same BrowseName appears
+		registry, err = LoadPathRegistry(file)
+		Expect(err).NotTo(HaveOccurred())
+
+		nodes = []NodeDef{
+			{Path: "Folder.Tag1", NodeID: ua.MustParseNodeID("ns=1;s=node0")},
+			{Path: "Folder.Tag1", NodeID: ua.MustParseNodeID("ns=1;s=node1")},
+		}
+		UpdateNodePathsWithRegistry(nodes, registry)
+		Expect(nodes[0].Path).To(Equal("Folder.ns_1_s_node0"))
+		Expect(nodes[1].Path).To(Equal("Folder.Tag1"))
+		Expect(registry.Paths()).To(HaveLen(2))
+	})
+
+	DescribeTable("should split nodes into chunks that respect the read limit",
+		func(n int, size int, expected [][2]int) {
+			Expect(SplitIntoChunks(n, size)).To(Equal(expected))
+		},
+		Entry("no nodes", 0, 100, [][2]int(nil)),
+		Entry("less nodes than the limit", 5, 100, [][2]int{{0, 5}}),
+		Entry("exact multiple of the limit", 4, 2, [][2]int{{0, 2}, {2, 4}}),
+		Entry("remainder", 5, 2, [][2]int{{0, 2}, {2, 4}, {4, 5}}),
+		Entry("no limit", 5, 0, [][2]int{{0, 5}}),
+	)
+
+	Describe("ParseTriggerGroups", func() {
+		parse := func(yaml string) ([]*TriggerGroup, error) {
+			conf, err := OPCUAConfigSpec.ParseYAML(yaml, nil)
+			Expect(err).NotTo(HaveOccurred())
+			triggerGroupConfs, err := conf.FieldObjectList("triggerGroups")
+			Expect(err).NotTo(HaveOccurred())
+			return ParseTriggerGroups(triggerGroupConfs)
+		}
+
+		It("should parse trigger groups", func() {
+			triggerGroups, err := parse(`
+endpoint: opc.tcp://localhost:4840
+nodeIDs: ["ns=2;s=Line1"]
+subscribeEnabled: true
+triggerGroups:
+  - name: quality
+    triggerNodeID: ns=2;s=PartFinished
+    triggerValue: "true"
+    nodeIDs: ["ns=2;s=Length", "ns=2;s=Weight"]
+  - triggerNodeID: ns=2;s=Counter
+    nodeIDs: ["ns=2;s=Temperature"]
+`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(triggerGroups).To(HaveLen(2))
+
+			Expect(triggerGroups[0].Name).To(Equal("quality"))
+			Expect(triggerGroups[0].TriggerNodeID).To(Equal(ua.MustParseNodeID("ns=2;s=PartFinished")))
+			Expect(triggerGroups[0].TriggerValue).To(Equal("true"))
+			Expect(triggerGroups[0].NodeIDs).To(Equal([]*ua.NodeID{ua.MustParseNodeID("ns=2;s=Length"), ua.MustParseNodeID("ns=2;s=Weight")}))
+
+			// the name defaults to the sanitized trigger NodeID
+			Expect(triggerGroups[1].Name).To(Equal("ns_2_s_Counter"))
+			Expect(triggerGroups[1].TriggerValue).To(Equal(""))
+		})
+
+		It("should fail on invalid NodeIDs", func() {
+			_, err := parse(`
+endpoint: opc.tcp://localhost:4840
+nodeIDs: ["ns=2;s=Line1"]
+triggerGroups:
+  - triggerNodeID: ns=abc;i=1
+    nodeIDs: ["ns=2;s=Temperature"]
+`)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should fail without nodeIDs", func() {
+			_, err := parse(`
+endpoint: opc.tcp://localhost:4840
+nodeIDs: ["ns=2;s=Line1"]
+triggerGroups:
+  - triggerNodeID: ns=2;s=Counter
+    nodeIDs: []
+`)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("ParseNodeConfigs", func() {
+		parse := func(yaml string, subscribeEnabled bool) ([]*NodeConfig, error) {
+			conf, err := OPCUAConfigSpec.ParseYAML(yaml, nil)
+			Expect(err).NotTo(HaveOccurred())
+			nodeConfs, err := conf.FieldObjectList("nodes")
+			Expect(err).NotTo(HaveOccurred())
+			return ParseNodeConfigs(nodeConfs, subscribeEnabled)
+		}
+
+		It("should parse nodes", func() {
+			nodeConfigs, err := parse(`
+endpoint: opc.tcp://localhost:4840
+nodes:
+  - nodeID: ns=2;s=Temperature
+    tagName: temperature
+    tagGroup: machine
+    mode: subscribe
+    samplingInterval: 100
+    deadband: 0.5
+    metadata:
+      unit: degC
+  - browsePath: /2:Line1/Counters
+    recursive: false
+`, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nodeConfigs).To(HaveLen(2))
+
+			Expect(nodeConfigs[0].NodeID.String()).To(Equal("ns=2;s=Temperature"))
+			Expect(nodeConfigs[0].TagName).To(Equal("temperature"))
+			Expect(nodeConfigs[0].TagGroup).To(Equal("machine"))
+			Expect(nodeConfigs[0].Mode).To(Equal(NodeModeSubscribe))
+			Expect(nodeConfigs[0].SamplingInterval).To(Equal(100.0))
+			Expect(nodeConfigs[0].Deadband).To(Equal(0.5))
+			Expect(nodeConfigs[0].Recursive).To(BeTrue())
+			Expect(nodeConfigs[0].Metadata).To(Equal(map[string]string{"unit": "degC"}))
+
+			Expect(nodeConfigs[1].NodeID).To(BeNil())
+			Expect(nodeConfigs[1].BrowsePath).To(Equal([]*ua.QualifiedName{{NamespaceIndex: 2, Name: "Line1"}, {Name: "Counters"}}))
+			Expect(nodeConfigs[1].String()).To(Equal("/2:Line1/0:Counters"))
+			Expect(nodeConfigs[1].Mode).To(Equal(NodeModePoll))
+			Expect(nodeConfigs[1].Recursive).To(BeFalse())
+		})
+
+		It("should default the mode to subscribeEnabled", func() {
+			nodeConfigs, err := parse(`
+endpoint: opc.tcp://localhost:4840
+nodes:
+  - nodeID: ns=2;s=Temperature
+  - nodeID: ns=2;s=Pressure
+    mode: poll
+`, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nodeConfigs[0].Mode).To(Equal(NodeModeSubscribe))
+			Expect(nodeConfigs[1].Mode).To(Equal(NodeModePoll))
+		})
+
+		DescribeTable("should reject invalid nodes", func(nodes string) {
+			_, err := parse("endpoint: opc.tcp://localhost:4840\nnodes:\n"+nodes, false)
+			Expect(err).To(HaveOccurred())
+		},
+			Entry("neither nodeID nor browsePath", "  - tagName: temperature\n"),
+			Entry("nodeID and browsePath", "  - nodeID: ns=2;s=Temperature\n    browsePath: /2:Temperature\n"),
+			Entry("invalid nodeID", "  - nodeID: ns=abc;i=1\n"),
+			Entry("empty browse name", "  - browsePath: /2:Line1//Temperature\n"),
+			Entry("invalid mode", "  - nodeID: ns=2;s=Temperature\n    mode: stream\n"),
+			Entry("deadband in poll mode", "  - nodeID: ns=2;s=Temperature\n    deadband: 1\n"),
+			Entry("negative deadband", "  - nodeID: ns=2;s=Temperature\n    mode: subscribe\n    deadband: -1\n"),
+			Entry("subscription in poll mode", "  - nodeID: ns=2;s=Temperature\n    subscription: fast\n"),
+		)
+	})
+
+	Describe("ParseSubscriptionConfigs", func() {
+		parse := func(yaml string) ([]*SubscriptionConfig, error) {
+			conf, err := OPCUAConfigSpec.ParseYAML(yaml, nil)
+			Expect(err).NotTo(HaveOccurred())
+			subscriptionConfs, err := conf.FieldObjectList("subscriptions")
+			Expect(err).NotTo(HaveOccurred())
+			return ParseSubscriptionConfigs(subscriptionConfs)
+		}
+
+		It("should parse subscriptions and add the default subscription", func() {
+			subscriptionConfigs, err := parse(`
+endpoint: opc.tcp://localhost:4840
+nodeIDs: ["ns=2;s=Line1"]
+subscriptions:
+  - name: fast
+    publishingInterval: 50
+    maxKeepAliveCount: 20
+    priority: 200
+    patterns: ["^ns=2;s=Fast\\.", "Vibration$"]
+  - name: slow
+    publishingInterval: 10000
+`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(subscriptionConfigs).To(HaveLen(3))
+
+			Expect(subscriptionConfigs[0].Name).To(Equal(DefaultSubscriptionName))
+			Expect(subscriptionConfigs[0].PublishingInterval).To(Equal(100 * time.Millisecond))
+
+			Expect(subscriptionConfigs[1].Name).To(Equal("fast"))
+			Expect(subscriptionConfigs[1].PublishingInterval).To(Equal(50 * time.Millisecond))
+			Expect(subscriptionConfigs[1].MaxKeepAliveCount).To(Equal(uint32(20)))
+			Expect(subscriptionConfigs[1].Priority).To(Equal(uint8(200)))
+			Expect(subscriptionConfigs[1].Patterns).To(HaveLen(2))
+
+			Expect(subscriptionConfigs[2].Name).To(Equal("slow"))
+			Expect(subscriptionConfigs[2].PublishingInterval).To(Equal(10 * time.Second))
+			Expect(subscriptionConfigs[2].Patterns).To(BeEmpty())
+		})
+
+		It("should use a configured default subscription", func() {
+			subscriptionConfigs, err := parse(`
+endpoint: opc.tcp://localhost:4840
+nodeIDs: ["ns=2;s=Line1"]
+subscriptions:
+  - name: default
+    publishingInterval: 500
+`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(subscriptionConfigs).To(HaveLen(1))
+			Expect(subscriptionConfigs[0].PublishingInterval).To(Equal(500 * time.Millisecond))
+		})
+
+		DescribeTable("should reject invalid subscriptions", func(subscriptions string) {
+			_, err := parse("endpoint: opc.tcp://localhost:4840\nnodeIDs: [\"ns=2;s=Line1\"]\nsubscriptions:\n" + subscriptions)
+			Expect(err).To(HaveOccurred())
+		},
+			Entry("empty name", "  - name: \"\"\n"),
+			Entry("duplicate name", "  - name: fast\n  - name: fast\n"),
+			Entry("zero publishing interval", "  - name: fast\n    publishingInterval: 0\n"),
+			Entry("priority out of range", "  - name: fast\n    priority: 256\n"),
+			Entry("invalid pattern", "  - name: fast\n    patterns: [\"(\"]\n"),
+		)
+
+		It("should read pending notifications of subscriptions with a higher priority first", func() {
+			channels := NewSubscriptionChannels([]*SubscriptionConfig{
+				{Name: DefaultSubscriptionName},
+				{Name: "fast", Priority: 200},
+				{Name: "medium", Priority: 100},
+			})
+			Expect(channels).To(HaveLen(3))
+			Expect([]string{channels[0].Name, channels[1].Name, channels[2].Name}).To(Equal([]string{"fast", "medium", DefaultSubscriptionName}))
+
+			// Notifications with an error are returned as error by ReadBatchSubscribe, which tells them apart
+			// without a node list
+			notify := func(channel SubscriptionChannel, err error) {
+				channel.Notifications <- &opcua.PublishNotificationData{Error: err}
+			}
+			errDefault1, errDefault2 := errors.New("default 1"), errors.New("default 2")
+			errMedium, errFast := errors.New("medium"), errors.New("fast")
+			notify(channels[2], errDefault1)
+			notify(channels[2], errDefault2)
+			notify(channels[1], errMedium)
+			notify(channels[0], errFast)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			input := &OPCUAInput{SubNotifyChans: channels}
+			for _, expected := range []error{errFast, errMedium, errDefault1, errDefault2} {
+				_, _, err := input.ReadBatchSubscribe(ctx)
+				Expect(err).To(MatchError(expected))
+			}
+
+			_, _, err := input.ReadBatchSubscribe(ctx)
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+		})
+	})
+
+	It("should parse attribute names", func() {
+		attributes, err := ParseAttributes([]string{"DisplayName", "UserAccessLevel", "Historizing", "DisplayName"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(attributes).To(Equal([]ua.AttributeID{ua.AttributeIDDisplayName, ua.AttributeIDUserAccessLevel, ua.AttributeIDHistorizing}))
+
+		_, err = ParseAttributes([]string{"Value"})
+		Expect(err).To(HaveOccurred())
+		_, err = ParseAttributes([]string{"Colour"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should describe endpoints for the discovery report", func() {
+		endpoint := DescribeEndpoint(MockGetEndpoints()[0])
+
+		Expect(endpoint.EndpointURL).To(Equal("opc.tcp://example.com:4840"))
+		Expect(endpoint.SecurityMode).To(Equal("SignAndEncrypt"))
+		Expect(endpoint.SecurityPolicy).To(Equal("Basic256Sha256"))
+		Expect(endpoint.SecurityLevel).To(Equal(uint8(3)))
+		Expect(endpoint.UserTokenTypes).To(Equal([]string{"Anonymous", "UserName"}))
+		Expect(endpoint.Certificate).To(BeNil())
+	})
+
+	Describe("NextPollTime", func() {
+		start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		It("should keep the schedule when the read was fast", func() {
+			next := NextPollTime(start, start.Add(200*time.Millisecond), time.Second)
+			Expect(next).To(Equal(start.Add(time.Second)))
+		})
+
+		It("should skip missed polls but stay aligned when the read was slow", func() {
+			next := NextPollTime(start, start.Add(2500*time.Millisecond), time.Second)
+			Expect(next).To(Equal(start.Add(3 * time.Second)))
+		})
+
+		It("should not schedule a poll at the current time", func() {
+			next := NextPollTime(start, start.Add(time.Second), time.Second)
+			Expect(next).To(Equal(start.Add(2 * time.Second)))
+		})
+	})
+
+	DescribeTable("should convert values into JSON safe values",
+		func(value any, expected string) {
+			b, err := json.Marshal(JSONSafeValue(value))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(b)).To(Equal(expected))
+		},
+		Entry("nil", nil, `null`),
+		Entry("bool", true, `true`),
+		Entry("int32", int32(-42), `-42`),
+		Entry("float64", 1.5, `1.5`),
+		Entry("string", "text", `"text"`),
+		Entry("int64 above 2^53", int64(9007199254740993), `"9007199254740993"`),
+		Entry("uint64", uint64(18446744073709551615), `"18446744073709551615"`),
+		Entry("NaN", math.NaN(), `"NaN"`),
+		Entry("positive infinity", float32(math.Inf(1)), `"Infinity"`),
+		Entry("negative infinity", math.Inf(-1), `"-Infinity"`),
+		Entry("int32 array", []int32{1, 2, 3}, `[1,2,3]`),
+		Entry("int64 array", []int64{1, 2}, `["1","2"]`),
+		Entry("byte string", []byte("abc"), `"YWJj"`),
+	)
 })
 
 func MockGetEndpoints() []*ua.EndpointDescription {
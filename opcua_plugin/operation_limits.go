@@ -0,0 +1,108 @@
+package opcua_plugin
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gopcua/opcua/id"
+	"github.com/gopcua/opcua/ua"
+)
+
+// DefaultMaxNodesPerRead is used when the server does not announce a MaxNodesPerRead limit.
+// It matches the batch size that MonitorBatched uses and keeps single requests well below
+// the typical TCP message size limits.
+const DefaultMaxNodesPerRead = 100
+
+// DefaultMaxMonitoredItemsPerCall is used when the server does not announce a MaxMonitoredItemsPerCall limit.
+const DefaultMaxMonitoredItemsPerCall = 100
+
+// OperationLimits holds the subset of the server's OperationLimits (Part 5, 6.3.11) that are relevant
+// for this plugin. A value of 0 means that the server did not announce a limit.
+type OperationLimits struct {
+	MaxNodesPerRead          uint32
+	MaxNodesPerRegisterNodes uint32
+	MaxMonitoredItemsPerCall uint32
+}
+
+// GetOperationLimits reads the OperationLimits of the connected OPC UA server.
+//
+// Servers are not required to expose the OperationLimits object, so nodes that do not exist
+// or that return a bad status are treated as "no limit" instead of failing the connection.
+func (g *OPCUAInput) GetOperationLimits(ctx context.Context) (OperationLimits, error) {
+	if g.Client == nil {
+		return OperationLimits{}, errors.New("client is nil")
+	}
+
+	req := &ua.ReadRequest{
+		NodesToRead: []*ua.ReadValueID{
+			{NodeID: ua.NewNumericNodeID(0, id.Server_ServerCapabilities_OperationLimits_MaxNodesPerRead), AttributeID: ua.AttributeIDValue},
+			{NodeID: ua.NewNumericNodeID(0, id.Server_ServerCapabilities_OperationLimits_MaxNodesPerRegisterNodes), AttributeID: ua.AttributeIDValue},
+			{NodeID: ua.NewNumericNodeID(0, id.Server_ServerCapabilities_OperationLimits_MaxMonitoredItemsPerCall), AttributeID: ua.AttributeIDValue},
+		},
+		TimestampsToReturn: ua.TimestampsToReturnNeither,
+	}
+
+	resp, err := g.Client.Read(ctx, req)
+	if err != nil {
+		return OperationLimits{}, err
+	}
+
+	if len(resp.Results) != len(req.NodesToRead) {
+		return OperationLimits{}, errors.New("unexpected number of results while reading operation limits")
+	}
+
+	limitFromResult := func(result *ua.DataValue) uint32 {
+		if result == nil || !errors.Is(result.Status, ua.StatusOK) || result.Value == nil {
+			return 0
+		}
+		if v, ok := result.Value.Value().(uint32); ok {
+			return v
+		}
+		return 0
+	}
+
+	return OperationLimits{
+		MaxNodesPerRead:          limitFromResult(resp.Results[0]),
+		MaxNodesPerRegisterNodes: limitFromResult(resp.Results[1]),
+		MaxMonitoredItemsPerCall: limitFromResult(resp.Results[2]),
+	}, nil
+}
+
+// effectiveLimit combines a user-provided limit with a server-announced limit.
+// The smaller non-zero value wins. If both are zero, the fallback is returned.
+func effectiveLimit(userLimit int, serverLimit uint32, fallback int) int {
+	limit := userLimit
+	if serverLimit > 0 && (limit <= 0 || int(serverLimit) < limit) {
+		limit = int(serverLimit)
+	}
+	if limit <= 0 {
+		limit = fallback
+	}
+	return limit
+}
+
+// maxNodesPerRead returns the number of nodes that can be put into a single ReadRequest.
+func (g *OPCUAInput) maxNodesPerRead() int {
+	return effectiveLimit(g.MaxNodesPerRead, g.OperationLimits.MaxNodesPerRead, DefaultMaxNodesPerRead)
+}
+
+// maxMonitoredItemsPerCall returns the number of monitored items that can be created in a single call.
+func (g *OPCUAInput) maxMonitoredItemsPerCall() int {
+	return effectiveLimit(0, g.OperationLimits.MaxMonitoredItemsPerCall, DefaultMaxMonitoredItemsPerCall)
+}
+
+// SplitIntoChunks returns the [start, end) ranges that split a list of n elements into chunks of at most size elements.
+func SplitIntoChunks(n int, size int) [][2]int {
+	if size <= 0 {
+		size = n
+	}
+	var chunks [][2]int
+	for start := 0; start < n; start += size {
+		end := start + size
+		if end > n {
+			end = n
+		}
+		chunks = append(chunks, [2]int{start, end})
+	}
+	return chunks
+}
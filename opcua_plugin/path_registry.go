@@ -0,0 +1,126 @@
+package opcua_plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// PathRegistry persists the path that was assigned to each NodeID.
+//
+// The path of a node determines its opcua_tag_group and opcua_tag_name. Without the registry,
+// the path of a node can change if nodes are added to or removed from the server, e.g., when a
+// new node with the same BrowseName appears. With the registry, a node keeps the path it was
+// published with for the first time.
+type PathRegistry struct {
+	file  string
+	mu    sync.Mutex
+	paths map[string]string // NodeID -> path
+	dirty bool
+}
+
+// LoadPathRegistry loads the registry from the given file.
+// If the file does not exist yet, an empty registry is returned that will be written to this file.
+func LoadPathRegistry(file string) (*PathRegistry, error) {
+	registry := &PathRegistry{
+		file:  file,
+		paths: make(map[string]string),
+	}
+
+	b, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return registry, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading path registry %s failed: %w", file, err)
+	}
+
+	if len(b) == 0 {
+		return registry, nil
+	}
+
+	if err := json.Unmarshal(b, &registry.paths); err != nil {
+		return nil, fmt.Errorf("parsing path registry %s failed: %w", file, err)
+	}
+
+	return registry, nil
+}
+
+// Paths returns a copy of all registered paths by NodeID. It is safe to call on a nil registry.
+func (r *PathRegistry) Paths() map[string]string {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	paths := make(map[string]string, len(r.paths))
+	for nodeID, path := range r.paths {
+		paths[nodeID] = path
+	}
+	return paths
+}
+
+// Register adds the paths of all nodes that are not registered yet. It is safe to call on a nil registry.
+func (r *PathRegistry) Register(nodes []NodeDef) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, node := range nodes {
+		nodeID := node.NodeID.String()
+		if _, ok := r.paths[nodeID]; ok {
+			continue
+		}
+		r.paths[nodeID] = node.Path
+		r.dirty = true
+	}
+}
+
+// Save writes the registry to its file if it changed since it was loaded or saved.
+// The file is replaced atomically, so that a crash during saving does not corrupt the registry.
+func (r *PathRegistry) Save() error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.dirty {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(r.paths, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(r.file), filepath.Base(r.file)+".tmp")
+	if err != nil {
+		return fmt.Errorf("writing path registry %s failed: %w", r.file, err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(b); err != nil {
+		_ = tmpFile.Close()
+		return fmt.Errorf("writing path registry %s failed: %w", r.file, err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("writing path registry %s failed: %w", r.file, err)
+	}
+
+	if err := os.Rename(tmpFile.Name(), r.file); err != nil {
+		return fmt.Errorf("writing path registry %s failed: %w", r.file, err)
+	}
+
+	r.dirty = false
+	return nil
+}
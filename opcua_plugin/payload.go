@@ -0,0 +1,120 @@
+package opcua_plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gopcua/opcua/ua"
+)
+
+const (
+	// PayloadFormatRaw writes the value as plain text into the payload (default).
+	PayloadFormatRaw = "raw"
+	// PayloadFormatJSON writes a JSON document with the value, its data type, timestamps, status and node identity into the payload.
+	PayloadFormatJSON = "json"
+)
+
+// JSONPayload is the payload of a message in the json payload format.
+type JSONPayload struct {
+	Value           any       `json:"value"`
+	DataType        string    `json:"dataType"`
+	IsArray         bool      `json:"isArray,omitempty"`
+	SourceTimestamp time.Time `json:"sourceTimestamp"`
+	ServerTimestamp time.Time `json:"serverTimestamp"`
+	StatusCode      uint32    `json:"statusCode"`
+	Status          string    `json:"status"`
+	NodeID          string    `json:"nodeId"`
+	BrowseName      string    `json:"browseName"`
+	TagGroup        string    `json:"tagGroup"`
+	TagName         string    `json:"tagName"`
+}
+
+// createJSONPayload creates the payload for the json payload format.
+func createJSONPayload(dataValue *ua.DataValue, nodeDef NodeDef, tagGroup string, tagName string) ([]byte, error) {
+	payload := JSONPayload{
+		Value:           JSONSafeValue(dataValue.Value.Value()),
+		DataType:        strings.TrimPrefix(dataValue.Value.Type().String(), "TypeID"),
+		IsArray:         dataValue.Value.Has(ua.VariantArrayValues),
+		SourceTimestamp: dataValue.SourceTimestamp,
+		ServerTimestamp: dataValue.ServerTimestamp,
+		StatusCode:      uint32(dataValue.Status),
+		Status:          statusName(dataValue.Status),
+		NodeID:          nodeDef.NodeID.String(),
+		BrowseName:      nodeDef.BrowseName,
+		TagGroup:        tagGroup,
+		TagName:         tagName,
+	}
+
+	return json.Marshal(payload)
+}
+
+// statusName returns the symbolic name of a status code, e.g., StatusGood.
+func statusName(status ua.StatusCode) string {
+	if status == ua.StatusOK {
+		return "Good"
+	}
+	if d, ok := ua.StatusCodes[status]; ok {
+		return d.Name
+	}
+	return fmt.Sprintf("0x%X", uint32(status))
+}
+
+// JSONSafeValue converts an OPC UA value into a value that can be marshalled to JSON without losing information.
+//
+//   - 64-bit integers are encoded as strings, as most JSON parsers store numbers as float64,
+//     which can not represent integers above 2^53 exactly.
+//   - NaN and infinite floats are encoded as the strings "NaN", "Infinity" and "-Infinity",
+//     as JSON does not support them.
+//   - Arrays are converted element by element.
+//
+// All other values keep their native JSON type (numbers as numbers, booleans as booleans).
+func JSONSafeValue(v any) any {
+	switch value := v.(type) {
+	case nil:
+		return nil
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case uint64:
+		return strconv.FormatUint(value, 10)
+	case int:
+		return strconv.FormatInt(int64(value), 10)
+	case uint:
+		return strconv.FormatUint(uint64(value), 10)
+	case float32:
+		return jsonSafeFloat(float64(value))
+	case float64:
+		return jsonSafeFloat(value)
+	case []byte:
+		// ByteStrings are marshalled as base64 by encoding/json
+		return value
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		values := make([]any, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			values[i] = JSONSafeValue(rv.Index(i).Interface())
+		}
+		return values
+	}
+
+	return v
+}
+
+// jsonSafeFloat encodes NaN and infinite floats as strings.
+func jsonSafeFloat(f float64) any {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+	return f
+}
@@ -0,0 +1,553 @@
+package opcua_plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/gopcua/opcua/ua"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// PubSub message encodings
+const (
+	PubSubEncodingUADP = "uadp"
+	PubSubEncodingJSON = "json"
+)
+
+// maxUDPPacketSize is the maximum size of a UDP datagram, which limits the size of an unchunked UADP NetworkMessage.
+const maxUDPPacketSize = 65535
+
+var OPCUAPubSubConfigSpec = service.NewConfigSpec().
+	Summary("Creates an input that receives data from OPC UA PubSub (Part 14) publishers, either as UADP NetworkMessages over UDP or as JSON NetworkMessages from an MQTT broker. Created & maintained by the United Manufacturing Hub. About us: www.umh.app").
+	Field(service.NewStringField("url").Description("Address to receive NetworkMessages from. Use opc.udp://<address>:<port> for UDP (multicast or unicast, e.g., opc.udp://239.0.0.1:4840) and mqtt://<broker>:<port> or mqtts://<broker>:<port> for MQTT.")).
+	Field(service.NewStringField("encoding").Description("The encoding of the NetworkMessages: 'uadp' or 'json'. Defaults to 'uadp' for UDP and 'json' for MQTT.").Default("")).
+	Field(service.NewStringField("networkInterface").Description("The name of the network interface (e.g., eth0) that joins the multicast group. If not set, the system default is used. Only used for UDP multicast.").Default("")).
+	Field(service.NewStringField("topic").Description("The MQTT topic to subscribe to. Wildcards are supported. Required for MQTT.").Default("")).
+	Field(service.NewStringField("clientID").Description("The MQTT client ID. If not set, a random client ID is used.").Default("")).
+	Field(service.NewStringField("username").Description("Username for the MQTT broker. If not set, no username is used.").Default("")).
+	Field(service.NewStringField("password").Description("Password for the MQTT broker. If not set, no password is used.").Default("")).
+	Field(service.NewStringField("publisherId").Description("If set, only NetworkMessages of this PublisherId are processed. Numeric PublisherIds are compared by their decimal representation.").Default("")).
+	Field(service.NewIntField("writerGroupId").Description("If set, only NetworkMessages of this WriterGroupId are processed. Only applies to NetworkMessages that contain a WriterGroupId (UADP with group header). Set to 0 to process all writer groups.").Default(0)).
+	Field(service.NewObjectListField("dataSetWriters",
+		service.NewIntField("dataSetWriterId").Description("The DataSetWriterId of the DataSetMessages."),
+		service.NewStringField("name").Description("The name of the DataSet, which is used as opcua_tag_group. Defaults to the DataSetWriterName (JSON) or <publisherId>.<dataSetWriterId>.").Default(""),
+		service.NewStringListField("fields").Description("The names of the fields in the order of the DataSet, which are used as opcua_tag_name. UADP does not transport field names, so without them the fields are named field_<index>.").Default([]any{}),
+	).Description("The DataSetWriters to process. If empty, the DataSetMessages of all DataSetWriters are processed.").Default([]any{}))
+
+func init() {
+	err := service.RegisterBatchInput(
+		"opcua_pubsub", OPCUAPubSubConfigSpec,
+		func(conf *service.ParsedConfig, mgr *service.Resources) (service.BatchInput, error) {
+			mgr.Logger().Infof("Created & maintained by the United Manufacturing Hub. About us: www.umh.app")
+			return newOPCUAPubSubInput(conf, mgr)
+		})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// PubSubDataSetWriter is the configuration of a DataSetWriter whose DataSetMessages are processed.
+type PubSubDataSetWriter struct {
+	DataSetWriterID uint16
+	Name            string
+	Fields          []string
+}
+
+type OPCUAPubSubInput struct {
+	URL              string
+	Encoding         string
+	NetworkInterface string
+	Topic            string
+	ClientID         string
+	Username         string
+	Password         string
+	PublisherID      string
+	WriterGroupID    uint16
+	DataSetWriters   []PubSubDataSetWriter
+	Log              *service.Logger
+
+	packets    chan []byte
+	done       chan struct{}
+	udpConn    *net.UDPConn
+	mqttClient mqtt.Client
+	mu         sync.Mutex
+}
+
+func newOPCUAPubSubInput(conf *service.ParsedConfig, mgr *service.Resources) (service.BatchInput, error) {
+	rawURL, err := conf.FieldString("url")
+	if err != nil {
+		return nil, err
+	}
+
+	encoding, err := conf.FieldString("encoding")
+	if err != nil {
+		return nil, err
+	}
+
+	networkInterface, err := conf.FieldString("networkInterface")
+	if err != nil {
+		return nil, err
+	}
+
+	topic, err := conf.FieldString("topic")
+	if err != nil {
+		return nil, err
+	}
+
+	clientID, err := conf.FieldString("clientID")
+	if err != nil {
+		return nil, err
+	}
+
+	username, err := conf.FieldString("username")
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := conf.FieldString("password")
+	if err != nil {
+		return nil, err
+	}
+
+	publisherID, err := conf.FieldString("publisherId")
+	if err != nil {
+		return nil, err
+	}
+
+	writerGroupID, err := conf.FieldInt("writerGroupId")
+	if err != nil {
+		return nil, err
+	}
+	if writerGroupID < 0 || writerGroupID > 0xFFFF {
+		return nil, fmt.Errorf("writerGroupId %d is out of range", writerGroupID)
+	}
+
+	dataSetWriterConfs, err := conf.FieldObjectList("dataSetWriters")
+	if err != nil {
+		return nil, err
+	}
+
+	dataSetWriters, err := ParsePubSubDataSetWriters(dataSetWriterConfs)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &OPCUAPubSubInput{
+		URL:              rawURL,
+		Encoding:         encoding,
+		NetworkInterface: networkInterface,
+		Topic:            topic,
+		ClientID:         clientID,
+		Username:         username,
+		Password:         password,
+		PublisherID:      publisherID,
+		WriterGroupID:    uint16(writerGroupID),
+		DataSetWriters:   dataSetWriters,
+		Log:              mgr.Logger(),
+	}
+
+	// Validate the URL, topic and encoding early instead of failing on every connection attempt
+	if _, _, err := m.transport(); err != nil {
+		return nil, err
+	}
+
+	return service.AutoRetryNacksBatched(m), nil
+}
+
+// ParsePubSubDataSetWriters parses the dataSetWriters configuration.
+func ParsePubSubDataSetWriters(confs []*service.ParsedConfig) ([]PubSubDataSetWriter, error) {
+	dataSetWriters := make([]PubSubDataSetWriter, 0, len(confs))
+
+	for i, conf := range confs {
+		dataSetWriterID, err := conf.FieldInt("dataSetWriterId")
+		if err != nil {
+			return nil, err
+		}
+		if dataSetWriterID <= 0 || dataSetWriterID > 0xFFFF {
+			return nil, fmt.Errorf("dataSetWriter %d: dataSetWriterId %d is out of range", i, dataSetWriterID)
+		}
+
+		name, err := conf.FieldString("name")
+		if err != nil {
+			return nil, err
+		}
+
+		fields, err := conf.FieldStringList("fields")
+		if err != nil {
+			return nil, err
+		}
+
+		dataSetWriters = append(dataSetWriters, PubSubDataSetWriter{
+			DataSetWriterID: uint16(dataSetWriterID),
+			Name:            name,
+			Fields:          fields,
+		})
+	}
+
+	return dataSetWriters, nil
+}
+
+// transport returns the parsed URL and the encoding of the NetworkMessages.
+func (m *OPCUAPubSubInput) transport() (*url.URL, string, error) {
+	u, err := url.Parse(m.URL)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid url %s: %w", m.URL, err)
+	}
+
+	encoding := m.Encoding
+	switch u.Scheme {
+	case "opc.udp":
+		if encoding == "" {
+			encoding = PubSubEncodingUADP
+		}
+	case "mqtt", "mqtts":
+		if encoding == "" {
+			encoding = PubSubEncodingJSON
+		}
+		if m.Topic == "" {
+			return nil, "", errors.New("topic is required for MQTT")
+		}
+	default:
+		return nil, "", fmt.Errorf("unsupported url scheme %s, use opc.udp, mqtt or mqtts", u.Scheme)
+	}
+
+	if encoding != PubSubEncodingUADP && encoding != PubSubEncodingJSON {
+		return nil, "", fmt.Errorf("unsupported encoding %s, use uadp or json", encoding)
+	}
+
+	return u, encoding, nil
+}
+
+// Connect starts receiving NetworkMessages from the UDP socket or the MQTT broker.
+func (m *OPCUAPubSubInput) Connect(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.packets != nil {
+		return nil
+	}
+
+	u, _, err := m.transport()
+	if err != nil {
+		return err
+	}
+
+	packets := make(chan []byte, 100)
+	done := make(chan struct{})
+
+	switch u.Scheme {
+	case "opc.udp":
+		err = m.connectUDP(u, packets, done)
+	default:
+		err = m.connectMQTT(ctx, u, packets, done)
+	}
+	if err != nil {
+		return err
+	}
+
+	m.packets = packets
+	m.done = done
+	m.Log.Infof("Receiving OPC UA PubSub NetworkMessages from %s", m.URL)
+	return nil
+}
+
+// connectUDP opens the UDP socket and joins the multicast group if the address is a multicast address.
+func (m *OPCUAPubSubInput) connectUDP(u *url.URL, packets chan []byte, done chan struct{}) error {
+	addr, err := net.ResolveUDPAddr("udp", u.Host)
+	if err != nil {
+		return fmt.Errorf("resolving %s failed: %w", u.Host, err)
+	}
+
+	var conn *net.UDPConn
+	if addr.IP != nil && addr.IP.IsMulticast() {
+		var iface *net.Interface
+		if m.NetworkInterface != "" {
+			iface, err = net.InterfaceByName(m.NetworkInterface)
+			if err != nil {
+				return fmt.Errorf("network interface %s not found: %w", m.NetworkInterface, err)
+			}
+		}
+		conn, err = net.ListenMulticastUDP("udp", iface, addr)
+	} else {
+		conn, err = net.ListenUDP("udp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("listening on %s failed: %w", u.Host, err)
+	}
+
+	if err := conn.SetReadBuffer(maxUDPPacketSize * 16); err != nil {
+		m.Log.Debugf("Failed to increase the UDP read buffer: %v", err)
+	}
+
+	m.udpConn = conn
+
+	go func() {
+		// Closing the channel signals ReadBatch that the socket is gone and a reconnect is required
+		defer close(packets)
+
+		buf := make([]byte, maxUDPPacketSize)
+		for {
+			n, _, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					m.Log.Errorf("Reading from %s failed: %v", u.Host, err)
+				}
+				return
+			}
+			packet := make([]byte, n)
+			copy(packet, buf[:n])
+			select {
+			case packets <- packet:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// connectMQTT connects to the MQTT broker and subscribes to the topic.
+// The subscription is renewed by the client after every reconnect.
+func (m *OPCUAPubSubInput) connectMQTT(ctx context.Context, u *url.URL, packets chan []byte, done chan struct{}) error {
+	clientID := m.ClientID
+	if clientID == "" {
+		clientID = "benthos-umh-" + randomString(8)
+	}
+
+	onMessage := func(_ mqtt.Client, msg mqtt.Message) {
+		select {
+		case packets <- msg.Payload():
+		case <-done:
+		}
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(u.String()).
+		SetClientID(clientID).
+		SetUsername(m.Username).
+		SetPassword(m.Password).
+		SetAutoReconnect(true).
+		SetConnectTimeout(SessionTimeout).
+		SetOnConnectHandler(func(client mqtt.Client) {
+			token := client.Subscribe(m.Topic, 1, onMessage)
+			if token.WaitTimeout(SessionTimeout) && token.Error() != nil {
+				m.Log.Errorf("Subscribing to %s failed: %v", m.Topic, token.Error())
+			}
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			m.Log.Warnf("Connection to %s lost, reconnecting: %v", m.URL, err)
+		})
+
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	select {
+	case <-token.Done():
+	case <-ctx.Done():
+		// Stop the pending connection attempt, otherwise the client keeps connecting in the background
+		client.Disconnect(0)
+		return ctx.Err()
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("connecting to %s failed: %w", m.URL, err)
+	}
+
+	m.mqttClient = client
+	return nil
+}
+
+// ReadBatch waits for the next NetworkMessage that contains data of the configured DataSetWriters
+// and returns one message per field.
+func (m *OPCUAPubSubInput) ReadBatch(ctx context.Context) (service.MessageBatch, service.AckFunc, error) {
+	m.mu.Lock()
+	packets := m.packets
+	m.mu.Unlock()
+
+	if packets == nil {
+		return nil, nil, service.ErrNotConnected
+	}
+
+	_, encoding, err := m.transport()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for {
+		var packet []byte
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case packet, ok = <-packets:
+		}
+
+		if !ok {
+			_ = m.Close(ctx)
+			return nil, nil, service.ErrNotConnected
+		}
+
+		var networkMessage *PubSubNetworkMessage
+		if encoding == PubSubEncodingUADP {
+			networkMessage, err = DecodeUADPNetworkMessage(packet)
+		} else {
+			networkMessage, err = DecodeJSONNetworkMessage(packet)
+		}
+		if err != nil {
+			m.Log.Warnf("Failed to decode NetworkMessage: %v", err)
+			continue
+		}
+
+		msgs := m.CreateMessages(networkMessage, time.Now())
+		if len(msgs) == 0 {
+			continue
+		}
+
+		return msgs, func(ctx context.Context, err error) error {
+			// Nacks are retried automatically when we use service.AutoRetryNacks
+			return nil
+		}, nil
+	}
+}
+
+// CreateMessages filters the DataSetMessages of a NetworkMessage by the configured PublisherId, WriterGroupId
+// and DataSetWriters and converts each field into a message with the same metadata as the opcua input.
+// Fields with a bad status are skipped.
+func (m *OPCUAPubSubInput) CreateMessages(networkMessage *PubSubNetworkMessage, receivedAt time.Time) service.MessageBatch {
+	if m.PublisherID != "" && networkMessage.PublisherID != m.PublisherID {
+		return nil
+	}
+	if m.WriterGroupID != 0 && networkMessage.WriterGroupID != 0 && networkMessage.WriterGroupID != m.WriterGroupID {
+		return nil
+	}
+
+	var msgs service.MessageBatch
+	for _, dataSetMessage := range networkMessage.DataSetMessages {
+		if dataSetMessage.MessageType == DataSetMessageTypeKeepAlive {
+			continue
+		}
+
+		dataSetWriter, ok := m.dataSetWriter(dataSetMessage.DataSetWriterID)
+		if !ok {
+			continue
+		}
+
+		tagGroup := dataSetWriter.Name
+		if tagGroup == "" {
+			tagGroup = dataSetMessage.DataSetWriterName
+		}
+		if tagGroup == "" {
+			tagGroup = strconv.Itoa(int(dataSetMessage.DataSetWriterID))
+			if networkMessage.PublisherID != "" {
+				tagGroup = sanitize(networkMessage.PublisherID) + "." + tagGroup
+			}
+		}
+
+		// The most specific timestamp wins: field, DataSetMessage, NetworkMessage, time of reception
+		serverTimestamp := receivedAt
+		if !networkMessage.Timestamp.IsZero() {
+			serverTimestamp = networkMessage.Timestamp
+		}
+		if !dataSetMessage.Timestamp.IsZero() {
+			serverTimestamp = dataSetMessage.Timestamp
+		}
+
+		for _, field := range dataSetMessage.Fields {
+			if statusIsBad(field.Status) {
+				m.Log.Debugf("Skipping field %d of DataSetWriter %d with status %v", field.Index, dataSetMessage.DataSetWriterID, field.Status)
+				continue
+			}
+
+			tagName := field.Name
+			if tagName == "" && field.Index < len(dataSetWriter.Fields) {
+				tagName = dataSetWriter.Fields[field.Index]
+			}
+			if tagName == "" {
+				tagName = "field_" + strconv.Itoa(field.Index)
+			}
+
+			b, tagType, err := formatPayload(field.Value)
+			if err != nil {
+				m.Log.Errorf("Error marshaling to JSON: %v", err)
+				continue
+			}
+
+			sourceTimestamp := serverTimestamp
+			if !field.SourceTimestamp.IsZero() {
+				sourceTimestamp = field.SourceTimestamp
+			}
+			fieldServerTimestamp := serverTimestamp
+			if !field.ServerTimestamp.IsZero() {
+				fieldServerTimestamp = field.ServerTimestamp
+			}
+
+			message := service.NewMessage(b)
+			message.MetaSet("opcua_source_timestamp", sourceTimestamp.Format("2006-01-02T15:04:05.000000Z07:00"))
+			message.MetaSet("opcua_server_timestamp", fieldServerTimestamp.Format("2006-01-02T15:04:05.000000Z07:00"))
+			message.MetaSet("opcua_attr_browsename", tagName)
+			message.MetaSet("opcua_attr_datatype", field.DataType)
+			message.MetaSet("opcua_tag_group", tagGroup)
+			message.MetaSet("opcua_tag_name", sanitize(tagName))
+			message.MetaSet("opcua_tag_type", tagType)
+			message.MetaSet("opcua_pubsub_publisher_id", networkMessage.PublisherID)
+			message.MetaSet("opcua_pubsub_writer_group_id", strconv.Itoa(int(networkMessage.WriterGroupID)))
+			message.MetaSet("opcua_pubsub_dataset_writer_id", strconv.Itoa(int(dataSetMessage.DataSetWriterID)))
+			message.MetaSet("opcua_pubsub_sequence_number", strconv.FormatUint(uint64(dataSetMessage.SequenceNumber), 10))
+			message.MetaSet("opcua_pubsub_message_type", dataSetMessage.MessageType)
+
+			msgs = append(msgs, message)
+		}
+	}
+
+	return msgs
+}
+
+// dataSetWriter returns the configuration of a DataSetWriter and whether its DataSetMessages are processed.
+func (m *OPCUAPubSubInput) dataSetWriter(dataSetWriterID uint16) (PubSubDataSetWriter, bool) {
+	if len(m.DataSetWriters) == 0 {
+		return PubSubDataSetWriter{DataSetWriterID: dataSetWriterID}, true
+	}
+	for _, dataSetWriter := range m.DataSetWriters {
+		if dataSetWriter.DataSetWriterID == dataSetWriterID {
+			return dataSetWriter, true
+		}
+	}
+	return PubSubDataSetWriter{}, false
+}
+
+// Close closes the UDP socket or disconnects from the MQTT broker.
+func (m *OPCUAPubSubInput) Close(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.udpConn != nil {
+		if err := m.udpConn.Close(); err != nil {
+			m.Log.Infof("Closing the UDP socket failed: %v", err)
+		}
+		m.udpConn = nil
+	}
+
+	if m.mqttClient != nil {
+		m.mqttClient.Disconnect(250)
+		m.mqttClient = nil
+	}
+
+	if m.done != nil {
+		close(m.done)
+		m.done = nil
+	}
+
+	m.packets = nil
+	return nil
+}
+
+// statusIsBad reports whether a status code is bad.
+func statusIsBad(status ua.StatusCode) bool {
+	return status&0x80000000 != 0
+}
@@ -0,0 +1,320 @@
+package opcua_plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gopcua/opcua/ua"
+)
+
+// jsonNetworkMessage is a JSON encoded NetworkMessage (OPC UA Part 14, 7.2.5.3).
+type jsonNetworkMessage struct {
+	MessageID   string            `json:"MessageId"`
+	MessageType string            `json:"MessageType"`
+	PublisherID json.RawMessage   `json:"PublisherId"`
+	Messages    []json.RawMessage `json:"Messages"`
+}
+
+// jsonDataSetMessage is a JSON encoded DataSetMessage (OPC UA Part 14, 7.2.5.4).
+type jsonDataSetMessage struct {
+	DataSetWriterID   uint16          `json:"DataSetWriterId"`
+	DataSetWriterName string          `json:"DataSetWriterName"`
+	PublisherID       json.RawMessage `json:"PublisherId"`
+	SequenceNumber    uint32          `json:"SequenceNumber"`
+	Timestamp         time.Time       `json:"Timestamp"`
+	Status            json.RawMessage `json:"Status"`
+	MessageType       string          `json:"MessageType"`
+	Payload           json.RawMessage `json:"Payload"`
+}
+
+// jsonEncodedValueKeys are the keys of a Variant or DataValue in the reversible JSON encoding.
+// Objects that contain other keys are treated as structures.
+var jsonEncodedValueKeys = map[string]bool{
+	"Type": true, "Body": true, "Dimensions": true, // Variant (1.04)
+	"UaType": true, "Value": true, // Variant (1.05)
+	"Status": true, "StatusCode": true, "SourceTimestamp": true, "SourcePicoseconds": true, "ServerTimestamp": true, "ServerPicoseconds": true, // DataValue
+}
+
+// DecodeJSONNetworkMessage decodes a JSON encoded NetworkMessage.
+//
+// Besides complete NetworkMessages, a single DataSetMessage or an array of DataSetMessages is accepted,
+// as publishers can be configured to omit the NetworkMessage header. Field values can be encoded
+// non-reversibly (plain JSON values), as Variants or as DataValues.
+func DecodeJSONNetworkMessage(b []byte) (*PubSubNetworkMessage, error) {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return nil, errors.New("empty JSON NetworkMessage")
+	}
+
+	msg := &PubSubNetworkMessage{}
+	var rawDataSetMessages []json.RawMessage
+
+	switch {
+	case b[0] == '[':
+		if err := json.Unmarshal(b, &rawDataSetMessages); err != nil {
+			return nil, fmt.Errorf("decoding JSON DataSetMessages failed: %w", err)
+		}
+	case b[0] == '{':
+		var networkMessage jsonNetworkMessage
+		if err := json.Unmarshal(b, &networkMessage); err != nil {
+			return nil, fmt.Errorf("decoding JSON NetworkMessage failed: %w", err)
+		}
+		if networkMessage.Messages == nil {
+			// A single DataSetMessage without NetworkMessage header
+			rawDataSetMessages = []json.RawMessage{b}
+			break
+		}
+		if networkMessage.MessageType != "" && networkMessage.MessageType != "ua-data" {
+			return nil, fmt.Errorf("NetworkMessage type %s is not supported, only ua-data is", networkMessage.MessageType)
+		}
+		msg.PublisherID = jsonPublisherID(networkMessage.PublisherID)
+		rawDataSetMessages = networkMessage.Messages
+	default:
+		return nil, errors.New("JSON NetworkMessage is neither an object nor an array")
+	}
+
+	for i, rawDataSetMessage := range rawDataSetMessages {
+		dataSetMessage, publisherID, err := decodeJSONDataSetMessage(rawDataSetMessage)
+		if err != nil {
+			return nil, fmt.Errorf("decoding DataSetMessage %d failed: %w", i, err)
+		}
+		if msg.PublisherID == "" {
+			msg.PublisherID = publisherID
+		}
+		msg.DataSetMessages = append(msg.DataSetMessages, *dataSetMessage)
+	}
+
+	return msg, nil
+}
+
+// decodeJSONDataSetMessage decodes a single JSON encoded DataSetMessage and returns it together with
+// the PublisherId that is part of it if the NetworkMessage header is omitted.
+func decodeJSONDataSetMessage(b []byte) (*PubSubDataSetMessage, string, error) {
+	var raw jsonDataSetMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, "", err
+	}
+
+	msg := &PubSubDataSetMessage{
+		DataSetWriterID:   raw.DataSetWriterID,
+		DataSetWriterName: raw.DataSetWriterName,
+		SequenceNumber:    raw.SequenceNumber,
+		Timestamp:         raw.Timestamp,
+		Status:            jsonStatusCode(raw.Status),
+	}
+
+	switch raw.MessageType {
+	case "", "ua-keyframe":
+		msg.MessageType = DataSetMessageTypeKeyFrame
+	case "ua-deltaframe":
+		msg.MessageType = DataSetMessageTypeDeltaFrame
+	case "ua-event":
+		msg.MessageType = DataSetMessageTypeEvent
+	case "ua-keepalive":
+		msg.MessageType = DataSetMessageTypeKeepAlive
+		return msg, jsonPublisherID(raw.PublisherID), nil
+	default:
+		return nil, "", fmt.Errorf("unsupported DataSetMessage type %s", raw.MessageType)
+	}
+
+	names, values, err := decodeOrderedJSONObject(raw.Payload)
+	if err != nil {
+		return nil, "", fmt.Errorf("decoding payload failed: %w", err)
+	}
+
+	for i, name := range names {
+		field, err := decodeJSONField(values[i])
+		if err != nil {
+			return nil, "", fmt.Errorf("decoding field %s failed: %w", name, err)
+		}
+		field.Index = i
+		field.Name = name
+		msg.Fields = append(msg.Fields, field)
+	}
+
+	return msg, jsonPublisherID(raw.PublisherID), nil
+}
+
+// decodeOrderedJSONObject returns the keys and values of a JSON object in the order in which they appear.
+// The order matters, as it is the field index of the DataSet.
+func decodeOrderedJSONObject(b []byte) ([]string, []json.RawMessage, error) {
+	if len(bytes.TrimSpace(b)) == 0 || bytes.Equal(bytes.TrimSpace(b), []byte("null")) {
+		return nil, nil, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, nil, err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '{' {
+		return nil, nil, errors.New("expected a JSON object")
+	}
+
+	var keys []string
+	var values []json.RawMessage
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key, ok := token.(string)
+		if !ok {
+			return nil, nil, errors.New("expected a JSON object key")
+		}
+
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, nil, err
+		}
+
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+
+	return keys, values, nil
+}
+
+// decodeJSONField decodes a field value that is either a plain JSON value, a Variant or a DataValue.
+func decodeJSONField(b []byte) (PubSubField, error) {
+	var field PubSubField
+
+	value, err := decodeJSONValue(b)
+	if err != nil {
+		return field, err
+	}
+
+	object, ok := value.(map[string]any)
+	if !ok || !isJSONEncodedValue(object) {
+		field.Value = value
+		return field, nil
+	}
+
+	// Variant: 1.04 uses Type and Body, 1.05 uses UaType and Value. A DataValue contains the
+	// Variant either flattened (1.05) or in its Value field (1.04).
+	dataType, hasType := object["Type"]
+	if !hasType {
+		dataType, hasType = object["UaType"]
+	}
+	if body, ok := object["Body"]; ok {
+		field.Value = body
+	} else if nested, ok := object["Value"].(map[string]any); ok && isJSONEncodedValue(nested) {
+		nestedField, err := decodeJSONField(mustMarshalJSON(nested))
+		if err != nil {
+			return field, err
+		}
+		field.Value = nestedField.Value
+		field.DataType = nestedField.DataType
+	} else {
+		field.Value = object["Value"]
+	}
+
+	if hasType {
+		if typeID, err := strconv.Atoi(fmt.Sprint(dataType)); err == nil {
+			field.DataType = strings.TrimPrefix(ua.TypeID(typeID).String(), "TypeID")
+		}
+	}
+
+	if status, ok := object["StatusCode"]; ok {
+		field.Status = jsonStatusCode(mustMarshalJSON(status))
+	} else if status, ok := object["Status"]; ok {
+		field.Status = jsonStatusCode(mustMarshalJSON(status))
+	}
+
+	if ts, ok := object["SourceTimestamp"].(string); ok {
+		field.SourceTimestamp, _ = time.Parse(time.RFC3339Nano, ts)
+	}
+	if ts, ok := object["ServerTimestamp"].(string); ok {
+		field.ServerTimestamp, _ = time.Parse(time.RFC3339Nano, ts)
+	}
+
+	field.Value = jsonNumberValue(field.Value)
+	return field, nil
+}
+
+// decodeJSONValue decodes a JSON value while keeping numbers as json.Number.
+func decodeJSONValue(b []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return jsonNumberValue(value), nil
+}
+
+// jsonNumberValue converts a json.Number into an int64 if it is an integer and into a float64 otherwise,
+// so that it is written into the payload like the value of a binary encoded number.
+func jsonNumberValue(value any) any {
+	number, ok := value.(json.Number)
+	if !ok {
+		return value
+	}
+	if i, err := number.Int64(); err == nil {
+		return i
+	}
+	if u, err := strconv.ParseUint(number.String(), 10, 64); err == nil {
+		return u
+	}
+	if f, err := number.Float64(); err == nil {
+		return f
+	}
+	return number.String()
+}
+
+// isJSONEncodedValue reports whether a JSON object is a Variant or DataValue in the reversible encoding.
+func isJSONEncodedValue(object map[string]any) bool {
+	_, hasBody := object["Body"]
+	_, hasValue := object["Value"]
+	if !hasBody && !hasValue {
+		return false
+	}
+	for key := range object {
+		if !jsonEncodedValueKeys[key] {
+			return false
+		}
+	}
+	return true
+}
+
+// jsonPublisherID returns the PublisherId, which can be encoded as string or number, as string.
+func jsonPublisherID(b json.RawMessage) string {
+	if len(b) == 0 {
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		return s
+	}
+	return string(bytes.TrimSpace(b))
+}
+
+// jsonStatusCode decodes a StatusCode, which is either a number or an object with a Code field (1.05).
+func jsonStatusCode(b json.RawMessage) ua.StatusCode {
+	if len(b) == 0 {
+		return ua.StatusOK
+	}
+	var code uint32
+	if err := json.Unmarshal(b, &code); err == nil {
+		return ua.StatusCode(code)
+	}
+	var object struct {
+		Code uint32 `json:"Code"`
+	}
+	if err := json.Unmarshal(b, &object); err == nil {
+		return ua.StatusCode(object.Code)
+	}
+	return ua.StatusOK
+}
+
+// mustMarshalJSON marshals a value that was decoded from JSON before and can therefore always be marshalled.
+func mustMarshalJSON(value any) []byte {
+	b, _ := json.Marshal(value)
+	return b
+}
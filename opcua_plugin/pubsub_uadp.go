@@ -0,0 +1,320 @@
+package opcua_plugin
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gopcua/opcua/ua"
+)
+
+// UADP NetworkMessage flags (OPC UA Part 14, 7.2.4.4.2)
+const (
+	uadpFlagPublisherID     = 1 << 4
+	uadpFlagGroupHeader     = 1 << 5
+	uadpFlagPayloadHeader   = 1 << 6
+	uadpFlagExtendedFlags1  = 1 << 7
+	uadpExt1PublisherIDMask = 0x07
+	uadpExt1DataSetClassID  = 1 << 3
+	uadpExt1Security        = 1 << 4
+	uadpExt1Timestamp       = 1 << 5
+	uadpExt1PicoSeconds     = 1 << 6
+	uadpExt1ExtendedFlags2  = 1 << 7
+	uadpExt2Chunk           = 1 << 0
+	uadpExt2PromotedFields  = 1 << 1
+	uadpExt2MessageTypeMask = 0x1C
+
+	uadpGroupWriterGroupID        = 1 << 0
+	uadpGroupGroupVersion         = 1 << 1
+	uadpGroupNetworkMessageNumber = 1 << 2
+	uadpGroupSequenceNumber       = 1 << 3
+)
+
+// UADP DataSetMessage flags (OPC UA Part 14, 7.2.4.5.4)
+const (
+	uadpDataSetValid          = 1 << 0
+	uadpDataSetEncodingMask   = 0x06
+	uadpDataSetSequenceNumber = 1 << 3
+	uadpDataSetStatus         = 1 << 4
+	uadpDataSetMajorVersion   = 1 << 5
+	uadpDataSetMinorVersion   = 1 << 6
+	uadpDataSetFlags2         = 1 << 7
+	uadpDataSet2TypeMask      = 0x0F
+	uadpDataSet2Timestamp     = 1 << 4
+	uadpDataSet2PicoSeconds   = 1 << 5
+
+	uadpFieldEncodingVariant   = 0
+	uadpFieldEncodingRawData   = 1
+	uadpFieldEncodingDataValue = 2
+)
+
+// DataSetMessage types as they are reported in the opcua_pubsub_message_type metadata.
+const (
+	DataSetMessageTypeKeyFrame   = "keyframe"
+	DataSetMessageTypeDeltaFrame = "deltaframe"
+	DataSetMessageTypeEvent      = "event"
+	DataSetMessageTypeKeepAlive  = "keepalive"
+)
+
+// PubSubNetworkMessage is a decoded OPC UA PubSub NetworkMessage, independent of its encoding.
+type PubSubNetworkMessage struct {
+	PublisherID     string
+	WriterGroupID   uint16 // 0 if the NetworkMessage does not contain it (e.g., JSON)
+	SequenceNumber  uint16
+	Timestamp       time.Time
+	DataSetMessages []PubSubDataSetMessage
+}
+
+// PubSubDataSetMessage is a decoded DataSetMessage of a NetworkMessage.
+type PubSubDataSetMessage struct {
+	DataSetWriterID   uint16
+	DataSetWriterName string // only available in JSON
+	SequenceNumber    uint32
+	MessageType       string
+	Timestamp         time.Time
+	Status            ua.StatusCode
+	Fields            []PubSubField
+}
+
+// PubSubField is a single field of a DataSetMessage.
+type PubSubField struct {
+	Index           int
+	Name            string // only available in JSON, UADP only transports the field index
+	DataType        string // only available for binary encoded values
+	Value           any
+	Status          ua.StatusCode
+	SourceTimestamp time.Time
+	ServerTimestamp time.Time
+}
+
+// DecodeUADPNetworkMessage decodes a UADP encoded NetworkMessage.
+//
+// Only unsecured and unchunked NetworkMessages with DataSetMessages are supported. Fields in the
+// RawData encoding can not be decoded without the DataSetMetaData and are therefore rejected.
+func DecodeUADPNetworkMessage(b []byte) (*PubSubNetworkMessage, error) {
+	buf := ua.NewBuffer(b)
+	msg := &PubSubNetworkMessage{}
+
+	flags := buf.ReadByte()
+	if version := flags & 0x0F; buf.Error() == nil && version != 1 {
+		return nil, fmt.Errorf("unsupported UADP version %d", version)
+	}
+
+	var ext1, ext2 byte
+	if flags&uadpFlagExtendedFlags1 != 0 {
+		ext1 = buf.ReadByte()
+	}
+	if ext1&uadpExt1ExtendedFlags2 != 0 {
+		ext2 = buf.ReadByte()
+	}
+
+	if ext2&uadpExt2Chunk != 0 {
+		return nil, errors.New("chunked NetworkMessages are not supported")
+	}
+	if messageType := (ext2 & uadpExt2MessageTypeMask) >> 2; messageType != 0 {
+		return nil, fmt.Errorf("NetworkMessage type %d is not supported, only DataSetMessages are", messageType)
+	}
+
+	if flags&uadpFlagPublisherID != 0 {
+		switch ext1 & uadpExt1PublisherIDMask {
+		case 0:
+			msg.PublisherID = strconv.FormatUint(uint64(buf.ReadByte()), 10)
+		case 1:
+			msg.PublisherID = strconv.FormatUint(uint64(buf.ReadUint16()), 10)
+		case 2:
+			msg.PublisherID = strconv.FormatUint(uint64(buf.ReadUint32()), 10)
+		case 3:
+			msg.PublisherID = strconv.FormatUint(buf.ReadUint64(), 10)
+		case 4:
+			msg.PublisherID = buf.ReadString()
+		default:
+			return nil, fmt.Errorf("unsupported PublisherId type %d", ext1&uadpExt1PublisherIDMask)
+		}
+	}
+
+	if ext1&uadpExt1DataSetClassID != 0 {
+		buf.ReadN(16)
+	}
+
+	if flags&uadpFlagGroupHeader != 0 {
+		groupFlags := buf.ReadByte()
+		if groupFlags&uadpGroupWriterGroupID != 0 {
+			msg.WriterGroupID = buf.ReadUint16()
+		}
+		if groupFlags&uadpGroupGroupVersion != 0 {
+			buf.ReadUint32()
+		}
+		if groupFlags&uadpGroupNetworkMessageNumber != 0 {
+			buf.ReadUint16()
+		}
+		if groupFlags&uadpGroupSequenceNumber != 0 {
+			msg.SequenceNumber = buf.ReadUint16()
+		}
+	}
+
+	// Without payload header, the NetworkMessage contains a single DataSetMessage of an unknown DataSetWriter
+	dataSetWriterIDs := []uint16{0}
+	if flags&uadpFlagPayloadHeader != 0 {
+		count := buf.ReadByte()
+		dataSetWriterIDs = make([]uint16, count)
+		for i := range dataSetWriterIDs {
+			dataSetWriterIDs[i] = buf.ReadUint16()
+		}
+	}
+
+	if ext1&uadpExt1Timestamp != 0 {
+		msg.Timestamp = buf.ReadTime()
+	}
+	if ext1&uadpExt1PicoSeconds != 0 {
+		buf.ReadUint16()
+	}
+
+	if ext2&uadpExt2PromotedFields != 0 {
+		size := buf.ReadUint16()
+		buf.ReadN(int(size))
+	}
+
+	if ext1&uadpExt1Security != 0 {
+		return nil, errors.New("secured NetworkMessages are not supported")
+	}
+
+	// The sizes of the DataSetMessages are only sent if there is more than one
+	sizes := make([]uint16, len(dataSetWriterIDs))
+	if len(dataSetWriterIDs) > 1 {
+		for i := range sizes {
+			sizes[i] = buf.ReadUint16()
+		}
+	}
+
+	if err := buf.Error(); err != nil {
+		return nil, fmt.Errorf("decoding UADP NetworkMessage header failed: %w", err)
+	}
+
+	for i, dataSetWriterID := range dataSetWriterIDs {
+		var dataSetMessageBytes []byte
+		if len(dataSetWriterIDs) > 1 {
+			dataSetMessageBytes = buf.ReadN(int(sizes[i]))
+		} else {
+			dataSetMessageBytes = buf.ReadN(buf.Len())
+		}
+		if err := buf.Error(); err != nil {
+			return nil, fmt.Errorf("decoding DataSetMessage %d failed: %w", i, err)
+		}
+
+		dataSetMessage, err := decodeUADPDataSetMessage(dataSetMessageBytes)
+		if err != nil {
+			return nil, fmt.Errorf("decoding DataSetMessage of DataSetWriter %d failed: %w", dataSetWriterID, err)
+		}
+		dataSetMessage.DataSetWriterID = dataSetWriterID
+
+		msg.DataSetMessages = append(msg.DataSetMessages, *dataSetMessage)
+	}
+
+	return msg, nil
+}
+
+// decodeUADPDataSetMessage decodes a single UADP encoded DataSetMessage.
+func decodeUADPDataSetMessage(b []byte) (*PubSubDataSetMessage, error) {
+	buf := ua.NewBuffer(b)
+	msg := &PubSubDataSetMessage{MessageType: DataSetMessageTypeKeyFrame}
+
+	flags1 := buf.ReadByte()
+	var flags2 byte
+	if flags1&uadpDataSetFlags2 != 0 {
+		flags2 = buf.ReadByte()
+	}
+
+	if flags1&uadpDataSetSequenceNumber != 0 {
+		msg.SequenceNumber = uint32(buf.ReadUint16())
+	}
+	if flags2&uadpDataSet2Timestamp != 0 {
+		msg.Timestamp = buf.ReadTime()
+	}
+	if flags2&uadpDataSet2PicoSeconds != 0 {
+		buf.ReadUint16()
+	}
+	if flags1&uadpDataSetStatus != 0 {
+		// The status contains the high order 16 bits of the StatusCode
+		msg.Status = ua.StatusCode(uint32(buf.ReadUint16()) << 16)
+	}
+	if flags1&uadpDataSetMajorVersion != 0 {
+		buf.ReadUint32()
+	}
+	if flags1&uadpDataSetMinorVersion != 0 {
+		buf.ReadUint32()
+	}
+
+	if err := buf.Error(); err != nil {
+		return nil, err
+	}
+
+	if flags1&uadpDataSetValid == 0 {
+		return nil, errors.New("DataSetMessage is not valid")
+	}
+
+	switch flags2 & uadpDataSet2TypeMask {
+	case 0:
+		msg.MessageType = DataSetMessageTypeKeyFrame
+	case 1:
+		msg.MessageType = DataSetMessageTypeDeltaFrame
+	case 2:
+		msg.MessageType = DataSetMessageTypeEvent
+	case 3:
+		msg.MessageType = DataSetMessageTypeKeepAlive
+		return msg, nil
+	default:
+		return nil, fmt.Errorf("unsupported DataSetMessage type %d", flags2&uadpDataSet2TypeMask)
+	}
+
+	fieldEncoding := (flags1 & uadpDataSetEncodingMask) >> 1
+	if fieldEncoding == uadpFieldEncodingRawData {
+		return nil, errors.New("the RawData field encoding is not supported")
+	}
+
+	fieldCount := int(buf.ReadUint16())
+	for i := 0; i < fieldCount; i++ {
+		index := i
+		if msg.MessageType == DataSetMessageTypeDeltaFrame {
+			index = int(buf.ReadUint16())
+		}
+
+		field := PubSubField{Index: index}
+		switch fieldEncoding {
+		case uadpFieldEncodingVariant:
+			variant := new(ua.Variant)
+			buf.ReadStruct(variant)
+			field.Value = variant.Value()
+			field.DataType = variantDataType(variant)
+		case uadpFieldEncodingDataValue:
+			dataValue := new(ua.DataValue)
+			buf.ReadStruct(dataValue)
+			if dataValue.Value != nil {
+				field.Value = dataValue.Value.Value()
+				field.DataType = variantDataType(dataValue.Value)
+			}
+			field.Status = dataValue.Status
+			field.SourceTimestamp = dataValue.SourceTimestamp
+			field.ServerTimestamp = dataValue.ServerTimestamp
+		default:
+			return nil, fmt.Errorf("unsupported field encoding %d", fieldEncoding)
+		}
+
+		if err := buf.Error(); err != nil {
+			return nil, fmt.Errorf("decoding field %d failed: %w", index, err)
+		}
+
+		msg.Fields = append(msg.Fields, field)
+	}
+
+	return msg, nil
+}
+
+// variantDataType returns the name of the data type of a variant, e.g., Double.
+func variantDataType(variant *ua.Variant) string {
+	if variant == nil || variant.Type() == 0 {
+		return ""
+	}
+	return strings.TrimPrefix(variant.Type().String(), "TypeID")
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/gopcua/opcua"
 	"github.com/gopcua/opcua/ua"
 	"github.com/redpanda-data/benthos/v4/public/service"
+	"golang.org/x/sync/errgroup"
 )
 
 // createMessageFromValue constructs a Benthos message from a given OPC UA DataValue and NodeDef.
@@ -27,11 +28,107 @@ func (g *OPCUAInput) createMessageFromValue(dataValue *ua.DataValue, nodeDef Nod
 		return nil
 	}
 
+	b, tagType, err := formatPayload(variant.Value())
+	if err != nil {
+		g.Log.Errorf("Error marshaling to JSON: %v", err)
+		return nil
+	}
+
+	if b == nil {
+		g.Log.Errorf("Could not create benthos message as payload is empty for node %s: %v", nodeDef.NodeID.String(), b)
+		return nil
+	}
+
+	message := service.NewMessage(nil)
+
+	// Deprecated
+	message.MetaSet("opcua_path", sanitize(nodeDef.NodeID.String()))
+	message.MetaSet("opcua_tag_path", sanitize(nodeDef.BrowseName))
+	message.MetaSet("opcua_parent_path", sanitize(nodeDef.ParentNodeID))
+
+	// New ones
+	message.MetaSet("opcua_source_timestamp", dataValue.SourceTimestamp.Format("2006-01-02T15:04:05.000000Z07:00"))
+	message.MetaSet("opcua_server_timestamp", dataValue.ServerTimestamp.Format("2006-01-02T15:04:05.000000Z07:00"))
+	message.MetaSet("opcua_attr_nodeid", nodeDef.NodeID.String())
+	message.MetaSet("opcua_attr_nodeclass", nodeDef.NodeClass.String())
+	message.MetaSet("opcua_attr_browsename", nodeDef.BrowseName)
+	message.MetaSet("opcua_attr_description", nodeDef.Description)
+	message.MetaSet("opcua_attr_accesslevel", nodeDef.AccessLevel.String())
+	message.MetaSet("opcua_attr_datatype", nodeDef.DataType)
+
+	tagGroup, tagName := tagNames(nodeDef)
+
+	// if the node is the CurrentTime node, mark is as a heartbeat message
+	if g.HeartbeatNodeId != nil && nodeDef.NodeID.Namespace() == g.HeartbeatNodeId.Namespace() && nodeDef.NodeID.IntID() == g.HeartbeatNodeId.IntID() && g.UseHeartbeat {
+		message.MetaSet("opcua_heartbeat_message", "true")
+	}
+
+	message.MetaSet("opcua_tag_group", tagGroup)
+	message.MetaSet("opcua_tag_name", tagName)
+
+	message.MetaSet("opcua_tag_type", tagType)
+
+	if nodeDef.Config != nil {
+		for key, value := range nodeDef.Config.Metadata {
+			message.MetaSet(key, value)
+		}
+	}
+
+	// In the json payload format, the payload is a JSON document that carries the value with its native JSON type.
+	// The metadata stays the same for compatibility.
+	if g.PayloadFormat == PayloadFormatJSON {
+		jsonPayload, err := createJSONPayload(dataValue, nodeDef, tagGroup, tagName)
+		if err != nil {
+			g.Log.Errorf("Error marshaling to JSON: %v", err)
+			return nil
+		}
+		b = jsonPayload
+	}
+
+	message.SetBytes(b)
+
+	return message
+}
+
+// tagNames returns the opcua_tag_group and opcua_tag_name of a node.
+func tagNames(nodeDef NodeDef) (tagGroup string, tagName string) {
+	tagName = sanitize(nodeDef.BrowseName)
+
+	// Tag Group
+	tagGroup = nodeDef.Path
+	// remove nodeDef.BrowseName from tagGroup
+	tagGroup = strings.Replace(tagGroup, nodeDef.BrowseName, "", 1)
+	// remove trailing dot
+	tagGroup = strings.TrimSuffix(tagGroup, ".")
+
+	// Entries of the nodes list can rename the node itself and the first element of the tag group,
+	// which is the name of the node at which the browse started
+	if nodeDef.Config != nil && nodeDef.Config.TagGroup != "" {
+		if _, rest, found := strings.Cut(tagGroup, "."); found {
+			tagGroup = nodeDef.Config.TagGroup + "." + rest
+		} else {
+			tagGroup = nodeDef.Config.TagGroup
+		}
+	}
+	if nodeDef.TagName != "" {
+		tagName = nodeDef.TagName
+	}
+
+	if tagGroup == "" {
+		tagGroup = tagName
+	}
+
+	return tagGroup, tagName
+}
+
+// formatPayload converts a value into the payload of a message and returns it together with its tag type
+// ("number", "string" or "bool"). Values of unknown types are converted to JSON.
+func formatPayload(value any) ([]byte, string, error) {
 	b := make([]byte, 0)
 
 	var tagType string
 
-	switch v := variant.Value().(type) {
+	switch v := value.(type) {
 	case float32:
 		b = append(b, []byte(strconv.FormatFloat(float64(v), 'f', -1, 32))...)
 		tagType = "number"
@@ -78,59 +175,13 @@ func (g *OPCUAInput) createMessageFromValue(dataValue *ua.DataValue, nodeDef Nod
 		// Convert unknown types to JSON
 		jsonBytes, err := json.Marshal(v)
 		if err != nil {
-			g.Log.Errorf("Error marshaling to JSON: %v", err)
-			return nil
+			return nil, "", err
 		}
 		b = append(b, jsonBytes...)
 		tagType = "string"
 	}
 
-	if b == nil {
-		g.Log.Errorf("Could not create benthos message as payload is empty for node %s: %v", nodeDef.NodeID.String(), b)
-		return nil
-	}
-
-	message := service.NewMessage(b)
-
-	// Deprecated
-	message.MetaSet("opcua_path", sanitize(nodeDef.NodeID.String()))
-	message.MetaSet("opcua_tag_path", sanitize(nodeDef.BrowseName))
-	message.MetaSet("opcua_parent_path", sanitize(nodeDef.ParentNodeID))
-
-	// New ones
-	message.MetaSet("opcua_source_timestamp", dataValue.SourceTimestamp.Format("2006-01-02T15:04:05.000000Z07:00"))
-	message.MetaSet("opcua_server_timestamp", dataValue.ServerTimestamp.Format("2006-01-02T15:04:05.000000Z07:00"))
-	message.MetaSet("opcua_attr_nodeid", nodeDef.NodeID.String())
-	message.MetaSet("opcua_attr_nodeclass", nodeDef.NodeClass.String())
-	message.MetaSet("opcua_attr_browsename", nodeDef.BrowseName)
-	message.MetaSet("opcua_attr_description", nodeDef.Description)
-	message.MetaSet("opcua_attr_accesslevel", nodeDef.AccessLevel.String())
-	message.MetaSet("opcua_attr_datatype", nodeDef.DataType)
-
-	tagName := sanitize(nodeDef.BrowseName)
-
-	// Tag Group
-	tagGroup := nodeDef.Path
-	// remove nodeDef.BrowseName from tagGroup
-	tagGroup = strings.Replace(tagGroup, nodeDef.BrowseName, "", 1)
-	// remove trailing dot
-	tagGroup = strings.TrimSuffix(tagGroup, ".")
-
-	// if the node is the CurrentTime node, mark is as a heartbeat message
-	if g.HeartbeatNodeId != nil && nodeDef.NodeID.Namespace() == g.HeartbeatNodeId.Namespace() && nodeDef.NodeID.IntID() == g.HeartbeatNodeId.IntID() && g.UseHeartbeat {
-		message.MetaSet("opcua_heartbeat_message", "true")
-	}
-
-	if tagGroup == "" {
-		tagGroup = tagName
-	}
-
-	message.MetaSet("opcua_tag_group", tagGroup)
-	message.MetaSet("opcua_tag_name", tagName)
-
-	message.MetaSet("opcua_tag_type", tagType)
-
-	return message
+	return b, tagType, nil
 }
 
 // Read performs a synchronous read operation on the OPC UA server using the provided ReadRequest.
@@ -138,30 +189,31 @@ func (g *OPCUAInput) createMessageFromValue(dataValue *ua.DataValue, nodeDef Nod
 // This function sends a ReadRequest to the OPC UA server and handles the response. It manages
 // specific error conditions by closing the current session and signaling that the client is
 // no longer connected, prompting reconnection attempts if necessary. Successful reads return
-// the ReadResponse, while errors are appropriately logged and propagated.
+// the ReadResponse, while errors are appropriately logged and propagated. The status of the
+// single results is not checked, so that callers can skip the nodes that could not be read.
 func (g *OPCUAInput) Read(ctx context.Context, req *ua.ReadRequest) (*ua.ReadResponse, error) {
-	resp, err := g.Client.Read(ctx, req)
+	resp, err := g.read(ctx, g.Client, req)
+	if errors.Is(err, service.ErrNotConnected) {
+		_ = g.Close(ctx)
+	}
+	return resp, err
+}
+
+// read sends the ReadRequest with the given client. Unlike Read, it does not close the connection,
+// so that it can be called concurrently. Errors that require a reconnect are returned as
+// service.ErrNotConnected, and the caller has to close the connection.
+func (g *OPCUAInput) read(ctx context.Context, client *opcua.Client, req *ua.ReadRequest) (*ua.ReadResponse, error) {
+	resp, err := client.Read(ctx, req)
 	if err != nil {
 		g.Log.Errorf("Read failed: %s", err)
 		// if the error is StatusBadSessionIDInvalid, the session has been closed, and we need to reconnect.
 		switch {
-		case errors.Is(err, ua.StatusBadSessionIDInvalid):
-			_ = g.Close(ctx)
-			return nil, service.ErrNotConnected
-		case errors.Is(err, ua.StatusBadCommunicationError):
-			_ = g.Close(ctx)
-			return nil, service.ErrNotConnected
-		case errors.Is(err, ua.StatusBadConnectionClosed):
-			_ = g.Close(ctx)
-			return nil, service.ErrNotConnected
-		case errors.Is(err, ua.StatusBadTimeout):
-			_ = g.Close(ctx)
-			return nil, service.ErrNotConnected
-		case errors.Is(err, ua.StatusBadConnectionRejected):
-			_ = g.Close(ctx)
-			return nil, service.ErrNotConnected
-		case errors.Is(err, ua.StatusBadServerNotConnected):
-			_ = g.Close(ctx)
+		case errors.Is(err, ua.StatusBadSessionIDInvalid),
+			errors.Is(err, ua.StatusBadCommunicationError),
+			errors.Is(err, ua.StatusBadConnectionClosed),
+			errors.Is(err, ua.StatusBadTimeout),
+			errors.Is(err, ua.StatusBadConnectionRejected),
+			errors.Is(err, ua.StatusBadServerNotConnected):
 			return nil, service.ErrNotConnected
 		}
 
@@ -169,133 +221,202 @@ func (g *OPCUAInput) Read(ctx context.Context, req *ua.ReadRequest) (*ua.ReadRes
 		return nil, err
 	}
 
-	if !errors.Is(resp.Results[0].Status, ua.StatusOK) {
-		g.Log.Errorf("Status not OK: %v", resp.Results[0].Status)
-		return nil, fmt.Errorf("status not OK: %v", resp.Results[0].Status)
-	}
-
 	return resp, nil
 }
 
 // ReadBatchPull performs a batch read of all OPC UA nodes in the NodeList using a pull method.
 //
-// This function constructs a ReadRequest encompassing all nodes to be read and sends it to the OPC UA server.
-// It processes the response by converting each DataValue into a Benthos message using `createMessageFromValue`.
-// The function introduces a brief pause before returning the messages to ensure system stability.
+// This function waits until the next poll is due, then splits the NodeList into chunks that respect the
+// server's MaxNodesPerRead operation limit and reads them, optionally in parallel (see `readChunks`).
+// The results are merged back into NodeList order and each DataValue is converted into a Benthos message
+// using `createMessageFromValue`.
 func (g *OPCUAInput) ReadBatchPull(ctx context.Context) (service.MessageBatch, service.AckFunc, error) {
 	if g.Client == nil {
 		return nil, nil, errors.New("client is nil")
 	}
-	// Read all values in NodeList and return each of them as a message with the node's path as the metadata
 
-	// Create first a list of all the values to read
-	var nodesToRead []*ua.ReadValueID
+	if err := g.waitForNextPoll(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	// Read all polled values in NodeList and return each of them as a message with the node's path as the metadata
+	allNodes, registeredNodeIDs := g.nodes()
+	nodeList := g.nodesInMode(allNodes, NodeModePoll)
 
-	for _, node := range g.NodeList {
+	// Create first a list of all the values to read
+	// If the nodes were registered, their handles are read instead of the original NodeIDs
+	nodesToRead := make([]*ua.ReadValueID, 0, len(nodeList))
+	for _, nodeID := range readNodeIDs(nodeList, registeredNodeIDs) {
 		nodesToRead = append(nodesToRead, &ua.ReadValueID{
-			NodeID: node.NodeID,
+			NodeID: nodeID,
 		})
 	}
 
-	if len(g.NodeList) > 100 {
-		g.Log.Warnf("Reading more than 100 nodes with pull method. The request might fail as it can take too much time. Recommendation: use subscribeEnabled: true instead for better performance")
-	}
-
-	req := &ua.ReadRequest{
-		MaxAge:             2000,
-		NodesToRead:        nodesToRead,
-		TimestampsToReturn: ua.TimestampsToReturnBoth,
+	results, err := g.readChunks(ctx, nodesToRead)
+	if errors.Is(err, service.ErrNotConnected) {
+		_ = g.Close(ctx)
 	}
-
-	resp, err := g.Read(ctx, req)
 	if err != nil {
 		g.Log.Errorf("Read failed: %s", err)
+		return nil, nil, err
 	}
 
 	// Create a message with the node's path as the metadata
 	msgs := service.MessageBatch{}
 
-	for i, node := range g.NodeList {
-		value := resp.Results[i]
+	for i, node := range nodeList {
+		value := results[i]
 		if value == nil || value.Value == nil {
 			g.Log.Debugf("Received nil in item structure on node %s. This can occur when subscribing to an OPC UA folder and may be ignored.", node.NodeID.String())
 			continue
 		}
+		if value.Status != ua.StatusOK {
+			g.Log.Warnf("Skipping node %s, as its value could not be read: %v", node.NodeID.String(), value.Status)
+			continue
+		}
 		message := g.createMessageFromValue(value, node)
 		if message != nil {
 			msgs = append(msgs, message)
 		}
 	}
 
-	// Wait for a second before returning a message.
-	time.Sleep(time.Second)
-
 	return msgs, func(ctx context.Context, err error) error {
 		// Nacks are retried automatically when we use service.AutoRetryNacks
 		return nil
 	}, nil
 }
 
-// ReadBatchSubscribe handles batch reads of OPC UA nodes using the subscription mechanism.
+// waitForNextPoll blocks until the next pull read is due.
 //
-// This function listens for subscription notifications on `SubNotifyChan`. Upon receiving data changes,
-// it converts each monitored item's value into a Benthos message using `createMessageFromValue`. It also
-// manages context cancellations and timeouts, ensuring that subscription operations are gracefully
-// terminated when needed.
-func (g *OPCUAInput) ReadBatchSubscribe(ctx context.Context) (service.MessageBatch, service.AckFunc, error) {
-	var res *opcua.PublishNotificationData
-
-	if ctx == nil || ctx.Done() == nil {
-		return nil, nil, errors.New("emptyCtx is invalid for ReadBatchSubscribe")
+// The schedule is anchored to the time of the first poll, so the time spent reading does not add up
+// to the poll interval. If a read takes longer than the poll interval, the missed polls are skipped
+// instead of being executed in a burst.
+func (g *OPCUAInput) waitForNextPoll(ctx context.Context) error {
+	now := time.Now()
+	if g.nextPoll.IsZero() {
+		g.nextPoll = now
 	}
-	select {
-	case res = <-g.SubNotifyChan:
-		// Received a result, check for error
-		if res.Error != nil {
-			g.Log.Errorf("ReadBatchSubscribe error: %s", res.Error)
-			return nil, nil, res.Error
-		}
 
-		if g.NodeList == nil {
-			g.Log.Errorf("nodelist is nil")
-			return nil, nil, errors.New("nodelist empty")
+	if wait := g.nextPoll.Sub(now); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
 		}
+	}
 
-		// Create a message with the node's path as the metadata
-		msgs := service.MessageBatch{}
+	g.nextPoll = NextPollTime(g.nextPoll, time.Now(), g.pollRate())
+	return nil
+}
 
-		switch x := res.Value.(type) {
-		case *ua.DataChangeNotification:
-			for _, item := range x.MonitoredItems {
-				if item == nil || item.Value == nil || item.Value.Value == nil {
-					g.Log.Debugf("Received nil in item structure. This can occur when subscribing to an OPC UA folder and may be ignored.")
-					continue
-				}
+// pollRate returns the configured poll interval or DefaultPollRate if none is set.
+func (g *OPCUAInput) pollRate() time.Duration {
+	if g.PollRate > 0 {
+		return time.Duration(g.PollRate) * time.Millisecond
+	}
+	return DefaultPollRate
+}
 
-				// now get the handle id, which is the position in g.Nodelist
-				// see also NewMonitoredItemCreateRequestWithDefaults call in other functions
-				handleID := item.ClientHandle
+// NextPollTime calculates when the poll after the one scheduled at `scheduled` is due.
+// Polls that would already be in the past at `now` are skipped, while the result stays aligned
+// to the original schedule.
+func NextPollTime(scheduled time.Time, now time.Time, interval time.Duration) time.Time {
+	next := scheduled.Add(interval)
+	if next.After(now) {
+		return next
+	}
+	missed := now.Sub(next)/interval + 1
+	return next.Add(missed * interval)
+}
 
-				if uint32(len(g.NodeList)) >= handleID {
-					message := g.createMessageFromValue(item.Value, g.NodeList[handleID])
-					if message != nil {
-						msgs = append(msgs, message)
-					}
-				}
+// readChunks reads the given nodes in chunks of at most maxNodesPerRead nodes and returns
+// the DataValues in the same order as nodesToRead.
+//
+// Up to ReadConcurrency chunks are read in parallel. If the server rejects a chunk with
+// BadTooManyOperations or BadTcpMessageTooLarge (e.g., because it announced no or a wrong limit),
+// the chunk is split in half and retried. After the first error, no further chunks are started. The
+// connection is not closed here; errors that require a reconnect are returned as service.ErrNotConnected
+// once all chunks have finished.
+func (g *OPCUAInput) readChunks(ctx context.Context, nodesToRead []*ua.ReadValueID) ([]*ua.DataValue, error) {
+	results := make([]*ua.DataValue, len(nodesToRead))
+	chunks := SplitIntoChunks(len(nodesToRead), g.maxNodesPerRead())
+
+	concurrency := g.ReadConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	// All chunks are read with the same client, as the connection is only closed after all of them finished
+	client := g.Client
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(concurrency)
+
+	for _, chunk := range chunks {
+		if groupCtx.Err() != nil {
+			break
+		}
+		start, end := chunk[0], chunk[1]
+		group.Go(func() error {
+			return g.readChunk(groupCtx, client, nodesToRead, results, start, end)
+		})
+	}
+
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// readChunk reads nodesToRead[start:end] and stores the DataValues in results[start:end].
+func (g *OPCUAInput) readChunk(ctx context.Context, client *opcua.Client, nodesToRead []*ua.ReadValueID, results []*ua.DataValue, start, end int) error {
+	req := &ua.ReadRequest{
+		MaxAge:             2000,
+		NodesToRead:        nodesToRead[start:end],
+		TimestampsToReturn: ua.TimestampsToReturnBoth,
+	}
+
+	resp, err := g.read(ctx, client, req)
+	if err != nil {
+		if end-start > 1 && (errors.Is(err, ua.StatusBadTooManyOperations) || errors.Is(err, ua.StatusBadTCPMessageTooLarge)) {
+			middle := start + (end-start)/2
+			g.Log.Warnf("Server rejected reading %d nodes at once (%v). Retrying with smaller chunks.", end-start, err)
+			if err := g.readChunk(ctx, client, nodesToRead, results, start, middle); err != nil {
+				return err
 			}
-		default:
-			g.Log.Errorf("Unknown publish result %T", res.Value)
+			return g.readChunk(ctx, client, nodesToRead, results, middle, end)
 		}
+		return err
+	}
 
-		return msgs, func(ctx context.Context, err error) error {
-			// Nacks are retried automatically when we use service.AutoRetryNacks
-			return nil
-		}, nil
+	if len(resp.Results) != end-start {
+		return fmt.Errorf("expected %d results, got %d", end-start, len(resp.Results))
+	}
 
-	case <-ctx.Done():
+	copy(results[start:end], resp.Results)
+	return nil
+}
+
+// ReadBatchSubscribe handles batch reads of OPC UA nodes using the subscription mechanism.
+//
+// This function listens for notifications of all subscriptions on `SubNotifyChans`, pending notifications of
+// subscriptions with a higher priority first. Upon receiving data changes,
+// it converts each monitored item's value into a Benthos message using `createMessageFromValue`. It also
+// manages context cancellations and timeouts, ensuring that subscription operations are gracefully
+// terminated when needed.
+func (g *OPCUAInput) ReadBatchSubscribe(ctx context.Context) (service.MessageBatch, service.AckFunc, error) {
+	if ctx == nil || ctx.Done() == nil {
+		return nil, nil, errors.New("emptyCtx is invalid for ReadBatchSubscribe")
+	}
+
+	subscriptionName, res := g.receiveNotification(ctx)
+	if res == nil {
 		// Check why the context was done
 		err := ctx.Err()
-		if errors.Is(err, context.DeadlineExceeded) {
+		if errors.Is(err, context.DeadlineExceeded) && g.usesMode(NodeModePoll) {
+			g.Log.Debugf("Subscribe timeout: the next poll is due")
+		} else if errors.Is(err, context.DeadlineExceeded) {
 			g.Log.Warnf("Subscribe timeout: this will happen if the server does not send any data updates within %v", SubscribeTimeoutContext)
 		} else if errors.Is(err, context.Canceled) {
 			g.Log.Warnf("Subscribe canceled: operation was manually canceled")
@@ -304,4 +425,74 @@ func (g *OPCUAInput) ReadBatchSubscribe(ctx context.Context) (service.MessageBat
 		}
 		return nil, nil, err
 	}
+
+	// Received a result, check for error
+	if res.Error != nil {
+		g.Log.Errorf("ReadBatchSubscribe error: %s", res.Error)
+		return nil, nil, res.Error
+	}
+
+	nodeList, _ := g.nodes()
+	if nodeList == nil {
+		g.Log.Errorf("nodelist is nil")
+		return nil, nil, errors.New("nodelist empty")
+	}
+
+	// Create a message with the node's path as the metadata
+	msgs := service.MessageBatch{}
+
+	switch x := res.Value.(type) {
+	case *ua.DataChangeNotification:
+		var triggerItems []*ua.MonitoredItemNotification
+		var attributeItems []*ua.MonitoredItemNotification
+
+		for _, item := range x.MonitoredItems {
+			// items of trigger groups are processed together after all other items, see trigger.go
+			if item != nil && item.ClientHandle >= triggerHandleBase {
+				triggerItems = append(triggerItems, item)
+				continue
+			}
+
+			// items of attribute snapshots are processed together after all other items, see attributes.go
+			if item != nil && item.ClientHandle >= attributeHandleBase {
+				attributeItems = append(attributeItems, item)
+				continue
+			}
+
+			if item == nil || item.Value == nil || item.Value.Value == nil {
+				g.Log.Debugf("Received nil in item structure. This can occur when subscribing to an OPC UA folder and may be ignored.")
+				continue
+			}
+
+			// now get the handle id, which is the position in g.Nodelist
+			// see also NewMonitoredItemCreateRequestWithDefaults call in other functions
+			handleID := item.ClientHandle
+
+			if handleID < uint32(len(nodeList)) {
+				message := g.createMessageFromValue(item.Value, nodeList[handleID])
+				if message != nil {
+					msgs = append(msgs, message)
+				}
+			}
+		}
+
+		if len(attributeItems) > 0 {
+			msgs = append(msgs, g.handleAttributeNotifications(attributeItems)...)
+		}
+
+		if len(triggerItems) > 0 {
+			msgs = append(msgs, g.handleTriggerNotifications(ctx, triggerItems)...)
+		}
+	default:
+		g.Log.Errorf("Unknown publish result %T", res.Value)
+	}
+
+	for _, msg := range msgs {
+		msg.MetaSet("opcua_subscription", subscriptionName)
+	}
+
+	return msgs, func(ctx context.Context, err error) error {
+		// Nacks are retried automatically when we use service.AutoRetryNacks
+		return nil
+	}, nil
 }
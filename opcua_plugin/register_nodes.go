@@ -0,0 +1,121 @@
+package opcua_plugin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gopcua/opcua/ua"
+)
+
+// DefaultMaxNodesPerRegisterNodes is used when the server does not announce a MaxNodesPerRegisterNodes limit.
+const DefaultMaxNodesPerRegisterNodes = 1000
+
+// maxNodesPerRegisterNodes returns the number of nodes that can be registered in a single call.
+func (g *OPCUAInput) maxNodesPerRegisterNodes() int {
+	return effectiveLimit(0, g.OperationLimits.MaxNodesPerRegisterNodes, DefaultMaxNodesPerRegisterNodes)
+}
+
+// RegisterNodes registers the NodeIDs of the given nodes at the server and returns the handles
+// in the same order as the nodes.
+//
+// Registering nodes allows the server to resolve (long) string identifiers only once and to
+// return a handle (usually a numeric NodeID) that is much cheaper to read. This is only valid
+// for the lifetime of the session, so the handles need to be registered again after a reconnect.
+func (g *OPCUAInput) RegisterNodes(ctx context.Context, nodes []NodeDef) ([]*ua.NodeID, error) {
+	if g.Client == nil {
+		return nil, fmt.Errorf("client is nil")
+	}
+
+	registeredNodeIDs := make([]*ua.NodeID, 0, len(nodes))
+
+	for _, chunk := range SplitIntoChunks(len(nodes), g.maxNodesPerRegisterNodes()) {
+		nodesToRegister := make([]*ua.NodeID, 0, chunk[1]-chunk[0])
+		for _, node := range nodes[chunk[0]:chunk[1]] {
+			nodesToRegister = append(nodesToRegister, node.NodeID)
+		}
+
+		resp, err := g.Client.RegisterNodes(ctx, &ua.RegisterNodesRequest{
+			NodesToRegister: nodesToRegister,
+		})
+		if err != nil {
+			// Do not leave the already registered nodes behind on the server
+			g.UnregisterNodes(ctx, registeredNodeIDs)
+			return nil, fmt.Errorf("registering nodes %d to %d failed: %w", chunk[0], chunk[1]-1, err)
+		}
+
+		if len(resp.RegisteredNodeIDs) != len(nodesToRegister) {
+			g.UnregisterNodes(ctx, append(registeredNodeIDs, resp.RegisteredNodeIDs...))
+			return nil, fmt.Errorf("expected %d registered nodes, got %d", len(nodesToRegister), len(resp.RegisteredNodeIDs))
+		}
+
+		registeredNodeIDs = append(registeredNodeIDs, resp.RegisteredNodeIDs...)
+	}
+
+	return registeredNodeIDs, nil
+}
+
+// UnregisterNodes releases handles that were previously returned by RegisterNodes.
+// Errors are only logged, as the handles are released by the server anyway when the session ends.
+func (g *OPCUAInput) UnregisterNodes(ctx context.Context, registeredNodeIDs []*ua.NodeID) {
+	if g.Client == nil || len(registeredNodeIDs) == 0 {
+		return
+	}
+
+	for _, chunk := range SplitIntoChunks(len(registeredNodeIDs), g.maxNodesPerRegisterNodes()) {
+		_, err := g.Client.UnregisterNodes(ctx, &ua.UnregisterNodesRequest{
+			NodesToUnregister: registeredNodeIDs[chunk[0]:chunk[1]],
+		})
+		if err != nil {
+			g.Log.Infof("Failed to unregister nodes %d to %d: %v", chunk[0], chunk[1]-1, err)
+			return
+		}
+	}
+}
+
+// registerNodesIfNeeded registers the nodes for pull mode if this was enabled by the user and returns their handles.
+// If the server does not support registering nodes, nil is returned and the original NodeIDs are used instead.
+func (g *OPCUAInput) registerNodesIfNeeded(ctx context.Context, nodeList []NodeDef) []*ua.NodeID {
+	nodeList = g.nodesInMode(nodeList, NodeModePoll)
+	if !g.UseRegisteredNodes || len(nodeList) == 0 {
+		return nil
+	}
+
+	registeredNodeIDs, err := g.RegisterNodes(ctx, nodeList)
+	if err != nil {
+		g.Log.Warnf("Failed to register nodes, reading them by their NodeIDs instead: %v", err)
+		return nil
+	}
+
+	g.Log.Infof("Registered %d nodes", len(registeredNodeIDs))
+	return registeredNodeIDs
+}
+
+// setNodes publishes the browsed nodes together with the handles of their registered NodeIDs.
+// Both are set at once, so that ReadBatch never sees handles that belong to another node list.
+func (g *OPCUAInput) setNodes(nodeList []NodeDef, registeredNodeIDs []*ua.NodeID) {
+	g.nodeMu.Lock()
+	defer g.nodeMu.Unlock()
+	g.NodeList = nodeList
+	g.RegisteredNodeIDs = registeredNodeIDs
+}
+
+// nodes returns the browsed nodes and the handles of their registered NodeIDs.
+func (g *OPCUAInput) nodes() ([]NodeDef, []*ua.NodeID) {
+	g.nodeMu.RLock()
+	defer g.nodeMu.RUnlock()
+	return g.NodeList, g.RegisteredNodeIDs
+}
+
+// readNodeIDs returns the NodeIDs that should be used to read the nodes of nodeList.
+// These are the registered handles if they exist and match nodeList, otherwise the original NodeIDs.
+func readNodeIDs(nodeList []NodeDef, registeredNodeIDs []*ua.NodeID) []*ua.NodeID {
+	nodeIDs := make([]*ua.NodeID, 0, len(nodeList))
+	for i, node := range nodeList {
+		if len(registeredNodeIDs) == len(nodeList) && registeredNodeIDs[i] != nil {
+			nodeIDs = append(nodeIDs, registeredNodeIDs[i])
+			continue
+		}
+		nodeIDs = append(nodeIDs, node.NodeID)
+	}
+	return nodeIDs
+}
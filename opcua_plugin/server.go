@@ -0,0 +1,506 @@
+package opcua_plugin
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopcua/opcua/ua"
+	"github.com/gopcua/opcua/uacp"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+const (
+	// DefaultServerMaxSessions is the number of sessions the OPC UA server accepts at the same time.
+	DefaultServerMaxSessions = 100
+	// transportProfileBinary is the transport profile of opc.tcp endpoints.
+	transportProfileBinary = "http://opcfoundation.org/UA-Profile/Transport/uatcp-uasc-uabinary"
+	// serverHandshakeTimeout limits the time a client has to send the Hello message.
+	serverHandshakeTimeout = 10 * time.Second
+)
+
+// OPCUAServerConfig configures an OPCUAServer.
+type OPCUAServerConfig struct {
+	// Endpoint is the opc.tcp URL the server listens on, e.g., opc.tcp://0.0.0.0:4840.
+	// Use port 0 to listen on a random free port.
+	Endpoint        string
+	ApplicationName string
+	ApplicationURI  string
+	NamespaceURI    string
+
+	// Certificate (DER) and PrivateKey of the server. Clients encrypt their password with the certificate,
+	// so they are required if Username is set.
+	Certificate []byte
+	PrivateKey  *rsa.PrivateKey
+
+	// If Username is set, clients have to log in with Username and Password instead of anonymously.
+	// Passwords are only accepted if they are encrypted with the security policy Basic256Sha256.
+	Username string
+	Password string
+
+	// AllowAnonymousWrites accepts writes of clients that did not log in, i.e., of all clients if Username is
+	// not set. Otherwise, these writes are rejected with BadUserAccessDenied.
+	AllowAnonymousWrites bool
+
+	Limits      OperationLimits
+	MaxSessions int
+
+	// OnWrite is called before a client writes a variable. Returning a bad status code rejects the write.
+	// Without OnWrite, all writes to writable variables are accepted.
+	OnWrite func(node *ServerNode, dv *ua.DataValue) ua.StatusCode
+
+	Log *service.Logger
+}
+
+// OPCUAServer is a minimal OPC UA server implementing the binary opc.tcp protocol.
+// It supports the discovery, session, attribute, view and subscription services that are needed by
+// OPC UA clients to browse, read, write and subscribe to the variables in its AddressSpace.
+// It only offers the security policy None, as it does not implement signing and encrypting messages.
+type OPCUAServer struct {
+	cfg          OPCUAServerConfig
+	addressSpace *AddressSpace
+	certificate  []byte
+	privateKey   *rsa.PrivateKey
+	endpoints    []*ua.EndpointDescription
+
+	listener    net.Listener
+	endpointURL string
+	log         *service.Logger
+
+	mu            sync.Mutex
+	channels      map[uint32]*serverChannel
+	sessions      map[string]*serverSession
+	nextChannelID uint32
+	nextTokenID   uint32
+	nextSessionID uint32
+	nextSubID     uint32
+
+	// watchers holds the monitored items by the NodeID of their node
+	watchMu  sync.RWMutex
+	watchers map[string]map[*serverMonitoredItem]struct{}
+
+	closing   chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+
+	// The hooks let the tests inject faults, see export_test.go. They are set before Start.
+	onCreateMonitoredItem func(node *ServerNode, item *ua.ReadValueID) ua.StatusCode
+	onSetTriggering       func(node *ServerNode) ua.StatusCode
+	onRegisterNodes       func(nodeIDs []*ua.NodeID, register bool)
+}
+
+// NewOPCUAServer validates the configuration and creates an OPC UA server. Call Start to start listening.
+func NewOPCUAServer(cfg OPCUAServerConfig) (*OPCUAServer, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.New("endpoint must be set")
+	}
+	if cfg.ApplicationURI == "" {
+		cfg.ApplicationURI = "urn:benthos-umh:server"
+	}
+	if cfg.ApplicationName == "" {
+		cfg.ApplicationName = "benthos-umh"
+	}
+	if cfg.NamespaceURI == "" {
+		cfg.NamespaceURI = "urn:benthos-umh:opcua-server"
+	}
+	if cfg.MaxSessions <= 0 {
+		cfg.MaxSessions = DefaultServerMaxSessions
+	}
+	if cfg.Limits.MaxNodesPerRead == 0 {
+		cfg.Limits.MaxNodesPerRead = DefaultMaxNodesPerRead
+	}
+	if cfg.Limits.MaxNodesPerRegisterNodes == 0 {
+		cfg.Limits.MaxNodesPerRegisterNodes = DefaultMaxNodesPerRead
+	}
+	if cfg.Limits.MaxMonitoredItemsPerCall == 0 {
+		cfg.Limits.MaxMonitoredItemsPerCall = DefaultMaxMonitoredItemsPerCall
+	}
+
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || u.Scheme != "opc.tcp" || u.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %q, expected opc.tcp://host:port", cfg.Endpoint)
+	}
+
+	if cfg.Username != "" && (cfg.Certificate == nil || cfg.PrivateKey == nil) {
+		return nil, errors.New("username requires a server certificate and private key to encrypt passwords")
+	}
+
+	s := &OPCUAServer{
+		cfg:          cfg,
+		addressSpace: NewAddressSpace(cfg.ApplicationURI, cfg.NamespaceURI, cfg.ApplicationName, cfg.Limits),
+		certificate:  cfg.Certificate,
+		privateKey:   cfg.PrivateKey,
+		log:          cfg.Log,
+		channels:     make(map[uint32]*serverChannel),
+		sessions:     make(map[string]*serverSession),
+		watchers:     make(map[string]map[*serverMonitoredItem]struct{}),
+		closing:      make(chan struct{}),
+	}
+	s.addressSpace.onChange = s.notifyChange
+	return s, nil
+}
+
+// userTokenPolicyURI is the security policy that clients use to encrypt their password.
+const userTokenPolicyURI = ua.SecurityPolicyURIBasic256Sha256
+
+// AddressSpace returns the address space of the server.
+func (s *OPCUAServer) AddressSpace() *AddressSpace {
+	return s.addressSpace
+}
+
+// EndpointURL returns the URL of the server. If the configured port was 0, it contains the actual port.
+func (s *OPCUAServer) EndpointURL() string {
+	return s.endpointURL
+}
+
+// Endpoints returns the endpoint descriptions that the server offers.
+func (s *OPCUAServer) Endpoints() []*ua.EndpointDescription {
+	return s.endpoints
+}
+
+// Start starts listening for client connections.
+func (s *OPCUAServer) Start() error {
+	u, err := url.Parse(s.cfg.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", u.Host)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", u.Host, err)
+	}
+	s.listener = listener
+
+	// Replace the port, as the listener might have picked a random free port
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	u.Host = net.JoinHostPort(u.Hostname(), port)
+	s.endpointURL = u.String()
+	s.endpoints = s.buildEndpoints(s.endpointURL)
+
+	s.wg.Add(2)
+	go s.acceptLoop()
+	go s.sessionJanitor()
+
+	s.log.Infof("OPC UA server listening on %s", s.endpointURL)
+	return nil
+}
+
+// Close stops the server and closes all client connections.
+func (s *OPCUAServer) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closing)
+		if s.listener != nil {
+			_ = s.listener.Close()
+		}
+
+		s.mu.Lock()
+		channels := make([]*serverChannel, 0, len(s.channels))
+		for _, ch := range s.channels {
+			channels = append(channels, ch)
+		}
+		s.mu.Unlock()
+
+		for _, ch := range channels {
+			ch.close()
+		}
+	})
+	s.wg.Wait()
+	return nil
+}
+
+// buildEndpoints creates the endpoint description of the server, which only offers the security policy None.
+func (s *OPCUAServer) buildEndpoints(endpointURL string) []*ua.EndpointDescription {
+	return []*ua.EndpointDescription{{
+		EndpointURL:         endpointURL,
+		Server:              s.applicationDescription(endpointURL),
+		ServerCertificate:   s.certificate,
+		SecurityMode:        ua.MessageSecurityModeNone,
+		SecurityPolicyURI:   ua.SecurityPolicyURINone,
+		UserIdentityTokens:  s.userTokenPolicies(),
+		TransportProfileURI: transportProfileBinary,
+	}}
+}
+
+// applicationDescription describes the server application.
+func (s *OPCUAServer) applicationDescription(endpointURL string) *ua.ApplicationDescription {
+	return &ua.ApplicationDescription{
+		ApplicationURI:  s.cfg.ApplicationURI,
+		ProductURI:      s.cfg.ApplicationURI,
+		ApplicationName: ua.NewLocalizedText(s.cfg.ApplicationName),
+		ApplicationType: ua.ApplicationTypeServer,
+		DiscoveryURLs:   []string{endpointURL},
+	}
+}
+
+// userTokenPolicies returns the accepted user identity tokens. As the channel is not encrypted,
+// passwords have to be encrypted with the policy of the token.
+func (s *OPCUAServer) userTokenPolicies() []*ua.UserTokenPolicy {
+	if s.cfg.Username == "" {
+		return []*ua.UserTokenPolicy{{PolicyID: "Anonymous", TokenType: ua.UserTokenTypeAnonymous}}
+	}
+	return []*ua.UserTokenPolicy{{PolicyID: "UserName", TokenType: ua.UserTokenTypeUserName, SecurityPolicyURI: userTokenPolicyURI}}
+}
+
+// endpointsFor returns the endpoints with the host of the URL the client used to connect,
+// as the listen address, e.g., 0.0.0.0, is not reachable for clients.
+func (s *OPCUAServer) endpointsFor(requestURL string) []*ua.EndpointDescription {
+	endpointURL := s.endpointURL
+	if u, err := url.Parse(requestURL); err == nil && u.Scheme == "opc.tcp" && u.Host != "" {
+		endpointURL = requestURL
+	}
+	if endpointURL == s.endpointURL {
+		return s.endpoints
+	}
+	return s.buildEndpoints(endpointURL)
+}
+
+func (s *OPCUAServer) acceptLoop() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.closing:
+				return
+			default:
+			}
+			s.log.Warnf("Failed to accept OPC UA connection: %v", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handleConn(conn.(*net.TCPConn))
+		}()
+	}
+}
+
+// handleConn performs the Hello/Acknowledge handshake and serves the secure channel of the connection.
+// The handshake is implemented here instead of using uacp.Listener, as the listener rejects clients
+// that use another host name in their endpoint URL than the one the server listens on.
+func (s *OPCUAServer) handleConn(tcpConn *net.TCPConn) {
+	ack, err := s.handshake(tcpConn)
+	if err != nil {
+		s.log.Debugf("OPC UA handshake with %s failed: %v", tcpConn.RemoteAddr(), err)
+		_ = tcpConn.Close()
+		return
+	}
+
+	conn, err := uacp.NewConn(tcpConn, ack)
+	if err != nil {
+		_ = tcpConn.Close()
+		return
+	}
+
+	ch := s.newChannel(conn)
+	if ch == nil {
+		conn.SendError(ua.StatusBadTooManySessions)
+		_ = conn.Close()
+		return
+	}
+	ch.serve()
+	s.removeChannel(ch)
+}
+
+// handshake receives the Hello message and answers with the negotiated buffer sizes.
+func (s *OPCUAServer) handshake(tcpConn *net.TCPConn) (*uacp.Acknowledge, error) {
+	// Use a temporary connection with the default sizes to receive the Hello message
+	conn, err := uacp.NewConn(tcpConn, uacp.DefaultServerACK)
+	if err != nil {
+		return nil, err
+	}
+
+	_ = tcpConn.SetDeadline(time.Now().Add(serverHandshakeTimeout))
+	defer func() { _ = tcpConn.SetDeadline(time.Time{}) }()
+
+	b, err := conn.Receive()
+	if err != nil {
+		return nil, err
+	}
+	if string(b[:4]) != "HELF" {
+		conn.SendError(ua.StatusBadTCPMessageTypeInvalid)
+		return nil, fmt.Errorf("unexpected message type %q", b[:4])
+	}
+
+	hello := new(uacp.Hello)
+	if _, err := hello.Decode(b[8:]); err != nil {
+		conn.SendError(ua.StatusBadTCPInternalError)
+		return nil, err
+	}
+
+	// The client uses the sizes of the acknowledge message for both directions, so use the same size for both
+	bufSize := minNonZero(uacp.DefaultReceiveBufSize, hello.ReceiveBufSize, hello.SendBufSize)
+	if bufSize < 8192 {
+		conn.SendError(ua.StatusBadTCPInternalError)
+		return nil, fmt.Errorf("buffer size %d is too small", bufSize)
+	}
+
+	ack := &uacp.Acknowledge{
+		ReceiveBufSize: bufSize,
+		SendBufSize:    bufSize,
+		MaxMessageSize: minNonZero(uacp.DefaultMaxMessageSize, hello.MaxMessageSize),
+		MaxChunkCount:  minNonZero(uacp.DefaultMaxChunkCount, hello.MaxChunkCount),
+	}
+	if err := conn.Send("ACKF", ack); err != nil {
+		return nil, err
+	}
+	return ack, nil
+}
+
+// minNonZero returns the smallest value that is not zero. Zero means "no limit" in the handshake.
+func minNonZero(values ...uint32) uint32 {
+	var result uint32
+	for _, v := range values {
+		if v != 0 && (result == 0 || v < result) {
+			result = v
+		}
+	}
+	return result
+}
+
+// newChannel registers a new secure channel for the connection.
+func (s *OPCUAServer) newChannel(conn *uacp.Conn) *serverChannel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Limit the number of connections, as each channel can hold a session
+	if len(s.channels) >= s.cfg.MaxSessions*2 {
+		return nil
+	}
+
+	s.nextChannelID++
+	ch := newServerChannel(s, conn, s.nextChannelID)
+	s.channels[ch.id] = ch
+	return ch
+}
+
+// removeChannel unregisters a closed channel. Its sessions stay alive until they time out,
+// so that clients can activate them on a new channel after reconnecting.
+func (s *OPCUAServer) removeChannel(ch *serverChannel) {
+	s.mu.Lock()
+	delete(s.channels, ch.id)
+	sessions := make([]*serverSession, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	s.mu.Unlock()
+
+	for _, session := range sessions {
+		session.channelClosed(ch)
+	}
+}
+
+// newTokenID returns a unique security token ID.
+func (s *OPCUAServer) newTokenID() uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextTokenID++
+	return s.nextTokenID
+}
+
+// sessionJanitor closes sessions that were not used within their timeout and answers
+// publish requests whose timeout elapsed.
+func (s *OPCUAServer) sessionJanitor() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.closing:
+			s.mu.Lock()
+			sessions := s.sessions
+			s.sessions = make(map[string]*serverSession)
+			s.mu.Unlock()
+			for _, session := range sessions {
+				session.close()
+			}
+			return
+		case now := <-ticker.C:
+			var expired, active []*serverSession
+			s.mu.Lock()
+			for key, session := range s.sessions {
+				if session.expired(now) {
+					delete(s.sessions, key)
+					expired = append(expired, session)
+				} else {
+					active = append(active, session)
+				}
+			}
+			s.mu.Unlock()
+
+			for _, session := range active {
+				session.expirePublishRequests(now)
+			}
+
+			for _, session := range expired {
+				s.log.Infof("OPC UA session %s timed out", session.name)
+				session.close()
+			}
+		}
+	}
+}
+
+// LoadOrCreateServerCertificate loads the certificate and private key of the server from PEM files.
+// If both paths are empty, a self-signed certificate is created in memory. If the files do not exist yet,
+// a self-signed certificate is created and written to them, so that clients can trust it permanently.
+func LoadOrCreateServerCertificate(certificateFile, privateKeyFile, applicationURI string) ([]byte, *rsa.PrivateKey, error) {
+	if (certificateFile == "") != (privateKeyFile == "") {
+		return nil, nil, errors.New("certificateFile and privateKeyFile must be set together")
+	}
+
+	var certPEM, keyPEM []byte
+	var err error
+	if certificateFile != "" {
+		certPEM, err = os.ReadFile(certificateFile)
+		if err == nil {
+			keyPEM, err = os.ReadFile(privateKeyFile)
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, err
+		}
+	}
+
+	if certPEM == nil || keyPEM == nil {
+		hosts := []string{applicationURI, "localhost", "127.0.0.1"}
+		if hostname, err := os.Hostname(); err == nil {
+			hosts = append(hosts, hostname)
+		}
+		certPEM, keyPEM, err = GenerateCert(strings.Join(hosts, ","), 2048, 24*time.Hour*365*10)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate server certificate: %w", err)
+		}
+		if certificateFile != "" {
+			if err := os.WriteFile(certificateFile, certPEM, 0o644); err != nil {
+				return nil, nil, err
+			}
+			if err := os.WriteFile(privateKeyFile, keyPEM, 0o600); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse server certificate: %w", err)
+	}
+	key, ok := pair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("the private key of the server must be an RSA key")
+	}
+	if _, err := x509.ParseCertificate(pair.Certificate[0]); err != nil {
+		return nil, nil, err
+	}
+	return pair.Certificate[0], key, nil
+}
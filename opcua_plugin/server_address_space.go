@@ -0,0 +1,712 @@
+package opcua_plugin
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/gopcua/opcua/id"
+	"github.com/gopcua/opcua/ua"
+)
+
+// ServerNamespaceIndex is the namespace index of the nodes that are created by the OPC UA server.
+const ServerNamespaceIndex = 1
+
+// ServerNode is a node in the address space of the OPC UA server.
+// Only the attributes of its node class are used.
+type ServerNode struct {
+	ID             *ua.NodeID
+	Class          ua.NodeClass
+	BrowseName     *ua.QualifiedName
+	DisplayName    *ua.LocalizedText
+	Description    *ua.LocalizedText
+	TypeDefinition *ua.NodeID
+
+	// Object attributes
+	EventNotifier byte
+
+	// Variable attributes
+	DataType                *ua.NodeID
+	ValueRank               int32
+	ArrayDimensions         []uint32
+	AccessLevel             byte
+	MinimumSamplingInterval float64
+
+	// ValueFunc returns the current value of the variable on every read if set, e.g., for CurrentTime.
+	// Variables with a ValueFunc are read-only and sampled by monitored items.
+	ValueFunc func() *ua.DataValue
+
+	value      *ua.DataValue
+	references []*ServerReference
+}
+
+// ServerReference is a reference from a node to a target node.
+type ServerReference struct {
+	ReferenceTypeID *ua.NodeID
+	IsForward       bool
+	TargetID        *ua.NodeID
+}
+
+// AddressSpace holds the nodes of the OPC UA server.
+// It is safe for concurrent use.
+type AddressSpace struct {
+	mu         sync.RWMutex
+	nodes      map[string]*ServerNode
+	namespaces []string
+
+	// onChange is called after the value of a variable changed, without holding the lock
+	onChange func(node *ServerNode, dv *ua.DataValue)
+}
+
+// referenceTypeParents maps the standard reference types to their supertype.
+var referenceTypeParents = map[uint32]uint32{
+	id.NonHierarchicalReferences: id.References,
+	id.HierarchicalReferences:    id.References,
+	id.HasChild:                  id.HierarchicalReferences,
+	id.Organizes:                 id.HierarchicalReferences,
+	id.HasEventSource:            id.HierarchicalReferences,
+	id.HasNotifier:               id.HasEventSource,
+	id.Aggregates:                id.HasChild,
+	id.HasSubtype:                id.HasChild,
+	id.HasComponent:              id.Aggregates,
+	id.HasProperty:               id.Aggregates,
+	id.HasOrderedComponent:       id.HasComponent,
+	id.HasModellingRule:          id.NonHierarchicalReferences,
+	id.HasEncoding:               id.NonHierarchicalReferences,
+	id.HasTypeDefinition:         id.NonHierarchicalReferences,
+	id.GeneratesEvent:            id.NonHierarchicalReferences,
+}
+
+// isReferenceType reports whether the NodeID is one of the standard reference types.
+func isReferenceType(nodeID *ua.NodeID) bool {
+	if nodeID == nil || nodeID.Namespace() != 0 || nodeID.Type() > ua.NodeIDTypeNumeric {
+		return false
+	}
+	_, ok := referenceTypeParents[nodeID.IntID()]
+	return ok || nodeID.IntID() == id.References
+}
+
+// isNullNodeID reports whether the NodeID is nil or the null NodeID i=0.
+func isNullNodeID(nodeID *ua.NodeID) bool {
+	return nodeID == nil || nodeID.Namespace() == 0 && nodeID.Type() <= ua.NodeIDTypeNumeric && nodeID.IntID() == 0
+}
+
+// isReferenceSubtype reports whether refType is base or, if includeSubtypes is set, one of its subtypes.
+func isReferenceSubtype(refType *ua.NodeID, base *ua.NodeID, includeSubtypes bool) bool {
+	if isNullNodeID(base) {
+		// A null reference type matches all references
+		return true
+	}
+	if refType.String() == base.String() {
+		return true
+	}
+	if !includeSubtypes || refType.Namespace() != 0 || base.Namespace() != 0 {
+		return false
+	}
+	current := refType.IntID()
+	for {
+		parent, ok := referenceTypeParents[current]
+		if !ok {
+			return false
+		}
+		if parent == base.IntID() {
+			return true
+		}
+		current = parent
+	}
+}
+
+// NewAddressSpace creates an address space with the standard Root, Objects, Types and Views folders
+// and a minimal Server object with the NamespaceArray, ServerStatus and OperationLimits.
+func NewAddressSpace(applicationURI, namespaceURI, productName string, limits OperationLimits) *AddressSpace {
+	a := &AddressSpace{
+		nodes:      make(map[string]*ServerNode),
+		namespaces: []string{"http://opcfoundation.org/UA/", namespaceURI},
+	}
+
+	startTime := time.Now()
+	ns0 := func(i uint32) *ua.NodeID { return ua.NewNumericNodeID(0, i) }
+
+	a.mustAdd(NewServerObject(ns0(id.RootFolder), "Root", ns0(id.FolderType)), nil, 0)
+	a.mustAdd(NewServerObject(ns0(id.ObjectsFolder), "Objects", ns0(id.FolderType)), ns0(id.RootFolder), id.Organizes)
+	a.mustAdd(NewServerObject(ns0(id.TypesFolder), "Types", ns0(id.FolderType)), ns0(id.RootFolder), id.Organizes)
+	a.mustAdd(NewServerObject(ns0(id.ViewsFolder), "Views", ns0(id.FolderType)), ns0(id.RootFolder), id.Organizes)
+
+	server := NewServerObject(ns0(id.Server), "Server", ns0(id.ServerType))
+	server.EventNotifier = 1 // SubscribeToEvents
+	a.mustAdd(server, ns0(id.ObjectsFolder), id.Organizes)
+
+	a.mustAdd(NewServerProperty(ns0(id.Server_ServerArray), "ServerArray", ns0(id.String), ua.MustVariant([]string{applicationURI})), ns0(id.Server), id.HasProperty)
+	a.mustAdd(NewServerProperty(ns0(id.Server_NamespaceArray), "NamespaceArray", ns0(id.String), ua.MustVariant(a.namespaces)), ns0(id.Server), id.HasProperty)
+
+	buildInfo := func() *ua.BuildInfo {
+		return &ua.BuildInfo{
+			ProductURI:       applicationURI,
+			ManufacturerName: "United Manufacturing Hub",
+			ProductName:      productName,
+			SoftwareVersion:  "1.0",
+			BuildNumber:      "1",
+			BuildDate:        startTime,
+		}
+	}
+
+	serverStatus := NewServerVariable(ns0(id.Server_ServerStatus), "ServerStatus", ns0(id.ServerStatusDataType), nil)
+	serverStatus.TypeDefinition = ns0(id.ServerStatusType)
+	serverStatus.ValueFunc = func() *ua.DataValue {
+		now := time.Now()
+		return newServerDataValue(ua.MustVariant(ua.NewExtensionObject(&ua.ServerStatusDataType{
+			StartTime:      startTime,
+			CurrentTime:    now,
+			State:          ua.ServerStateRunning,
+			BuildInfo:      buildInfo(),
+			ShutdownReason: &ua.LocalizedText{},
+		})), now, now)
+	}
+	a.mustAdd(serverStatus, ns0(id.Server), id.HasComponent)
+
+	startTimeNode := NewServerVariable(ns0(id.Server_ServerStatus_StartTime), "StartTime", ns0(id.UtcTime), ua.MustVariant(startTime))
+	a.mustAdd(startTimeNode, ns0(id.Server_ServerStatus), id.HasComponent)
+
+	currentTime := NewServerVariable(ns0(id.Server_ServerStatus_CurrentTime), "CurrentTime", ns0(id.UtcTime), nil)
+	currentTime.ValueFunc = func() *ua.DataValue {
+		now := time.Now()
+		return newServerDataValue(ua.MustVariant(now), now, now)
+	}
+	a.mustAdd(currentTime, ns0(id.Server_ServerStatus), id.HasComponent)
+
+	a.mustAdd(NewServerVariable(ns0(id.Server_ServerStatus_State), "State", ns0(id.ServerState), ua.MustVariant(int32(ua.ServerStateRunning))), ns0(id.Server_ServerStatus), id.HasComponent)
+
+	capabilities := NewServerObject(ns0(id.Server_ServerCapabilities), "ServerCapabilities", ns0(id.ServerCapabilitiesType))
+	a.mustAdd(capabilities, ns0(id.Server), id.HasComponent)
+	a.mustAdd(NewServerProperty(ns0(id.Server_ServerCapabilities_MaxBrowseContinuationPoints), "MaxBrowseContinuationPoints", ns0(id.UInt16), ua.MustVariant(uint16(maxBrowseContinuationPoints))), ns0(id.Server_ServerCapabilities), id.HasProperty)
+
+	operationLimits := NewServerObject(ns0(id.Server_ServerCapabilities_OperationLimits), "OperationLimits", ns0(id.OperationLimitsType))
+	a.mustAdd(operationLimits, ns0(id.Server_ServerCapabilities), id.HasComponent)
+	for _, limit := range []struct {
+		id    uint32
+		name  string
+		value uint32
+	}{
+		{id.Server_ServerCapabilities_OperationLimits_MaxNodesPerRead, "MaxNodesPerRead", limits.MaxNodesPerRead},
+		{id.Server_ServerCapabilities_OperationLimits_MaxNodesPerWrite, "MaxNodesPerWrite", limits.MaxNodesPerRead},
+		{id.Server_ServerCapabilities_OperationLimits_MaxNodesPerBrowse, "MaxNodesPerBrowse", limits.MaxNodesPerRead},
+		{id.Server_ServerCapabilities_OperationLimits_MaxNodesPerRegisterNodes, "MaxNodesPerRegisterNodes", limits.MaxNodesPerRegisterNodes},
+		{id.Server_ServerCapabilities_OperationLimits_MaxNodesPerTranslateBrowsePathsToNodeIDs, "MaxNodesPerTranslateBrowsePathsToNodeIds", limits.MaxNodesPerRead},
+		{id.Server_ServerCapabilities_OperationLimits_MaxMonitoredItemsPerCall, "MaxMonitoredItemsPerCall", limits.MaxMonitoredItemsPerCall},
+	} {
+		a.mustAdd(NewServerProperty(ns0(limit.id), limit.name, ns0(id.UInt32), ua.MustVariant(limit.value)), ns0(id.Server_ServerCapabilities_OperationLimits), id.HasProperty)
+	}
+
+	return a
+}
+
+// NewServerObject creates an object node. Use the FolderType as type definition for folders.
+func NewServerObject(nodeID *ua.NodeID, name string, typeDefinition *ua.NodeID) *ServerNode {
+	return &ServerNode{
+		ID:             nodeID,
+		Class:          ua.NodeClassObject,
+		BrowseName:     &ua.QualifiedName{NamespaceIndex: nodeID.Namespace(), Name: name},
+		DisplayName:    ua.NewLocalizedText(name),
+		TypeDefinition: typeDefinition,
+	}
+}
+
+// NewServerVariable creates a readable variable node of the BaseDataVariableType.
+// A scalar or an array value rank is derived from the initial value if it is set.
+func NewServerVariable(nodeID *ua.NodeID, name string, dataType *ua.NodeID, value *ua.Variant) *ServerNode {
+	node := &ServerNode{
+		ID:             nodeID,
+		Class:          ua.NodeClassVariable,
+		BrowseName:     &ua.QualifiedName{NamespaceIndex: nodeID.Namespace(), Name: name},
+		DisplayName:    ua.NewLocalizedText(name),
+		TypeDefinition: ua.NewNumericNodeID(0, id.BaseDataVariableType),
+		DataType:       dataType,
+		ValueRank:      -1, // scalar
+		AccessLevel:    byte(ua.AccessLevelTypeCurrentRead),
+	}
+	if value != nil {
+		if value.Has(ua.VariantArrayValues) {
+			node.ValueRank = 1
+			node.ArrayDimensions = []uint32{uint32(value.ArrayLength())}
+		}
+		now := time.Now()
+		node.value = newServerDataValue(value, now, now)
+	}
+	return node
+}
+
+// NewServerProperty creates a readable variable node of the PropertyType.
+func NewServerProperty(nodeID *ua.NodeID, name string, dataType *ua.NodeID, value *ua.Variant) *ServerNode {
+	node := NewServerVariable(nodeID, name, dataType, value)
+	node.TypeDefinition = ua.NewNumericNodeID(0, id.PropertyType)
+	return node
+}
+
+// newServerDataValue creates a good DataValue with the encoding mask set.
+func newServerDataValue(value *ua.Variant, sourceTimestamp, serverTimestamp time.Time) *ua.DataValue {
+	dv := &ua.DataValue{
+		Value:           value,
+		SourceTimestamp: sourceTimestamp,
+		ServerTimestamp: serverTimestamp,
+	}
+	dv.UpdateMask()
+	return dv
+}
+
+// newServerStatusDataValue creates a DataValue that only contains a status code.
+func newServerStatusDataValue(status ua.StatusCode) *ua.DataValue {
+	dv := &ua.DataValue{Status: status}
+	dv.UpdateMask()
+	return dv
+}
+
+func (a *AddressSpace) mustAdd(node *ServerNode, parentID *ua.NodeID, referenceTypeID uint32) {
+	if err := a.AddNode(node, parentID, referenceTypeID); err != nil {
+		panic(err)
+	}
+}
+
+// NamespaceIndex returns the index of a namespace URI and whether it is known.
+func (a *AddressSpace) NamespaceIndex(uri string) (uint16, bool) {
+	for i, ns := range a.namespaces {
+		if ns == uri {
+			return uint16(i), true
+		}
+	}
+	return 0, false
+}
+
+// AddNode adds a node to the address space. If parentID is set, a reference of the given type is created
+// from the parent to the node, together with the inverse reference. The HasTypeDefinition reference is
+// created from the TypeDefinition of the node.
+func (a *AddressSpace) AddNode(node *ServerNode, parentID *ua.NodeID, referenceTypeID uint32) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := node.ID.String()
+	if _, exists := a.nodes[key]; exists {
+		return fmt.Errorf("node %s already exists", key)
+	}
+
+	if parentID != nil {
+		parent, ok := a.nodes[parentID.String()]
+		if !ok {
+			return fmt.Errorf("parent node %s of node %s does not exist", parentID, key)
+		}
+		refType := ua.NewNumericNodeID(0, referenceTypeID)
+		parent.references = append(parent.references, &ServerReference{ReferenceTypeID: refType, IsForward: true, TargetID: node.ID})
+		node.references = append(node.references, &ServerReference{ReferenceTypeID: refType, IsForward: false, TargetID: parent.ID})
+	}
+
+	if node.TypeDefinition != nil {
+		node.references = append(node.references, &ServerReference{
+			ReferenceTypeID: ua.NewNumericNodeID(0, id.HasTypeDefinition),
+			IsForward:       true,
+			TargetID:        node.TypeDefinition,
+		})
+	}
+
+	if node.Description == nil {
+		node.Description = &ua.LocalizedText{}
+	}
+
+	a.nodes[key] = node
+	return nil
+}
+
+// AddReference adds a reference and its inverse reference between two existing nodes.
+func (a *AddressSpace) AddReference(sourceID *ua.NodeID, referenceTypeID uint32, targetID *ua.NodeID) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	source, ok := a.nodes[sourceID.String()]
+	if !ok {
+		return fmt.Errorf("node %s does not exist", sourceID)
+	}
+	target, ok := a.nodes[targetID.String()]
+	if !ok {
+		return fmt.Errorf("node %s does not exist", targetID)
+	}
+
+	refType := ua.NewNumericNodeID(0, referenceTypeID)
+	source.references = append(source.references, &ServerReference{ReferenceTypeID: refType, IsForward: true, TargetID: target.ID})
+	target.references = append(target.references, &ServerReference{ReferenceTypeID: refType, IsForward: false, TargetID: source.ID})
+	return nil
+}
+
+// Node returns the node with the given NodeID.
+func (a *AddressSpace) Node(nodeID *ua.NodeID) (*ServerNode, bool) {
+	if nodeID == nil {
+		return nil, false
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	node, ok := a.nodes[nodeID.String()]
+	return node, ok
+}
+
+// SetValue sets the value of a variable and notifies the monitored items of the variable.
+func (a *AddressSpace) SetValue(nodeID *ua.NodeID, dv *ua.DataValue) error {
+	a.mu.Lock()
+	node, ok := a.nodes[nodeID.String()]
+	if !ok {
+		a.mu.Unlock()
+		return fmt.Errorf("node %s does not exist", nodeID)
+	}
+	if node.Class != ua.NodeClassVariable {
+		a.mu.Unlock()
+		return fmt.Errorf("node %s is not a variable", nodeID)
+	}
+	dv.UpdateMask()
+	node.value = dv
+	onChange := a.onChange
+	a.mu.Unlock()
+
+	if onChange != nil {
+		onChange(node, dv)
+	}
+	return nil
+}
+
+// UpdateNode changes the attributes of a node other than its value, e.g., its DisplayName or Description.
+// Monitored items of these attributes report the change with their next sample.
+func (a *AddressSpace) UpdateNode(nodeID *ua.NodeID, update func(node *ServerNode)) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	node, ok := a.nodes[nodeID.String()]
+	if !ok {
+		return fmt.Errorf("node %s does not exist", nodeID)
+	}
+	update(node)
+	return nil
+}
+
+// Read reads an attribute of a node. Timestamps are only returned for the Value attribute.
+func (a *AddressSpace) Read(rv *ua.ReadValueID, timestamps ua.TimestampsToReturn) *ua.DataValue {
+	if rv == nil || rv.NodeID == nil {
+		return newServerStatusDataValue(ua.StatusBadNodeIDInvalid)
+	}
+
+	// The lock is held while the attribute is read, so that UpdateNode can change it concurrently
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	node, ok := a.nodes[rv.NodeID.String()]
+	var value *ua.DataValue
+	if ok {
+		value = node.value
+	}
+
+	if !ok {
+		return newServerStatusDataValue(ua.StatusBadNodeIDUnknown)
+	}
+
+	if rv.IndexRange != "" {
+		return newServerStatusDataValue(ua.StatusBadIndexRangeInvalid)
+	}
+
+	var v interface{}
+	switch rv.AttributeID {
+	case ua.AttributeIDNodeID:
+		v = node.ID
+	case ua.AttributeIDNodeClass:
+		v = int32(node.Class)
+	case ua.AttributeIDBrowseName:
+		v = node.BrowseName
+	case ua.AttributeIDDisplayName:
+		v = node.DisplayName
+	case ua.AttributeIDDescription:
+		v = node.Description
+	case ua.AttributeIDWriteMask, ua.AttributeIDUserWriteMask:
+		v = uint32(0)
+	case ua.AttributeIDEventNotifier:
+		if node.Class != ua.NodeClassObject {
+			return newServerStatusDataValue(ua.StatusBadAttributeIDInvalid)
+		}
+		v = node.EventNotifier
+	case ua.AttributeIDValue:
+		if node.Class != ua.NodeClassVariable {
+			return newServerStatusDataValue(ua.StatusBadAttributeIDInvalid)
+		}
+		if node.ValueFunc != nil {
+			value = node.ValueFunc()
+		}
+		return filterTimestamps(value, timestamps)
+	case ua.AttributeIDDataType:
+		if node.Class != ua.NodeClassVariable {
+			return newServerStatusDataValue(ua.StatusBadAttributeIDInvalid)
+		}
+		v = node.DataType
+	case ua.AttributeIDValueRank:
+		if node.Class != ua.NodeClassVariable {
+			return newServerStatusDataValue(ua.StatusBadAttributeIDInvalid)
+		}
+		v = node.ValueRank
+	case ua.AttributeIDArrayDimensions:
+		if node.Class != ua.NodeClassVariable {
+			return newServerStatusDataValue(ua.StatusBadAttributeIDInvalid)
+		}
+		v = node.ArrayDimensions
+		if node.ArrayDimensions == nil {
+			v = []uint32{}
+		}
+	case ua.AttributeIDAccessLevel, ua.AttributeIDUserAccessLevel:
+		if node.Class != ua.NodeClassVariable {
+			return newServerStatusDataValue(ua.StatusBadAttributeIDInvalid)
+		}
+		v = node.AccessLevel
+	case ua.AttributeIDMinimumSamplingInterval:
+		if node.Class != ua.NodeClassVariable {
+			return newServerStatusDataValue(ua.StatusBadAttributeIDInvalid)
+		}
+		v = node.MinimumSamplingInterval
+	case ua.AttributeIDHistorizing:
+		if node.Class != ua.NodeClassVariable {
+			return newServerStatusDataValue(ua.StatusBadAttributeIDInvalid)
+		}
+		v = false
+	default:
+		return newServerStatusDataValue(ua.StatusBadAttributeIDInvalid)
+	}
+
+	variant, err := ua.NewVariant(v)
+	if err != nil {
+		return newServerStatusDataValue(ua.StatusBadInternalError)
+	}
+	dv := &ua.DataValue{Value: variant}
+	dv.UpdateMask()
+	return dv
+}
+
+// filterTimestamps returns a copy of the DataValue that only contains the requested timestamps.
+func filterTimestamps(dv *ua.DataValue, timestamps ua.TimestampsToReturn) *ua.DataValue {
+	if dv == nil {
+		// A variable that never received a value
+		return newServerStatusDataValue(ua.StatusBadWaitingForInitialData)
+	}
+	filtered := *dv
+	switch timestamps {
+	case ua.TimestampsToReturnSource:
+		filtered.ServerTimestamp = time.Time{}
+	case ua.TimestampsToReturnServer:
+		filtered.SourceTimestamp = time.Time{}
+	case ua.TimestampsToReturnNeither:
+		filtered.SourceTimestamp = time.Time{}
+		filtered.ServerTimestamp = time.Time{}
+	}
+	filtered.SourcePicoseconds = 0
+	filtered.ServerPicoseconds = 0
+	filtered.UpdateMask()
+	return &filtered
+}
+
+// Write writes the Value attribute of a writable variable. The value must match the data type and
+// value rank of the variable. If onWrite is set, it is called before the value is changed and can reject
+// the write by returning a bad status code.
+func (a *AddressSpace) Write(wv *ua.WriteValue, onWrite func(node *ServerNode, dv *ua.DataValue) ua.StatusCode) ua.StatusCode {
+	if wv == nil || wv.NodeID == nil || wv.Value == nil {
+		return ua.StatusBadNodeIDInvalid
+	}
+
+	node, ok := a.Node(wv.NodeID)
+	if !ok {
+		return ua.StatusBadNodeIDUnknown
+	}
+	if wv.AttributeID != ua.AttributeIDValue {
+		if !attributeIsValid(node, wv.AttributeID) {
+			return ua.StatusBadAttributeIDInvalid
+		}
+		return ua.StatusBadNotWritable
+	}
+	if node.Class != ua.NodeClassVariable {
+		return ua.StatusBadAttributeIDInvalid
+	}
+	if node.AccessLevel&byte(ua.AccessLevelTypeCurrentWrite) == 0 || node.ValueFunc != nil {
+		return ua.StatusBadNotWritable
+	}
+	if wv.IndexRange != "" {
+		return ua.StatusBadWriteNotSupported
+	}
+	if wv.Value.Value == nil || !variantMatchesNode(node, wv.Value.Value) {
+		return ua.StatusBadTypeMismatch
+	}
+
+	now := time.Now()
+	dv := &ua.DataValue{
+		Value:           wv.Value.Value,
+		Status:          wv.Value.Status,
+		SourceTimestamp: wv.Value.SourceTimestamp,
+		ServerTimestamp: now,
+	}
+	if dv.SourceTimestamp.IsZero() {
+		dv.SourceTimestamp = now
+	}
+	dv.UpdateMask()
+
+	if onWrite != nil {
+		if status := onWrite(node, dv); status != ua.StatusOK {
+			return status
+		}
+	}
+
+	if err := a.SetValue(node.ID, dv); err != nil {
+		return ua.StatusBadNodeIDUnknown
+	}
+	return ua.StatusOK
+}
+
+// attributeIsValid reports whether the attribute exists for the node class of the node.
+func attributeIsValid(node *ServerNode, attributeID ua.AttributeID) bool {
+	switch attributeID {
+	case ua.AttributeIDNodeID, ua.AttributeIDNodeClass, ua.AttributeIDBrowseName, ua.AttributeIDDisplayName,
+		ua.AttributeIDDescription, ua.AttributeIDWriteMask, ua.AttributeIDUserWriteMask:
+		return true
+	case ua.AttributeIDEventNotifier:
+		return node.Class == ua.NodeClassObject
+	case ua.AttributeIDValue, ua.AttributeIDDataType, ua.AttributeIDValueRank, ua.AttributeIDArrayDimensions,
+		ua.AttributeIDAccessLevel, ua.AttributeIDUserAccessLevel, ua.AttributeIDMinimumSamplingInterval, ua.AttributeIDHistorizing:
+		return node.Class == ua.NodeClassVariable
+	default:
+		return false
+	}
+}
+
+// variantMatchesNode reports whether a written value matches the data type and value rank of a variable.
+// Values of BaseDataType variables and structures (ExtensionObjects) of non-builtin data types are accepted
+// without further checks.
+func variantMatchesNode(node *ServerNode, v *ua.Variant) bool {
+	isArray := v.Has(ua.VariantArrayValues)
+	if node.ValueRank == -1 && isArray || node.ValueRank >= 1 && !isArray {
+		return false
+	}
+	if node.DataType == nil || node.DataType.Namespace() != 0 {
+		return v.Type() == ua.TypeIDExtensionObject
+	}
+	switch dataType := node.DataType.IntID(); {
+	case dataType == id.BaseDataType:
+		return true
+	case dataType == id.UtcTime:
+		return v.Type() == ua.TypeIDDateTime
+	case dataType <= uint32(ua.TypeIDDiagnosticInfo):
+		return v.Type() == ua.TypeID(dataType)
+	default:
+		return v.Type() == ua.TypeIDExtensionObject || v.Type() == ua.TypeIDInt32
+	}
+}
+
+// Browse returns the references of a node that match the browse description.
+func (a *AddressSpace) Browse(desc *ua.BrowseDescription) ([]*ua.ReferenceDescription, ua.StatusCode) {
+	if desc == nil || desc.NodeID == nil {
+		return nil, ua.StatusBadNodeIDInvalid
+	}
+	if desc.BrowseDirection > ua.BrowseDirectionBoth {
+		return nil, ua.StatusBadBrowseDirectionInvalid
+	}
+	if !isNullNodeID(desc.ReferenceTypeID) && !isReferenceType(desc.ReferenceTypeID) {
+		return nil, ua.StatusBadReferenceTypeIDInvalid
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	node, ok := a.nodes[desc.NodeID.String()]
+	if !ok {
+		return nil, ua.StatusBadNodeIDUnknown
+	}
+
+	refs := []*ua.ReferenceDescription{}
+	for _, ref := range node.references {
+		if ref.IsForward && desc.BrowseDirection == ua.BrowseDirectionInverse ||
+			!ref.IsForward && desc.BrowseDirection == ua.BrowseDirectionForward {
+			continue
+		}
+		if !isReferenceSubtype(ref.ReferenceTypeID, desc.ReferenceTypeID, desc.IncludeSubtypes) {
+			continue
+		}
+
+		rd := &ua.ReferenceDescription{
+			ReferenceTypeID: ref.ReferenceTypeID,
+			IsForward:       ref.IsForward,
+			NodeID:          ua.NewExpandedNodeID(ref.TargetID, "", 0),
+			BrowseName:      &ua.QualifiedName{},
+			DisplayName:     &ua.LocalizedText{},
+			NodeClass:       ua.NodeClassUnspecified,
+			TypeDefinition:  ua.NewTwoByteExpandedNodeID(0),
+		}
+
+		// Targets outside of the address space, e.g., type definitions, are returned without target info
+		if target, ok := a.nodes[ref.TargetID.String()]; ok {
+			if desc.NodeClassMask != 0 && desc.NodeClassMask&uint32(target.Class) == 0 {
+				continue
+			}
+			rd.BrowseName = target.BrowseName
+			rd.DisplayName = target.DisplayName
+			rd.NodeClass = target.Class
+			if target.TypeDefinition != nil {
+				rd.TypeDefinition = ua.NewExpandedNodeID(target.TypeDefinition, "", 0)
+			}
+		} else if desc.NodeClassMask != 0 && ref.ReferenceTypeID.IntID() == id.HasTypeDefinition && desc.NodeClassMask&uint32(ua.NodeClassObjectType|ua.NodeClassVariableType) == 0 {
+			continue
+		}
+
+		refs = append(refs, rd)
+	}
+
+	return refs, ua.StatusOK
+}
+
+// TranslateBrowsePath follows a relative path of browse names from a starting node.
+func (a *AddressSpace) TranslateBrowsePath(path *ua.BrowsePath) *ua.BrowsePathResult {
+	if path == nil || path.StartingNode == nil {
+		return &ua.BrowsePathResult{StatusCode: ua.StatusBadNodeIDInvalid}
+	}
+	if path.RelativePath == nil || len(path.RelativePath.Elements) == 0 {
+		return &ua.BrowsePathResult{StatusCode: ua.StatusBadNothingToDo}
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	start, ok := a.nodes[path.StartingNode.String()]
+	if !ok {
+		return &ua.BrowsePathResult{StatusCode: ua.StatusBadNodeIDUnknown}
+	}
+
+	current := []*ServerNode{start}
+	for _, element := range path.RelativePath.Elements {
+		if element == nil || element.TargetName == nil || element.TargetName.Name == "" {
+			return &ua.BrowsePathResult{StatusCode: ua.StatusBadBrowseNameInvalid}
+		}
+
+		var next []*ServerNode
+		for _, node := range current {
+			for _, ref := range node.references {
+				if ref.IsForward == element.IsInverse {
+					continue
+				}
+				if !isReferenceSubtype(ref.ReferenceTypeID, element.ReferenceTypeID, element.IncludeSubtypes) {
+					continue
+				}
+				target, ok := a.nodes[ref.TargetID.String()]
+				if !ok || !reflect.DeepEqual(target.BrowseName, element.TargetName) {
+					continue
+				}
+				next = append(next, target)
+			}
+		}
+		if len(next) == 0 {
+			return &ua.BrowsePathResult{StatusCode: ua.StatusBadNoMatch}
+		}
+		current = next
+	}
+
+	result := &ua.BrowsePathResult{StatusCode: ua.StatusOK}
+	for _, node := range current {
+		result.Targets = append(result.Targets, &ua.BrowsePathTarget{
+			TargetID:           ua.NewExpandedNodeID(node.ID, "", 0),
+			RemainingPathIndex: 0xFFFFFFFF,
+		})
+	}
+	return result
+}
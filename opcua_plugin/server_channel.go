@@ -0,0 +1,460 @@
+package opcua_plugin
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gopcua/opcua/ua"
+	"github.com/gopcua/opcua/uacp"
+	"github.com/gopcua/opcua/uasc"
+)
+
+const (
+	// minTokenLifetime and maxTokenLifetime bound the lifetime of the security tokens that clients can request.
+	minTokenLifetime = 10 * time.Second
+	maxTokenLifetime = time.Hour
+
+	secureHeaderLength    = 12
+	symmetricHeaderLength = 4
+	sequenceHeaderLength  = 8
+)
+
+// serverChannel is the server side of a secure channel on a single TCP connection.
+// Only the security policy None is supported, so messages are neither signed nor encrypted.
+type serverChannel struct {
+	srv  *OPCUAServer
+	conn *uacp.Conn
+	id   uint32
+
+	// mu protects the tokens, the sequence number and the connection while sending
+	mu             sync.Mutex
+	tokens         []*channelToken
+	sequenceNumber uint32
+
+	// The sequence number of the last received chunk, the chunks holding the bodies of incomplete requests
+	// by request ID and their count are only used by the serve goroutine
+	receivedSequenceNumber uint32
+	chunks                 map[uint32][]byte
+	chunkCount             map[uint32]uint32
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// channelToken is a security token of the channel. Clients have to renew it before it expires.
+type channelToken struct {
+	id        uint32
+	createdAt time.Time
+	lifetime  time.Duration
+}
+
+// expired reports whether the token can no longer be used. Clients may use a token for 25% longer than its lifetime.
+func (t *channelToken) expired(now time.Time) bool {
+	return now.After(t.createdAt.Add(t.lifetime + t.lifetime/4))
+}
+
+func newServerChannel(srv *OPCUAServer, conn *uacp.Conn, id uint32) *serverChannel {
+	return &serverChannel{
+		srv:        srv,
+		conn:       conn,
+		id:         id,
+		chunks:     make(map[uint32][]byte),
+		chunkCount: make(map[uint32]uint32),
+		closed:     make(chan struct{}),
+	}
+}
+
+// close closes the connection of the channel. Pending requests are dropped.
+func (c *serverChannel) close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		_ = c.conn.Close()
+	})
+}
+
+// isClosed reports whether the channel was closed.
+func (c *serverChannel) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// serve receives and handles messages until the connection is closed or a fatal error occurs.
+func (c *serverChannel) serve() {
+	defer c.close()
+
+	for {
+		b, err := c.conn.Receive()
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !c.isClosed() {
+				c.srv.log.Debugf("OPC UA channel %d: failed to receive: %v", c.id, err)
+			}
+			return
+		}
+
+		if err := c.handleChunk(b); err != nil {
+			if errors.Is(err, io.EOF) {
+				// The client closed the channel
+				return
+			}
+			c.srv.log.Debugf("OPC UA channel %d: %v", c.id, err)
+
+			var status ua.StatusCode
+			if !errors.As(err, &status) {
+				status = ua.StatusBadTCPInternalError
+			}
+			c.mu.Lock()
+			c.conn.SendError(status)
+			c.mu.Unlock()
+			return
+		}
+	}
+}
+
+// handleChunk handles a single message chunk. Errors are fatal for the channel.
+func (c *serverChannel) handleChunk(b []byte) error {
+	if len(b) < secureHeaderLength {
+		return ua.StatusBadTCPMessageTypeInvalid
+	}
+
+	header := new(uasc.Header)
+	if _, err := header.Decode(b[:secureHeaderLength]); err != nil {
+		return ua.StatusBadDecodingError
+	}
+
+	switch header.MessageType {
+	case "OPN":
+		return c.handleOpen(header, b)
+	case "MSG", "CLO":
+		if header.SecureChannelID != c.id || len(c.tokens) == 0 {
+			return ua.StatusBadSecureChannelIDInvalid
+		}
+		return c.handleMessage(header, b)
+	default:
+		return ua.StatusBadTCPMessageTypeInvalid
+	}
+}
+
+// handleOpen handles an OpenSecureChannel request, which either opens the channel or renews its security token.
+func (c *serverChannel) handleOpen(header *uasc.Header, b []byte) error {
+	if header.ChunkType != uasc.ChunkTypeFinal {
+		return ua.StatusBadTCPMessageTypeInvalid
+	}
+
+	securityHeader := new(uasc.AsymmetricSecurityHeader)
+	n, err := securityHeader.Decode(b[secureHeaderLength:])
+	if err != nil {
+		return ua.StatusBadDecodingError
+	}
+	if securityHeader.SecurityPolicyURI != ua.SecurityPolicyURINone {
+		return ua.StatusBadSecurityPolicyRejected
+	}
+
+	renew := len(c.tokens) > 0
+	if renew && header.SecureChannelID != c.id {
+		return ua.StatusBadSecureChannelIDInvalid
+	}
+
+	body := b[secureHeaderLength+n:]
+	sequence := new(uasc.SequenceHeader)
+	if _, err := sequence.Decode(body); err != nil {
+		return ua.StatusBadDecodingError
+	}
+	if err := c.checkSequenceNumber(sequence.SequenceNumber, !renew); err != nil {
+		return err
+	}
+
+	_, svc, err := ua.DecodeService(body[sequenceHeaderLength:])
+	if err != nil {
+		return ua.StatusBadDecodingError
+	}
+	req, ok := svc.(*ua.OpenSecureChannelRequest)
+	if !ok {
+		return ua.StatusBadTCPMessageTypeInvalid
+	}
+
+	if renew && req.RequestType != ua.SecurityTokenRequestTypeRenew {
+		return ua.StatusBadSecurityChecksFailed
+	}
+	if !renew && req.RequestType != ua.SecurityTokenRequestTypeIssue {
+		return ua.StatusBadSecurityChecksFailed
+	}
+	if req.SecurityMode != ua.MessageSecurityModeNone {
+		return ua.StatusBadSecurityModeRejected
+	}
+
+	lifetime := time.Duration(req.RequestedLifetime) * time.Millisecond
+	if lifetime < minTokenLifetime {
+		lifetime = minTokenLifetime
+	}
+	if lifetime > maxTokenLifetime {
+		lifetime = maxTokenLifetime
+	}
+
+	token := &channelToken{
+		id:        c.srv.newTokenID(),
+		createdAt: time.Now(),
+		lifetime:  lifetime,
+	}
+
+	resp := &ua.OpenSecureChannelResponse{
+		ResponseHeader: newResponseHeader(req.RequestHeader, ua.StatusOK),
+		SecurityToken: &ua.ChannelSecurityToken{
+			ChannelID:       c.id,
+			TokenID:         token.id,
+			CreatedAt:       token.createdAt,
+			RevisedLifetime: uint32(lifetime / time.Millisecond),
+		},
+		ServerNonce: []byte{},
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Drop expired tokens, but keep the previous one, as the client may still use it until it got the response
+	now := time.Now()
+	tokens := c.tokens[:0]
+	for _, t := range c.tokens {
+		if !t.expired(now) {
+			tokens = append(tokens, t)
+		}
+	}
+	c.tokens = append(tokens, token)
+
+	return c.sendOpenResponse(sequence.RequestID, resp)
+}
+
+// checkSequenceNumber checks that a chunk has the sequence number following the one of the previous chunk,
+// so that replayed or dropped chunks are detected. Clients start again below 1024 after 4294966271.
+// The first chunk of a channel can have any sequence number.
+func (c *serverChannel) checkSequenceNumber(number uint32, first bool) error {
+	previous := c.receivedSequenceNumber
+	c.receivedSequenceNumber = number
+	if first || number == previous+1 {
+		return nil
+	}
+	if previous > math.MaxUint32-1024 && number < 1024 {
+		return nil
+	}
+	return ua.StatusBadSequenceNumberInvalid
+}
+
+// handleMessage handles a chunk of a MSG or CLO message and dispatches the request once all chunks were received.
+func (c *serverChannel) handleMessage(header *uasc.Header, b []byte) error {
+	if len(b) < secureHeaderLength+symmetricHeaderLength {
+		return ua.StatusBadDecodingError
+	}
+	tokenID := binary.LittleEndian.Uint32(b[secureHeaderLength:])
+	headerLength := secureHeaderLength + symmetricHeaderLength
+
+	c.mu.Lock()
+	var token *channelToken
+	for _, t := range c.tokens {
+		if t.id == tokenID {
+			token = t
+		}
+	}
+	c.mu.Unlock()
+
+	if token == nil {
+		return ua.StatusBadSecureChannelTokenUnknown
+	}
+	if token.expired(time.Now()) {
+		return ua.StatusBadSecureChannelClosed
+	}
+
+	body := b[headerLength:]
+	sequence := new(uasc.SequenceHeader)
+	if _, err := sequence.Decode(body); err != nil {
+		return ua.StatusBadDecodingError
+	}
+	if err := c.checkSequenceNumber(sequence.SequenceNumber, false); err != nil {
+		return err
+	}
+	body = body[sequenceHeaderLength:]
+
+	if header.MessageType == "CLO" {
+		return io.EOF
+	}
+
+	requestID := sequence.RequestID
+	switch header.ChunkType {
+	case uasc.ChunkTypeError:
+		delete(c.chunks, requestID)
+		delete(c.chunkCount, requestID)
+		return nil
+	case uasc.ChunkTypeIntermediate:
+		c.chunks[requestID] = append(c.chunks[requestID], body...)
+		c.chunkCount[requestID]++
+		if c.chunkCount[requestID] >= c.conn.MaxChunkCount() || uint32(len(c.chunks[requestID])) > c.conn.MaxMessageSize() {
+			return ua.StatusBadRequestTooLarge
+		}
+		return nil
+	case uasc.ChunkTypeFinal:
+		if previous, ok := c.chunks[requestID]; ok {
+			body = append(previous, body...)
+			delete(c.chunks, requestID)
+			delete(c.chunkCount, requestID)
+		}
+	default:
+		return ua.StatusBadTCPMessageTypeInvalid
+	}
+
+	_, svc, err := ua.DecodeService(body)
+	if err != nil {
+		// The request handle is unknown, so the client cannot match a fault to its request
+		return ua.StatusBadDecodingError
+	}
+
+	req, ok := svc.(ua.Request)
+	if !ok {
+		return ua.StatusBadServiceUnsupported
+	}
+	c.srv.handleRequest(c, requestID, req)
+	return nil
+}
+
+// nextSequenceNumber returns the sequence number of the next chunk. The lock must be held.
+func (c *serverChannel) nextSequenceNumber() uint32 {
+	c.sequenceNumber++
+	if c.sequenceNumber > math.MaxUint32-1023 {
+		c.sequenceNumber = 1
+	}
+	return c.sequenceNumber
+}
+
+// encodeService encodes a service with its type id.
+func encodeService(svc interface{}) ([]byte, error) {
+	typeID := ua.ServiceTypeID(svc)
+	if typeID == 0 {
+		return nil, fmt.Errorf("unknown service %T", svc)
+	}
+	buf := ua.NewBuffer(nil)
+	buf.WriteStruct(ua.NewFourByteExpandedNodeID(0, typeID))
+	buf.WriteStruct(svc)
+	return buf.Bytes(), buf.Error()
+}
+
+// sendOpenResponse sends the OpenSecureChannel response. The lock must be held.
+func (c *serverChannel) sendOpenResponse(requestID uint32, resp *ua.OpenSecureChannelResponse) error {
+	body, err := encodeService(resp)
+	if err != nil {
+		return ua.StatusBadEncodingError
+	}
+
+	securityHeader := uasc.NewAsymmetricSecurityHeader(ua.SecurityPolicyURINone, nil, nil)
+
+	buf := ua.NewBuffer(nil)
+	buf.WriteStruct(uasc.NewHeader("OPN", uasc.ChunkTypeFinal, c.id))
+	buf.WriteStruct(securityHeader)
+	buf.WriteStruct(uasc.NewSequenceHeader(c.nextSequenceNumber(), requestID))
+	buf.Write(body)
+	if buf.Error() != nil {
+		return ua.StatusBadEncodingError
+	}
+
+	chunk := setMessageSize(buf.Bytes())
+	if uint32(len(chunk)) > c.conn.SendBufSize() {
+		return ua.StatusBadResponseTooLarge
+	}
+	if _, err := c.conn.Write(chunk); err != nil {
+		return err
+	}
+	return nil
+}
+
+// setMessageSize sets the message size in the header of the chunk to its length.
+func setMessageSize(chunk []byte) []byte {
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(chunk)))
+	return chunk
+}
+
+// maxBodySize returns the number of body bytes that fit into a chunk.
+func (c *serverChannel) maxBodySize() int {
+	return int(c.conn.SendBufSize()) - secureHeaderLength - symmetricHeaderLength - sequenceHeaderLength
+}
+
+// send sends a response, split into chunks if needed. Responses that exceed the limits of the
+// client are replaced by a ServiceFault with BadResponseTooLarge.
+func (c *serverChannel) send(requestID uint32, resp ua.Response) error {
+	if c.isClosed() {
+		return ua.StatusBadSecureChannelClosed
+	}
+
+	body, err := encodeService(resp)
+	if err != nil {
+		c.srv.log.Errorf("OPC UA channel %d: failed to encode %T: %v", c.id, resp, err)
+		body, err = encodeService(&ua.ServiceFault{ResponseHeader: newResponseHeader(nil, ua.StatusBadEncodingError)})
+		if err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.tokens) == 0 {
+		return ua.StatusBadSecureChannelClosed
+	}
+	token := c.tokens[len(c.tokens)-1]
+
+	maxBodySize := c.maxBodySize()
+	chunkCount := (len(body) + maxBodySize - 1) / maxBodySize
+	if uint32(len(body)) > c.conn.MaxMessageSize() || uint32(chunkCount) > c.conn.MaxChunkCount() {
+		header := resp.Header()
+		fault := &ua.ServiceFault{ResponseHeader: newResponseHeader(nil, ua.StatusBadResponseTooLarge)}
+		if header != nil {
+			fault.ResponseHeader.RequestHandle = header.RequestHandle
+		}
+		if body, err = encodeService(fault); err != nil {
+			return err
+		}
+	}
+
+	for len(body) > 0 {
+		n := len(body)
+		chunkType := byte(uasc.ChunkTypeFinal)
+		if n > maxBodySize {
+			n = maxBodySize
+			chunkType = uasc.ChunkTypeIntermediate
+		}
+
+		buf := ua.NewBuffer(nil)
+		buf.WriteStruct(uasc.NewHeader("MSG", chunkType, c.id))
+		buf.WriteStruct(uasc.NewSymmetricSecurityHeader(token.id))
+		buf.WriteStruct(uasc.NewSequenceHeader(c.nextSequenceNumber(), requestID))
+		buf.Write(body[:n])
+		if buf.Error() != nil {
+			return buf.Error()
+		}
+
+		if _, err := c.conn.Write(setMessageSize(buf.Bytes())); err != nil {
+			c.close()
+			return err
+		}
+		body = body[n:]
+	}
+	return nil
+}
+
+// newResponseHeader creates the header of a response to a request with the given header.
+func newResponseHeader(reqHeader *ua.RequestHeader, status ua.StatusCode) *ua.ResponseHeader {
+	header := &ua.ResponseHeader{
+		Timestamp:          time.Now(),
+		ServiceResult:      status,
+		ServiceDiagnostics: &ua.DiagnosticInfo{},
+		StringTable:        []string{},
+	}
+	if reqHeader != nil {
+		header.RequestHandle = reqHeader.RequestHandle
+	}
+	return header
+}
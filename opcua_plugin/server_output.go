@@ -0,0 +1,601 @@
+package opcua_plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopcua/opcua/id"
+	"github.com/gopcua/opcua/ua"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// writeBackQueueSize is the number of client writes that are buffered until the opcua_server_writes input reads them.
+const writeBackQueueSize = 1000
+
+var OPCUAServerConfigSpec = service.NewConfigSpec().
+	Summary("Creates an output that hosts an OPC UA server and exposes the messages of the pipeline as OPC UA variables. The server only offers the security policy None. Created & maintained by the United Manufacturing Hub. About us: www.umh.app").
+	Field(service.NewStringField("endpoint").Description("The opc.tcp address the server listens on.").Default("opc.tcp://0.0.0.0:4840")).
+	Field(service.NewStringField("applicationName").Description("The application name of the server.").Default("benthos-umh")).
+	Field(service.NewStringField("applicationURI").Description("The application URI of the server.").Default("urn:benthos-umh:server")).
+	Field(service.NewStringField("namespaceURI").Description("The URI of the namespace that contains the variables.").Default("urn:benthos-umh:opcua-server")).
+	Field(service.NewStringField("certificateFile").Description("PEM file with the server certificate that clients use to encrypt their password. If the file does not exist, a self-signed certificate is created and stored in it. If not set, a new self-signed certificate is created on every start.").Default("")).
+	Field(service.NewStringField("privateKeyFile").Description("PEM file with the RSA private key of the server certificate. Must be set together with certificateFile.").Default("")).
+	Field(service.NewStringField("username").Description("If set, clients have to log in with this username and password instead of anonymously. The password has to be encrypted with the security policy Basic256Sha256, plaintext passwords are rejected.").Default("")).
+	Field(service.NewStringField("password").Description("The password of the user.").Default("")).
+	Field(service.NewInterpolatedStringField("tagGroup").Description("The group of the variable. Dots separate nested folders below the Objects folder.").Default(`${! @opcua_tag_group | "" }`)).
+	Field(service.NewInterpolatedStringField("tagName").Description("The name of the variable.").Default(`${! @opcua_tag_name | "" }`)).
+	Field(service.NewInterpolatedStringField("dataType").Description("The OPC UA data type of a new variable, e.g., Double, Int32, Boolean, String or DateTime. If empty, the data type is derived from the first payload: Boolean for true and false, Double for numbers and String otherwise.").Default("")).
+	Field(service.NewInterpolatedStringField("sourceTimestamp").Description("The source timestamp of the value as RFC3339 timestamp or unix milliseconds. If empty, the time of the write is used.").Default("")).
+	Field(service.NewStringField("writeBack").Description("If set, clients can write the variables and every write is emitted by the opcua_server_writes input with the same writeBack name. If not set, the variables are read-only.").Default("")).
+	Field(service.NewBoolField("allowAnonymousWrites").Description("Accept writes of anonymous clients if username is not set. By default, writeBack requires clients to log in with username and password.").Default(false))
+
+var OPCUAServerWritesConfigSpec = service.NewConfigSpec().
+	Summary("Creates an input that emits the values that OPC UA clients write to the variables of an opcua_server output. Created & maintained by the United Manufacturing Hub. About us: www.umh.app").
+	Field(service.NewStringField("writeBack").Description("The writeBack name of the opcua_server output."))
+
+func init() {
+	err := service.RegisterOutput(
+		"opcua_server", OPCUAServerConfigSpec,
+		func(conf *service.ParsedConfig, mgr *service.Resources) (service.Output, int, error) {
+			mgr.Logger().Infof("Created & maintained by the United Manufacturing Hub. About us: www.umh.app")
+			output, err := newOPCUAServerOutput(conf, mgr)
+			// The address space is updated in the order of the messages
+			return output, 1, err
+		})
+	if err != nil {
+		panic(err)
+	}
+
+	err = service.RegisterBatchInput(
+		"opcua_server_writes", OPCUAServerWritesConfigSpec,
+		func(conf *service.ParsedConfig, mgr *service.Resources) (service.BatchInput, error) {
+			mgr.Logger().Infof("Created & maintained by the United Manufacturing Hub. About us: www.umh.app")
+			writeBack, err := conf.FieldString("writeBack")
+			if err != nil {
+				return nil, err
+			}
+			if writeBack == "" {
+				return nil, errors.New("writeBack must be set")
+			}
+			return service.AutoRetryNacksBatched(&OPCUAServerWritesInput{WriteBack: writeBack}), nil
+		})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// writeBacks holds the queues between the opcua_server outputs and the opcua_server_writes inputs by their writeBack name.
+var (
+	writeBacksMu sync.Mutex
+	writeBacks   = make(map[string]chan *service.Message)
+)
+
+// writeBackQueue returns the queue of a writeBack name and creates it if necessary, so that the output and
+// the input can be created in any order.
+func writeBackQueue(name string) chan *service.Message {
+	writeBacksMu.Lock()
+	defer writeBacksMu.Unlock()
+
+	queue, ok := writeBacks[name]
+	if !ok {
+		queue = make(chan *service.Message, writeBackQueueSize)
+		writeBacks[name] = queue
+	}
+	return queue
+}
+
+// serverTag is a variable that was created by the output.
+type serverTag struct {
+	node  *ServerNode
+	group string
+	name  string
+}
+
+type OPCUAServerOutput struct {
+	Config          OPCUAServerConfig
+	CertificateFile string
+	PrivateKeyFile  string
+	TagGroup        *service.InterpolatedString
+	TagName         *service.InterpolatedString
+	DataType        *service.InterpolatedString
+	SourceTimestamp *service.InterpolatedString
+	WriteBack       string
+	Log             *service.Logger
+
+	mu     sync.Mutex
+	server *OPCUAServer
+
+	// tags has its own lock, as client writes look up their tag while Close waits for the server to stop
+	tagsMu sync.RWMutex
+	tags   map[string]*serverTag
+}
+
+func newOPCUAServerOutput(conf *service.ParsedConfig, mgr *service.Resources) (*OPCUAServerOutput, error) {
+	endpoint, err := conf.FieldString("endpoint")
+	if err != nil {
+		return nil, err
+	}
+
+	applicationName, err := conf.FieldString("applicationName")
+	if err != nil {
+		return nil, err
+	}
+
+	applicationURI, err := conf.FieldString("applicationURI")
+	if err != nil {
+		return nil, err
+	}
+
+	namespaceURI, err := conf.FieldString("namespaceURI")
+	if err != nil {
+		return nil, err
+	}
+
+	certificateFile, err := conf.FieldString("certificateFile")
+	if err != nil {
+		return nil, err
+	}
+
+	privateKeyFile, err := conf.FieldString("privateKeyFile")
+	if err != nil {
+		return nil, err
+	}
+
+	username, err := conf.FieldString("username")
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := conf.FieldString("password")
+	if err != nil {
+		return nil, err
+	}
+
+	tagGroup, err := conf.FieldInterpolatedString("tagGroup")
+	if err != nil {
+		return nil, err
+	}
+
+	tagName, err := conf.FieldInterpolatedString("tagName")
+	if err != nil {
+		return nil, err
+	}
+
+	dataType, err := conf.FieldInterpolatedString("dataType")
+	if err != nil {
+		return nil, err
+	}
+
+	sourceTimestamp, err := conf.FieldInterpolatedString("sourceTimestamp")
+	if err != nil {
+		return nil, err
+	}
+
+	writeBack, err := conf.FieldString("writeBack")
+	if err != nil {
+		return nil, err
+	}
+
+	allowAnonymousWrites, err := conf.FieldBool("allowAnonymousWrites")
+	if err != nil {
+		return nil, err
+	}
+	if writeBack != "" && username == "" && !allowAnonymousWrites {
+		mgr.Logger().Warnf("writeBack %s is set without username, all client writes are rejected unless allowAnonymousWrites is set", writeBack)
+	}
+
+	return &OPCUAServerOutput{
+		Config: OPCUAServerConfig{
+			Endpoint:             endpoint,
+			ApplicationName:      applicationName,
+			ApplicationURI:       applicationURI,
+			NamespaceURI:         namespaceURI,
+			Username:             username,
+			Password:             password,
+			AllowAnonymousWrites: allowAnonymousWrites,
+			Log:                  mgr.Logger(),
+		},
+		CertificateFile: certificateFile,
+		PrivateKeyFile:  privateKeyFile,
+		TagGroup:        tagGroup,
+		TagName:         tagName,
+		DataType:        dataType,
+		SourceTimestamp: sourceTimestamp,
+		WriteBack:       writeBack,
+		Log:             mgr.Logger(),
+	}, nil
+}
+
+// Connect creates the server certificate if clients have to log in and starts the server.
+func (o *OPCUAServerOutput) Connect(ctx context.Context) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.server != nil {
+		return nil
+	}
+
+	cfg := o.Config
+	if cfg.Username != "" && cfg.Certificate == nil {
+		certificate, privateKey, err := LoadOrCreateServerCertificate(o.CertificateFile, o.PrivateKeyFile, cfg.ApplicationURI)
+		if err != nil {
+			return err
+		}
+		cfg.Certificate = certificate
+		cfg.PrivateKey = privateKey
+	}
+	if o.WriteBack != "" {
+		queue := writeBackQueue(o.WriteBack)
+		cfg.OnWrite = func(node *ServerNode, dv *ua.DataValue) ua.StatusCode {
+			return o.handleWrite(queue, node, dv)
+		}
+	}
+
+	server, err := NewOPCUAServer(cfg)
+	if err != nil {
+		return err
+	}
+	if err := server.Start(); err != nil {
+		return err
+	}
+
+	o.server = server
+	o.tagsMu.Lock()
+	o.tags = make(map[string]*serverTag)
+	o.tagsMu.Unlock()
+	o.Log.Infof("OPC UA server listening on %s", server.EndpointURL())
+	return nil
+}
+
+// EndpointURL returns the URL of the running server, or an empty string if it is not started.
+func (o *OPCUAServerOutput) EndpointURL() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.server == nil {
+		return ""
+	}
+	return o.server.EndpointURL()
+}
+
+// Write updates the value of the variable of the message and creates the variable and its folders
+// on the first message. Messages whose payload cannot be converted to the data type of the variable
+// are logged and dropped.
+func (o *OPCUAServerOutput) Write(ctx context.Context, msg *service.Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.server == nil {
+		return service.ErrNotConnected
+	}
+
+	group, err := o.TagGroup.TryString(msg)
+	if err != nil {
+		return fmt.Errorf("failed to interpolate tagGroup: %w", err)
+	}
+	name, err := o.TagName.TryString(msg)
+	if err != nil {
+		return fmt.Errorf("failed to interpolate tagName: %w", err)
+	}
+	dataTypeName, err := o.DataType.TryString(msg)
+	if err != nil {
+		return fmt.Errorf("failed to interpolate dataType: %w", err)
+	}
+	timestamp, err := o.SourceTimestamp.TryString(msg)
+	if err != nil {
+		return fmt.Errorf("failed to interpolate sourceTimestamp: %w", err)
+	}
+
+	payload, err := msg.AsBytes()
+	if err != nil {
+		return err
+	}
+
+	if name == "" {
+		o.Log.Warnf("Skipping message without tag name")
+		return nil
+	}
+
+	now := time.Now()
+	sourceTimestamp, err := ParseServerTimestamp(timestamp, now)
+	if err != nil {
+		o.Log.Warnf("Skipping value of %s.%s: %v", group, name, err)
+		return nil
+	}
+
+	tag, err := o.tag(group, name, dataTypeName, payload)
+	if err != nil {
+		o.Log.Warnf("Skipping value of %s.%s: %v", group, name, err)
+		return nil
+	}
+
+	value, err := ConvertServerValue(payload, ua.TypeID(tag.node.DataType.IntID()))
+	if err != nil {
+		o.Log.Warnf("Skipping value of %s.%s: %v", group, name, err)
+		return nil
+	}
+
+	return o.server.AddressSpace().SetValue(tag.node.ID, newServerDataValue(value, sourceTimestamp, now))
+}
+
+// tag returns the variable of a tag and creates it together with its folders if it does not exist yet.
+// The data type of a variable is fixed when it is created.
+func (o *OPCUAServerOutput) tag(group, name, dataTypeName string, payload []byte) (*serverTag, error) {
+	nodeID := ServerTagNodeID(group, name)
+	o.tagsMu.RLock()
+	tag, ok := o.tags[nodeID.String()]
+	o.tagsMu.RUnlock()
+	if ok {
+		return tag, nil
+	}
+
+	dataType, err := ParseServerDataType(dataTypeName, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	parentID, err := o.folder(group)
+	if err != nil {
+		return nil, err
+	}
+
+	node := NewServerVariable(nodeID, name, ua.NewNumericNodeID(0, uint32(dataType)), nil)
+	if o.WriteBack != "" {
+		node.AccessLevel |= byte(ua.AccessLevelTypeCurrentWrite)
+	}
+	if err := o.server.AddressSpace().AddNode(node, parentID, id.Organizes); err != nil {
+		return nil, err
+	}
+
+	tag = &serverTag{node: node, group: group, name: name}
+	o.tagsMu.Lock()
+	o.tags[nodeID.String()] = tag
+	o.tagsMu.Unlock()
+	return tag, nil
+}
+
+// folder returns the NodeID of the folder of a group and creates the folder and its parent folders
+// if they do not exist yet.
+func (o *OPCUAServerOutput) folder(group string) (*ua.NodeID, error) {
+	parentID := ua.NewNumericNodeID(0, id.ObjectsFolder)
+	if group == "" {
+		return parentID, nil
+	}
+
+	path := ""
+	for _, name := range strings.Split(group, ".") {
+		if name == "" {
+			return nil, fmt.Errorf("tag group %q contains an empty folder name", group)
+		}
+		if path != "" {
+			path += "."
+		}
+		path += name
+
+		nodeID := ua.NewStringNodeID(ServerNamespaceIndex, path)
+		node, ok := o.server.AddressSpace().Node(nodeID)
+		if !ok {
+			node = NewServerObject(nodeID, name, ua.NewNumericNodeID(0, id.FolderType))
+			if err := o.server.AddressSpace().AddNode(node, parentID, id.Organizes); err != nil {
+				return nil, err
+			}
+		} else if node.Class != ua.NodeClassObject {
+			return nil, fmt.Errorf("folder %s conflicts with a variable of the same name", path)
+		}
+		parentID = nodeID
+	}
+	return parentID, nil
+}
+
+// handleWrite queues a client write for the opcua_server_writes input. Writes are rejected with
+// BadResourceUnavailable if the input does not keep up.
+func (o *OPCUAServerOutput) handleWrite(queue chan *service.Message, node *ServerNode, dv *ua.DataValue) ua.StatusCode {
+	o.tagsMu.RLock()
+	tag, ok := o.tags[node.ID.String()]
+	o.tagsMu.RUnlock()
+	if !ok {
+		return ua.StatusBadNotWritable
+	}
+
+	b, tagType, err := formatPayload(dv.Value.Value())
+	if err != nil {
+		return ua.StatusBadTypeMismatch
+	}
+
+	msg := service.NewMessage(b)
+	msg.MetaSet("opcua_tag_group", tag.group)
+	msg.MetaSet("opcua_tag_name", tag.name)
+	msg.MetaSet("opcua_tag_type", tagType)
+	msg.MetaSet("opcua_attr_nodeid", node.ID.String())
+	msg.MetaSet("opcua_attr_datatype", strings.TrimPrefix(ua.TypeID(node.DataType.IntID()).String(), "TypeID"))
+	msg.MetaSet("opcua_source_timestamp", dv.SourceTimestamp.Format("2006-01-02T15:04:05.000000Z07:00"))
+	msg.MetaSet("opcua_server_timestamp", dv.ServerTimestamp.Format("2006-01-02T15:04:05.000000Z07:00"))
+
+	select {
+	case queue <- msg:
+		return ua.StatusOK
+	default:
+		o.Log.Warnf("Rejecting write to %s as the write-back queue %s is full", node.ID, o.WriteBack)
+		return ua.StatusBadResourceUnavailable
+	}
+}
+
+// Close stops the server and disconnects all clients.
+func (o *OPCUAServerOutput) Close(ctx context.Context) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.server == nil {
+		return nil
+	}
+	err := o.server.Close()
+	o.server = nil
+	return err
+}
+
+// ServerTagNodeID returns the NodeID of the variable of a tag: ns=1;s=<group>.<name>, or ns=1;s=<name>
+// without group.
+func ServerTagNodeID(group, name string) *ua.NodeID {
+	if group == "" {
+		return ua.NewStringNodeID(ServerNamespaceIndex, name)
+	}
+	return ua.NewStringNodeID(ServerNamespaceIndex, group+"."+name)
+}
+
+// serverDataTypes maps the lower-case names of the supported data types to their type, accepting both
+// the OPC UA names and the Go names that are used elsewhere in the plugin, e.g., Double and float64.
+var serverDataTypes = map[string]ua.TypeID{
+	"boolean":   ua.TypeIDBoolean,
+	"bool":      ua.TypeIDBoolean,
+	"sbyte":     ua.TypeIDSByte,
+	"int8":      ua.TypeIDSByte,
+	"byte":      ua.TypeIDByte,
+	"uint8":     ua.TypeIDByte,
+	"int16":     ua.TypeIDInt16,
+	"uint16":    ua.TypeIDUint16,
+	"int32":     ua.TypeIDInt32,
+	"uint32":    ua.TypeIDUint32,
+	"int64":     ua.TypeIDInt64,
+	"uint64":    ua.TypeIDUint64,
+	"float":     ua.TypeIDFloat,
+	"float32":   ua.TypeIDFloat,
+	"double":    ua.TypeIDDouble,
+	"float64":   ua.TypeIDDouble,
+	"string":    ua.TypeIDString,
+	"datetime":  ua.TypeIDDateTime,
+	"time.time": ua.TypeIDDateTime,
+}
+
+// ParseServerDataType returns the data type of a new variable. Without a data type name, it is derived
+// from the payload: Boolean for true and false, Double for numbers and String otherwise.
+func ParseServerDataType(name string, payload []byte) (ua.TypeID, error) {
+	if name == "" {
+		s := string(payload)
+		if s == "true" || s == "false" {
+			return ua.TypeIDBoolean, nil
+		}
+		if _, err := strconv.ParseFloat(s, 64); err == nil {
+			return ua.TypeIDDouble, nil
+		}
+		return ua.TypeIDString, nil
+	}
+
+	typeID, ok := serverDataTypes[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unsupported data type %s", name)
+	}
+	return typeID, nil
+}
+
+// ConvertServerValue converts a payload into a Variant of the given data type.
+// DateTime values are parsed as RFC3339 timestamp or unix milliseconds.
+func ConvertServerValue(payload []byte, typeID ua.TypeID) (*ua.Variant, error) {
+	s := strings.TrimSpace(string(payload))
+
+	var value any
+	var err error
+	switch typeID {
+	case ua.TypeIDBoolean:
+		value, err = strconv.ParseBool(s)
+	case ua.TypeIDSByte:
+		var v int64
+		v, err = strconv.ParseInt(s, 10, 8)
+		value = int8(v)
+	case ua.TypeIDByte:
+		var v uint64
+		v, err = strconv.ParseUint(s, 10, 8)
+		value = uint8(v)
+	case ua.TypeIDInt16:
+		var v int64
+		v, err = strconv.ParseInt(s, 10, 16)
+		value = int16(v)
+	case ua.TypeIDUint16:
+		var v uint64
+		v, err = strconv.ParseUint(s, 10, 16)
+		value = uint16(v)
+	case ua.TypeIDInt32:
+		var v int64
+		v, err = strconv.ParseInt(s, 10, 32)
+		value = int32(v)
+	case ua.TypeIDUint32:
+		var v uint64
+		v, err = strconv.ParseUint(s, 10, 32)
+		value = uint32(v)
+	case ua.TypeIDInt64:
+		value, err = strconv.ParseInt(s, 10, 64)
+	case ua.TypeIDUint64:
+		value, err = strconv.ParseUint(s, 10, 64)
+	case ua.TypeIDFloat:
+		var v float64
+		v, err = strconv.ParseFloat(s, 32)
+		value = float32(v)
+	case ua.TypeIDDouble:
+		value, err = strconv.ParseFloat(s, 64)
+	case ua.TypeIDString:
+		// Strings are taken as they are, including surrounding whitespace
+		value = string(payload)
+	case ua.TypeIDDateTime:
+		value, err = ParseServerTimestamp(s, time.Time{})
+	default:
+		return nil, fmt.Errorf("unsupported data type %s", typeID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot convert %q to %s: %w", s, typeID, err)
+	}
+	return ua.NewVariant(value)
+}
+
+// ParseServerTimestamp parses an RFC3339 timestamp or unix milliseconds. An empty string returns the fallback.
+func ParseServerTimestamp(s string, fallback time.Time) (time.Time, error) {
+	if s == "" {
+		return fallback, nil
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.UnixMilli(ms).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %s, use RFC3339 or unix milliseconds", s)
+	}
+	return t, nil
+}
+
+// OPCUAServerWritesInput emits the values that clients write to the variables of an opcua_server output.
+type OPCUAServerWritesInput struct {
+	WriteBack string
+
+	queue chan *service.Message
+}
+
+// Connect attaches the input to the write-back queue.
+func (w *OPCUAServerWritesInput) Connect(ctx context.Context) error {
+	w.queue = writeBackQueue(w.WriteBack)
+	return nil
+}
+
+// ReadBatch waits for the next client write.
+func (w *OPCUAServerWritesInput) ReadBatch(ctx context.Context) (service.MessageBatch, service.AckFunc, error) {
+	if w.queue == nil {
+		return nil, nil, service.ErrNotConnected
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	case msg := <-w.queue:
+		return service.MessageBatch{msg}, func(ctx context.Context, err error) error {
+			// Nacks are retried automatically when we use service.AutoRetryNacks
+			return nil
+		}, nil
+	}
+}
+
+// Close detaches the input. Writes that arrive in the meantime stay queued.
+func (w *OPCUAServerWritesInput) Close(ctx context.Context) error {
+	return nil
+}
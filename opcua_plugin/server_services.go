@@ -0,0 +1,545 @@
+package opcua_plugin
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+
+	"github.com/gopcua/opcua/ua"
+	"github.com/gopcua/opcua/uapolicy"
+)
+
+// handleRequest dispatches a request to its service and sends the response.
+// Publish requests are queued and answered by the subscriptions of the session.
+func (s *OPCUAServer) handleRequest(ch *serverChannel, requestID uint32, req ua.Request) {
+	header := req.Header()
+	if header == nil {
+		header = &ua.RequestHeader{}
+	}
+
+	var resp ua.Response
+	switch r := req.(type) {
+	case *ua.GetEndpointsRequest:
+		resp = &ua.GetEndpointsResponse{
+			ResponseHeader: newResponseHeader(header, ua.StatusOK),
+			Endpoints:      s.endpointsFor(r.EndpointURL),
+		}
+	case *ua.FindServersRequest:
+		resp = &ua.FindServersResponse{
+			ResponseHeader: newResponseHeader(header, ua.StatusOK),
+			Servers:        []*ua.ApplicationDescription{s.applicationDescription(s.endpointsFor(r.EndpointURL)[0].EndpointURL)},
+		}
+	case *ua.CreateSessionRequest:
+		resp = s.createSession(ch, r)
+	case *ua.ActivateSessionRequest:
+		resp = s.activateSession(ch, r)
+	default:
+		session, status := s.sessionFor(ch, header)
+		if status != ua.StatusOK {
+			resp = &ua.ServiceFault{ResponseHeader: newResponseHeader(header, status)}
+			break
+		}
+		if publish, ok := req.(*ua.PublishRequest); ok {
+			session.queuePublish(ch, requestID, publish)
+			return
+		}
+		resp = s.handleSessionRequest(session, req)
+	}
+
+	if err := ch.send(requestID, resp); err != nil {
+		s.log.Debugf("OPC UA channel %d: failed to send %T: %v", ch.id, resp, err)
+	}
+}
+
+// handleSessionRequest handles the services that require an activated session.
+func (s *OPCUAServer) handleSessionRequest(session *serverSession, req ua.Request) ua.Response {
+	header := req.Header()
+
+	switch r := req.(type) {
+	case *ua.CloseSessionRequest:
+		s.mu.Lock()
+		delete(s.sessions, session.authToken.String())
+		s.mu.Unlock()
+		session.close()
+		return &ua.CloseSessionResponse{ResponseHeader: newResponseHeader(header, ua.StatusOK)}
+
+	case *ua.ReadRequest:
+		if status := checkOperationCount(len(r.NodesToRead), s.cfg.Limits.MaxNodesPerRead); status != ua.StatusOK {
+			return &ua.ServiceFault{ResponseHeader: newResponseHeader(header, status)}
+		}
+		if r.TimestampsToReturn > ua.TimestampsToReturnNeither {
+			return &ua.ServiceFault{ResponseHeader: newResponseHeader(header, ua.StatusBadTimestampsToReturnInvalid)}
+		}
+		results := make([]*ua.DataValue, len(r.NodesToRead))
+		for i, rv := range r.NodesToRead {
+			results[i] = s.addressSpace.Read(rv, r.TimestampsToReturn)
+		}
+		return &ua.ReadResponse{ResponseHeader: newResponseHeader(header, ua.StatusOK), Results: results, DiagnosticInfos: []*ua.DiagnosticInfo{}}
+
+	case *ua.WriteRequest:
+		if status := checkOperationCount(len(r.NodesToWrite), s.cfg.Limits.MaxNodesPerRead); status != ua.StatusOK {
+			return &ua.ServiceFault{ResponseHeader: newResponseHeader(header, status)}
+		}
+		onWrite := s.cfg.OnWrite
+		// Anonymous clients can only log in if no username is configured
+		if s.cfg.Username == "" && !s.cfg.AllowAnonymousWrites {
+			onWrite = func(*ServerNode, *ua.DataValue) ua.StatusCode { return ua.StatusBadUserAccessDenied }
+		}
+		results := make([]ua.StatusCode, len(r.NodesToWrite))
+		for i, wv := range r.NodesToWrite {
+			results[i] = s.addressSpace.Write(wv, onWrite)
+		}
+		return &ua.WriteResponse{ResponseHeader: newResponseHeader(header, ua.StatusOK), Results: results, DiagnosticInfos: []*ua.DiagnosticInfo{}}
+
+	case *ua.BrowseRequest:
+		return s.browse(session, r)
+
+	case *ua.BrowseNextRequest:
+		return s.browseNext(session, r)
+
+	case *ua.TranslateBrowsePathsToNodeIDsRequest:
+		if status := checkOperationCount(len(r.BrowsePaths), s.cfg.Limits.MaxNodesPerRead); status != ua.StatusOK {
+			return &ua.ServiceFault{ResponseHeader: newResponseHeader(header, status)}
+		}
+		results := make([]*ua.BrowsePathResult, len(r.BrowsePaths))
+		for i, path := range r.BrowsePaths {
+			results[i] = s.addressSpace.TranslateBrowsePath(path)
+			if results[i].Targets == nil {
+				results[i].Targets = []*ua.BrowsePathTarget{}
+			}
+		}
+		return &ua.TranslateBrowsePathsToNodeIDsResponse{ResponseHeader: newResponseHeader(header, ua.StatusOK), Results: results, DiagnosticInfos: []*ua.DiagnosticInfo{}}
+
+	case *ua.RegisterNodesRequest:
+		// Registered nodes are not optimized, so the NodeIDs are returned unchanged
+		if status := checkOperationCount(len(r.NodesToRegister), s.cfg.Limits.MaxNodesPerRegisterNodes); status != ua.StatusOK {
+			return &ua.ServiceFault{ResponseHeader: newResponseHeader(header, status)}
+		}
+		if onRegister := s.onRegisterNodes; onRegister != nil {
+			onRegister(r.NodesToRegister, true)
+		}
+		return &ua.RegisterNodesResponse{ResponseHeader: newResponseHeader(header, ua.StatusOK), RegisteredNodeIDs: r.NodesToRegister}
+
+	case *ua.UnregisterNodesRequest:
+		if status := checkOperationCount(len(r.NodesToUnregister), s.cfg.Limits.MaxNodesPerRegisterNodes); status != ua.StatusOK {
+			return &ua.ServiceFault{ResponseHeader: newResponseHeader(header, status)}
+		}
+		if onRegister := s.onRegisterNodes; onRegister != nil {
+			onRegister(r.NodesToUnregister, false)
+		}
+		return &ua.UnregisterNodesResponse{ResponseHeader: newResponseHeader(header, ua.StatusOK)}
+
+	case *ua.CreateSubscriptionRequest:
+		s.mu.Lock()
+		s.nextSubID++
+		subID := s.nextSubID
+		s.mu.Unlock()
+
+		sub := newServerSubscription(session, subID, r)
+		session.mu.Lock()
+		session.subscriptions[subID] = sub
+		session.mu.Unlock()
+
+		return &ua.CreateSubscriptionResponse{
+			ResponseHeader:            newResponseHeader(header, ua.StatusOK),
+			SubscriptionID:            subID,
+			RevisedPublishingInterval: float64(sub.publishingInterval / time.Millisecond),
+			RevisedLifetimeCount:      sub.lifetimeCount,
+			RevisedMaxKeepAliveCount:  sub.maxKeepAliveCount,
+		}
+
+	case *ua.ModifySubscriptionRequest:
+		sub, ok := session.subscription(r.SubscriptionID)
+		if !ok {
+			return &ua.ServiceFault{ResponseHeader: newResponseHeader(header, ua.StatusBadSubscriptionIDInvalid)}
+		}
+		interval, lifetime, keepAlive := sub.modify(r)
+		return &ua.ModifySubscriptionResponse{
+			ResponseHeader:            newResponseHeader(header, ua.StatusOK),
+			RevisedPublishingInterval: float64(interval / time.Millisecond),
+			RevisedLifetimeCount:      lifetime,
+			RevisedMaxKeepAliveCount:  keepAlive,
+		}
+
+	case *ua.SetPublishingModeRequest:
+		if status := checkOperationCount(len(r.SubscriptionIDs), 0); status != ua.StatusOK {
+			return &ua.ServiceFault{ResponseHeader: newResponseHeader(header, status)}
+		}
+		results := make([]ua.StatusCode, len(r.SubscriptionIDs))
+		for i, subID := range r.SubscriptionIDs {
+			sub, ok := session.subscription(subID)
+			if !ok {
+				results[i] = ua.StatusBadSubscriptionIDInvalid
+				continue
+			}
+			sub.setPublishingEnabled(r.PublishingEnabled)
+		}
+		return &ua.SetPublishingModeResponse{ResponseHeader: newResponseHeader(header, ua.StatusOK), Results: results, DiagnosticInfos: []*ua.DiagnosticInfo{}}
+
+	case *ua.DeleteSubscriptionsRequest:
+		if status := checkOperationCount(len(r.SubscriptionIDs), 0); status != ua.StatusOK {
+			return &ua.ServiceFault{ResponseHeader: newResponseHeader(header, status)}
+		}
+		results := make([]ua.StatusCode, len(r.SubscriptionIDs))
+		for i, subID := range r.SubscriptionIDs {
+			if !session.removeSubscription(subID) {
+				results[i] = ua.StatusBadSubscriptionIDInvalid
+			}
+		}
+		return &ua.DeleteSubscriptionsResponse{ResponseHeader: newResponseHeader(header, ua.StatusOK), Results: results, DiagnosticInfos: []*ua.DiagnosticInfo{}}
+
+	case *ua.RepublishRequest:
+		sub, ok := session.subscription(r.SubscriptionID)
+		if !ok {
+			return &ua.ServiceFault{ResponseHeader: newResponseHeader(header, ua.StatusBadSubscriptionIDInvalid)}
+		}
+		msg, ok := sub.republish(r.RetransmitSequenceNumber)
+		if !ok {
+			return &ua.ServiceFault{ResponseHeader: newResponseHeader(header, ua.StatusBadMessageNotAvailable)}
+		}
+		return &ua.RepublishResponse{ResponseHeader: newResponseHeader(header, ua.StatusOK), NotificationMessage: msg}
+
+	case *ua.CreateMonitoredItemsRequest:
+		sub, status := s.monitoredItemsSubscription(session, r.SubscriptionID, len(r.ItemsToCreate))
+		if status != ua.StatusOK {
+			return &ua.ServiceFault{ResponseHeader: newResponseHeader(header, status)}
+		}
+		if r.TimestampsToReturn > ua.TimestampsToReturnNeither {
+			return &ua.ServiceFault{ResponseHeader: newResponseHeader(header, ua.StatusBadTimestampsToReturnInvalid)}
+		}
+		results := make([]*ua.MonitoredItemCreateResult, len(r.ItemsToCreate))
+		for i, item := range r.ItemsToCreate {
+			results[i] = sub.createItem(item, r.TimestampsToReturn)
+		}
+		return &ua.CreateMonitoredItemsResponse{ResponseHeader: newResponseHeader(header, ua.StatusOK), Results: results, DiagnosticInfos: []*ua.DiagnosticInfo{}}
+
+	case *ua.ModifyMonitoredItemsRequest:
+		sub, status := s.monitoredItemsSubscription(session, r.SubscriptionID, len(r.ItemsToModify))
+		if status != ua.StatusOK {
+			return &ua.ServiceFault{ResponseHeader: newResponseHeader(header, status)}
+		}
+		if r.TimestampsToReturn > ua.TimestampsToReturnNeither {
+			return &ua.ServiceFault{ResponseHeader: newResponseHeader(header, ua.StatusBadTimestampsToReturnInvalid)}
+		}
+		results := make([]*ua.MonitoredItemModifyResult, len(r.ItemsToModify))
+		for i, item := range r.ItemsToModify {
+			results[i] = sub.modifyItem(item, r.TimestampsToReturn)
+		}
+		return &ua.ModifyMonitoredItemsResponse{ResponseHeader: newResponseHeader(header, ua.StatusOK), Results: results, DiagnosticInfos: []*ua.DiagnosticInfo{}}
+
+	case *ua.DeleteMonitoredItemsRequest:
+		sub, status := s.monitoredItemsSubscription(session, r.SubscriptionID, len(r.MonitoredItemIDs))
+		if status != ua.StatusOK {
+			return &ua.ServiceFault{ResponseHeader: newResponseHeader(header, status)}
+		}
+		results := make([]ua.StatusCode, len(r.MonitoredItemIDs))
+		for i, itemID := range r.MonitoredItemIDs {
+			results[i] = sub.deleteItem(itemID)
+		}
+		return &ua.DeleteMonitoredItemsResponse{ResponseHeader: newResponseHeader(header, ua.StatusOK), Results: results, DiagnosticInfos: []*ua.DiagnosticInfo{}}
+
+	case *ua.SetMonitoringModeRequest:
+		sub, status := s.monitoredItemsSubscription(session, r.SubscriptionID, len(r.MonitoredItemIDs))
+		if status != ua.StatusOK {
+			return &ua.ServiceFault{ResponseHeader: newResponseHeader(header, status)}
+		}
+		if r.MonitoringMode > ua.MonitoringModeReporting {
+			return &ua.ServiceFault{ResponseHeader: newResponseHeader(header, ua.StatusBadMonitoringModeInvalid)}
+		}
+		results := make([]ua.StatusCode, len(r.MonitoredItemIDs))
+		for i, itemID := range r.MonitoredItemIDs {
+			results[i] = sub.setMonitoringMode(itemID, r.MonitoringMode)
+		}
+		return &ua.SetMonitoringModeResponse{ResponseHeader: newResponseHeader(header, ua.StatusOK), Results: results, DiagnosticInfos: []*ua.DiagnosticInfo{}}
+
+	case *ua.SetTriggeringRequest:
+		sub, status := s.monitoredItemsSubscription(session, r.SubscriptionID, len(r.LinksToAdd)+len(r.LinksToRemove))
+		if status != ua.StatusOK {
+			return &ua.ServiceFault{ResponseHeader: newResponseHeader(header, status)}
+		}
+		addResults, removeResults, status := sub.setTriggering(r.TriggeringItemID, r.LinksToAdd, r.LinksToRemove)
+		if status != ua.StatusOK {
+			return &ua.ServiceFault{ResponseHeader: newResponseHeader(header, status)}
+		}
+		return &ua.SetTriggeringResponse{
+			ResponseHeader:        newResponseHeader(header, ua.StatusOK),
+			AddResults:            addResults,
+			AddDiagnosticInfos:    []*ua.DiagnosticInfo{},
+			RemoveResults:         removeResults,
+			RemoveDiagnosticInfos: []*ua.DiagnosticInfo{},
+		}
+
+	default:
+		return &ua.ServiceFault{ResponseHeader: newResponseHeader(header, ua.StatusBadServiceUnsupported)}
+	}
+}
+
+// checkOperationCount validates the number of operations of a request against an operation limit.
+// A limit of 0 means no limit.
+func checkOperationCount(count int, limit uint32) ua.StatusCode {
+	if count == 0 {
+		return ua.StatusBadNothingToDo
+	}
+	if limit > 0 && uint32(count) > limit {
+		return ua.StatusBadTooManyOperations
+	}
+	return ua.StatusOK
+}
+
+// monitoredItemsSubscription returns the subscription of a monitored items request and checks its operation count.
+func (s *OPCUAServer) monitoredItemsSubscription(session *serverSession, subscriptionID uint32, count int) (*serverSubscription, ua.StatusCode) {
+	if status := checkOperationCount(count, s.cfg.Limits.MaxMonitoredItemsPerCall); status != ua.StatusOK {
+		return nil, status
+	}
+	sub, ok := session.subscription(subscriptionID)
+	if !ok {
+		return nil, ua.StatusBadSubscriptionIDInvalid
+	}
+	return sub, ua.StatusOK
+}
+
+// sessionFor returns the activated session of a request on a channel.
+func (s *OPCUAServer) sessionFor(ch *serverChannel, header *ua.RequestHeader) (*serverSession, ua.StatusCode) {
+	if header.AuthenticationToken == nil {
+		return nil, ua.StatusBadSessionIDInvalid
+	}
+
+	s.mu.Lock()
+	session, ok := s.sessions[header.AuthenticationToken.String()]
+	s.mu.Unlock()
+	if !ok {
+		return nil, ua.StatusBadSessionIDInvalid
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if !session.activated {
+		return nil, ua.StatusBadSessionNotActivated
+	}
+	if session.channel != ch {
+		return nil, ua.StatusBadSecureChannelIDInvalid
+	}
+	session.touch()
+	return session, ua.StatusOK
+}
+
+// newNonce returns a random nonce for sessions.
+func newNonce() []byte {
+	nonce := make([]byte, 32)
+	_, _ = rand.Read(nonce)
+	return nonce
+}
+
+// createSession creates a session that has to be activated before it can be used.
+func (s *OPCUAServer) createSession(ch *serverChannel, req *ua.CreateSessionRequest) ua.Response {
+	header := req.RequestHeader
+
+	timeout := time.Duration(req.RequestedSessionTimeout * float64(time.Millisecond))
+	if timeout < minSessionTimeout {
+		timeout = minSessionTimeout
+	}
+	if timeout > maxSessionTimeout {
+		timeout = maxSessionTimeout
+	}
+
+	s.mu.Lock()
+	if len(s.sessions) >= s.cfg.MaxSessions {
+		s.mu.Unlock()
+		return &ua.ServiceFault{ResponseHeader: newResponseHeader(header, ua.StatusBadTooManySessions)}
+	}
+	s.nextSessionID++
+	session := &serverSession{
+		srv:                s,
+		id:                 ua.NewNumericNodeID(ServerNamespaceIndex, s.nextSessionID),
+		authToken:          ua.NewByteStringNodeID(0, newNonce()),
+		name:               req.SessionName,
+		timeout:            timeout,
+		channel:            ch,
+		nonce:              newNonce(),
+		lastSeen:           time.Now(),
+		subscriptions:      make(map[uint32]*serverSubscription),
+		continuationPoints: make(map[string]*browseContinuation),
+	}
+	s.sessions[session.authToken.String()] = session
+	s.mu.Unlock()
+
+	s.log.Infof("Created OPC UA session %s", session.name)
+
+	return &ua.CreateSessionResponse{
+		ResponseHeader:             newResponseHeader(header, ua.StatusOK),
+		SessionID:                  session.id,
+		AuthenticationToken:        session.authToken,
+		RevisedSessionTimeout:      float64(timeout / time.Millisecond),
+		ServerNonce:                session.nonce,
+		ServerCertificate:          s.certificate,
+		ServerEndpoints:            s.endpointsFor(req.EndpointURL),
+		ServerSoftwareCertificates: []*ua.SignedSoftwareCertificate{},
+		ServerSignature:            &ua.SignatureData{},
+		MaxRequestMessageSize:      ch.conn.MaxMessageSize(),
+	}
+}
+
+// activateSession checks the user identity and binds the session to the channel.
+func (s *OPCUAServer) activateSession(ch *serverChannel, req *ua.ActivateSessionRequest) ua.Response {
+	header := req.RequestHeader
+
+	s.mu.Lock()
+	session, ok := s.sessions[header.AuthenticationToken.String()]
+	s.mu.Unlock()
+	if !ok {
+		return &ua.ServiceFault{ResponseHeader: newResponseHeader(header, ua.StatusBadSessionIDInvalid)}
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	// A session could only be moved to another channel by the client application that created it, which
+	// requires verified client certificates on both channels. Without security, clients create a new session.
+	if session.activated && session.channel != ch {
+		return &ua.ServiceFault{ResponseHeader: newResponseHeader(header, ua.StatusBadSecureChannelIDInvalid)}
+	}
+
+	var token interface{}
+	if req.UserIdentityToken != nil {
+		token = req.UserIdentityToken.Value
+	}
+	if status := s.authenticate(token, session.nonce); status != ua.StatusOK {
+		s.log.Warnf("Rejected OPC UA session %s: %v", session.name, status)
+		return &ua.ServiceFault{ResponseHeader: newResponseHeader(header, status)}
+	}
+
+	session.activated = true
+	session.channel = ch
+	session.nonce = newNonce()
+	session.touch()
+
+	return &ua.ActivateSessionResponse{
+		ResponseHeader:  newResponseHeader(header, ua.StatusOK),
+		ServerNonce:     session.nonce,
+		Results:         []ua.StatusCode{},
+		DiagnosticInfos: []*ua.DiagnosticInfo{},
+	}
+}
+
+// authenticate checks the user identity token of a client. Without configured username, only anonymous
+// clients are accepted, otherwise only clients with the configured username and password. As the channel
+// is not encrypted, the password has to be encrypted with the policy of the token and the server nonce.
+func (s *OPCUAServer) authenticate(token interface{}, nonce []byte) ua.StatusCode {
+	if s.cfg.Username == "" {
+		switch token.(type) {
+		case nil, *ua.AnonymousIdentityToken:
+			return ua.StatusOK
+		default:
+			return ua.StatusBadIdentityTokenRejected
+		}
+	}
+
+	userToken, ok := token.(*ua.UserNameIdentityToken)
+	if !ok {
+		return ua.StatusBadIdentityTokenRejected
+	}
+
+	algo, err := uapolicy.Asymmetric(userTokenPolicyURI, s.privateKey, nil)
+	if err != nil {
+		return ua.StatusBadIdentityTokenInvalid
+	}
+	// Plaintext passwords are rejected
+	if userToken.EncryptionAlgorithm != algo.EncryptionURI() {
+		return ua.StatusBadIdentityTokenInvalid
+	}
+	secret, err := algo.Decrypt(userToken.Password)
+	if err != nil || len(secret) < 4 {
+		return ua.StatusBadIdentityTokenInvalid
+	}
+	length := int(binary.LittleEndian.Uint32(secret))
+	if length != len(secret)-4 || length < len(nonce) {
+		return ua.StatusBadIdentityTokenInvalid
+	}
+	secret = secret[4:]
+	if !bytes.Equal(secret[len(secret)-len(nonce):], nonce) {
+		return ua.StatusBadIdentityTokenInvalid
+	}
+	password := secret[:len(secret)-len(nonce)]
+
+	userOK := subtle.ConstantTimeCompare([]byte(userToken.UserName), []byte(s.cfg.Username)) == 1
+	passwordOK := subtle.ConstantTimeCompare(password, []byte(s.cfg.Password)) == 1
+	if !userOK || !passwordOK {
+		return ua.StatusBadUserAccessDenied
+	}
+	return ua.StatusOK
+}
+
+// browse returns the references of the nodes. If a node has more references than the client requested,
+// the remaining references can be fetched with BrowseNext.
+func (s *OPCUAServer) browse(session *serverSession, req *ua.BrowseRequest) ua.Response {
+	header := req.RequestHeader
+	if status := checkOperationCount(len(req.NodesToBrowse), s.cfg.Limits.MaxNodesPerRead); status != ua.StatusOK {
+		return &ua.ServiceFault{ResponseHeader: newResponseHeader(header, status)}
+	}
+	if req.View != nil && !isNullNodeID(req.View.ViewID) {
+		return &ua.ServiceFault{ResponseHeader: newResponseHeader(header, ua.StatusBadViewIDUnknown)}
+	}
+
+	results := make([]*ua.BrowseResult, len(req.NodesToBrowse))
+	for i, desc := range req.NodesToBrowse {
+		refs, status := s.addressSpace.Browse(desc)
+		if status != ua.StatusOK {
+			results[i] = &ua.BrowseResult{StatusCode: status, References: []*ua.ReferenceDescription{}}
+			continue
+		}
+		results[i] = session.page(refs, req.RequestedMaxReferencesPerNode)
+	}
+	return &ua.BrowseResponse{ResponseHeader: newResponseHeader(header, ua.StatusOK), Results: results, DiagnosticInfos: []*ua.DiagnosticInfo{}}
+}
+
+// browseNext continues or releases browse requests.
+func (s *OPCUAServer) browseNext(session *serverSession, req *ua.BrowseNextRequest) ua.Response {
+	header := req.RequestHeader
+	if status := checkOperationCount(len(req.ContinuationPoints), s.cfg.Limits.MaxNodesPerRead); status != ua.StatusOK {
+		return &ua.ServiceFault{ResponseHeader: newResponseHeader(header, status)}
+	}
+
+	results := make([]*ua.BrowseResult, len(req.ContinuationPoints))
+	for i, cp := range req.ContinuationPoints {
+		session.mu.Lock()
+		continuation, ok := session.continuationPoints[hex.EncodeToString(cp)]
+		delete(session.continuationPoints, hex.EncodeToString(cp))
+		session.mu.Unlock()
+
+		switch {
+		case !ok:
+			results[i] = &ua.BrowseResult{StatusCode: ua.StatusBadContinuationPointInvalid, References: []*ua.ReferenceDescription{}}
+		case req.ReleaseContinuationPoints:
+			results[i] = &ua.BrowseResult{StatusCode: ua.StatusOK, References: []*ua.ReferenceDescription{}}
+		default:
+			results[i] = session.page(continuation.refs, continuation.maxRefs)
+		}
+	}
+	return &ua.BrowseNextResponse{ResponseHeader: newResponseHeader(header, ua.StatusOK), Results: results, DiagnosticInfos: []*ua.DiagnosticInfo{}}
+}
+
+// browseContinuation holds the references that did not fit into a browse response.
+type browseContinuation struct {
+	refs    []*ua.ReferenceDescription
+	maxRefs uint32
+}
+
+// page returns up to maxRefs references and stores the remaining ones in a continuation point.
+func (session *serverSession) page(refs []*ua.ReferenceDescription, maxRefs uint32) *ua.BrowseResult {
+	if maxRefs == 0 || uint32(len(refs)) <= maxRefs {
+		return &ua.BrowseResult{StatusCode: ua.StatusOK, References: refs}
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if len(session.continuationPoints) >= maxBrowseContinuationPoints {
+		return &ua.BrowseResult{StatusCode: ua.StatusBadNoContinuationPoints, References: []*ua.ReferenceDescription{}}
+	}
+	cp := newNonce()[:16]
+	session.continuationPoints[hex.EncodeToString(cp)] = &browseContinuation{refs: refs[maxRefs:], maxRefs: maxRefs}
+	return &ua.BrowseResult{StatusCode: ua.StatusOK, ContinuationPoint: cp, References: refs[:maxRefs]}
+}
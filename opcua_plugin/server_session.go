@@ -0,0 +1,220 @@
+package opcua_plugin
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gopcua/opcua/ua"
+)
+
+const (
+	// maxBrowseContinuationPoints is the number of browse continuation points a session can hold.
+	maxBrowseContinuationPoints = 10
+	// maxQueuedPublishRequests is the number of publish requests a session can queue.
+	maxQueuedPublishRequests = 20
+	// minSessionTimeout and maxSessionTimeout bound the session timeout that clients can request.
+	minSessionTimeout = 10 * time.Second
+	maxSessionTimeout = time.Hour
+)
+
+// serverSession is a session of a client. It outlives its secure channel until it times out, but it cannot be
+// activated on another channel, as channels without security do not prove which client they belong to.
+type serverSession struct {
+	srv       *OPCUAServer
+	id        *ua.NodeID
+	authToken *ua.NodeID
+	name      string
+	timeout   time.Duration
+
+	mu        sync.Mutex
+	channel   *serverChannel
+	activated bool
+	nonce     []byte
+	lastSeen  time.Time
+	closed    bool
+
+	subscriptions      map[uint32]*serverSubscription
+	publishQueue       []*queuedPublish
+	continuationPoints map[string]*browseContinuation
+}
+
+// queuedPublish is a publish request that waits for notifications or a keep-alive of a subscription.
+type queuedPublish struct {
+	channel   *serverChannel
+	requestID uint32
+	header    *ua.RequestHeader
+	results   []ua.StatusCode
+	deadline  time.Time
+}
+
+// touch marks the session as used. The lock must be held.
+func (s *serverSession) touch() {
+	s.lastSeen = time.Now()
+}
+
+// expired reports whether the session was not used within its timeout.
+func (s *serverSession) expired(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return now.Sub(s.lastSeen) > s.timeout
+}
+
+// channelClosed drops the publish requests of a closed channel, as their responses cannot be sent anymore.
+func (s *serverSession) channelClosed(ch *serverChannel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queue := s.publishQueue[:0]
+	for _, p := range s.publishQueue {
+		if p.channel != ch {
+			queue = append(queue, p)
+		}
+	}
+	s.publishQueue = queue
+	if s.channel == ch {
+		s.channel = nil
+	}
+}
+
+// close deletes all subscriptions of the session and drops its pending requests.
+func (s *serverSession) close() {
+	s.mu.Lock()
+	s.closed = true
+	subscriptions := s.subscriptions
+	s.subscriptions = make(map[uint32]*serverSubscription)
+	queue := s.publishQueue
+	s.publishQueue = nil
+	s.mu.Unlock()
+
+	for _, sub := range subscriptions {
+		sub.stop()
+	}
+	for _, p := range queue {
+		_ = p.channel.send(p.requestID, &ua.ServiceFault{ResponseHeader: newResponseHeader(p.header, ua.StatusBadSessionClosed)})
+	}
+}
+
+// subscription returns a subscription of the session.
+func (s *serverSession) subscription(subscriptionID uint32) (*serverSubscription, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subscriptions[subscriptionID]
+	return sub, ok
+}
+
+// sortedSubscriptions returns the subscriptions of the session, the ones with the highest priority first.
+func (s *serverSession) sortedSubscriptions() []*serverSubscription {
+	s.mu.Lock()
+	subs := make([]*serverSubscription, 0, len(s.subscriptions))
+	for _, sub := range s.subscriptions {
+		subs = append(subs, sub)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(subs, func(i, j int) bool {
+		if subs[i].priority() != subs[j].priority() {
+			return subs[i].priority() > subs[j].priority()
+		}
+		return subs[i].id < subs[j].id
+	})
+	return subs
+}
+
+// queuePublish acknowledges the notifications of a publish request and queues it until a subscription
+// has notifications or a keep-alive to send.
+func (s *serverSession) queuePublish(ch *serverChannel, requestID uint32, req *ua.PublishRequest) {
+	results := make([]ua.StatusCode, len(req.SubscriptionAcknowledgements))
+	for i, ack := range req.SubscriptionAcknowledgements {
+		sub, ok := s.subscription(ack.SubscriptionID)
+		if !ok {
+			results[i] = ua.StatusBadSubscriptionIDInvalid
+			continue
+		}
+		results[i] = sub.acknowledge(ack.SequenceNumber)
+	}
+
+	s.mu.Lock()
+	if len(s.subscriptions) == 0 {
+		s.mu.Unlock()
+		_ = ch.send(requestID, &ua.ServiceFault{ResponseHeader: newResponseHeader(req.RequestHeader, ua.StatusBadNoSubscription)})
+		return
+	}
+
+	var dropped *queuedPublish
+	if len(s.publishQueue) >= maxQueuedPublishRequests {
+		dropped = s.publishQueue[0]
+		s.publishQueue = s.publishQueue[1:]
+	}
+
+	p := &queuedPublish{channel: ch, requestID: requestID, header: req.RequestHeader, results: results}
+	if hint := req.RequestHeader.TimeoutHint; hint > 0 {
+		p.deadline = time.Now().Add(time.Duration(hint) * time.Millisecond)
+	}
+	s.publishQueue = append(s.publishQueue, p)
+	s.mu.Unlock()
+
+	if dropped != nil {
+		_ = dropped.channel.send(dropped.requestID, &ua.ServiceFault{ResponseHeader: newResponseHeader(dropped.header, ua.StatusBadTooManyPublishRequests)})
+	}
+
+	// Subscriptions that waited for a publish request can publish now
+	for _, sub := range s.sortedSubscriptions() {
+		sub.publishIfLate()
+	}
+}
+
+// takePublish removes the oldest queued publish request.
+func (s *serverSession) takePublish() (*queuedPublish, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.publishQueue) == 0 {
+		return nil, false
+	}
+	p := s.publishQueue[0]
+	s.publishQueue = s.publishQueue[1:]
+	return p, true
+}
+
+// expirePublishRequests answers the publish requests whose timeout hint elapsed with BadTimeout.
+func (s *serverSession) expirePublishRequests(now time.Time) {
+	s.mu.Lock()
+	var expired []*queuedPublish
+	queue := s.publishQueue[:0]
+	for _, p := range s.publishQueue {
+		if !p.deadline.IsZero() && now.After(p.deadline) {
+			expired = append(expired, p)
+		} else {
+			queue = append(queue, p)
+		}
+	}
+	s.publishQueue = queue
+	s.mu.Unlock()
+
+	for _, p := range expired {
+		_ = p.channel.send(p.requestID, &ua.ServiceFault{ResponseHeader: newResponseHeader(p.header, ua.StatusBadTimeout)})
+	}
+}
+
+// removeSubscription removes a subscription from the session and stops it.
+// Queued publish requests are answered with BadNoSubscription once the last subscription was removed.
+func (s *serverSession) removeSubscription(subscriptionID uint32) bool {
+	s.mu.Lock()
+	sub, ok := s.subscriptions[subscriptionID]
+	delete(s.subscriptions, subscriptionID)
+	var queue []*queuedPublish
+	if len(s.subscriptions) == 0 {
+		queue = s.publishQueue
+		s.publishQueue = nil
+	}
+	s.mu.Unlock()
+
+	if ok {
+		sub.stop()
+	}
+	for _, p := range queue {
+		_ = p.channel.send(p.requestID, &ua.ServiceFault{ResponseHeader: newResponseHeader(p.header, ua.StatusBadNoSubscription)})
+	}
+	return ok
+}
@@ -0,0 +1,772 @@
+package opcua_plugin
+
+import (
+	"math"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gopcua/opcua/ua"
+)
+
+const (
+	minPublishingInterval = 50 * time.Millisecond
+	maxPublishingInterval = time.Hour
+	minSamplingInterval   = 50.0 // in milliseconds
+	maxMonitoredQueueSize = 1000
+	// maxRetransmissionQueue is the number of unacknowledged notification messages kept for Republish.
+	maxRetransmissionQueue = 100
+	// deadbandAbsolute is the DeadbandType of an absolute deadband in a DataChangeFilter.
+	deadbandAbsolute = 1
+	deadbandPercent  = 2
+)
+
+// serverSubscription sends the notifications of its monitored items in the responses to publish requests.
+// Every publishing interval, it either publishes the queued notifications or, if there are none for
+// MaxKeepAliveCount intervals, a keep-alive message. If no publish request is queued, the subscription
+// is late and publishes as soon as the next publish request arrives.
+type serverSubscription struct {
+	session *serverSession
+	id      uint32
+
+	mu                 sync.Mutex
+	publishingInterval time.Duration
+	lifetimeCount      uint32
+	maxKeepAliveCount  uint32
+	maxNotifications   uint32
+	publishingEnabled  bool
+	prio               uint8
+
+	items      map[uint32]*serverMonitoredItem
+	nextItemID uint32
+
+	sequenceNumber uint32
+	retransmission map[uint32]*ua.NotificationMessage
+	keepAlives     uint32
+	lifetimeCycles uint32
+	late           bool
+
+	reset chan struct{}
+	done  chan struct{}
+	once  sync.Once
+}
+
+// serverMonitoredItem monitors an attribute of a node. Changes of the Value attribute of variables
+// without ValueFunc are reported as they happen, all other attributes are sampled.
+type serverMonitoredItem struct {
+	sub  *serverSubscription
+	id   uint32
+	item *ua.ReadValueID
+
+	mode             ua.MonitoringMode
+	clientHandle     uint32
+	samplingInterval float64
+	queueSize        uint32
+	discardOldest    bool
+	filter           *ua.DataChangeFilter
+	timestamps       ua.TimestampsToReturn
+
+	sampled    bool
+	nextSample time.Time
+	last       *ua.DataValue
+	queue      []*ua.DataValue
+
+	// links are the IDs of the items that are reported together with this item, see SetTriggering.
+	// triggered is set on a linked item in sampling mode until its queue was reported.
+	links     map[uint32]struct{}
+	triggered bool
+}
+
+// revisePublishingParameters bounds the publishing parameters that a client requested.
+func revisePublishingParameters(interval float64, lifetimeCount, maxKeepAliveCount uint32) (time.Duration, uint32, uint32) {
+	publishingInterval := time.Duration(interval * float64(time.Millisecond))
+	if publishingInterval < minPublishingInterval || math.IsNaN(interval) {
+		publishingInterval = minPublishingInterval
+	}
+	if publishingInterval > maxPublishingInterval {
+		publishingInterval = maxPublishingInterval
+	}
+	if maxKeepAliveCount == 0 {
+		maxKeepAliveCount = 10
+	}
+	if maxKeepAliveCount > 1000 {
+		maxKeepAliveCount = 1000
+	}
+	// The lifetime must be at least three times the keep-alive interval
+	if lifetimeCount < 3*maxKeepAliveCount {
+		lifetimeCount = 3 * maxKeepAliveCount
+	}
+	if lifetimeCount > 100000 {
+		lifetimeCount = 100000
+	}
+	return publishingInterval, lifetimeCount, maxKeepAliveCount
+}
+
+func newServerSubscription(session *serverSession, id uint32, req *ua.CreateSubscriptionRequest) *serverSubscription {
+	interval, lifetime, keepAlive := revisePublishingParameters(req.RequestedPublishingInterval, req.RequestedLifetimeCount, req.RequestedMaxKeepAliveCount)
+	sub := &serverSubscription{
+		session:            session,
+		id:                 id,
+		publishingInterval: interval,
+		lifetimeCount:      lifetime,
+		maxKeepAliveCount:  keepAlive,
+		maxNotifications:   req.MaxNotificationsPerPublish,
+		publishingEnabled:  req.PublishingEnabled,
+		prio:               req.Priority,
+		items:              make(map[uint32]*serverMonitoredItem),
+		sequenceNumber:     1,
+		retransmission:     make(map[uint32]*ua.NotificationMessage),
+		// The first cycle sends a keep-alive, so that the client knows that the subscription is alive
+		keepAlives: keepAlive,
+		reset:      make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	go sub.run()
+	return sub
+}
+
+// priority returns the priority of the subscription.
+func (sub *serverSubscription) priority() uint8 {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.prio
+}
+
+// modify changes the publishing parameters of the subscription.
+func (sub *serverSubscription) modify(req *ua.ModifySubscriptionRequest) (time.Duration, uint32, uint32) {
+	interval, lifetime, keepAlive := revisePublishingParameters(req.RequestedPublishingInterval, req.RequestedLifetimeCount, req.RequestedMaxKeepAliveCount)
+
+	sub.mu.Lock()
+	sub.publishingInterval = interval
+	sub.lifetimeCount = lifetime
+	sub.maxKeepAliveCount = keepAlive
+	sub.maxNotifications = req.MaxNotificationsPerPublish
+	sub.prio = req.Priority
+	sub.mu.Unlock()
+
+	// Restart the timer with the new interval
+	select {
+	case sub.reset <- struct{}{}:
+	default:
+	}
+	return interval, lifetime, keepAlive
+}
+
+// setPublishingEnabled enables or disables publishing notifications. Keep-alives are sent in both cases.
+func (sub *serverSubscription) setPublishingEnabled(enabled bool) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	sub.publishingEnabled = enabled
+}
+
+// stop stops the publishing timer and removes the monitored items from the address space watchers.
+func (sub *serverSubscription) stop() {
+	sub.once.Do(func() {
+		close(sub.done)
+
+		sub.mu.Lock()
+		items := make([]*serverMonitoredItem, 0, len(sub.items))
+		for _, item := range sub.items {
+			items = append(items, item)
+		}
+		sub.items = make(map[uint32]*serverMonitoredItem)
+		sub.mu.Unlock()
+
+		for _, item := range items {
+			sub.session.srv.unwatch(item)
+		}
+	})
+}
+
+func (sub *serverSubscription) run() {
+	sub.mu.Lock()
+	interval := sub.publishingInterval
+	sub.mu.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sub.done:
+			return
+		case <-sub.reset:
+			sub.mu.Lock()
+			interval = sub.publishingInterval
+			sub.mu.Unlock()
+			ticker.Reset(interval)
+		case <-ticker.C:
+			if sub.cycle() {
+				// The subscription expired as the client did not send publish requests within its lifetime
+				sub.session.srv.log.Infof("OPC UA subscription %d of session %s expired", sub.id, sub.session.name)
+				sub.session.removeSubscription(sub.id)
+				return
+			}
+		}
+	}
+}
+
+// cycle is called every publishing interval. It returns true if the subscription expired.
+func (sub *serverSubscription) cycle() bool {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	sub.sampleLocked(time.Now())
+
+	if sub.late {
+		sub.lifetimeCycles++
+		return sub.lifetimeCycles >= sub.lifetimeCount
+	}
+
+	if sub.hasNotificationsLocked() {
+		sub.publishLocked()
+		return false
+	}
+
+	sub.keepAlives++
+	if sub.keepAlives >= sub.maxKeepAliveCount {
+		sub.publishLocked()
+	}
+	return false
+}
+
+// publishIfLate publishes if the subscription waited for a publish request.
+func (sub *serverSubscription) publishIfLate() {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.late {
+		sub.publishLocked()
+	}
+}
+
+// hasNotificationsLocked reports whether there are queued notifications that can be published.
+func (sub *serverSubscription) hasNotificationsLocked() bool {
+	if !sub.publishingEnabled {
+		return false
+	}
+	for _, item := range sub.items {
+		if item.reportingLocked() && len(item.queue) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// reportingLocked reports whether the queued notifications of the item are published, which is the case
+// in reporting mode and for items in sampling mode whose triggering item reported a notification.
+func (item *serverMonitoredItem) reportingLocked() bool {
+	return item.mode == ua.MonitoringModeReporting || (item.mode == ua.MonitoringModeSampling && item.triggered)
+}
+
+// publishLocked sends the queued notifications, or a keep-alive if there are none, in the response to a
+// queued publish request. Without a queued publish request, the subscription becomes late.
+func (sub *serverSubscription) publishLocked() {
+	for {
+		p, ok := sub.session.takePublish()
+		if !ok {
+			sub.late = true
+			return
+		}
+		sub.late = false
+		sub.lifetimeCycles = 0
+		sub.keepAlives = 0
+
+		msg := &ua.NotificationMessage{
+			SequenceNumber:   sub.sequenceNumber,
+			PublishTime:      time.Now(),
+			NotificationData: []*ua.ExtensionObject{},
+		}
+
+		more := false
+		if sub.hasNotificationsLocked() {
+			var notifications []*ua.MonitoredItemNotification
+			notifications, more = sub.collectLocked()
+			msg.NotificationData = append(msg.NotificationData, ua.NewExtensionObject(&ua.DataChangeNotification{
+				MonitoredItems:  notifications,
+				DiagnosticInfos: []*ua.DiagnosticInfo{},
+			}))
+
+			sub.retransmission[msg.SequenceNumber] = msg
+			if len(sub.retransmission) > maxRetransmissionQueue {
+				delete(sub.retransmission, sub.availableSequenceNumbersLocked()[0])
+			}
+			sub.sequenceNumber++
+			if sub.sequenceNumber == 0 {
+				sub.sequenceNumber = 1
+			}
+		}
+
+		resp := &ua.PublishResponse{
+			ResponseHeader:           newResponseHeader(p.header, ua.StatusOK),
+			SubscriptionID:           sub.id,
+			AvailableSequenceNumbers: sub.availableSequenceNumbersLocked(),
+			MoreNotifications:        more,
+			NotificationMessage:      msg,
+			Results:                  p.results,
+			DiagnosticInfos:          []*ua.DiagnosticInfo{},
+		}
+		if err := p.channel.send(p.requestID, resp); err != nil {
+			sub.session.srv.log.Debugf("OPC UA subscription %d: failed to send publish response: %v", sub.id, err)
+		}
+
+		if !more {
+			return
+		}
+	}
+}
+
+// collectLocked removes the queued notifications of the reporting items, up to the maximum number of
+// notifications per publish. It reports whether notifications are left.
+func (sub *serverSubscription) collectLocked() ([]*ua.MonitoredItemNotification, bool) {
+	ids := make([]uint32, 0, len(sub.items))
+	for id := range sub.items {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	// Items that report a notification trigger the reporting of their linked items
+	for _, id := range ids {
+		item := sub.items[id]
+		if item.mode != ua.MonitoringModeReporting || len(item.queue) == 0 {
+			continue
+		}
+		for linkID := range item.links {
+			if linked, ok := sub.items[linkID]; ok && linked.mode == ua.MonitoringModeSampling {
+				linked.triggered = true
+			}
+		}
+	}
+
+	var notifications []*ua.MonitoredItemNotification
+	for _, id := range ids {
+		item := sub.items[id]
+		if !item.reportingLocked() {
+			continue
+		}
+		for len(item.queue) > 0 {
+			if sub.maxNotifications > 0 && uint32(len(notifications)) >= sub.maxNotifications {
+				return notifications, true
+			}
+			notifications = append(notifications, &ua.MonitoredItemNotification{
+				ClientHandle: item.clientHandle,
+				Value:        item.queue[0],
+			})
+			item.queue = item.queue[1:]
+		}
+		item.triggered = false
+	}
+	return notifications, false
+}
+
+// availableSequenceNumbersLocked returns the sequence numbers of the unacknowledged notification messages.
+func (sub *serverSubscription) availableSequenceNumbersLocked() []uint32 {
+	numbers := make([]uint32, 0, len(sub.retransmission))
+	for n := range sub.retransmission {
+		numbers = append(numbers, n)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	return numbers
+}
+
+// acknowledge removes an acknowledged notification message from the retransmission queue.
+func (sub *serverSubscription) acknowledge(sequenceNumber uint32) ua.StatusCode {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if _, ok := sub.retransmission[sequenceNumber]; !ok {
+		return ua.StatusBadSequenceNumberUnknown
+	}
+	delete(sub.retransmission, sequenceNumber)
+	return ua.StatusOK
+}
+
+// republish returns an unacknowledged notification message.
+func (sub *serverSubscription) republish(sequenceNumber uint32) (*ua.NotificationMessage, bool) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	msg, ok := sub.retransmission[sequenceNumber]
+	return msg, ok
+}
+
+// sampleLocked samples the items that are not reported by the address space.
+func (sub *serverSubscription) sampleLocked(now time.Time) {
+	addressSpace := sub.session.srv.addressSpace
+	for _, item := range sub.items {
+		if !item.sampled || item.mode == ua.MonitoringModeDisabled || now.Before(item.nextSample) {
+			continue
+		}
+		item.nextSample = now.Add(time.Duration(item.samplingInterval * float64(time.Millisecond)))
+		item.enqueue(addressSpace.Read(item.item, ua.TimestampsToReturnBoth))
+	}
+}
+
+// createItem creates a monitored item and queues its initial value.
+func (sub *serverSubscription) createItem(req *ua.MonitoredItemCreateRequest, timestamps ua.TimestampsToReturn) *ua.MonitoredItemCreateResult {
+	result := &ua.MonitoredItemCreateResult{StatusCode: ua.StatusOK}
+	if req == nil || req.ItemToMonitor == nil || req.RequestedParameters == nil {
+		result.StatusCode = ua.StatusBadMonitoredItemFilterInvalid
+		return result
+	}
+
+	addressSpace := sub.session.srv.addressSpace
+	node, ok := addressSpace.Node(req.ItemToMonitor.NodeID)
+	if !ok {
+		result.StatusCode = ua.StatusBadNodeIDUnknown
+		return result
+	}
+	if !attributeIsValid(node, req.ItemToMonitor.AttributeID) {
+		result.StatusCode = ua.StatusBadAttributeIDInvalid
+		return result
+	}
+	if req.ItemToMonitor.IndexRange != "" {
+		result.StatusCode = ua.StatusBadIndexRangeInvalid
+		return result
+	}
+	if req.MonitoringMode > ua.MonitoringModeReporting {
+		result.StatusCode = ua.StatusBadMonitoringModeInvalid
+		return result
+	}
+
+	isValue := req.ItemToMonitor.AttributeID == ua.AttributeIDValue
+	filter, status := parseDataChangeFilter(req.RequestedParameters.Filter, isValue)
+	if status != ua.StatusOK {
+		result.StatusCode = status
+		return result
+	}
+
+	if onCreate := sub.session.srv.onCreateMonitoredItem; onCreate != nil {
+		if status := onCreate(node, req.ItemToMonitor); status != ua.StatusOK {
+			result.StatusCode = status
+			return result
+		}
+	}
+
+	item := &serverMonitoredItem{
+		sub:        sub,
+		item:       req.ItemToMonitor,
+		mode:       req.MonitoringMode,
+		filter:     filter,
+		timestamps: timestamps,
+		sampled:    !isValue || node.ValueFunc != nil,
+	}
+	item.setParameters(req.RequestedParameters, sub.publishingIntervalMillis())
+
+	sub.mu.Lock()
+	sub.nextItemID++
+	item.id = sub.nextItemID
+	sub.items[item.id] = item
+	if item.mode != ua.MonitoringModeDisabled {
+		// The initial value is always reported
+		item.enqueue(addressSpace.Read(item.item, ua.TimestampsToReturnBoth))
+		item.nextSample = time.Now().Add(time.Duration(item.samplingInterval * float64(time.Millisecond)))
+	}
+	sub.mu.Unlock()
+
+	if !item.sampled {
+		sub.session.srv.watch(item)
+	}
+
+	result.MonitoredItemID = item.id
+	result.RevisedSamplingInterval = item.samplingInterval
+	result.RevisedQueueSize = item.queueSize
+	return result
+}
+
+// modifyItem changes the parameters of a monitored item.
+func (sub *serverSubscription) modifyItem(req *ua.MonitoredItemModifyRequest, timestamps ua.TimestampsToReturn) *ua.MonitoredItemModifyResult {
+	result := &ua.MonitoredItemModifyResult{StatusCode: ua.StatusOK}
+	if req == nil || req.RequestedParameters == nil {
+		result.StatusCode = ua.StatusBadMonitoredItemIDInvalid
+		return result
+	}
+
+	interval := sub.publishingIntervalMillis()
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	item, ok := sub.items[req.MonitoredItemID]
+	if !ok {
+		result.StatusCode = ua.StatusBadMonitoredItemIDInvalid
+		return result
+	}
+
+	filter, status := parseDataChangeFilter(req.RequestedParameters.Filter, item.item.AttributeID == ua.AttributeIDValue)
+	if status != ua.StatusOK {
+		result.StatusCode = status
+		return result
+	}
+
+	item.filter = filter
+	item.timestamps = timestamps
+	item.setParameters(req.RequestedParameters, interval)
+	if uint32(len(item.queue)) > item.queueSize {
+		item.queue = item.queue[uint32(len(item.queue))-item.queueSize:]
+	}
+
+	result.RevisedSamplingInterval = item.samplingInterval
+	result.RevisedQueueSize = item.queueSize
+	return result
+}
+
+// deleteItem deletes a monitored item.
+func (sub *serverSubscription) deleteItem(itemID uint32) ua.StatusCode {
+	sub.mu.Lock()
+	item, ok := sub.items[itemID]
+	delete(sub.items, itemID)
+	sub.mu.Unlock()
+
+	if !ok {
+		return ua.StatusBadMonitoredItemIDInvalid
+	}
+	sub.session.srv.unwatch(item)
+	return ua.StatusOK
+}
+
+// setMonitoringMode changes the monitoring mode of a monitored item. Disabling an item drops its queue.
+func (sub *serverSubscription) setMonitoringMode(itemID uint32, mode ua.MonitoringMode) ua.StatusCode {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	item, ok := sub.items[itemID]
+	if !ok {
+		return ua.StatusBadMonitoredItemIDInvalid
+	}
+	if item.mode == ua.MonitoringModeDisabled && mode != ua.MonitoringModeDisabled {
+		item.last = nil
+		item.enqueue(sub.session.srv.addressSpace.Read(item.item, ua.TimestampsToReturnBoth))
+	}
+	if mode == ua.MonitoringModeDisabled {
+		item.queue = nil
+	}
+	item.mode = mode
+	return ua.StatusOK
+}
+
+// setTriggering adds and removes the links of a triggering item. The links are removed before they are added.
+func (sub *serverSubscription) setTriggering(triggerID uint32, add, remove []uint32) ([]ua.StatusCode, []ua.StatusCode, ua.StatusCode) {
+	sub.mu.Lock()
+	trigger, ok := sub.items[triggerID]
+	sub.mu.Unlock()
+	if !ok {
+		return nil, nil, ua.StatusBadMonitoredItemIDInvalid
+	}
+
+	if onSetTriggering := sub.session.srv.onSetTriggering; onSetTriggering != nil {
+		node, _ := sub.session.srv.addressSpace.Node(trigger.item.NodeID)
+		if status := onSetTriggering(node); status != ua.StatusOK {
+			return nil, nil, status
+		}
+	}
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	removeResults := make([]ua.StatusCode, len(remove))
+	for i, itemID := range remove {
+		if _, ok := trigger.links[itemID]; !ok {
+			removeResults[i] = ua.StatusBadMonitoredItemIDInvalid
+			continue
+		}
+		delete(trigger.links, itemID)
+		removeResults[i] = ua.StatusOK
+	}
+
+	addResults := make([]ua.StatusCode, len(add))
+	for i, itemID := range add {
+		if _, ok := sub.items[itemID]; !ok {
+			addResults[i] = ua.StatusBadMonitoredItemIDInvalid
+			continue
+		}
+		if trigger.links == nil {
+			trigger.links = make(map[uint32]struct{})
+		}
+		trigger.links[itemID] = struct{}{}
+		addResults[i] = ua.StatusOK
+	}
+
+	return addResults, removeResults, ua.StatusOK
+}
+
+// publishingIntervalMillis returns the publishing interval in milliseconds.
+func (sub *serverSubscription) publishingIntervalMillis() float64 {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return float64(sub.publishingInterval / time.Millisecond)
+}
+
+// setParameters applies the requested monitoring parameters with revised sampling interval and queue size.
+func (item *serverMonitoredItem) setParameters(params *ua.MonitoringParameters, publishingInterval float64) {
+	item.clientHandle = params.ClientHandle
+	item.discardOldest = params.DiscardOldest
+
+	item.samplingInterval = params.SamplingInterval
+	if item.samplingInterval < 0 || math.IsNaN(item.samplingInterval) {
+		// A negative sampling interval means the publishing interval
+		item.samplingInterval = publishingInterval
+	}
+	if item.samplingInterval < minSamplingInterval {
+		item.samplingInterval = minSamplingInterval
+	}
+
+	item.queueSize = params.QueueSize
+	if item.queueSize == 0 {
+		item.queueSize = 1
+	}
+	if item.queueSize > maxMonitoredQueueSize {
+		item.queueSize = maxMonitoredQueueSize
+	}
+}
+
+// enqueue queues a new value if it passes the filter of the item. The lock of the subscription must be held.
+func (item *serverMonitoredItem) enqueue(dv *ua.DataValue) {
+	if item.mode == ua.MonitoringModeDisabled || !item.changed(dv) {
+		return
+	}
+	item.last = dv
+
+	if uint32(len(item.queue)) >= item.queueSize {
+		if item.discardOldest {
+			item.queue = item.queue[1:]
+		} else {
+			item.queue = item.queue[:len(item.queue)-1]
+		}
+	}
+	item.queue = append(item.queue, filterTimestamps(dv, item.timestamps))
+}
+
+// changed reports whether a value differs from the last reported value according to the data change filter.
+// Without a filter, the status and the value are compared.
+func (item *serverMonitoredItem) changed(dv *ua.DataValue) bool {
+	if item.last == nil {
+		return true
+	}
+	last := item.last
+
+	if last.Status != dv.Status {
+		return true
+	}
+
+	trigger := ua.DataChangeTriggerStatusValue
+	if item.filter != nil {
+		trigger = item.filter.Trigger
+	}
+	if trigger == ua.DataChangeTriggerStatus {
+		return false
+	}
+	if trigger == ua.DataChangeTriggerStatusValueTimestamp && !last.SourceTimestamp.Equal(dv.SourceTimestamp) {
+		return true
+	}
+
+	var lastValue, value interface{}
+	if last.Value != nil {
+		lastValue = last.Value.Value()
+	}
+	if dv.Value != nil {
+		value = dv.Value.Value()
+	}
+
+	if item.filter != nil && item.filter.DeadbandType == deadbandAbsolute {
+		if a, ok := toFloat64(lastValue); ok {
+			if b, ok := toFloat64(value); ok {
+				return math.Abs(a-b) > item.filter.DeadbandValue
+			}
+		}
+	}
+	return !reflect.DeepEqual(lastValue, value)
+}
+
+// toFloat64 converts a numeric value to float64.
+func toFloat64(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int8:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	default:
+		return 0, false
+	}
+}
+
+// parseDataChangeFilter validates the filter of a monitored item. Only data change filters with an
+// absolute deadband are supported, and only for the Value attribute.
+func parseDataChangeFilter(filter *ua.ExtensionObject, isValue bool) (*ua.DataChangeFilter, ua.StatusCode) {
+	if filter == nil || filter.Value == nil {
+		return nil, ua.StatusOK
+	}
+	if !isValue {
+		return nil, ua.StatusBadFilterNotAllowed
+	}
+
+	dataChangeFilter, ok := filter.Value.(*ua.DataChangeFilter)
+	if !ok {
+		return nil, ua.StatusBadMonitoredItemFilterUnsupported
+	}
+	switch dataChangeFilter.DeadbandType {
+	case 0, deadbandAbsolute:
+	case deadbandPercent:
+		return nil, ua.StatusBadMonitoredItemFilterUnsupported
+	default:
+		return nil, ua.StatusBadDeadbandFilterInvalid
+	}
+	if dataChangeFilter.Trigger > ua.DataChangeTriggerStatusValueTimestamp || dataChangeFilter.DeadbandValue < 0 {
+		return nil, ua.StatusBadMonitoredItemFilterInvalid
+	}
+	return dataChangeFilter, ua.StatusOK
+}
+
+// watch registers a monitored item for changes of its node.
+func (s *OPCUAServer) watch(item *serverMonitoredItem) {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+
+	key := item.item.NodeID.String()
+	if s.watchers[key] == nil {
+		s.watchers[key] = make(map[*serverMonitoredItem]struct{})
+	}
+	s.watchers[key][item] = struct{}{}
+}
+
+// unwatch removes a monitored item from the watchers of its node.
+func (s *OPCUAServer) unwatch(item *serverMonitoredItem) {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+
+	key := item.item.NodeID.String()
+	delete(s.watchers[key], item)
+	if len(s.watchers[key]) == 0 {
+		delete(s.watchers, key)
+	}
+}
+
+// notifyChange queues a changed value at the monitored items of the node.
+func (s *OPCUAServer) notifyChange(node *ServerNode, dv *ua.DataValue) {
+	s.watchMu.RLock()
+	items := make([]*serverMonitoredItem, 0, len(s.watchers[node.ID.String()]))
+	for item := range s.watchers[node.ID.String()] {
+		items = append(items, item)
+	}
+	s.watchMu.RUnlock()
+
+	for _, item := range items {
+		item.sub.mu.Lock()
+		item.enqueue(dv)
+		item.sub.mu.Unlock()
+	}
+}
@@ -0,0 +1,254 @@
+package opcua_plugin
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/gopcua/opcua"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// DefaultSubscriptionName is the name of the subscription that receives all subscribed nodes which are not
+// assigned to another subscription, as well as the items of trigger groups and attribute snapshots.
+const DefaultSubscriptionName = "default"
+
+// SubscriptionConfig is an entry of the subscriptions list. Each entry is created as its own OPC UA
+// subscription, so that nodes with different update rates do not share one publishing interval.
+type SubscriptionConfig struct {
+	Name               string
+	PublishingInterval time.Duration
+	MaxKeepAliveCount  uint32 // 0 uses the default of the client
+	LifetimeCount      uint32 // 0 uses the default of the client
+	Priority           uint8
+	// Patterns are matched against the NodeID and the path of the subscribed nodes
+	Patterns []*regexp.Regexp
+}
+
+// ParseSubscriptionConfigs parses the subscriptions configuration into SubscriptionConfigs. If no entry is
+// named DefaultSubscriptionName, an entry with the default parameters is added as the first entry.
+func ParseSubscriptionConfigs(confs []*service.ParsedConfig) ([]*SubscriptionConfig, error) {
+	subscriptionConfigs := make([]*SubscriptionConfig, 0, len(confs)+1)
+	names := make(map[string]bool)
+
+	for i, conf := range confs {
+		name, err := conf.FieldString("name")
+		if err != nil {
+			return nil, err
+		}
+
+		publishingInterval, err := conf.FieldInt("publishingInterval")
+		if err != nil {
+			return nil, err
+		}
+
+		maxKeepAliveCount, err := conf.FieldInt("maxKeepAliveCount")
+		if err != nil {
+			return nil, err
+		}
+
+		lifetimeCount, err := conf.FieldInt("lifetimeCount")
+		if err != nil {
+			return nil, err
+		}
+
+		priority, err := conf.FieldInt("priority")
+		if err != nil {
+			return nil, err
+		}
+
+		patternStrings, err := conf.FieldStringList("patterns")
+		if err != nil {
+			return nil, err
+		}
+
+		if name == "" {
+			return nil, fmt.Errorf("subscription %d: name must be set", i)
+		}
+
+		if names[name] {
+			return nil, fmt.Errorf("subscription %d: duplicate name %q", i, name)
+		}
+		names[name] = true
+
+		if publishingInterval <= 0 {
+			return nil, fmt.Errorf("subscription %s: publishingInterval must be greater than 0", name)
+		}
+
+		if maxKeepAliveCount < 0 || lifetimeCount < 0 {
+			return nil, fmt.Errorf("subscription %s: maxKeepAliveCount and lifetimeCount must not be negative", name)
+		}
+
+		if priority < 0 || priority > 255 {
+			return nil, fmt.Errorf("subscription %s: priority must be between 0 and 255", name)
+		}
+
+		patterns := make([]*regexp.Regexp, 0, len(patternStrings))
+		for _, patternString := range patternStrings {
+			pattern, err := regexp.Compile(patternString)
+			if err != nil {
+				return nil, fmt.Errorf("subscription %s: invalid pattern %q: %w", name, patternString, err)
+			}
+			patterns = append(patterns, pattern)
+		}
+
+		subscriptionConfigs = append(subscriptionConfigs, &SubscriptionConfig{
+			Name:               name,
+			PublishingInterval: time.Duration(publishingInterval) * time.Millisecond,
+			MaxKeepAliveCount:  uint32(maxKeepAliveCount),
+			LifetimeCount:      uint32(lifetimeCount),
+			Priority:           uint8(priority),
+			Patterns:           patterns,
+		})
+	}
+
+	if !names[DefaultSubscriptionName] {
+		subscriptionConfigs = append([]*SubscriptionConfig{defaultSubscriptionConfig()}, subscriptionConfigs...)
+	}
+
+	return subscriptionConfigs, nil
+}
+
+// matches reports whether one of the patterns of the subscription matches the NodeID or the path of a node.
+func (c *SubscriptionConfig) matches(nodeDef NodeDef) bool {
+	for _, pattern := range c.Patterns {
+		if pattern.MatchString(nodeDef.NodeID.String()) || pattern.MatchString(nodeDef.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// subscriptionConfigs returns the configured subscriptions including the default subscription.
+func (g *OPCUAInput) subscriptionConfigs() []*SubscriptionConfig {
+	for _, subscriptionConfig := range g.SubscriptionConfigs {
+		if subscriptionConfig.Name == DefaultSubscriptionName {
+			return g.SubscriptionConfigs
+		}
+	}
+	return append([]*SubscriptionConfig{defaultSubscriptionConfig()}, g.SubscriptionConfigs...)
+}
+
+// defaultSubscriptionConfig returns the parameters of the default subscription if it is not configured.
+func defaultSubscriptionConfig() *SubscriptionConfig {
+	return &SubscriptionConfig{Name: DefaultSubscriptionName, PublishingInterval: opcua.DefaultSubscriptionInterval}
+}
+
+// subscriptionName returns the name of the subscription in which a node is monitored: the subscription of
+// its entry in the nodes list, or else the first subscription with a matching pattern, or else the default.
+func (g *OPCUAInput) subscriptionName(nodeDef NodeDef) string {
+	if nodeDef.Config != nil && nodeDef.Config.Subscription != "" {
+		return nodeDef.Config.Subscription
+	}
+	for _, subscriptionConfig := range g.SubscriptionConfigs {
+		if subscriptionConfig.matches(nodeDef) {
+			return subscriptionConfig.Name
+		}
+	}
+	return DefaultSubscriptionName
+}
+
+// SubscriptionChannel is the channel to which one subscription delivers its notifications.
+type SubscriptionChannel struct {
+	Name          string
+	Notifications chan *opcua.PublishNotificationData
+}
+
+// NewSubscriptionChannels creates a channel for each configured subscription, ordered by descending priority.
+// Each subscription has its own channel, so that a backlog of one subscription does not delay the others.
+func NewSubscriptionChannels(subscriptionConfigs []*SubscriptionConfig) []SubscriptionChannel {
+	sorted := append([]*SubscriptionConfig(nil), subscriptionConfigs...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Priority > sorted[j].Priority })
+
+	channels := make([]SubscriptionChannel, 0, len(sorted))
+	for _, subscriptionConfig := range sorted {
+		channels = append(channels, SubscriptionChannel{
+			Name:          subscriptionConfig.Name,
+			Notifications: make(chan *opcua.PublishNotificationData, 10000),
+		})
+	}
+	return channels
+}
+
+// subNotifyChan returns the notification channel of the subscription with the given name.
+func (g *OPCUAInput) subNotifyChan(name string) chan *opcua.PublishNotificationData {
+	for _, channel := range g.SubNotifyChans {
+		if channel.Name == name {
+			return channel.Notifications
+		}
+	}
+	return nil
+}
+
+// receiveNotification returns the next notification and the name of its subscription. Pending notifications
+// of subscriptions with a higher priority are returned first. If the context is done before a notification
+// arrives, nil is returned.
+func (g *OPCUAInput) receiveNotification(ctx context.Context) (string, *opcua.PublishNotificationData) {
+	for _, channel := range g.SubNotifyChans {
+		select {
+		case res := <-channel.Notifications:
+			return channel.Name, res
+		default:
+		}
+	}
+
+	// Nothing is pending, so wait for the first notification of any subscription
+	cases := make([]reflect.SelectCase, 0, len(g.SubNotifyChans)+1)
+	cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())})
+	for _, channel := range g.SubNotifyChans {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(channel.Notifications)})
+	}
+	chosen, value, _ := reflect.Select(cases)
+	if chosen == 0 {
+		return "", nil
+	}
+	return g.SubNotifyChans[chosen-1].Name, value.Interface().(*opcua.PublishNotificationData)
+}
+
+// createSubscriptions creates the default subscription and every configured subscription that monitors at
+// least one of the nodes. Each subscription delivers its notifications to its channel in SubNotifyChans.
+func (g *OPCUAInput) createSubscriptions(ctx context.Context, nodeList []NodeDef) error {
+	used := map[string]bool{DefaultSubscriptionName: true}
+	for _, nodeDef := range g.nodesInMode(nodeList, NodeModeSubscribe) {
+		used[g.subscriptionName(nodeDef)] = true
+	}
+
+	g.Subscriptions = make(map[string]*opcua.Subscription)
+	for _, subscriptionConfig := range g.subscriptionConfigs() {
+		if !used[subscriptionConfig.Name] {
+			g.Log.Debugf("Subscription %s is not created, as no node is assigned to it", subscriptionConfig.Name)
+			continue
+		}
+
+		subscription, err := g.Client.Subscribe(ctx, &opcua.SubscriptionParameters{
+			Interval:          subscriptionConfig.PublishingInterval,
+			MaxKeepAliveCount: subscriptionConfig.MaxKeepAliveCount,
+			LifetimeCount:     subscriptionConfig.LifetimeCount,
+			Priority:          subscriptionConfig.Priority,
+		}, g.subNotifyChan(subscriptionConfig.Name))
+		if err != nil {
+			return fmt.Errorf("creating subscription %s failed: %w", subscriptionConfig.Name, err)
+		}
+
+		g.Subscriptions[subscriptionConfig.Name] = subscription
+		g.Log.Infof("Created subscription %s (ID %d) with a publishing interval of %v and priority %d",
+			subscriptionConfig.Name, subscription.SubscriptionID, subscription.RevisedPublishingInterval, subscriptionConfig.Priority)
+	}
+
+	g.Subscription = g.Subscriptions[DefaultSubscriptionName]
+	return nil
+}
+
+// cancelSubscriptions deletes all subscriptions of the input at the server.
+func (g *OPCUAInput) cancelSubscriptions(ctx context.Context) {
+	for name, subscription := range g.Subscriptions {
+		if err := subscription.Cancel(ctx); err != nil {
+			g.Log.Infof("Failed to unsubscribe from OPC UA subscription %s: %v", name, err)
+		}
+	}
+	g.Subscriptions = nil
+	g.Subscription = nil
+}
@@ -0,0 +1,378 @@
+package opcua_plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/gopcua/opcua"
+	"github.com/gopcua/opcua/ua"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// triggerHandleBase is the first client handle that is used for monitored items of trigger groups.
+// Client handles below attributeHandleBase are positions in NodeList (see MonitorBatched).
+// The handle of a trigger group item is triggerHandleBase + groupIndex<<16 + itemIndex,
+// where itemIndex 0 is the trigger node and itemIndex i > 0 is NodeIDs[i-1].
+const triggerHandleBase uint32 = 1 << 31
+
+// maxNodesPerTriggerGroup is the number of nodes that fit into the itemIndex part of a client handle.
+const maxNodesPerTriggerGroup = 1<<16 - 1
+
+// TriggerGroup is a group of nodes that are read together whenever a trigger node changes.
+type TriggerGroup struct {
+	Name          string
+	TriggerNodeID *ua.NodeID
+	TriggerValue  string // if set, the group is only read when the trigger changes to this value
+	NodeIDs       []*ua.NodeID
+
+	triggerNode      NodeDef
+	nodes            []NodeDef
+	useSetTriggering bool
+	triggerSeen      bool
+	lastValues       []*ua.DataValue
+}
+
+// ParseTriggerGroups parses the triggerGroups configuration into TriggerGroups.
+func ParseTriggerGroups(confs []*service.ParsedConfig) ([]*TriggerGroup, error) {
+	triggerGroups := make([]*TriggerGroup, 0, len(confs))
+
+	for i, conf := range confs {
+		name, err := conf.FieldString("name")
+		if err != nil {
+			return nil, err
+		}
+
+		triggerNodeIDString, err := conf.FieldString("triggerNodeID")
+		if err != nil {
+			return nil, err
+		}
+
+		triggerNodeID, err := ua.ParseNodeID(triggerNodeIDString)
+		if err != nil {
+			return nil, fmt.Errorf("trigger group %d: invalid triggerNodeID %q: %w", i, triggerNodeIDString, err)
+		}
+
+		triggerValue, err := conf.FieldString("triggerValue")
+		if err != nil {
+			return nil, err
+		}
+
+		nodeIDStrings, err := conf.FieldStringList("nodeIDs")
+		if err != nil {
+			return nil, err
+		}
+
+		if len(nodeIDStrings) == 0 {
+			return nil, fmt.Errorf("trigger group %d: no nodeIDs provided", i)
+		}
+
+		if len(nodeIDStrings) > maxNodesPerTriggerGroup {
+			return nil, fmt.Errorf("trigger group %d: at most %d nodeIDs are supported", i, maxNodesPerTriggerGroup)
+		}
+
+		nodeIDs := make([]*ua.NodeID, 0, len(nodeIDStrings))
+		for _, nodeIDString := range nodeIDStrings {
+			nodeID, err := ua.ParseNodeID(nodeIDString)
+			if err != nil {
+				return nil, fmt.Errorf("trigger group %d: invalid nodeID %q: %w", i, nodeIDString, err)
+			}
+			nodeIDs = append(nodeIDs, nodeID)
+		}
+
+		if name == "" {
+			name = sanitize(triggerNodeID.String())
+		}
+
+		triggerGroups = append(triggerGroups, &TriggerGroup{
+			Name:          name,
+			TriggerNodeID: triggerNodeID,
+			TriggerValue:  triggerValue,
+			NodeIDs:       nodeIDs,
+		})
+	}
+
+	return triggerGroups, nil
+}
+
+// triggerHandle returns the client handle of the item at itemIndex in the trigger group at groupIndex.
+func triggerHandle(groupIndex int, itemIndex int) uint32 {
+	return triggerHandleBase + uint32(groupIndex)<<16 + uint32(itemIndex)
+}
+
+// splitTriggerHandle is the inverse of triggerHandle.
+func splitTriggerHandle(handle uint32) (groupIndex int, itemIndex int, ok bool) {
+	if handle < triggerHandleBase {
+		return 0, 0, false
+	}
+	handle -= triggerHandleBase
+	return int(handle >> 16), int(handle & 0xFFFF), true
+}
+
+// browseNodeDefs fetches the NodeDefs of the given NodeIDs and returns them in the same order.
+// If a node can not be browsed, a NodeDef with only the NodeID set is returned for it.
+func (g *OPCUAInput) browseNodeDefs(ctx context.Context, nodeIDs []*ua.NodeID) []NodeDef {
+	nodeDefs := make([]NodeDef, 0, len(nodeIDs))
+
+	for _, nodeID := range nodeIDs {
+		nodeChan := make(chan NodeDef, 100)
+		errChan := make(chan error, 100)
+		pathIDMapChan := make(chan map[string]string, 1)
+		var wg sync.WaitGroup
+
+		// browse only follows the children of variables with components, which are not needed here
+		wg.Add(1)
+		go browse(ctx, g.Client.Node(nodeID), "", 10, g.Log, nodeID.String(), nodeChan, errChan, pathIDMapChan, &wg)
+		wg.Wait()
+		close(nodeChan)
+		close(errChan)
+		close(pathIDMapChan)
+
+		nodeDef := NodeDef{NodeID: nodeID, BrowseName: nodeID.String(), Path: sanitize(nodeID.String())}
+		for def := range nodeChan {
+			if def.NodeID.String() == nodeID.String() {
+				nodeDef = def
+				break
+			}
+		}
+		for err := range errChan {
+			g.Log.Debugf("Error while browsing node %s: %v", nodeID, err)
+		}
+
+		nodeDefs = append(nodeDefs, nodeDef)
+	}
+
+	return nodeDefs
+}
+
+// MonitorTriggerGroups creates the monitored items for all trigger groups in the current subscription.
+//
+// For each group, the trigger node is monitored in reporting mode. If the server supports it, the nodes
+// of the group are monitored in sampling mode and linked to the trigger with SetTriggering, so that
+// the server reports their values together with the trigger. Otherwise, the nodes are read whenever
+// the trigger changes.
+func (g *OPCUAInput) MonitorTriggerGroups(ctx context.Context) error {
+	if len(g.TriggerGroups) == 0 {
+		return nil
+	}
+
+	if g.Subscription == nil {
+		return errors.New("trigger groups require a subscription")
+	}
+
+	for groupIndex, group := range g.TriggerGroups {
+		group.triggerNode = g.browseNodeDefs(ctx, []*ua.NodeID{group.TriggerNodeID})[0]
+		group.nodes = g.browseNodeDefs(ctx, group.NodeIDs)
+		group.lastValues = make([]*ua.DataValue, len(group.nodes))
+		group.triggerSeen = false
+		group.useSetTriggering = false
+
+		resp, err := g.Subscription.Monitor(ctx, ua.TimestampsToReturnBoth, opcua.NewMonitoredItemCreateRequestWithDefaults(group.TriggerNodeID, ua.AttributeIDValue, triggerHandle(groupIndex, 0)))
+		if err != nil {
+			return fmt.Errorf("monitoring trigger node %s of trigger group %s failed: %w", group.TriggerNodeID, group.Name, err)
+		}
+		if len(resp.Results) != 1 || !errors.Is(resp.Results[0].StatusCode, ua.StatusOK) {
+			return fmt.Errorf("monitoring trigger node %s of trigger group %s failed: %v", group.TriggerNodeID, group.Name, resp.Results)
+		}
+		triggerItemID := resp.Results[0].MonitoredItemID
+
+		if g.setTriggeringUnsupported {
+			continue
+		}
+
+		if err := g.linkTriggerGroup(ctx, groupIndex, group, triggerItemID); err != nil {
+			g.Log.Infof("Server does not support triggering for trigger group %s, reading the nodes when the trigger changes instead: %v", group.Name, err)
+			continue
+		}
+
+		group.useSetTriggering = true
+		g.Log.Infof("Linked %d nodes to trigger node %s of trigger group %s", len(group.nodes), group.TriggerNodeID, group.Name)
+	}
+
+	return nil
+}
+
+// linkTriggerGroup monitors the nodes of a trigger group in sampling mode and links them to the trigger item.
+// If anything fails, the created sampling items are removed again.
+func (g *OPCUAInput) linkTriggerGroup(ctx context.Context, groupIndex int, group *TriggerGroup, triggerItemID uint32) error {
+	monitoredItemIDs := make([]uint32, 0, len(group.nodes))
+
+	unmonitor := func() {
+		if len(monitoredItemIDs) == 0 {
+			return
+		}
+		if _, err := g.Subscription.Unmonitor(ctx, monitoredItemIDs...); err != nil {
+			g.Log.Infof("Failed to remove sampling items of trigger group %s: %v", group.Name, err)
+		}
+	}
+
+	for _, chunk := range SplitIntoChunks(len(group.nodes), g.maxMonitoredItemsPerCall()) {
+		requests := make([]*ua.MonitoredItemCreateRequest, 0, chunk[1]-chunk[0])
+		for i := chunk[0]; i < chunk[1]; i++ {
+			request := opcua.NewMonitoredItemCreateRequestWithDefaults(group.nodes[i].NodeID, ua.AttributeIDValue, triggerHandle(groupIndex, i+1))
+			request.MonitoringMode = ua.MonitoringModeSampling
+			requests = append(requests, request)
+		}
+
+		resp, err := g.Subscription.Monitor(ctx, ua.TimestampsToReturnBoth, requests...)
+		if err != nil {
+			unmonitor()
+			return err
+		}
+
+		for i, result := range resp.Results {
+			if !errors.Is(result.StatusCode, ua.StatusOK) {
+				unmonitor()
+				return fmt.Errorf("monitoring node %s failed: %v", group.nodes[chunk[0]+i].NodeID, result.StatusCode)
+			}
+			monitoredItemIDs = append(monitoredItemIDs, result.MonitoredItemID)
+		}
+	}
+
+	resp, err := g.Subscription.SetTriggering(ctx, triggerItemID, monitoredItemIDs, nil)
+	if err != nil {
+		// The client re-creates its secure channel after a service fault, which usually fails the
+		// current connection attempt. Remember that the service is missing, so that it is not called
+		// again after the reconnect.
+		if errors.Is(err, ua.StatusBadServiceUnsupported) {
+			g.setTriggeringUnsupported = true
+		}
+		unmonitor()
+		return err
+	}
+
+	for i, result := range resp.AddResults {
+		if !errors.Is(result, ua.StatusOK) {
+			unmonitor()
+			return fmt.Errorf("linking node %s failed: %v", group.nodes[i].NodeID, result)
+		}
+	}
+
+	return nil
+}
+
+// handleTriggerNotifications processes the monitored items of a DataChangeNotification that belong to
+// trigger groups and returns one message for each trigger group that was triggered.
+func (g *OPCUAInput) handleTriggerNotifications(ctx context.Context, items []*ua.MonitoredItemNotification) service.MessageBatch {
+	msgs := service.MessageBatch{}
+	triggered := make(map[int]*ua.DataValue)
+
+	for _, item := range items {
+		groupIndex, itemIndex, ok := splitTriggerHandle(item.ClientHandle)
+		if !ok || groupIndex >= len(g.TriggerGroups) {
+			continue
+		}
+		group := g.TriggerGroups[groupIndex]
+
+		if itemIndex == 0 {
+			triggered[groupIndex] = item.Value
+			continue
+		}
+
+		if itemIndex-1 < len(group.lastValues) {
+			group.lastValues[itemIndex-1] = item.Value
+		}
+	}
+
+	for groupIndex, group := range g.TriggerGroups {
+		triggerValue, ok := triggered[groupIndex]
+		if !ok || triggerValue == nil || triggerValue.Value == nil {
+			continue
+		}
+
+		// The first notification of the trigger is its initial value and not a change
+		if !group.triggerSeen {
+			group.triggerSeen = true
+			continue
+		}
+
+		if group.TriggerValue != "" && g.formatValue(triggerValue, group.triggerNode) != group.TriggerValue {
+			continue
+		}
+
+		values := group.lastValues
+		if !group.useSetTriggering {
+			var err error
+			values, err = g.readTriggerGroup(ctx, group)
+			if err != nil {
+				g.Log.Errorf("Failed to read nodes of trigger group %s: %v", group.Name, err)
+				continue
+			}
+		}
+
+		message, err := g.createTriggerGroupMessage(group, triggerValue, values)
+		if err != nil {
+			g.Log.Errorf("Failed to create message for trigger group %s: %v", group.Name, err)
+			continue
+		}
+		msgs = append(msgs, message)
+	}
+
+	return msgs
+}
+
+// readTriggerGroup reads the current values of all nodes of a trigger group.
+func (g *OPCUAInput) readTriggerGroup(ctx context.Context, group *TriggerGroup) ([]*ua.DataValue, error) {
+	nodesToRead := make([]*ua.ReadValueID, 0, len(group.nodes))
+	for _, node := range group.nodes {
+		nodesToRead = append(nodesToRead, &ua.ReadValueID{NodeID: node.NodeID})
+	}
+	return g.readChunks(ctx, nodesToRead)
+}
+
+// formatValue returns the value of a DataValue as it would be written into a message payload in the raw payload format.
+func (g *OPCUAInput) formatValue(dataValue *ua.DataValue, nodeDef NodeDef) string {
+	if dataValue == nil || dataValue.Value == nil {
+		return ""
+	}
+	b, _, err := formatPayload(dataValue.Value.Value())
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// createTriggerGroupMessage combines the values of a trigger group into a single JSON message.
+// All values share the source timestamp of the trigger.
+func (g *OPCUAInput) createTriggerGroupMessage(group *TriggerGroup, triggerValue *ua.DataValue, values []*ua.DataValue) (*service.Message, error) {
+	payload := map[string]any{}
+	for i, node := range group.nodes {
+		key := sanitize(node.BrowseName)
+		if _, exists := payload[key]; exists || key == "" {
+			key = sanitize(node.NodeID.String())
+		}
+
+		if i >= len(values) || values[i] == nil || values[i].Value == nil || !errors.Is(values[i].Status, ua.StatusOK) {
+			payload[key] = nil
+			continue
+		}
+		payload[key] = JSONSafeValue(values[i].Value.Value())
+	}
+
+	b, err := json.Marshal(map[string]any{
+		"timestamp_ms": triggerValue.SourceTimestamp.UnixMilli(),
+		"trigger":      JSONSafeValue(triggerValue.Value.Value()),
+		"values":       payload,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mode := "read"
+	if group.useSetTriggering {
+		mode = "set_triggering"
+	}
+
+	message := service.NewMessage(b)
+	message.MetaSet("opcua_tag_group", group.Name)
+	message.MetaSet("opcua_tag_name", sanitize(group.triggerNode.BrowseName))
+	message.MetaSet("opcua_tag_type", "string")
+	message.MetaSet("opcua_trigger_group", group.Name)
+	message.MetaSet("opcua_trigger_mode", mode)
+	message.MetaSet("opcua_attr_nodeid", group.TriggerNodeID.String())
+	message.MetaSet("opcua_source_timestamp", triggerValue.SourceTimestamp.Format("2006-01-02T15:04:05.000000Z07:00"))
+	message.MetaSet("opcua_server_timestamp", triggerValue.ServerTimestamp.Format("2006-01-02T15:04:05.000000Z07:00"))
+	return message, nil
+}
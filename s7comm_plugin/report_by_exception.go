@@ -0,0 +1,26 @@
+// Copyright 2024 UMH Systems GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s7comm_plugin
+
+// isNumeric reports whether a result of the converter functions is a number, so that report-by-exception
+// compares it with the deadband.
+func isNumeric(value interface{}) bool {
+	switch value.(type) {
+	case uint8, uint16, int16, uint32, int32, int64, float32:
+		return true
+	default:
+		return false
+	}
+}
@@ -24,6 +24,8 @@ import (
 
 	"github.com/redpanda-data/benthos/v4/public/service"
 	"github.com/robinson/gos7" // gos7 is a Go client library for interacting with Siemens S7 PLCs.
+
+	"github.com/united-manufacturing-hub/benthos-umh/internal/report_by_exception"
 )
 
 const addressRegexp = `^(?P<area>[A-Z]+)(?P<no>[0-9]+)\.(?P<type>[A-Z]+)(?P<start>[0-9]+)(?:\.(?P<extra>.*))?$`
@@ -77,6 +79,8 @@ type S7CommInput struct {
 	Log            *service.Logger                       // Logger for logging plugin activity.
 	Batches        [][]S7DataItemWithAddressAndConverter // List of items to read from the PLC, grouped into batches with a maximum size.
 	DisableCPUInfo bool                                  // Set this to true to not fetch CPU information from the PLC. Should be used when you get the error "Failed to get CPU information"
+
+	ReportByException report_by_exception.ReportByException // Only emit the values of addresses that changed since they were last reported
 }
 
 type converterFunc func([]byte) interface{}
@@ -93,6 +97,7 @@ var S7CommConfigSpec = service.NewConfigSpec().
 	Field(service.NewIntField("batchMaxSize").Description("Maximum count of addresses to be bundled in one batch-request (PDU size).").Default(480)).
 	Field(service.NewIntField("timeout").Description("The timeout duration in seconds for connection attempts and read requests.").Default(10)).
 	Field(service.NewBoolField("disableCPUInfo").Description("Set this to true to not fetch CPU information from the PLC. Should be used when you get the error 'Failed to get CPU information'").Default(false)).
+	Field(report_by_exception.ConfigField("Report-by-exception mode. The last emitted values are kept per address in memory. The first read after a (re)connect emits all values.")).
 	Field(service.NewStringListField("addresses").Description("List of S7 addresses to read in the format '<area>.<type><address>[.extra]', e.g., 'DB5.X3.2', 'DB5.B3', or 'DB5.C3'. " +
 		"Address formats include direct area access (e.g., DB1 for data block one) and data types (e.g., X for bit, B for byte)."))
 
@@ -134,6 +139,11 @@ func newS7CommInput(conf *service.ParsedConfig, mgr *service.Resources) (service
 		return nil, err
 	}
 
+	reportByException, err := report_by_exception.Parse(conf)
+	if err != nil {
+		return nil, err
+	}
+
 	// Now split the addresses into batches based on the batchMaxSize
 	batches, err := ParseAddresses(addresses, batchMaxSize)
 	if err != nil {
@@ -149,6 +159,8 @@ func newS7CommInput(conf *service.ParsedConfig, mgr *service.Resources) (service
 		BatchMaxSize:   batchMaxSize,
 		Timeout:        time.Duration(timeoutInt) * time.Second,
 		DisableCPUInfo: disableCPUInfo,
+
+		ReportByException: reportByException,
 	}
 
 	return service.AutoRetryNacksBatched(m), nil
@@ -216,6 +228,9 @@ func (g *S7CommInput) Connect(ctx context.Context) error {
 	g.Handler.Timeout = g.Timeout
 	g.Handler.IdleTimeout = g.Timeout
 
+	// The first read after a (re)connect reports all values
+	g.ReportByException.Reset()
+
 	err := g.Handler.Connect()
 	if err != nil {
 		g.Log.Errorf("Failed to connect to S7 PLC at %s: %v", g.TcpDevice, err)
@@ -244,6 +259,7 @@ func (g *S7CommInput) ReadBatch(ctx context.Context) (service.MessageBatch, serv
 	}
 
 	msgs := make(service.MessageBatch, 0)
+	now := time.Now()
 	for i, b := range g.Batches {
 
 		// Create a new batch to read
@@ -264,8 +280,6 @@ func (g *S7CommInput) ReadBatch(ctx context.Context) (service.MessageBatch, serv
 		}
 
 		// Read the data from the batch and convert it using the converter function
-		buffer := make([]byte, 0)
-
 		for _, item := range b {
 			// Execute the converter function to get the converted data
 			convertedData := item.ConverterFunc(item.Item.Data)
@@ -277,13 +291,13 @@ func (g *S7CommInput) ReadBatch(ctx context.Context) (service.MessageBatch, serv
 			// Convert the string representation to a []byte
 			dataAsBytes := []byte(dataAsString)
 
-			// Append the converted data as bytes to the buffer
-			buffer = append(buffer, dataAsBytes...)
+			// Skip values that did not change in report-by-exception mode
+			if !g.ReportByException.Report(item.Address, dataAsBytes, isNumeric(convertedData), now) {
+				continue
+			}
 
-			// Create a new message with the current state of the buffer
-			// Note: Depending on your requirements, you may want to reset the buffer
-			// after creating each message or keep accumulating data in it.
-			msg := service.NewMessage(buffer)
+			// Create a new message with the value of the address only
+			msg := service.NewMessage(dataAsBytes)
 			msg.MetaSet("s7_address", item.Address)
 
 			// Append the new message to the msgs slice
@@ -0,0 +1,72 @@
+package s7comm_plugin_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/robinson/gos7"
+
+	"github.com/united-manufacturing-hub/benthos-umh/internal/report_by_exception"
+	"github.com/united-manufacturing-hub/benthos-umh/s7comm_plugin"
+)
+
+// fakeS7Client answers AGReadMulti with the given words by address. The other methods of the client are not used.
+type fakeS7Client struct {
+	gos7.Client
+	words map[int]uint16
+}
+
+func (c *fakeS7Client) AGReadMulti(items []gos7.S7DataItem, count int) error {
+	for _, item := range items[:count] {
+		item.Data[0] = byte(c.words[item.Start] >> 8)
+		item.Data[1] = byte(c.words[item.Start])
+	}
+	return nil
+}
+
+var _ = Describe("Report by exception", func() {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	It("should report all values again after a connect", func() {
+		input := &s7comm_plugin.S7CommInput{
+			TcpDevice:         "127.0.0.1:1",
+			Timeout:           time.Second,
+			ReportByException: report_by_exception.ReportByException{Enabled: true},
+		}
+		Expect(input.ReportByException.Report("DB1.W0", []byte("1"), true, start)).To(BeTrue())
+		Expect(input.ReportByException.Report("DB1.W0", []byte("1"), true, start)).To(BeFalse())
+
+		// Nothing listens on the port, but every connection attempt starts with a full image
+		Expect(input.Connect(context.Background())).NotTo(Succeed())
+		Expect(input.ReportByException.Report("DB1.W0", []byte("1"), true, start)).To(BeTrue())
+	})
+
+	It("should only emit the value of its address in each message", func() {
+		batches, err := s7comm_plugin.ParseAddresses([]string{"DB1.W0", "DB1.W2", "DB1.W4"}, 480)
+		Expect(err).NotTo(HaveOccurred())
+		input := &s7comm_plugin.S7CommInput{
+			Client:            &fakeS7Client{words: map[int]uint16{0: 1, 2: 22, 4: 333}},
+			Batches:           batches,
+			ReportByException: report_by_exception.ReportByException{Enabled: true},
+		}
+
+		msgs, _, err := input.ReadBatch(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		var payloads []string
+		for _, msg := range msgs {
+			b, err := msg.AsBytes()
+			Expect(err).NotTo(HaveOccurred())
+			payloads = append(payloads, string(b))
+		}
+		Expect(payloads).To(Equal([]string{"1", "22", "333"}))
+
+		// Only the changed value is emitted, without the values read before
+		input.Client.(*fakeS7Client).words[2] = 23
+		msgs, _, err = input.ReadBatch(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(msgs).To(HaveLen(1))
+		Expect(msgs[0].AsBytes()).To(Equal([]byte("23")))
+	})
+})